@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// maxApprovalArgs caps how much of a call's arguments the approval prompt
+// prints.
+const maxApprovalArgs = 500
+
+// lineReader reads lines in the background, so a read can give up when its
+// context is canceled. The REPL and the approver share stdinLines, so
+// neither loses a line the other has read ahead.
+type lineReader struct {
+	r     io.Reader
+	once  sync.Once
+	lines chan string
+	err   error // Why the lines ended; set before lines is closed
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{r: r, lines: make(chan string)}
+}
+
+// stdinLines reads standard input, from the first time it is used.
+var stdinLines = newLineReader(os.Stdin)
+
+// readLine returns the next line, without its newline, or io.EOF at the
+// end. If ctx is canceled first it returns ctx's error, and the line is
+// left for the next call.
+func (l *lineReader) readLine(ctx context.Context) (string, error) {
+	l.once.Do(func() { go l.run() })
+	select {
+	case line, ok := <-l.lines:
+		if !ok {
+			return "", l.err
+		}
+		return line, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (l *lineReader) run() {
+	scanner := bufio.NewScanner(l.r)
+	for scanner.Scan() {
+		l.lines <- scanner.Text()
+	}
+	l.err = scanner.Err()
+	if l.err == nil {
+		l.err = io.EOF
+	}
+	close(l.lines)
+}
+
+// terminalApprover asks on the terminal before a tool call runs, reading
+// the answer from lines. Answering "a" approves every later call of the
+// same tool.
+func terminalApprover(lines *lineReader) agent.Approver {
+	var mu sync.Mutex
+	always := make(map[string]bool)
+	return func(ctx context.Context, call llm.ToolCallPart) (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if always[call.Name] {
+			return true, nil
+		}
+
+		args, _ := json.Marshal(call.Args)
+		if len(args) > maxApprovalArgs {
+			args = append(args[:maxApprovalArgs], "…"...)
+		}
+		fmt.Fprintf(os.Stderr, "\n[Approve tool call] %s %s\n", call.Name, args)
+		fmt.Fprint(os.Stderr, "Allow? [y/N/a=always] ")
+
+		answer, err := lines.readLine(ctx)
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true, nil
+		case "a", "always":
+			always[call.Name] = true
+			return true, nil
+		}
+		return false, nil
+	}
+}
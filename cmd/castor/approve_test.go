@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+func TestLineReader(t *testing.T) {
+	r, w := io.Pipe()
+	lines := newLineReader(r)
+
+	// A canceled read leaves the line for the next one
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := lines.readLine(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	go func() {
+		io.WriteString(w, "first\nsecond\n")
+		w.Close()
+	}()
+	for _, want := range []string{"first", "second"} {
+		if line, err := lines.readLine(context.Background()); err != nil || line != want {
+			t.Errorf("readLine = %q, %v, want %q", line, err, want)
+		}
+	}
+	if _, err := lines.readLine(context.Background()); err != io.EOF {
+		t.Errorf("err = %v at the end, want io.EOF", err)
+	}
+}
+
+func TestTerminalApprover(t *testing.T) {
+	approve := terminalApprover(newLineReader(strings.NewReader("n\na\n")))
+	ctx := context.Background()
+	answers := []struct {
+		tool string
+		want bool
+	}{
+		{"replace", false},
+		{"replace", true},           // Always
+		{"replace", true},           // Not asked again
+		{"edit_transaction", false}, // No more input
+	}
+	for i, a := range answers {
+		ok, err := approve(ctx, llm.ToolCallPart{Name: a.tool})
+		if err != nil || ok != a.want {
+			t.Errorf("call %d of %s = %v, %v, want %v", i+1, a.tool, ok, err, a.want)
+		}
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	r, w := io.Pipe() // Never answered
+	defer w.Close()
+	approve = terminalApprover(newLineReader(r))
+	if _, err := approve(canceled, llm.ToolCallPart{Name: "replace"}); err == nil {
+		t.Error("expected an error once the context is canceled")
+	}
+}
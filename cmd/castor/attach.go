@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/techmuch/castor/pkg/tools/workspace"
+)
+
+// maxAttachBytes caps the total size of the files attached with -file and
+// -dir, so a stray directory cannot fill the context window.
+const maxAttachBytes = 1 << 20
+
+// attachments reads the -file files and the text files under the -dir
+// directories into blocks to append to the prompt, in the same form as
+// "@" mentions in the TUI. Files larger than maxBytes are cut off. Under a
+// directory, ignored and binary files are skipped; a binary file named
+// with -file is an error.
+func attachments(files, dirs []string, maxBytes int) (string, error) {
+	var blocks []string
+	total := 0
+	add := func(path string, data []byte) error {
+		if len(data) > maxBytes {
+			data = append(data[:maxBytes:maxBytes], fmt.Sprintf("\n[Truncated at %d of %d bytes]", maxBytes, len(data))...)
+		}
+		if total += len(data); total > maxAttachBytes {
+			return fmt.Errorf("attached files exceed %d KiB; name fewer files", maxAttachBytes>>10)
+		}
+		blocks = append(blocks, fmt.Sprintf("Contents of %s:\n```\n%s\n```", filepath.ToSlash(path), strings.TrimRight(string(data), "\n")))
+		return nil
+	}
+
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to attach file: %w", err)
+		}
+		if !isText(data) {
+			return "", fmt.Errorf("cannot attach %s: not a text file", path)
+		}
+		if err := add(path, data); err != nil {
+			return "", err
+		}
+	}
+
+	for _, dir := range dirs {
+		ignorer := workspace.NewIgnorer(dir)
+		err := filepath.WalkDir(dir, func(path string, d iofs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != dir && ignorer.Ignored(path, d.IsDir()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() || !d.Type().IsRegular() {
+				return nil
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if !isText(data) {
+				return nil
+			}
+			return add(path, data)
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to attach directory %s: %w", dir, err)
+		}
+	}
+	return strings.Join(blocks, "\n\n"), nil
+}
+
+// isText reports whether data looks like text rather than binary content.
+func isText(data []byte) bool {
+	head := data[:min(len(data), 8000)]
+	if bytes.IndexByte(head, 0) >= 0 {
+		return false
+	}
+	// Allow a multi-byte rune to be cut off at the end of the sample
+	for i := 0; i < utf8.UTFMax && len(head) > 0; i++ {
+		if utf8.Valid(head) {
+			return true
+		}
+		head = head[:len(head)-1]
+	}
+	return len(head) == 0
+}
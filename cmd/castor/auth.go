@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/secrets"
+	"golang.org/x/term"
+)
+
+// providerKeys lists the providers whose API keys `castor auth` manages,
+// with the environment variables that take precedence over the keyring.
+var providerKeys = []struct {
+	name string
+	envs []string
+}{
+	{"openai", []string{"CASTOR_API_KEY", "OPENAI_API_KEY"}},
+}
+
+// apiKeySecret is the keyring key holding a provider's API key.
+func apiKeySecret(provider string) string {
+	return "apikey:" + provider
+}
+
+// findAPIKey returns the API key of a provider from its environment
+// variables or the keyring, and where it was found. It returns
+// secrets.ErrNotFound when there is none.
+func findAPIKey(provider string, store secrets.Store) (key, source string, err error) {
+	for _, p := range providerKeys {
+		if p.name != provider {
+			continue
+		}
+		for _, env := range p.envs {
+			if v := os.Getenv(env); v != "" {
+				return v, env, nil
+			}
+		}
+	}
+	key, err = store.Get(apiKeySecret(provider))
+	if err != nil {
+		return "", "", err
+	}
+	return key, "keyring", nil
+}
+
+// apiKey returns the key for the model's API. Servers at a -url other
+// than OpenAI's usually need none, so a missing key is only an error
+// without one.
+func (f *agentFlags) apiKey() (string, error) {
+	key, _, err := findAPIKey("openai", secrets.Keyring{})
+	switch {
+	case err == nil:
+		return key, nil
+	case f.baseURL != "":
+		return "", nil
+	case errors.Is(err, secrets.ErrNotFound):
+		return "", fmt.Errorf("no API key for OpenAI: set OPENAI_API_KEY or run castor auth login openai")
+	default:
+		return "", fmt.Errorf("no API key for OpenAI: set OPENAI_API_KEY (%w)", err)
+	}
+}
+
+// runAuth implements `castor auth`, which stores API keys in the OS
+// keyring.
+func runAuth(args []string) {
+	flags := flag.NewFlagSet("auth", flag.ExitOnError)
+	flags.Usage = commandUsage(flags, "auth login|logout|status [provider]",
+		"Stores provider API keys in the OS keyring, so they need not be kept in environment variables. "+
+			"login reads the key from the terminal, or from stdin when it is piped. "+
+			"Environment variables such as OPENAI_API_KEY take precedence over stored keys. "+
+			"The provider defaults to openai.")
+	parseFlags(flags, args)
+	if flags.NArg() == 0 || flags.NArg() > 2 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	action, provider := flags.Arg(0), "openai"
+	if flags.NArg() == 2 {
+		provider = flags.Arg(1)
+	}
+	known := false
+	var names []string
+	for _, p := range providerKeys {
+		known = known || p.name == provider
+		names = append(names, p.name)
+	}
+	if !known {
+		fmt.Fprintf(os.Stderr, "Error: unknown provider %q (expected %s)\n", provider, strings.Join(names, ", "))
+		os.Exit(exitUsage)
+	}
+
+	store := secrets.Keyring{}
+	switch action {
+	case "login":
+		key, err := readAPIKey(provider)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		if err := store.Set(apiKeySecret(provider), key); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		fmt.Printf("Saved the %s API key to the keyring.\n", provider)
+	case "logout":
+		if err := store.Delete(apiKeySecret(provider)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		fmt.Printf("Removed the %s API key from the keyring.\n", provider)
+	case "status":
+		_, source, err := findAPIKey(provider, store)
+		switch {
+		case err == nil:
+			fmt.Printf("%s: key from %s\n", provider, source)
+		case errors.Is(err, secrets.ErrNotFound):
+			fmt.Printf("%s: no key\n", provider)
+		default:
+			fmt.Printf("%s: no key (%v)\n", provider, err)
+		}
+	default:
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+}
+
+// readAPIKey reads a key without echoing it when stdin is a terminal, or
+// the first line of stdin otherwise.
+func readAPIKey(provider string) (string, error) {
+	var key string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		fmt.Fprintf(os.Stderr, "API key for %s: ", provider)
+		data, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read key: %w", err)
+		}
+		key = string(data)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("failed to read key: %w", err)
+		}
+		key = line
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", fmt.Errorf("the key is empty")
+	}
+	return key, nil
+}
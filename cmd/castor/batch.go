@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// batchItem is one line of a batch prompts file.
+type batchItem struct {
+	ID        string `json:"id"`        // Defaults to the line number
+	Prompt    string `json:"prompt"`    // Required
+	Workspace string `json:"workspace"` // Defaults to -w
+}
+
+// batchResult is one line of batch output: the result event of the run,
+// with the item it belongs to and the exit code `castor run` would have
+// given it.
+type batchResult struct {
+	ID        string `json:"id"`
+	Workspace string `json:"workspace"`
+	Status    int    `json:"status"`
+	jsonEvent
+}
+
+// runBatch implements `castor batch`, which runs every prompt of a JSON
+// Lines file through the agent and writes a result for each.
+func runBatch(args []string) {
+	flags := flag.NewFlagSet("batch", flag.ExitOnError)
+	f := addAgentFlags(flags)
+	output := flags.String("output", "", "File to write the results to as JSON Lines (defaults to stdout)")
+	jobs := flags.Int("j", 1, "Number of prompts to run at once")
+	flags.Usage = commandUsage(flags, "batch [flags] <prompts.jsonl>",
+		`Runs each prompt of a JSON Lines file through the agent, with a fresh conversation for each, and writes one result per line in the order the runs finish. `+
+			`Each line is an object with a "prompt" and optionally an "id" and a "workspace" to run it in instead of -w. `+
+			`Prompts run at once may edit the same files when they share a workspace. `+
+			`The exit code is that of the most serious failure among the runs.`)
+	parseFlags(flags, args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if *jobs < 1 {
+		fmt.Fprintln(os.Stderr, "Error: -j must be at least 1")
+		os.Exit(exitUsage)
+	}
+
+	items, err := readBatch(flags.Arg(0), f.workspace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	// The tools are bound to a workspace, so each one gets its own agent
+	ctx := signalContext()
+	apps := make(map[string]*app)
+	for _, item := range items {
+		if apps[item.Workspace] == nil {
+			wf := *f
+			wf.workspace = item.Workspace
+			apps[item.Workspace] = wf.start(ctx, true, false)
+			apps[item.Workspace].ag.Approve = nil // Nobody answers during a batch
+		}
+	}
+
+	var (
+		mu     sync.Mutex
+		status runStatus
+		failed int
+		enc    = json.NewEncoder(out)
+		queue  = make(chan batchItem)
+		wg     sync.WaitGroup
+	)
+	for range min(*jobs, len(items)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				result, s := runBatchItem(ctx, apps[item.Workspace], item)
+				mu.Lock()
+				enc.Encode(result)
+				status.providerErr = status.providerErr || s.providerErr
+				status.toolFailed = status.toolFailed || s.toolFailed
+				status.turnLimit = status.turnLimit || s.turnLimit
+				status.denied = status.denied || s.denied
+				if result.Status != exitOK {
+					failed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, item := range items {
+		queue <- item
+	}
+	close(queue)
+	wg.Wait()
+
+	for _, a := range apps {
+		a.close()
+	}
+	fmt.Fprintf(os.Stderr, "Ran %d prompts: %d succeeded, %d failed.\n", len(items), len(items)-failed, failed)
+	exit(status.exitCode())
+}
+
+// readBatch reads the items of a prompts file, filling in their defaults.
+func readBatch(path, workspace string) ([]batchItem, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open prompts file: %w", err)
+	}
+	defer file.Close()
+
+	var items []batchItem
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var item batchItem
+		if err := json.Unmarshal([]byte(text), &item); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if strings.TrimSpace(item.Prompt) == "" {
+			return nil, fmt.Errorf("%s:%d: no prompt", path, line)
+		}
+		if item.ID == "" {
+			item.ID = strconv.Itoa(line)
+		}
+		if item.Workspace == "" {
+			item.Workspace = workspace
+		}
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read prompts file: %w", err)
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%s holds no prompts", path)
+	}
+	return items, nil
+}
+
+// runBatchItem runs one prompt in a fresh conversation with a copy of the
+// workspace's agent.
+func runBatchItem(ctx context.Context, a *app, item batchItem) (batchResult, runStatus) {
+	ag := *a.ag
+	ag.History = slices.Clone(a.ag.History)
+	ag.Usage = slices.Clone(a.ag.Usage)
+
+	out := newJSONOutput(io.Discard, "json")
+	stream, err := ag.Chat(ctx, item.Prompt)
+	if err != nil {
+		out.err = err.Error()
+		out.status.providerErr = true
+	} else {
+		for event := range stream {
+			out.record(event)
+		}
+	}
+	return batchResult{ID: item.ID, Workspace: item.Workspace, Status: out.status.exitCode(), jsonEvent: out.result()}, out.status
+}
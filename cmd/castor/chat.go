@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/tui"
+)
+
+// runChat implements `castor chat`, the terminal UI, or a line-based REPL
+// with -repl.
+func runChat(args []string) {
+	flags := flag.NewFlagSet("chat", flag.ExitOnError)
+	f := addAgentFlags(flags)
+	repl := flags.Bool("repl", false, "Use a line-based REPL instead of the terminal UI")
+	flags.Usage = commandUsage(flags, "chat [flags]", "Chats with the agent in the terminal UI, or in a line-based REPL with -repl.")
+	parseFlags(flags, args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	ctx := signalContext()
+	a := f.start(ctx, true, !*repl)
+	defer a.close()
+	if a.ag.Approval == "" {
+		a.ag.Approval = agent.ApproveMutating
+	}
+
+	if *repl {
+		runInteractive(ctx, a.ag, f.sessionPath)
+		return
+	}
+
+	// The TUI always saves its conversation so /sessions can offer it later
+	sessionDir := agent.SessionDir(f.workspace)
+	path := f.sessionPath
+	if path == "" {
+		path = agent.NewSessionPath(sessionDir)
+	}
+	theme, err := tui.LoadTheme(a.cfg.TUI.Theme, a.cfg.TUI.Colors)
+	if err != nil {
+		fmt.Printf("Error: invalid tui config: %v\n", err)
+		exit(exitError)
+	}
+	keys, err := tui.LoadKeyMap(a.cfg.TUI.Keys)
+	if err != nil {
+		fmt.Printf("Error: invalid tui config: %v\n", err)
+		exit(exitError)
+	}
+	opts := tui.Options{Logs: a.logs, Servers: a.conns, SessionDir: sessionDir, SessionPath: path, OfferResume: f.sessionPath == "", Workspace: f.workspace, Theme: &theme, DisableMouse: a.cfg.TUI.DisableMouse, Keys: &keys, Context: ctx}
+	switch n := a.cfg.TUI.NotifyAfter; {
+	case n == 0:
+		opts.NotifyAfter = tui.DefaultNotifyAfter
+	case n > 0:
+		opts.NotifyAfter = time.Duration(n) * time.Second
+	}
+	if err := tui.Run(a.ag, opts); err != nil && ctx.Err() == nil {
+		fmt.Printf("Error running TUI: %v\n", err)
+		exit(exitError)
+	}
+}
+
+func runInteractive(ctx context.Context, ag *agent.Agent, sessionPath string) {
+	fmt.Println("Castor Interactive Mode (Ctrl+C to exit)")
+	fmt.Println("----------------------------------------")
+
+	for {
+		fmt.Print("> ")
+		input, err := stdinLines.readLine(ctx)
+		if err != nil {
+			break
+		}
+		if input == "" {
+			continue
+		}
+
+		stream, err := ag.Chat(ctx, input)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+
+		for event := range stream {
+			if event.Error != nil {
+				fmt.Printf("\nError: %v\n", event.Error)
+				break
+			}
+			if event.Delta != "" {
+				fmt.Print(event.Delta)
+			}
+			if len(event.ToolCalls) > 0 {
+				for _, tc := range event.ToolCalls {
+					fmt.Printf("\n[Tool Call: %s(%v)]\n", tc.Name, tc.Args)
+				}
+			}
+			if event.Progress != nil {
+				fmt.Printf("[Progress %s]\n", event.Progress)
+			}
+			if event.TurnLimit > 0 {
+				fmt.Printf("\n[Stopped at the limit of %d turns; send a message to continue]\n", event.TurnLimit)
+			}
+		}
+		fmt.Println()
+
+		if sessionPath != "" {
+			nameSession(ctx, ag)
+			if err := ag.SaveSession(sessionPath); err != nil {
+				fmt.Printf("Error saving session: %v\n", err)
+			}
+		}
+	}
+}
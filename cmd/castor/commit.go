@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"golang.org/x/term"
+)
+
+// maxCommitDiff caps how much of the staged diff is sent to the model.
+const maxCommitDiff = 100 << 10
+
+// commitSystemPrompt is the system prompt of `castor commit` unless
+// -system is given.
+const commitSystemPrompt = `You write git commit messages in the Conventional Commits format.
+The first line is "type(scope): summary", where type is one of feat, fix, docs, style, refactor, perf, test, build, ci, chore, or revert, the scope is optional, and the summary is in the imperative mood, lowercase, without a final period, and at most 72 characters.
+If the change needs explaining, add a blank line and a body wrapped at 72 characters that says what changed and why.
+Reply with the commit message only, without quotes or code fences.`
+
+// runCommit implements `castor commit`, which writes a commit message for
+// the staged changes and commits them once it is confirmed.
+func runCommit(args []string) {
+	flags := flag.NewFlagSet("commit", flag.ExitOnError)
+	f := addAgentFlags(flags)
+	yes := flags.Bool("y", false, "Commit without asking for confirmation")
+	flags.Usage = commandUsage(flags, "commit [flags]",
+		"Writes a Conventional Commits message for the staged changes in the workspace and commits them once confirmed: "+
+			"y commits, e opens the message in git's editor first, r writes a new one, and n gives up. "+
+			"With -dry-run the message is only printed.")
+	parseFlags(flags, args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	given := false
+	flags.Visit(func(fl *flag.Flag) { given = given || fl.Name == "system" || fl.Name == "system-file" })
+	if !given {
+		f.systemPrompt = commitSystemPrompt
+	}
+
+	stat, err := git(f.workspace, "diff", "--cached", "--stat")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if strings.TrimSpace(stat) == "" {
+		fmt.Fprintln(os.Stderr, "Error: nothing is staged; add changes with git add first")
+		os.Exit(exitError)
+	}
+	patch, err := git(f.workspace, "diff", "--cached")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	recent, _ := git(f.workspace, "log", "-n", "10", "--format=%s") // Empty in a new repository
+	prompt := commitPrompt(stat, patch, recent)
+
+	ctx := signalContext()
+	f.noTools = true // The diff is all the model needs
+	a := f.start(ctx, true, false)
+	defer a.close()
+	base := slices.Clone(a.ag.History)
+
+	interactive := term.IsTerminal(int(os.Stdin.Fd()))
+	in := bufio.NewReader(os.Stdin)
+	for {
+		a.ag.History = slices.Clone(base)
+		msg, err := commitMessage(ctx, a.ag, prompt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			a.close()
+			exit(exitProvider)
+		}
+		fmt.Printf("%s\n\n", msg)
+
+		switch {
+		case f.dryRun:
+			return
+		case *yes:
+		case !interactive:
+			fmt.Fprintln(os.Stderr, "Not committed: pass -y to commit when stdin is not a terminal")
+			a.close()
+			exit(exitDenied)
+		default:
+			fmt.Fprint(os.Stderr, "Commit with this message? [y/e/r/N] ")
+			answer, _ := in.ReadString('\n')
+			switch strings.ToLower(strings.TrimSpace(answer)) {
+			case "y", "yes":
+			case "e", "edit":
+				if err := gitCommit(f.workspace, msg, true); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					a.close()
+					exit(exitError)
+				}
+				return
+			case "r", "regenerate":
+				continue
+			default:
+				fmt.Fprintln(os.Stderr, "Not committed.")
+				a.close()
+				exit(exitDenied)
+			}
+		}
+
+		if err := gitCommit(f.workspace, msg, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			a.close()
+			exit(exitError)
+		}
+		return
+	}
+}
+
+// commitPrompt asks for a message for the staged changes, showing recent
+// subjects so the model can follow the repository's habits.
+func commitPrompt(stat, patch, recent string) string {
+	var b strings.Builder
+	b.WriteString("Write the commit message for these staged changes.\n\n")
+	if recent = strings.TrimSpace(recent); recent != "" {
+		fmt.Fprintf(&b, "Recent commit subjects in this repository:\n%s\n\n", recent)
+	}
+	fmt.Fprintf(&b, "Summary:\n%s\n", stat)
+	if len(patch) > maxCommitDiff {
+		patch = patch[:maxCommitDiff] + "\n[diff truncated]\n"
+	}
+	fmt.Fprintf(&b, "Diff:\n```diff\n%s```\n", patch)
+	return b.String()
+}
+
+// commitMessage returns the model's reply to prompt, without any code
+// fence around it.
+func commitMessage(ctx context.Context, ag *agent.Agent, prompt string) (string, error) {
+	stream, err := ag.Chat(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+	var reply strings.Builder
+	for event := range stream {
+		if event.Error != nil {
+			return "", event.Error
+		}
+		reply.WriteString(event.Delta)
+	}
+	msg := stripFence(reply.String())
+	if msg == "" {
+		return "", fmt.Errorf("the model returned an empty message")
+	}
+	return msg, nil
+}
+
+// stripFence trims a reply and removes a code fence around all of it.
+func stripFence(reply string) string {
+	reply = strings.TrimSpace(reply)
+	if strings.HasPrefix(reply, "```") {
+		_, reply, _ = strings.Cut(reply, "\n") // Drop the opening fence and its language
+		reply = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(reply), "```"))
+	}
+	return reply
+}
+
+// gitCommit commits the staged changes with msg, first opening it in
+// git's editor if edit is set.
+func gitCommit(dir, msg string, edit bool) error {
+	file, err := os.CreateTemp("", "castor-commit-*.txt")
+	if err != nil {
+		return fmt.Errorf("failed to write commit message: %w", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(msg + "\n")
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write commit message: %w", err)
+	}
+
+	args := []string{"commit", "-F", file.Name()}
+	if edit {
+		args = append(args, "-e")
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git commit failed: %w", err)
+	}
+	return nil
+}
+
+// git runs a git command in dir and returns its output.
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/tools/fs"
+)
+
+// askRequest is what `castor ask` sends the daemon, as one JSON line. The
+// daemon answers with the events of the run in the stream-json format,
+// ending with the result.
+type askRequest struct {
+	Prompt string `json:"prompt"`
+}
+
+// defaultSocket is where the daemon of a workspace listens.
+func defaultSocket(workspace string) string {
+	return filepath.Join(workspace, ".castor", "daemon.sock")
+}
+
+// runDaemon implements `castor daemon`, which sets the agent up once and
+// answers prompts from `castor ask` over a Unix socket, so each prompt
+// skips connecting to the provider and the MCP servers.
+func runDaemon(args []string) {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	f := addAgentFlags(flags)
+	socket := flags.String("socket", "", "Unix socket to listen on (defaults to .castor/daemon.sock in the workspace)")
+	flags.Usage = commandUsage(flags, "daemon [flags]", "Keeps the agent and its MCP servers running and answers prompts from castor ask. Each prompt starts a new conversation; prompts are answered one at a time.")
+	parseFlags(flags, args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if *socket == "" {
+		*socket = defaultSocket(f.workspace)
+	}
+
+	ctx := signalContext()
+	a := f.start(ctx, true, false)
+	defer a.close()
+	a.ag.Approve = nil // Nobody answers at the daemon's terminal
+
+	listener, err := listenUnix(*socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(exitError)
+	}
+	defer listener.Close()
+	fmt.Fprintf(os.Stderr, "Listening on %s\n", *socket)
+
+	// Each prompt gets a copy of the agent with a fresh history, and the
+	// tools are not safe to run from several prompts at once.
+	base := slices.Clone(a.ag.History)
+	var mu sync.Mutex
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+	for {
+		conn, err := listener.Accept()
+		if ctx.Err() != nil {
+			return // Interrupted; the deferred calls shut the daemon down
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(exitError)
+		}
+		go func() {
+			defer conn.Close()
+			mu.Lock()
+			defer mu.Unlock()
+			ag := *a.ag
+			ag.History = slices.Clone(base)
+			ag.Usage = slices.Clone(a.ag.Usage)
+			serveAsk(ctx, &ag, conn)
+		}()
+	}
+}
+
+// listenUnix listens on the socket at path, replacing a socket left behind
+// by a daemon that is no longer running.
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a daemon is already listening on %s", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	// Prompts run tools in the workspace, so only the owner may send them
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict socket: %w", err)
+	}
+	return listener, nil
+}
+
+// serveAsk answers one request from conn. The run is cancelled if the
+// client goes away.
+func serveAsk(ctx context.Context, ag *agent.Agent, conn net.Conn) {
+	out := newJSONOutput(conn, "stream-json")
+	var req askRequest
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&req); err != nil || strings.TrimSpace(req.Prompt) == "" {
+		out.err = "expected a JSON request with a prompt"
+		out.finish()
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		// The client sends nothing more, so a read ends when it hangs up
+		io.Copy(io.Discard, conn)
+		cancel()
+	}()
+
+	stream, err := ag.Chat(ctx, req.Prompt)
+	if err != nil {
+		out.err = err.Error()
+		out.finish()
+		return
+	}
+	for event := range stream {
+		out.record(event)
+	}
+	out.finish()
+}
+
+// runAsk implements `castor ask`, which sends a prompt to the workspace's
+// daemon and prints the reply like `castor run`.
+func runAsk(args []string) {
+	flags := flag.NewFlagSet("ask", flag.ExitOnError)
+	workspace := flags.String("w", ".", "Workspace root directory, whose daemon to ask")
+	socket := flags.String("socket", "", "Unix socket of the daemon (defaults to .castor/daemon.sock in the workspace)")
+	output := flags.String("output", "text", "Output format: text, json (one result object), or stream-json (an event per line)")
+	quiet := flags.Bool("q", false, "Print only the final reply, without tool calls or intermediate text")
+	var files, dirs []string
+	flags.Func("file", "File to include in the prompt; may be repeated", appendFlag(&files))
+	flags.Func("dir", "Directory whose text files to include in the prompt, skipping ignored files; may be repeated", appendFlag(&dirs))
+	flags.Usage = commandUsage(flags, `ask [flags] "<prompt>"`, "Sends one prompt to a running castor daemon and prints the reply as it streams in.")
+	parseFlags(flags, args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if !slices.Contains(outputFormats, *output) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -output %q (expected %s)\n", *output, strings.Join(outputFormats, ", "))
+		os.Exit(exitUsage)
+	}
+	if *socket == "" {
+		*socket = defaultSocket(*workspace)
+	}
+
+	prompt := strings.Join(flags.Args(), " ")
+	attached, err := attachments(files, dirs, fs.DefaultMaxReadBytes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if attached != "" {
+		prompt += "\n\n" + attached
+	}
+
+	conn, err := net.Dial("unix", *socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: no daemon is listening on %s; start one with castor daemon\n", *socket)
+		os.Exit(exitError)
+	}
+	if err := json.NewEncoder(conn).Encode(askRequest{Prompt: prompt}); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	code := printAnswer(conn, *output, *quiet)
+	conn.Close()
+	os.Exit(code)
+}
+
+// printAnswer prints the events the daemon sends in the given output
+// format, and returns the exit code of the run.
+func printAnswer(r io.Reader, output string, quiet bool) int {
+	dec := json.NewDecoder(r)
+	enc := json.NewEncoder(os.Stdout)
+	var calls []jsonEvent
+	var status runStatus
+	for {
+		var e jsonEvent
+		if err := dec.Decode(&e); err != nil {
+			fmt.Fprintf(os.Stderr, "\nError: daemon closed the connection: %v\n", err)
+			return exitError
+		}
+		switch e.Type {
+		case "tool_result":
+			status.toolFailed = status.toolFailed || e.Error != ""
+			status.denied = status.denied || e.Denied
+		case "turn_limit":
+			status.turnLimit = true
+		case "error":
+			status.providerErr = true
+		}
+
+		switch output {
+		case "stream-json":
+			enc.Encode(e)
+		case "json":
+			if e.Type == "tool_result" {
+				calls = append(calls, e)
+			}
+			if e.Type == "result" {
+				e.ToolCalls = calls
+				enc.Encode(e)
+			}
+		default:
+			switch e.Type {
+			case "text":
+				if !quiet {
+					fmt.Print(e.Text)
+				}
+			case "tool_call":
+				if !quiet {
+					fmt.Printf("\n[Tool Call: %s(%v)]\n", e.Name, e.Args)
+				}
+			case "turn_limit":
+				fmt.Fprintf(os.Stderr, "\n[Stopped at the limit of %d turns; raise it with -max-turns]\n", e.TurnLimit)
+			case "result":
+				if quiet {
+					fmt.Print(strings.TrimSpace(e.Text))
+				}
+				if e.Error == "" {
+					fmt.Println()
+				}
+			}
+		}
+
+		if e.Type == "result" {
+			if e.Error == "" {
+				return status.exitCode()
+			}
+			fmt.Fprintf(os.Stderr, "\nError: %s\n", e.Error)
+			if status.providerErr {
+				return exitProvider
+			}
+			return exitError // The daemon could not start the run
+		}
+	}
+}
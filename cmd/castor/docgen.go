@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/tools/workspace"
+)
+
+// docGenStages are the progress lines that start the stages of
+// documentation generation.
+var docGenStages = map[string]string{
+	"document": "Documenting",
+}
+
+// runDocGen implements `castor docgen`, which drafts the doc comments and
+// README of a package and prints them as a patch.
+func runDocGen(args []string) {
+	flags := flag.NewFlagSet("docgen", flag.ExitOnError)
+	f := addAgentFlags(flags)
+	write := flags.Bool("write", false, "Write the changes to the files instead of printing them as a patch")
+	noReadme := flags.Bool("no-readme", false, "Leave the package's README.md alone")
+	quiet := flags.Bool("quiet", false, "Do not show the progress and summary on stderr")
+	format := flags.String("format", "text", "Output format: text (the patch, or with -write a summary) or json")
+	flags.Usage = commandUsage(flags, "docgen [flags] <package-dir>",
+		"Documents a package: drafts doc comments for its exported symbols, fixes those that are out of date, "+
+			"and drafts or updates its README.md. The changes are kept in memory and printed as a patch to review and apply with git apply; "+
+			"-write writes them instead. In Go packages the exported symbols are found by parsing the code; elsewhere the model finds them.")
+	parseFlags(flags, args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if !slices.Contains(fixFormats, *format) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -format %q (expected %s)\n", *format, strings.Join(fixFormats, ", "))
+		os.Exit(exitUsage)
+	}
+	if *write && f.dryRun {
+		fmt.Fprintln(os.Stderr, "Error: -write conflicts with -dry-run")
+		os.Exit(exitUsage)
+	}
+
+	target := flags.Arg(0)
+	root, _ := filepath.EvalSymlinks(f.workspace)
+	root, _ = filepath.Abs(root)
+	dir, err := workspace.Resolve(root, target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s is not a file or directory in the workspace\n", target)
+		os.Exit(exitUsage)
+	}
+	if !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	rel, _ := filepath.Rel(root, dir)
+	symbols, err := goSymbols(root, dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+
+	// Unless the changes are to be written, they are made in a dry run
+	f.dryRun = !*write
+	ctx := signalContext()
+	a := f.start(ctx, true, false)
+	defer a.close()
+	gen := &agent.DocGenerator{Agent: a.ag, Readme: !*noReadme}
+	if a.overlay != nil {
+		// The patch is the output, rather than the report of a dry run
+		gen.FS = a.overlay
+		a.overlay = nil
+	}
+	if !*quiet {
+		fmt.Fprintf(os.Stderr, "📝 Documenting: %s\n", rel)
+	}
+
+	progress := make(chan agent.WorkflowEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range progress {
+			printWorkflowEvent(os.Stderr, event, docGenStages, "")
+		}
+	}()
+	if !*quiet {
+		gen.Progress = progress
+	}
+	report, err := gen.Generate(ctx, rel, symbols)
+	close(progress)
+	<-done
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: documentation failed: %v\n", err)
+		a.close()
+		exit(exitError)
+	}
+
+	if !*quiet && *format == "text" {
+		fmt.Fprintf(os.Stderr, "%s\n", report.Summary)
+	}
+	if *format == "text" && len(report.FilesChanged) == 0 {
+		fmt.Fprintln(os.Stderr, "No files were changed.")
+		return
+	}
+	printDocGen(os.Stdout, report, *format, *write)
+}
+
+// printDocGen writes the report of documentation generation in the given
+// format. In text, that is the patch, unless the changes were written.
+func printDocGen(w io.Writer, report *agent.DocGenReport, format string, written bool) {
+	switch {
+	case format == "json":
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Fprintln(w, string(data))
+	case written:
+		fmt.Fprintf(w, "Wrote %s\n", strings.Join(report.FilesChanged, ", "))
+	default:
+		fmt.Fprint(w, report.Diff)
+	}
+}
+
+// goSymbols returns the exported symbols of the Go package in dir, with
+// their files relative to root, or none if dir has no Go files. Test
+// files are left out.
+func goSymbols(root, dir string) ([]agent.DocSymbol, error) {
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	fset := token.NewFileSet()
+	var symbols []agent.DocSymbol
+	add := func(name, kind string, pos token.Pos, documented bool) {
+		p := fset.Position(pos)
+		rel, _ := filepath.Rel(root, p.Filename)
+		symbols = append(symbols, agent.DocSymbol{Name: name, Kind: kind, File: filepath.ToSlash(rel), Line: p.Line, Documented: documented})
+	}
+
+	pkg := -1 // The package's symbol, documented by any file's comment
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if pkg < 0 {
+			pkg = len(symbols)
+			add(file.Name.Name, "package", file.Package, false)
+		}
+		if file.Doc != nil {
+			symbols[pkg].Documented = true
+		}
+
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if !d.Name.IsExported() {
+					continue
+				}
+				if d.Recv == nil {
+					add(d.Name.Name, "func", d.Pos(), d.Doc != nil)
+				} else if recv := receiverName(d.Recv.List[0].Type); ast.IsExported(recv) {
+					add(recv+"."+d.Name.Name, "method", d.Pos(), d.Doc != nil)
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						if s.Name.IsExported() {
+							add(s.Name.Name, "type", s.Pos(), s.Doc != nil || d.Doc != nil)
+						}
+					case *ast.ValueSpec:
+						for _, name := range s.Names {
+							if name.IsExported() {
+								add(name.Name, d.Tok.String(), name.Pos(), s.Doc != nil || d.Doc != nil)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return symbols, nil
+}
+
+// receiverName returns the name of the type of a method receiver.
+func receiverName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(e.X)
+	case *ast.IndexExpr:
+		return receiverName(e.X)
+	case *ast.IndexListExpr:
+		return receiverName(e.X)
+	case *ast.Ident:
+		return e.Name
+	}
+	return ""
+}
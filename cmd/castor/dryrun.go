@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/diff"
+	"github.com/techmuch/castor/pkg/tools/edit"
+	"github.com/techmuch/castor/pkg/tools/workspace"
+	"github.com/techmuch/castor/pkg/vfs"
+)
+
+// dryRunPrompt is added to the system prompt with -dry-run, so the model
+// knows its changes are only previewed.
+const dryRunPrompt = "This is a dry run. File edits are kept in memory to preview them and are discarded afterwards, and other tools that would change something are not run."
+
+// dryRunTool stands in for a tool that may change something outside the
+// workspace files, reporting the call instead of making it.
+type dryRunTool struct {
+	agent.Tool
+}
+
+// ReadOnly reports true, as the call is never made.
+func (t dryRunTool) ReadOnly() bool { return true }
+
+func (t dryRunTool) Execute(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	data, _ := json.Marshal(args)
+	return fmt.Sprintf("Dry run: %s was not called. It would have been called with %s.", t.Name(), data), nil
+}
+
+// stubTools replaces the tools that may change something, other than
+// those named in keep, with dryRunTools.
+func stubTools(ag *agent.Agent, keep map[string]bool) {
+	for name, tool := range ag.Tools {
+		if ro, ok := tool.(agent.ReadOnly); keep[name] || ok && ro.ReadOnly() {
+			continue
+		}
+		ag.Tools[name] = dryRunTool{tool}
+	}
+}
+
+// reportDryRun prints a diff of each workspace file the run would have
+// changed. The undo journal is left out, as it only backs up the changes.
+func reportDryRun(w io.Writer, overlay *vfs.Overlay, root string) {
+	absRoot, _ := filepath.Abs(root)
+	undoDir := edit.UndoDir(root)
+	var patches []string
+	for _, path := range overlay.Changes() {
+		if workspace.Contains(undoDir, path) {
+			continue
+		}
+		rel, err := filepath.Rel(absRoot, path)
+		if err != nil {
+			rel = path
+		}
+		from, to := "a/"+filepath.ToSlash(rel), "b/"+filepath.ToSlash(rel)
+		before, err := vfs.OS.ReadFile(path)
+		if err != nil {
+			from = "/dev/null"
+		}
+		after, err := overlay.ReadFile(path)
+		if err != nil {
+			to = "/dev/null"
+		}
+		if patch := diff.Unified(from, to, string(before), string(after)); patch != "" {
+			patches = append(patches, patch)
+		}
+	}
+
+	if len(patches) == 0 {
+		fmt.Fprintln(w, "[Dry run: no files would change]")
+		return
+	}
+	fmt.Fprintf(w, "[Dry run: %d file(s) would change; nothing was written]\n", len(patches))
+	for _, patch := range patches {
+		fmt.Fprint(w, patch)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/tools/edit"
+)
+
+// auditLogPath returns the edit audit log for this run. Sessions keep a
+// journal next to the session file so resumed runs append to it; otherwise
+// each run gets its own file under the workspace's .castor/edits directory.
+func auditLogPath(workspace, sessionPath string) string {
+	if sessionPath != "" {
+		return agent.TrimSessionExt(sessionPath) + ".edits.jsonl"
+	}
+	return filepath.Join(edit.AuditDir(workspace), time.Now().Format("20060102-150405")+".jsonl")
+}
+
+// runEdits implements `castor edits`, which lists recorded edits.
+func runEdits(args []string) {
+	fs := flag.NewFlagSet("edits", flag.ExitOnError)
+	workspace := fs.String("w", ".", "Workspace root directory")
+	sessionPath := fs.String("session", "", "Show edits recorded for this session file")
+	logPath := fs.String("log", "", "Path to a specific edit audit log")
+	pathFilter := fs.String("path", "", "Only show edits to files containing this substring")
+	showDiff := fs.Bool("diff", false, "Include diffs in the output")
+	asJSON := fs.Bool("json", false, "Print entries as JSON lines")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: castor edits [flags]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	var logs []string
+	switch {
+	case *logPath != "":
+		logs = []string{*logPath}
+	case *sessionPath != "":
+		logs = []string{auditLogPath(*workspace, *sessionPath)}
+	default:
+		matches, _ := filepath.Glob(filepath.Join(edit.AuditDir(*workspace), "*.jsonl"))
+		sort.Strings(matches)
+		logs = matches
+	}
+	if len(logs) == 0 {
+		fmt.Println("No edits recorded.")
+		return
+	}
+
+	count := 0
+	for _, log := range logs {
+		entries, err := edit.ReadAuditLog(log)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", log, err)
+			os.Exit(exitError)
+		}
+		for _, e := range entries {
+			if *pathFilter != "" && !strings.Contains(e.Path, *pathFilter) {
+				continue
+			}
+			count++
+			if *asJSON {
+				data, _ := json.Marshal(e)
+				fmt.Println(string(data))
+				continue
+			}
+			before := shortHash(e.BeforeHash)
+			if e.BeforeHash == "" {
+				before = "(new)"
+			}
+			fmt.Printf("%s  %-40s %s -> %s  %s\n", e.Time.Format(time.RFC3339), e.Path, before, shortHash(e.AfterHash), e.ToolCallID)
+			if *showDiff {
+				fmt.Println(e.Diff)
+			}
+		}
+	}
+	if count == 0 && !*asJSON {
+		fmt.Println("No edits recorded.")
+	}
+}
+
+// shortHash abbreviates a content hash for display. Hashes in a
+// hand-edited or truncated log may be shorter than usual.
+func shortHash(h string) string {
+	return h[:min(12, len(h))]
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// Exit codes of castor, so scripts can tell why a run failed. They are
+// listed in the README; keep the two in step.
+const (
+	exitOK          = 0
+	exitError       = 1   // Any other failure, such as an unreadable config
+	exitUsage       = 2   // Bad flags or arguments
+	exitProvider    = 3   // The model provider returned an error
+	exitToolFailed  = 4   // The run finished, but a tool call failed
+	exitTurnLimit   = 5   // The run stopped at the turn limit
+	exitDenied      = 6   // The user denied an action that needed approval
+	exitUnfixed     = 7   // castor fix or gen-tests gave up with the tests still failing, or refactor left out a step
+	exitInterrupted = 130 // Stopped by Ctrl+C or SIGTERM
+)
+
+// runStatus collects what a run's exit code depends on.
+type runStatus struct {
+	providerErr bool
+	toolFailed  bool
+	turnLimit   bool
+	denied      bool
+}
+
+// exitCode returns the code for the run, reporting the most serious
+// problem: a provider error stopped the run early, while the other
+// problems leave a reply behind.
+func (s runStatus) exitCode() int {
+	switch {
+	case s.providerErr:
+		return exitProvider
+	case s.denied:
+		return exitDenied
+	case s.turnLimit:
+		return exitTurnLimit
+	case s.toolFailed:
+		return exitToolFailed
+	}
+	return exitOK
+}
+
+// interrupted is set once Ctrl+C or SIGTERM has canceled the run.
+var interrupted atomic.Bool
+
+// signalContext returns a context that Ctrl+C or SIGTERM cancels. The run
+// then stops and takes its usual way out, saving the session and shutting
+// the MCP servers down, and exit turns its code into exitInterrupted. A
+// second signal ends the process at once.
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		interrupted.Store(true)
+		cancel()
+		<-signals
+		os.Exit(exitInterrupted)
+	}()
+	return ctx
+}
+
+// exit ends the process with the code of the run.
+func exit(code int) {
+	os.Exit(interruptedCode(code, interrupted.Load()))
+}
+
+// interruptedCode returns code, or exitInterrupted if the run was
+// interrupted: whatever went wrong after that was caused by stopping it.
+func interruptedCode(code int, interrupted bool) int {
+	if interrupted {
+		return exitInterrupted
+	}
+	return code
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		status runStatus
+		want   int
+	}{
+		{"OK", runStatus{}, exitOK},
+		{"ToolFailed", runStatus{toolFailed: true}, exitToolFailed},
+		{"TurnLimit", runStatus{toolFailed: true, turnLimit: true}, exitTurnLimit},
+		{"Denied", runStatus{toolFailed: true, turnLimit: true, denied: true}, exitDenied},
+		{"Provider", runStatus{providerErr: true, toolFailed: true, turnLimit: true, denied: true}, exitProvider},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.status.exitCode(); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterruptedCode(t *testing.T) {
+	for _, code := range []int{exitOK, exitError, exitProvider, exitUnfixed} {
+		if got := interruptedCode(code, false); got != code {
+			t.Errorf("interruptedCode(%d, false) = %d", code, got)
+		}
+		if got := interruptedCode(code, true); got != exitInterrupted {
+			t.Errorf("interruptedCode(%d, true) = %d, want %d", code, got, exitInterrupted)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/project"
+)
+
+// fixFormats are the values of fix -format.
+var fixFormats = []string{"text", "json"}
+
+// runFix implements `castor fix`, which investigates a bug, plans a fix,
+// makes it, and runs the tests until they pass.
+func runFix(args []string) {
+	flags := flag.NewFlagSet("fix", flag.ExitOnError)
+	f := addAgentFlags(flags)
+	testCmd := flags.String("test", "", "Shell command that runs the tests (defaults to the project's, such as go test ./...)")
+	testTimeout := flags.Duration("test-timeout", 10*time.Minute, "How long a test run may take")
+	attempts := flags.Int("attempts", agent.DefaultFixAttempts, "Test runs before giving up with the tests still failing")
+	quiet := flags.Bool("quiet", false, "Do not show the fix's progress on stderr")
+	format := flags.String("format", "text", "Output format: text (summary, plan, test result, and diff) or json")
+	flags.Usage = commandUsage(flags, "fix [flags] <bug description>",
+		"Fixes a bug: investigates where it is, plans a fix, edits the code, and runs the tests, "+
+			"feeding failures back to the model until they pass or -attempts is reached. "+
+			"Prints a summary of the change and its diff. Exits with 7 if the tests still fail.")
+	parseFlags(flags, args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if !slices.Contains(fixFormats, *format) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -format %q (expected %s)\n", *format, strings.Join(fixFormats, ", "))
+		os.Exit(exitUsage)
+	}
+	if *attempts < 1 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -attempts %d (expected at least 1)\n", *attempts)
+		os.Exit(exitUsage)
+	}
+	if f.dryRun {
+		fmt.Fprintln(os.Stderr, "Error: fix cannot be run with -dry-run, as the tests run against the files on disk")
+		os.Exit(exitUsage)
+	}
+	if *testCmd == "" {
+		if info, err := project.Detect(f.workspace); err == nil {
+			for _, c := range info.Commands {
+				if c.Purpose == "test" {
+					*testCmd = c.Run
+				}
+			}
+		}
+		if *testCmd == "" {
+			fmt.Fprintln(os.Stderr, "Error: no test command found for the project; set one with -test")
+			os.Exit(exitUsage)
+		}
+	}
+
+	ctx := signalContext()
+	a := f.start(ctx, true, false)
+	defer a.close()
+
+	bug := strings.Join(flags.Args(), " ")
+	fixer := &agent.Fixer{
+		Agent:       a.ag,
+		MaxAttempts: *attempts,
+		Test:        shellTest(a.workspace, *testCmd, *testTimeout),
+	}
+	if !*quiet {
+		fmt.Fprintf(os.Stderr, "🔧 Fixing: %s\n", bug)
+	}
+
+	progress := make(chan agent.WorkflowEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range progress {
+			printWorkflowEvent(os.Stderr, event, fixStages, *testCmd)
+		}
+	}()
+	if !*quiet {
+		fixer.Progress = progress
+	}
+	report, err := fixer.Fix(ctx, bug)
+	close(progress)
+	<-done
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: fix failed: %v\n", err)
+		a.close()
+		exit(exitError)
+	}
+
+	printFix(os.Stdout, report, *format)
+	if !report.Tests.Passed {
+		a.close()
+		exit(exitUnfixed)
+	}
+}
+
+// shellTest returns a test function that runs command with the shell in
+// dir, stopping it after timeout.
+func shellTest(dir, command string, timeout time.Duration) agent.TestFunc {
+	return func(ctx context.Context) (string, bool, error) {
+		return runShell(ctx, dir, command, timeout)
+	}
+}
+
+// runShell runs command with the shell in dir, reporting whether it
+// exited with zero. Its output is stdout and stderr combined.
+func runShell(ctx context.Context, dir, command string, timeout time.Duration) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return fmt.Sprintf("%s\n[The command took longer than %s and was stopped.]", out, timeout), false, nil
+	case errors.As(err, &exitErr):
+		return string(out), false, nil
+	case err != nil:
+		return "", false, err
+	}
+	return string(out), true, nil
+}
+
+// fixStages are the progress lines that start the stages of a fix.
+var fixStages = map[string]string{
+	"investigate": "Investigating the bug",
+	"plan":        "Planning the fix",
+	"edit":        "Editing",
+}
+
+// printWorkflowEvent writes a line of the activity feed of a workflow
+// that edits code and runs the tests. stages are the lines that start its
+// stages, other than testing; in stages taken step by step, they are
+// followed by the step's number.
+func printWorkflowEvent(w io.Writer, event agent.WorkflowEvent, stages map[string]string, testCmd string) {
+	switch {
+	case event.Investigation != nil:
+		printInvestigationEvent(w, *event.Investigation)
+	case len(event.Plan) > 0:
+		for i, step := range event.Plan {
+			fmt.Fprintf(w, "  %d. %s\n", i+1, truncateLine(step, 160))
+		}
+	case event.Started != nil:
+		fmt.Fprintf(w, "  → %s\n", truncateLine(fmt.Sprintf("%s(%v)", event.Started.Name, event.Started.Args), 160))
+	case event.Result != nil && event.Result.Err != nil:
+		fmt.Fprintf(w, "  ✗ %s: %v\n", event.Result.Tool, event.Result.Err)
+	case event.Result != nil:
+	case event.Test != nil && event.Test.Passed:
+		fmt.Fprintln(w, "  ✓ The tests pass")
+	case event.Test != nil:
+		fmt.Fprintln(w, "  ✗ The tests fail")
+	case event.Stage == "rollback" && len(event.Restored) > 0:
+		fmt.Fprintf(w, "  ↩ Rolled back %s\n", strings.Join(event.Restored, ", "))
+	case event.Stage == "rollback":
+		fmt.Fprintln(w, "  ↩ Rolled back")
+	case event.Stage == "test":
+		fmt.Fprintf(w, "Testing: %s\n", testCmd)
+	case event.Step > 0:
+		fmt.Fprintf(w, "%s %d (attempt %d)\n", stages[event.Stage], event.Step, event.Attempt)
+	case event.Attempt > 0:
+		fmt.Fprintf(w, "%s (attempt %d)\n", stages[event.Stage], event.Attempt)
+	default:
+		fmt.Fprintln(w, stages[event.Stage])
+	}
+}
+
+// printFix writes the report of a fix in the given format.
+func printFix(w io.Writer, report *agent.FixReport, format string) {
+	if format == "json" {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Fprintln(w, string(data))
+		return
+	}
+
+	fmt.Fprintln(w, report.Summary)
+	fmt.Fprintln(w, "\nPlan:")
+	for i, step := range report.Plan {
+		fmt.Fprintf(w, "  %d. %s\n", i+1, step)
+	}
+	if report.Tests.Passed {
+		fmt.Fprintf(w, "\nThe tests pass after %d attempt(s).\n", report.Attempts)
+	} else {
+		fmt.Fprintf(w, "\nThe tests still fail after %d attempt(s):\n\n%s\n", report.Attempts, strings.TrimSpace(report.Tests.Output))
+	}
+	if len(report.FilesChanged) == 0 {
+		fmt.Fprintln(w, "\nNo files were changed.")
+		return
+	}
+	fmt.Fprintf(w, "\nChanged %s:\n\n%s", strings.Join(report.FilesChanged, ", "), report.Diff)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/project"
+	"github.com/techmuch/castor/pkg/tools/workspace"
+)
+
+// genTestsStages are the progress lines that start the stages of test
+// generation.
+var genTestsStages = map[string]string{
+	"analyze": "Analyzing the code",
+	"edit":    "Writing tests",
+}
+
+// goCoverage matches the coverage go test -cover reports for a package.
+var goCoverage = regexp.MustCompile(`coverage: ([0-9.]+)% of statements`)
+
+// runGenTests implements `castor gen-tests`, which writes tests for a file
+// or package and runs them until they pass.
+func runGenTests(args []string) {
+	flags := flag.NewFlagSet("gen-tests", flag.ExitOnError)
+	f := addAgentFlags(flags)
+	testCmd := flags.String("test", "", "Shell command that runs the target's tests (defaults to go test for the target's package in Go projects)")
+	testTimeout := flags.Duration("test-timeout", 10*time.Minute, "How long a test run may take")
+	attempts := flags.Int("attempts", agent.DefaultTestGenAttempts, "Test runs before giving up with the tests still failing")
+	quiet := flags.Bool("quiet", false, "Do not show the progress on stderr")
+	format := flags.String("format", "text", "Output format: text (summary, cases, test result, coverage, and diff) or json")
+	flags.Usage = commandUsage(flags, "gen-tests [flags] <file-or-package>",
+		"Writes tests for a file or package: analyzes the code and proposes the cases it lacks, writes them to test files, "+
+			"and runs them, repairing failures until they pass or -attempts is reached. Only test files are changed. "+
+			"Prints what was written, its diff, and, in Go projects, the change in the package's coverage. Exits with 7 if the tests still fail.")
+	parseFlags(flags, args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if !slices.Contains(fixFormats, *format) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -format %q (expected %s)\n", *format, strings.Join(fixFormats, ", "))
+		os.Exit(exitUsage)
+	}
+	if *attempts < 1 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -attempts %d (expected at least 1)\n", *attempts)
+		os.Exit(exitUsage)
+	}
+	if f.dryRun {
+		fmt.Fprintln(os.Stderr, "Error: gen-tests cannot be run with -dry-run, as the tests run against the files on disk")
+		os.Exit(exitUsage)
+	}
+
+	target := flags.Arg(0)
+	root, _ := filepath.EvalSymlinks(f.workspace)
+	root, _ = filepath.Abs(root)
+	path, err := workspace.Resolve(root, target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s is not a file or directory in the workspace\n", target)
+		os.Exit(exitUsage)
+	}
+	// Go tests are run, and coverage measured, for the target's package
+	dir := path
+	if !info.IsDir() {
+		dir = filepath.Dir(path)
+	}
+	pkg := "."
+	if rel, _ := filepath.Rel(root, dir); rel != "." {
+		pkg = "./" + filepath.ToSlash(rel)
+	}
+
+	gen := &agent.TestGenerator{MaxAttempts: *attempts, IsTestFile: isTestFile}
+	isGo := false
+	if p, err := project.Detect(f.workspace); err == nil {
+		isGo = slices.Contains(p.Languages, "Go")
+	}
+	if isGo {
+		gen.IsTestFile = func(path string) bool { return strings.HasSuffix(path, "_test.go") }
+		gen.Coverage = func(ctx context.Context) (float64, error) {
+			out, _, err := runShell(ctx, root, "go test -cover "+pkg, *testTimeout)
+			if err != nil {
+				return 0, err
+			}
+			return parseGoCoverage(out)
+		}
+		if *testCmd == "" {
+			*testCmd = "go test " + pkg
+		}
+	}
+	if *testCmd == "" {
+		fmt.Fprintln(os.Stderr, "Error: no test command known for the target; set one with -test")
+		os.Exit(exitUsage)
+	}
+	gen.Test = shellTest(root, *testCmd, *testTimeout)
+
+	ctx := signalContext()
+	a := f.start(ctx, true, false)
+	defer a.close()
+	gen.Agent = a.ag
+	if !*quiet {
+		fmt.Fprintf(os.Stderr, "🧪 Writing tests for: %s\n", target)
+	}
+
+	progress := make(chan agent.WorkflowEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range progress {
+			printWorkflowEvent(os.Stderr, event, genTestsStages, *testCmd)
+		}
+	}()
+	if !*quiet {
+		gen.Progress = progress
+	}
+	report, err := gen.Generate(ctx, target)
+	close(progress)
+	<-done
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: test generation failed: %v\n", err)
+		a.close()
+		exit(exitError)
+	}
+
+	printGenTests(os.Stdout, report, *format)
+	if !report.Tests.Passed {
+		a.close()
+		exit(exitUnfixed)
+	}
+}
+
+// isTestFile reports whether path looks like a test file in most
+// languages: its name or a directory it is in mentions tests or specs.
+func isTestFile(path string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(strings.ToLower(path)), "/") {
+		if strings.Contains(part, "test") || strings.Contains(part, "spec") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseGoCoverage reads the coverage from the output of go test -cover
+// for one package. A package without tests has none.
+func parseGoCoverage(out string) (float64, error) {
+	if m := goCoverage.FindStringSubmatch(out); m != nil {
+		return strconv.ParseFloat(m[1], 64)
+	}
+	if strings.Contains(out, "[no test files]") {
+		return 0, nil
+	}
+	return 0, fmt.Errorf("go test reported no coverage")
+}
+
+// printGenTests writes the report of test generation in the given format.
+func printGenTests(w io.Writer, report *agent.TestGenReport, format string) {
+	if format == "json" {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Fprintln(w, string(data))
+		return
+	}
+
+	fmt.Fprintln(w, report.Summary)
+	fmt.Fprintln(w, "\nCases:")
+	for i, c := range report.Cases {
+		fmt.Fprintf(w, "  %d. %s\n", i+1, c)
+	}
+	if report.Tests.Passed {
+		fmt.Fprintf(w, "\nThe tests pass after %d attempt(s).\n", report.Attempts)
+	} else {
+		fmt.Fprintf(w, "\nThe tests still fail after %d attempt(s):\n\n%s\n", report.Attempts, strings.TrimSpace(report.Tests.Output))
+	}
+	if report.CoverageBefore != nil && report.CoverageAfter != nil {
+		fmt.Fprintf(w, "Coverage: %.1f%% → %.1f%% (%+.1f)\n", *report.CoverageBefore, *report.CoverageAfter, *report.CoverageAfter-*report.CoverageBefore)
+	}
+	if len(report.FilesChanged) == 0 {
+		fmt.Fprintln(w, "\nNo files were changed.")
+		return
+	}
+	fmt.Fprintf(w, "\nChanged %s:\n\n%s", strings.Join(report.FilesChanged, ", "), report.Diff)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/project"
+)
+
+// defaultConfig is the .castor/config.json that `castor init` writes.
+var defaultConfig = fmt.Sprintf(`{
+  "maxTurns": %d,
+  "mcpServers": {},
+  "profiles": {}
+}
+`, agent.DefaultMaxTurns)
+
+// castorGitignore keeps castor's per-user state out of version control,
+// leaving the shared config tracked.
+const castorGitignore = `sessions/
+undo/
+edits/
+daemon.sock
+`
+
+// initPrompt asks the agent to write CASTOR.md from what Detect found.
+const initPrompt = `Write the CASTOR.md file of this repository: the instructions every coding agent working here reads first.
+Read the README, build files, and a few source files to learn how the project is built, tested, and organized, and which conventions its code follows.
+Keep it short and specific to this repository, in Markdown with these sections: a one-paragraph overview, Commands (build, test, lint, and format, as exact shell commands), Structure (what the main directories hold), and Conventions (naming, error handling, tests, and anything a newcomer would get wrong).
+Do not change any files. Reply with the contents of CASTOR.md only.
+
+This is what was detected automatically; correct it where the repository says otherwise:
+
+`
+
+// runInit implements `castor init`, which sets up a workspace for castor.
+func runInit(args []string) {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	f := addAgentFlags(flags)
+	force := flags.Bool("force", false, "Overwrite existing files")
+	offline := flags.Bool("offline", false, "Write CASTOR.md from what is detected, without asking the model")
+	flags.Usage = commandUsage(flags, "init [flags]",
+		"Inspects the workspace and writes "+project.InstructionsFile+", the project instructions added to every system prompt, "+
+			"along with a .castor directory holding a default config.json and a .gitignore for castor's local state. "+
+			"The agent drafts "+project.InstructionsFile+" with read-only tools; existing files are kept unless -force is given. "+
+			"With -dry-run the instructions are only printed.")
+	parseFlags(flags, args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	info, err := project.Detect(f.workspace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	instructionsPath := filepath.Join(f.workspace, project.InstructionsFile)
+	_, statErr := os.Stat(instructionsPath)
+	writeInstructions := *force || f.dryRun || os.IsNotExist(statErr)
+
+	instructions := info.Markdown()
+	if writeInstructions && !*offline {
+		ctx := signalContext()
+		a := f.start(ctx, true, false)
+		a.ag.KeepReadOnly()
+		instructions, err = initInstructions(ctx, a.ag, info)
+		a.close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(exitProvider)
+		}
+	}
+	if f.dryRun {
+		fmt.Println(instructions)
+		return
+	}
+
+	castorDir := filepath.Join(f.workspace, ".castor")
+	if err := os.MkdirAll(castorDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to create %s: %v\n", castorDir, err)
+		exit(exitError)
+	}
+	files := []struct {
+		path    string
+		content string
+		write   bool
+	}{
+		{instructionsPath, instructions, writeInstructions},
+		{filepath.Join(castorDir, "config.json"), defaultConfig, true},
+		{filepath.Join(castorDir, ".gitignore"), castorGitignore, true},
+	}
+	for _, file := range files {
+		rel, _ := filepath.Rel(f.workspace, file.path)
+		if _, err := os.Stat(file.path); err == nil && (!*force || !file.write) {
+			fmt.Printf("Kept %s (use -force to overwrite)\n", rel)
+			continue
+		}
+		if err := os.WriteFile(file.path, []byte(strings.TrimRight(file.content, "\n")+"\n"), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to write %s: %v\n", rel, err)
+			exit(exitError)
+		}
+		fmt.Printf("Wrote %s\n", rel)
+	}
+}
+
+// initInstructions has the agent write CASTOR.md, starting from the
+// detected information. Only the text of its last turn is kept, so what
+// it says before reading files is dropped.
+func initInstructions(ctx context.Context, ag *agent.Agent, info *project.Info) (string, error) {
+	stream, err := ag.Chat(ctx, initPrompt+info.Markdown())
+	if err != nil {
+		return "", err
+	}
+	var reply strings.Builder
+	for event := range stream {
+		if event.Error != nil {
+			return "", event.Error
+		}
+		if event.Result != nil {
+			reply.Reset()
+		}
+		reply.WriteString(event.Delta)
+	}
+	instructions := stripFence(reply.String())
+	if instructions == "" {
+		return "", fmt.Errorf("the model returned no instructions")
+	}
+	return instructions, nil
+}
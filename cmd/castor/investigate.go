@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/agent"
+)
+
+// investigateFormats are the values of investigate -format.
+var investigateFormats = []string{"json", "markdown"}
+
+// runInvestigate implements `castor investigate`, which researches a goal
+// and prints the report.
+func runInvestigate(args []string) {
+	flags := flag.NewFlagSet("investigate", flag.ExitOnError)
+	f := addAgentFlags(flags)
+	quiet := flags.Bool("quiet", false, "Do not show the investigation's progress on stderr")
+	rounds := flags.Int("rounds", agent.DefaultInvestigationTurns, "Maximum rounds of the investigation before it gives up without a report")
+	promptFile := flags.String("prompt-file", "", "File with instructions to use instead of the investigator's own; -system is still appended")
+	split := flags.Int("split", 0, "Split the goal into at most this many sub-questions, investigate them in parallel, and combine their reports")
+	format := flags.String("format", "json", "Output format: json, or markdown for a report to read (goal, methodology, findings, evidence with file:line citations, and conclusion)")
+	flags.Usage = commandUsage(flags, "investigate [flags] <goal>",
+		"Runs the investigator loop on a goal and prints its structured report. "+
+			"Its progress (each round, the tools it calls, and what it finds on the way) is shown on stderr meanwhile. "+
+			"The investigation may use the tools chosen by -tools and -disable-tools.")
+	parseFlags(flags, args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if !slices.Contains(investigateFormats, *format) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -format %q (expected %s)\n", *format, strings.Join(investigateFormats, ", "))
+		os.Exit(exitUsage)
+	}
+	if *split < 0 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -split %d\n", *split)
+		os.Exit(exitUsage)
+	}
+	if *rounds < 1 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -rounds %d (expected at least 1)\n", *rounds)
+		os.Exit(exitUsage)
+	}
+	var prompt string
+	if *promptFile != "" {
+		data, err := os.ReadFile(*promptFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to read investigator prompt: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		prompt = strings.TrimSpace(string(data))
+	}
+
+	ctx := signalContext()
+	a := f.start(ctx, true, false)
+	defer a.close()
+
+	goal := strings.Join(flags.Args(), " ")
+	inv := &agent.Investigator{Agent: a.ag, MaxTurns: *rounds, SystemPrompt: prompt, SubQuestions: *split}
+	if !*quiet {
+		fmt.Fprintf(os.Stderr, "🔍 Investigating: %s\n", goal)
+	}
+
+	progress := make(chan agent.InvestigationEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range progress {
+			printInvestigationEvent(os.Stderr, event)
+		}
+	}()
+	if !*quiet {
+		inv.Progress = progress
+	}
+	report, err := inv.Investigate(ctx, goal)
+	close(progress)
+	<-done
+	if err != nil {
+		fmt.Printf("Investigation failed: %v\n", err)
+		a.close()
+		exit(exitError)
+	}
+
+	printInvestigation(os.Stdout, report, *format)
+}
+
+// printInvestigation writes an investigation report in the given format.
+func printInvestigation(w io.Writer, report *agent.InvestigationReport, format string) {
+	if format == "json" {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Fprintln(w, string(data))
+		return
+	}
+
+	fmt.Fprintf(w, "# Investigation: %s\n", report.Goal)
+	if report.Methodology != "" {
+		fmt.Fprintf(w, "\n## Methodology\n\n%s\n", report.Methodology)
+	}
+	fmt.Fprintf(w, "\n## Findings\n\n")
+	if len(report.Findings) == 0 {
+		fmt.Fprintln(w, "None.")
+	}
+	for _, finding := range report.Findings {
+		fmt.Fprintf(w, "- %s\n", finding)
+	}
+	if len(report.SubReports) > 0 {
+		fmt.Fprintf(w, "\n## Sub-questions\n\n")
+		for i, sub := range report.SubReports {
+			fmt.Fprintf(w, "%d. %s\n", i+1, sub.Goal)
+			if sub.Conclusion != "" {
+				fmt.Fprintf(w, "   %s\n", strings.ReplaceAll(strings.TrimSpace(sub.Conclusion), "\n", "\n   "))
+			}
+		}
+	}
+	if len(report.Evidence) > 0 {
+		fmt.Fprintf(w, "\n## Evidence\n")
+		for _, e := range report.Evidence {
+			loc := e.File
+			if e.Lines.Start > 0 {
+				loc += ":" + e.Lines.String()
+			}
+			fmt.Fprintf(w, "\n- `%s`", loc)
+			if e.Note != "" {
+				fmt.Fprintf(w, ": %s", e.Note)
+			}
+			if !e.Verified {
+				fmt.Fprintf(w, " (unverified: %s)", e.Problem)
+			}
+			fmt.Fprintln(w)
+			if e.Snippet != "" {
+				fence := "```"
+				for strings.Contains(e.Snippet, fence) {
+					fence += "`"
+				}
+				fmt.Fprintf(w, "\n  %s\n", fence)
+				for _, line := range strings.Split(strings.TrimRight(e.Snippet, "\n"), "\n") {
+					fmt.Fprintf(w, "  %s\n", line)
+				}
+				fmt.Fprintf(w, "  %s\n", fence)
+			}
+		}
+	}
+	if len(report.FilesExplored) > 0 {
+		fmt.Fprintf(w, "\n## Files explored\n\n")
+		for _, file := range report.FilesExplored {
+			fmt.Fprintf(w, "- `%s`\n", file)
+		}
+	}
+	fmt.Fprintf(w, "\n## Conclusion\n\n%s\n", report.Conclusion)
+}
+
+// printInvestigationEvent writes a line of the activity feed of an
+// investigation.
+func printInvestigationEvent(w io.Writer, event agent.InvestigationEvent) {
+	// Sub-questions are investigated at once, so their lines interleave
+	prefix := ""
+	if event.Question > 0 {
+		prefix = fmt.Sprintf("[%d] ", event.Question)
+	}
+	switch {
+	case len(event.Questions) > 0:
+		fmt.Fprintf(w, "Split into %d sub-questions:\n", len(event.Questions))
+		for i, q := range event.Questions {
+			fmt.Fprintf(w, "  [%d] %s\n", i+1, q)
+		}
+	case event.Started != nil && event.Started.Name == (&agent.ReportTool{}).Name():
+		fmt.Fprintf(w, "  %sWriting the report\n", prefix)
+	case event.Started != nil:
+		fmt.Fprintf(w, "  %s→ %s\n", prefix, truncateLine(fmt.Sprintf("%s(%v)", event.Started.Name, event.Started.Args), 160))
+	case event.Result != nil && event.Result.Err != nil:
+		fmt.Fprintf(w, "  %s✗ %s: %v\n", prefix, event.Result.Tool, event.Result.Err)
+	case event.Result != nil:
+		// What succeeded shows in what the model finds next
+	case event.Finding != "":
+		for _, line := range strings.Split(event.Finding, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				fmt.Fprintf(w, "  %s%s\n", prefix, truncateLine(line, 160))
+			}
+		}
+	default:
+		fmt.Fprintf(w, "%s[Turn %d/%d]\n", prefix, event.Turn, event.MaxTurns)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/techmuch/castor/pkg/tui"
+)
+
+// openLog sets up the logger of the -log-level and -log-file flags. A log
+// file gets JSON records; otherwise they go to stderr as text, or to the
+// /debug panel while the TUI owns the terminal.
+func (f *agentFlags) openLog(a *app, tuiMode bool) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(f.logLevel)); err != nil {
+		return fmt.Errorf("invalid -log-level %q (expected debug, info, warn, or error)", f.logLevel)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch {
+	case f.logFile != "":
+		file, err := os.OpenFile(f.logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		a.logFile = file
+		a.log = slog.New(slog.NewJSONHandler(file, opts))
+	case tuiMode:
+		a.logs = tui.NewLogHandler(level)
+		a.log = slog.New(a.logs)
+	default:
+		a.log = slog.New(slog.NewTextHandler(os.Stderr, opts))
+	}
+	return nil
+}
@@ -1,205 +1,559 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/config"
 	"github.com/techmuch/castor/pkg/llm/openai"
 	"github.com/techmuch/castor/pkg/mcp"
+	"github.com/techmuch/castor/pkg/project"
 	"github.com/techmuch/castor/pkg/tools/edit"
 	"github.com/techmuch/castor/pkg/tools/fs"
 	"github.com/techmuch/castor/pkg/tui"
+	"github.com/techmuch/castor/pkg/vfs"
+	"golang.org/x/term"
 )
 
+// command is a castor subcommand.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+// commands are the subcommands, in the order the usage lists them.
+var commands = []command{
+	{"chat", "Chat with the agent in the terminal UI (the default command)", runChat},
+	{"run", "Run a single prompt and print the reply", runPrompt},
+	{"prompt", "Save prompt templates and run them by name", runPromptTemplates},
+	{"batch", "Run every prompt of a JSON Lines file and write the results", runBatch},
+	{"ask", "Send a prompt to a running daemon and print the reply", runAsk},
+	{"daemon", "Keep the agent and its MCP servers running for castor ask", runDaemon},
+	{"investigate", "Research a goal and print a structured report", runInvestigate},
+	{"fix", "Investigate a bug, fix it, and run the tests until they pass", runFix},
+	{"gen-tests", "Write tests for a file or package and repair them until they pass", runGenTests},
+	{"refactor", "Refactor step by step, rolling back steps that break the tests", runRefactor},
+	{"docgen", "Draft doc comments and a README for a package as a patch", runDocGen},
+	{"tools", "List the tools available to the agent", runTools},
+	{"mcp", "List the configured MCP servers and the tools they offer", runMCP},
+	{"session", "List, show, resume, rename, and delete saved sessions", runSession},
+	{"auth", "Store API keys in the OS keyring", runAuth},
+	{"commit", "Write a commit message for the staged changes and commit them", runCommit},
+	{"review", "Review a diff or pull request and list the findings", runReview},
+	{"init", "Write CASTOR.md and a .castor directory for the workspace", runInit},
+	{"edits", "List the file edits the agent has made", runEdits},
+	{"version", "Print the version and build details", runVersion},
+}
+
 func main() {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	model := flag.String("model", "gpt-3.5-turbo", "LLM model to use")
-	baseURL := flag.String("url", "", "Base URL for OpenAI-compatible API (e.g. http://localhost:11434/v1)")
-	systemPrompt := flag.String("system", "You are a helpful assistant with access to files.", "System prompt")
-	interactive := flag.Bool("i", false, "Interactive mode (REPL)")
-	gui := flag.Bool("tui", false, "Start Terminal UI")
-	workspace := flag.String("w", ".", "Workspace root directory")
-	sessionPath := flag.String("session", "", "Path to session file for persistence")
-	mcpCmd := flag.String("mcp", "", "Command to run an MCP server")
-	investigate := flag.Bool("investigate", false, "Run in investigator mode (requires prompt)")
-	flag.Parse()
-
-	if apiKey == "" {
-		fmt.Println("Error: OPENAI_API_KEY environment variable is required.")
-		os.Exit(1)
-	}
-
-	client := openai.NewClient(*baseURL, apiKey, *model)
-	ag := agent.New(client, *systemPrompt)
-	
-	// Register Tools
-	ag.RegisterTool(&fs.ListDirTool{WorkspaceRoot: *workspace})
-	ag.RegisterTool(&fs.ReadFileTool{WorkspaceRoot: *workspace})
-	ag.RegisterTool(&edit.EditTool{
-		WorkspaceRoot: *workspace,
-		Provider:      client,
-	})
-	
-	ctx := context.Background()
-
-	// Connect to MCP Server
-	if *mcpCmd != "" {
-		parts := strings.Fields(*mcpCmd)
-		if len(parts) > 0 {
-			transport, err := mcp.NewStdioTransport(parts[0], parts[1:])
-			if err != nil {
-				fmt.Printf("Error starting MCP server: %v\n", err)
-				os.Exit(1)
+	args := os.Args[1:]
+	name := "chat"
+	switch {
+	case len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help"):
+		usage(os.Stdout)
+		return
+	case len(args) > 0 && (args[0] == "-version" || args[0] == "--version"):
+		runVersion(args[1:])
+		return
+	case len(args) > 0 && !strings.HasPrefix(args[0], "-"):
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		if len(args) > 0 {
+			if c, ok := findCommand(args[0]); ok {
+				c.run([]string{"-h"})
+				return
 			}
-			defer transport.Close()
+		}
+		usage(os.Stdout)
+		return
+	}
+	c, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q.\n\n", name)
+		usage(os.Stderr)
+		os.Exit(exitUsage)
+	}
+	c.run(args)
+	exit(exitOK) // Commands that return succeeded, unless they were interrupted
+}
 
-			mcpClient := mcp.NewClient(transport)
-			if err := mcpClient.Initialize(ctx); err != nil {
-				fmt.Printf("Error initializing MCP client: %v\n", err)
-				os.Exit(1)
-			}
-			defer mcpClient.Close()
+// findCommand returns the subcommand called name.
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
 
-		
-tools, err := mcpClient.ListTools(ctx)
-			if err != nil {
-				fmt.Printf("Error listing MCP tools: %v\n", err)
-				os.Exit(1)
-			}
+// usage lists the subcommands.
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: castor <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-12s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "castor help <command>" for the flags of a command.`)
+	fmt.Fprintln(w, "Flags may also be set with CASTOR_ variables, such as CASTOR_MODEL for -model")
+	fmt.Fprintln(w, "and CASTOR_MAX_TURNS for -max-turns; -url, -w, and -system use CASTOR_BASE_URL,")
+	fmt.Fprintln(w, "CASTOR_WORKSPACE, and CASTOR_SYSTEM_PROMPT.")
+}
 
-			fmt.Printf("Connected to MCP server. Discovered %d tools:\n", len(tools))
-			for _, t := range tools {
-				ag.RegisterTool(t)
-			}
+// commandUsage returns the usage function of a subcommand's flag set.
+func commandUsage(flags *flag.FlagSet, synopsis, description string) func() {
+	return func() {
+		fmt.Fprintf(flags.Output(), "Usage: castor %s\n\n%s\n\nFlags:\n", synopsis, description)
+		flags.PrintDefaults()
+	}
+}
+
+// envNames are the environment variables of flags whose names are too
+// short to derive one from.
+var envNames = map[string]string{
+	"url":    "CASTOR_BASE_URL",
+	"w":      "CASTOR_WORKSPACE",
+	"system": "CASTOR_SYSTEM_PROMPT",
+}
+
+// envName returns the environment variable that sets the flag called name.
+func envName(name string) string {
+	if env, ok := envNames[name]; ok {
+		return env
+	}
+	return "CASTOR_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// parseFlags sets each flag from its environment variable, if there is
+// one, then parses args, so the command line wins. List flags such as
+// -tools add to the variable's values.
+func parseFlags(flags *flag.FlagSet, args []string) {
+	flags.VisitAll(func(fl *flag.Flag) {
+		env := envName(fl.Name)
+		v, ok := os.LookupEnv(env)
+		if !ok || v == "" {
+			return
+		}
+		if err := flags.Set(fl.Name, v); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid %s: %v\n", env, err)
+			os.Exit(exitUsage)
 		}
+	})
+	flags.Parse(args)
+}
+
+// agentFlags are the flags shared by the commands that set up the agent.
+type agentFlags struct {
+	flags        *flag.FlagSet
+	profile      string
+	model        string
+	baseURL      string
+	systemPrompt string
+	systemFile   string
+	workspace    string
+	sessionPath  string
+	configPath   string
+	mcpCmds      []string
+	mcpConfigs   []string
+	mcpOnly      []string
+	mcpSampling  string
+	approval     string
+	yolo         bool
+	mcpLogLevel  string
+	fixerModel   string
+	fixerURL     string
+	titleModel   string
+	noAutoTitle  bool
+	maxReadBytes int
+	maxTurns     int
+	temperature  *float32
+	topP         *float32
+	maxTokens    int
+	tools        config.ToolFilter
+	noTools      bool
+	dryRun       bool
+	logLevel     string
+	logFile      string
+	logProvider  bool
+}
+
+// addAgentFlags registers the agent flags with a subcommand's flag set.
+func addAgentFlags(flags *flag.FlagSet) *agentFlags {
+	f := &agentFlags{flags: flags}
+	flags.StringVar(&f.profile, "profile", "", "Named profile from the config file to take settings from")
+	flags.StringVar(&f.model, "model", "gpt-3.5-turbo", "LLM model to use")
+	flags.StringVar(&f.baseURL, "url", "", "Base URL for OpenAI-compatible API (e.g. http://localhost:11434/v1)")
+	flags.StringVar(&f.systemPrompt, "system", "You are a helpful assistant with access to files.", "System prompt, or @path to read it from a file")
+	flags.StringVar(&f.systemFile, "system-file", "", "File to read the system prompt from, instead of -system")
+	flags.StringVar(&f.workspace, "w", ".", "Workspace root directory")
+	flags.StringVar(&f.sessionPath, "session", "", "Path to session file for persistence, or the ID or title of a saved session")
+	flags.StringVar(&f.configPath, "config", "", "Path to config file (defaults to .castor/config.json, then the user config dir)")
+	flags.Func("mcp", "Command to run an additional MCP server; may be repeated", appendFlag(&f.mcpCmds))
+	flags.Func("mcp-config", "JSON file with more MCP servers in an mcpServers section; may be repeated", appendFlag(&f.mcpConfigs))
+	flags.Func("mcp-only", "Comma-separated names of the only MCP servers to connect to (-mcp servers are named mcp, mcp-2, ...)", listFlag(&f.mcpOnly))
+	flags.StringVar(&f.mcpSampling, "mcp-sampling", "ask", "How to handle MCP server requests for completions: ask, allow, or deny (ask denies in the TUI)")
+	flags.StringVar(&f.approval, "approve", "", "Which tool calls to confirm before they run: never, mutating, or always (defaults to mutating in chat, never otherwise)")
+	flags.BoolVar(&f.yolo, "yolo", false, "Run every tool call without asking; the same as -approve never")
+	flags.StringVar(&f.mcpLogLevel, "mcp-log-level", "warning", "Minimum severity of MCP server log messages: debug, info, notice, warning, error, critical, alert, or emergency")
+	flags.StringVar(&f.logLevel, "log-level", "info", "Minimum level of castor's log records: debug, info, warn, or error; debug records each model request and tool call")
+	flags.StringVar(&f.logFile, "log-file", "", "File to append log records to as JSON (defaults to stderr, or the /debug panel in the TUI)")
+	flags.BoolVar(&f.logProvider, "log-provider", false, "Log raw provider requests and responses at debug level")
+	flags.StringVar(&f.fixerModel, "fixer-model", "", "Model used by the edit tool to self-correct failed matches (defaults to -model)")
+	flags.StringVar(&f.fixerURL, "fixer-url", "", "Base URL for the fixer model (defaults to -url)")
+	flags.StringVar(&f.titleModel, "title-model", "", "Model that names new sessions after their first turn (defaults to -model); a small, cheap one will do")
+	flags.BoolVar(&f.noAutoTitle, "no-auto-title", false, "Name new sessions after their first message instead of asking the model")
+	flags.IntVar(&f.maxReadBytes, "max-read-bytes", fs.DefaultMaxReadBytes, "Maximum bytes returned by a single read_file call")
+	flags.Func("temperature", fmt.Sprintf("Sampling temperature, e.g. 0 for deterministic edits (default %g)", agent.DefaultTemperature), floatFlag(&f.temperature))
+	flags.Func("top-p", "Nucleus sampling probability mass (defaults to the provider's)", floatFlag(&f.topP))
+	flags.IntVar(&f.maxTokens, "max-tokens", 0, "Maximum tokens generated per model request (defaults to the provider's)")
+	flags.Func("tools", "Comma-separated tools the agent may use, by name or pattern such as \"read_*\" (defaults to all)", listFlag(&f.tools.Allow))
+	flags.Func("disable-tools", "Comma-separated tools the agent may not use, by name or pattern", listFlag(&f.tools.Deny))
+	flags.BoolVar(&f.noTools, "no-tools", false, "Give the agent no tools at all")
+	flags.BoolVar(&f.dryRun, "dry-run", false, "Preview changes: file edits are kept in memory and shown as diffs at the end, and other tools that change things are not run")
+	flags.IntVar(&f.maxTurns, "max-turns", 0, fmt.Sprintf("Maximum model requests per message (defaults to maxTurns in the config, then %d)", agent.DefaultMaxTurns))
+	return f
+}
+
+// floatFlag returns a flag parser that sets *v, which stays nil while the
+// flag is not given.
+func floatFlag(v **float32) func(string) error {
+	return func(s string) error {
+		f, err := strconv.ParseFloat(s, 32)
+		if err != nil || f < 0 {
+			return fmt.Errorf("expected a non-negative number")
+		}
+		*v = new(float32)
+		**v = float32(f)
+		return nil
 	}
+}
 
-	// Session Loading
-	if *sessionPath != "" {
-		if _, err := os.Stat(*sessionPath); err == nil {
-			if err := ag.LoadSession(*sessionPath); err != nil {
-				fmt.Printf("Warning: Failed to load session: %v\n", err)
+// listFlag returns a flag parser that adds comma-separated values to
+// *list, so the flag may also be repeated.
+func listFlag(list *[]string) func(string) error {
+	return func(s string) error {
+		for _, v := range strings.Split(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				*list = append(*list, v)
 			}
 		}
+		return nil
 	}
+}
 
-	// Mode Selection
-	if *investigate {
-		args := flag.Args()
-		if len(args) == 0 {
-			fmt.Println("Usage: castor -investigate <goal>")
-			os.Exit(1)
-		}
-		goal := strings.Join(args, " ")
-		inv := &agent.Investigator{Agent: ag}
-		fmt.Printf("🔍 Investigating: %s\n", goal)
-		
-		report, err := inv.Investigate(ctx, goal)
-		if err != nil {
-			fmt.Printf("Investigation failed: %v\n", err)
-			os.Exit(1)
-		}
-		
-		jsonReport, _ := json.MarshalIndent(report, "", "  ")
-		fmt.Println(string(jsonReport))
-		return
+// appendFlag returns a flag parser that adds each use of the flag to *list.
+func appendFlag(list *[]string) func(string) error {
+	return func(s string) error {
+		*list = append(*list, s)
+		return nil
 	}
+}
 
-	if *gui {
-		if err := tui.Run(ag); err != nil {
-			fmt.Printf("Error running TUI: %v\n", err)
-			os.Exit(1)
+// applyProfile takes the settings of the -profile profile for the flags
+// not given on the command line. The profile's tool filter is combined
+// with -tools and -disable-tools.
+func (f *agentFlags) applyProfile(cfg *config.Config) error {
+	if f.profile == "" {
+		return nil
+	}
+	p, ok := cfg.Profiles[f.profile]
+	if !ok {
+		if len(cfg.Profiles) == 0 {
+			return fmt.Errorf("unknown profile %q: the config defines no profiles", f.profile)
 		}
-	} else if *interactive {
-		runInteractive(ctx, ag, *sessionPath)
-	} else {
-		args := flag.Args()
-		if len(args) == 0 {
-			fmt.Println("Usage: castor [flags] <prompt>")
-			os.Exit(1)
+		return fmt.Errorf("unknown profile %q (expected %s)", f.profile, strings.Join(cfg.ProfileNames(), ", "))
+	}
+
+	given := make(map[string]bool)
+	f.flags.Visit(func(fl *flag.Flag) { given[fl.Name] = true })
+	set := func(name string, dst *string, v string) {
+		if v != "" && !given[name] {
+			*dst = v
 		}
-		prompt := strings.Join(args, " ")
-		runOnce(ctx, ag, prompt, *sessionPath)
 	}
+	set("url", &f.baseURL, p.URL)
+	set("model", &f.model, p.Model)
+	set("system", &f.systemPrompt, p.System)
+	set("mcp-sampling", &f.mcpSampling, p.MCPSampling)
+	if !f.yolo {
+		set("approve", &f.approval, p.Approval)
+	}
+	if f.temperature == nil {
+		f.temperature = p.Temperature
+	}
+	if f.maxTurns == 0 {
+		f.maxTurns = p.MaxTurns
+	}
+	if len(f.mcpOnly) == 0 {
+		f.mcpOnly = p.MCPServers
+	}
+	if len(f.tools.Allow) == 0 {
+		f.tools.Allow = p.Tools.Allow
+	}
+	f.tools.Deny = append(f.tools.Deny, p.Tools.Deny...)
+	return nil
 }
 
-func runOnce(ctx context.Context, ag *agent.Agent, prompt string, sessionPath string) {
-	stream, err := ag.Chat(ctx, prompt)
+// system returns the system prompt, reading it from -system-file or from
+// the file named by a -system value starting with "@". A value starting
+// with "@@" stands for itself without the first "@".
+func (f *agentFlags) system() (string, error) {
+	path := f.systemFile
+	switch {
+	case path != "":
+	case strings.HasPrefix(f.systemPrompt, "@@"):
+		return f.systemPrompt[1:], nil
+	case strings.HasPrefix(f.systemPrompt, "@"):
+		path = f.systemPrompt[1:]
+	default:
+		return f.systemPrompt, nil
+	}
+	data, err := os.ReadFile(path)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+		return "", fmt.Errorf("failed to read system prompt: %w", err)
+	}
+	prompt := strings.TrimSpace(string(data))
+	if prompt == "" {
+		return "", fmt.Errorf("system prompt file %s is empty", path)
 	}
+	return prompt, nil
+}
 
-	for event := range stream {
-		if event.Error != nil {
-			fmt.Printf("\nError during generation: %v\n", event.Error)
-			return
-		}
-		if event.Delta != "" {
-			fmt.Print(event.Delta)
-		}
-		if len(event.ToolCalls) > 0 {
-			for _, tc := range event.ToolCalls {
-				fmt.Printf("\n[Tool Call: %s(%v)]\n", tc.Name, tc.Args)
+// app is an agent set up from the agent flags, with its MCP servers.
+type app struct {
+	ag        *agent.Agent
+	cfg       *config.Config
+	conns     []*mcp.Conn
+	log       *slog.Logger
+	logs      *tui.LogHandler // Log records for the /debug panel, in the TUI
+	logFile   *os.File        // Set with -log-file
+	overlay   *vfs.Overlay    // Holds the file changes of a dry run
+	workspace string
+}
+
+// start builds the agent with the built-in tools and the configured MCP
+// servers, and loads the session if there is one. Commands that send
+// prompts set needKey, making a missing API key an error. In tuiMode
+// server logs are kept for the TUI rather than written to stderr. close
+// must be called when done.
+func (f *agentFlags) start(ctx context.Context, needKey, tuiMode bool) *app {
+	a := &app{workspace: f.workspace}
+	if err := f.openLog(a, tuiMode); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	if f.sessionPath != "" {
+		if _, err := os.Stat(f.sessionPath); os.IsNotExist(err) {
+			// Not a file, so perhaps a saved session's ID or title
+			if info, err := sessionStore(f.workspace).Find(f.sessionPath); err == nil {
+				f.sessionPath = info.Path
 			}
 		}
 	}
-	fmt.Println()
 
-	if sessionPath != "" {
-		if err := ag.SaveSession(sessionPath); err != nil {
-			fmt.Printf("Error saving session: %v\n", err)
+	cfg := &config.Config{}
+	if f.configPath != "" {
+		loaded, err := config.Load(f.configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitError)
 		}
+		cfg = loaded
+	} else if found, path, err := config.Find(f.workspace); err != nil {
+		a.log.Warn("ignoring invalid config", "path", path, "error", err)
+	} else {
+		cfg = found
+	}
+	if err := f.applyProfile(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if cfg.Sessions.PruneAfter > 0 && !f.dryRun {
+		autoPrune(a, cfg.Sessions, f.sessionPath)
 	}
-}
 
-func runInteractive(ctx context.Context, ag *agent.Agent, sessionPath string) {
-	scanner := bufio.NewScanner(os.Stdin)
-	fmt.Println("Castor Interactive Mode (Ctrl+C to exit)")
-	fmt.Println("----------------------------------------")
+	system, err := f.system()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+
+	if instructions, err := project.LoadInstructions(f.workspace); err != nil {
+		a.log.Warn("ignoring project instructions", "error", err)
+	} else if instructions != "" {
+		system += "\n\nProject instructions from " + project.InstructionsFile + ":\n\n" + instructions
+	}
+
+	apiKey, err := f.apiKey()
+	if err != nil && needKey {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+
+	a.cfg = cfg
+	var fsys vfs.FS
+	if f.dryRun {
+		a.overlay = vfs.NewOverlay(vfs.OS)
+		fsys = a.overlay
+		system += "\n\n" + dryRunPrompt
+	}
 
-	for {
-		fmt.Print("> ")
-		if !scanner.Scan() {
-			break
+	client := openai.NewClient(f.baseURL, apiKey, f.model)
+	client.Logger = a.log.With("component", "provider")
+	client.Dump = f.logProvider
+	ag := agent.New(client, system)
+	ag.Logger = a.log.With("component", "agent")
+	a.ag = ag
+	ag.Model = f.model
+	ag.Workspace, _ = filepath.Abs(f.workspace)
+	if f.temperature != nil {
+		ag.Options.Temperature = f.temperature
+	}
+	ag.Options.TopP = f.topP
+	if f.maxTokens < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -max-tokens must be positive")
+		os.Exit(exitError)
+	}
+	ag.Options.MaxTokens = f.maxTokens
+	if f.yolo {
+		if f.approval != "" && f.approval != string(agent.ApproveNever) {
+			fmt.Fprintf(os.Stderr, "Error: -yolo conflicts with -approve %s\n", f.approval)
+			os.Exit(exitUsage)
 		}
-		input := scanner.Text()
-		if input == "" {
-			continue
+		f.approval = string(agent.ApproveNever)
+	}
+	if f.approval != "" {
+		policy, err := agent.ParseApprovalPolicy(f.approval)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
 		}
+		ag.Approval = policy
+	}
+	if !tuiMode && term.IsTerminal(int(os.Stdin.Fd())) {
+		// Without a terminal to ask on, calls that need approval are denied
+		ag.Approve = terminalApprover(stdinLines)
+	}
 
-		stream, err := ag.Chat(ctx, input)
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			continue
+	// Register Tools
+	ag.RegisterTool(&fs.ListDirTool{WorkspaceRoot: f.workspace, FS: fsys})
+	ag.RegisterTool(&fs.ReadFileTool{WorkspaceRoot: f.workspace, MaxBytes: f.maxReadBytes, FS: fsys})
+	journal := edit.NewJournal(f.workspace)
+	journal.FS = fsys
+	editor := &edit.EditTool{
+		WorkspaceRoot: f.workspace,
+		Provider:      client,
+		Journal:       journal,
+		FS:            fsys,
+	}
+	if !f.dryRun {
+		editor.Audit = edit.NewAuditLog(auditLogPath(f.workspace, f.sessionPath))
+	}
+	if f.fixerModel != "" || f.fixerURL != "" {
+		fixerBase, fixerName := f.fixerURL, f.fixerModel
+		if fixerBase == "" {
+			fixerBase = f.baseURL
+		}
+		if fixerName == "" {
+			fixerName = f.model
+		}
+		fixer := openai.NewClient(fixerBase, apiKey, fixerName)
+		fixer.Logger = a.log.With("component", "fixer")
+		fixer.Dump = f.logProvider
+		editor.FixerProvider = fixer
+	}
+	ag.RegisterTool(editor)
+	if !f.noAutoTitle {
+		ag.TitleProvider = client
+		if f.titleModel != "" {
+			titler := openai.NewClient(f.baseURL, apiKey, f.titleModel)
+			titler.Logger = a.log.With("component", "titles")
+			titler.Dump = f.logProvider
+			ag.TitleProvider = titler
 		}
+	}
+	ag.RegisterTool(&edit.TransactionTool{Editor: editor})
+	ag.RegisterTool(&edit.UndoTool{WorkspaceRoot: f.workspace, Journal: journal})
+	// The built-in tools change files only through fsys
+	builtin := make(map[string]bool)
+	for name := range ag.Tools {
+		builtin[name] = true
+	}
 
-		for event := range stream {
-			if event.Error != nil {
-				fmt.Printf("\nError: %v\n", event.Error)
-				break
-			}
-			if event.Delta != "" {
-				fmt.Print(event.Delta)
-			}
-			if len(event.ToolCalls) > 0 {
-				for _, tc := range event.ToolCalls {
-					fmt.Printf("\n[Tool Call: %s(%v)]\n", tc.Name, tc.Args)
-				}
-			}
+	switch {
+	case f.maxTurns < 0 || cfg.MaxTurns < 0:
+		fmt.Fprintln(os.Stderr, "Error: the turn limit must be positive")
+		os.Exit(exitError)
+	case f.maxTurns > 0:
+		ag.MaxTurns = f.maxTurns
+	case cfg.MaxTurns > 0:
+		ag.MaxTurns = cfg.MaxTurns
+	}
+	if err := addMCPServers(cfg, f.mcpConfigs, f.mcpCmds, f.mcpOnly); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	// Connect to MCP servers from the config file and -mcp
+	approve, err := samplingApprover(f.mcpSampling, tuiMode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if !mcp.ValidLogLevel(f.mcpLogLevel) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -mcp-log-level %q\n", f.mcpLogLevel)
+		os.Exit(exitError)
+	}
+	setup := func(c *mcp.MCPClient) {
+		if approve != nil {
+			c.EnableSampling(client, f.model, approve)
 		}
-		fmt.Println()
+	}
+	a.conns = connectMCPServers(ctx, ag, cfg, f.workspace, a.log, f.mcpLogLevel, setup)
+
+	if err := f.tools.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	filterTools(ag, f.tools, f.noTools)
+	if f.dryRun {
+		stubTools(ag, builtin)
+	}
 
-		if sessionPath != "" {
-			if err := ag.SaveSession(sessionPath); err != nil {
-				fmt.Printf("Error saving session: %v\n", err)
+	// Session Loading
+	if f.sessionPath != "" {
+		if _, err := os.Stat(f.sessionPath); err == nil {
+			if err := ag.LoadSession(f.sessionPath); err != nil {
+				// Carrying on would overwrite the session with a new one
+				fmt.Fprintf(os.Stderr, "Error: failed to load session %s: %v\nRun castor session repair %s to recover what it holds.\n", f.sessionPath, err, f.sessionPath)
+				a.close()
+				os.Exit(exitError)
 			}
 		}
 	}
-}
\ No newline at end of file
+	return a
+}
+
+// close shuts the MCP servers down and, after a dry run, prints the
+// changes it would have made.
+func (a *app) close() {
+	closeMCPServers(a.conns)
+	if a.overlay != nil {
+		reportDryRun(os.Stderr, a.overlay, a.workspace)
+	}
+	if a.logFile != nil {
+		a.logFile.Close()
+	}
+}
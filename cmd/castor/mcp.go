@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/config"
+	"github.com/techmuch/castor/pkg/mcp"
+	"github.com/techmuch/castor/pkg/secrets"
+)
+
+// mcpHealthInterval is how often connected MCP servers are pinged.
+const mcpHealthInterval = 30 * time.Second
+
+// connectMCPServers starts or dials every enabled server and registers its
+// tools with the agent. Server log messages at logLevel and above (unless a
+// server sets its own) go to logger. setup, if not nil, configures each
+// client before the handshake. A server that fails to connect is reported
+// and skipped so the others remain usable; servers that die later are
+// restarted. The returned connections must be closed on exit.
+func connectMCPServers(ctx context.Context, ag *agent.Agent, cfg *config.Config, workspace string, logger *slog.Logger, logLevel string, setup func(*mcp.MCPClient)) []*mcp.Conn {
+	root, err := mcp.RootFromPath(workspace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error resolving workspace root: %v\n", err)
+		os.Exit(exitError)
+	}
+
+	var conns []*mcp.Conn
+	for _, name := range cfg.EnabledServers() {
+		server := cfg.MCPServers[name]
+		if err := server.Validate(); err != nil {
+			logger.Warn("MCP server unavailable", "mcp_server", name, "error", err)
+			continue
+		}
+
+		level := logLevel
+		if server.LogLevel != "" {
+			level = server.LogLevel
+		}
+		if !mcp.ValidLogLevel(level) {
+			logger.Warn("invalid MCP server logLevel", "mcp_server", name, "log_level", level, "using", logLevel)
+			level = logLevel
+		}
+		serverLog := logger.With("mcp_server", name)
+
+		timeout := time.Duration(server.Timeout)
+		if timeout <= 0 {
+			timeout = mcp.DefaultTimeout
+		}
+		startup := time.Duration(server.StartupTimeout)
+		if startup <= 0 && server.URL == "" {
+			startup = mcp.DefaultStartupTimeout
+		}
+		conn := &mcp.Conn{
+			Name:           name,
+			Dial:           mcpDialer(server, workspace),
+			StartupTimeout: startup,
+			Timeout:        timeout,
+			HealthInterval: mcpHealthInterval,
+			LogLevel:       level,
+			Setup: func(c *mcp.MCPClient) {
+				c.ReadOnlyTools = server.ReadOnly
+				c.SetLogger(serverLog)
+				c.SetRoots(ctx, []mcp.Root{root})
+				if setup != nil {
+					setup(c)
+				}
+			},
+		}
+		if err := conn.Connect(ctx); err != nil {
+			serverLog.Warn("MCP server unavailable", "error", err)
+			continue
+		}
+		conns = append(conns, conn)
+
+		tools := conn.Tools()
+		excluded := 0
+		for _, t := range tools {
+			if !server.Tools.Allowed(t.Name()) {
+				excluded++
+				continue
+			}
+			ag.RegisterTool(t)
+		}
+		serverLog.Info("connected to MCP server", "tools", len(tools), "excluded", excluded)
+	}
+	return conns
+}
+
+// runMCP implements `castor mcp`, which connects to the configured MCP
+// servers and lists the tools each one offers.
+func runMCP(args []string) {
+	flags := flag.NewFlagSet("mcp", flag.ExitOnError)
+	f := addAgentFlags(flags)
+	flags.Usage = commandUsage(flags, "mcp [list] [flags]", "Connects to the configured MCP servers and lists the tools each one offers.")
+	if len(args) > 0 && args[0] == "list" {
+		args = args[1:]
+	}
+	parseFlags(flags, args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	a := f.start(signalContext(), false, false)
+	defer a.close()
+	if len(a.cfg.MCPServers) == 0 {
+		fmt.Println("No MCP servers configured.")
+		return
+	}
+
+	var names []string
+	for name := range a.cfg.MCPServers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		server := a.cfg.MCPServers[name]
+		target := server.URL
+		if target == "" {
+			target = strings.Join(append([]string{server.Command}, server.Args...), " ")
+		}
+		var conn *mcp.Conn
+		for _, c := range a.conns {
+			if c.Name == name {
+				conn = c
+			}
+		}
+		switch {
+		case server.Disabled:
+			fmt.Printf("%s (disabled): %s\n", name, target)
+		case conn == nil:
+			fmt.Printf("%s (unavailable): %s\n", name, target)
+		default:
+			fmt.Printf("%s: %s\n", name, target)
+			for _, t := range conn.Tools() {
+				if server.Tools.Allowed(t.Name()) {
+					description, _, _ := strings.Cut(t.Description(), "\n")
+					fmt.Printf("  %-24s %s\n", t.Name(), description)
+				}
+			}
+		}
+	}
+}
+
+// closeMCPServers shuts all servers down in parallel.
+func closeMCPServers(conns []*mcp.Conn) {
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c *mcp.Conn) {
+			defer wg.Done()
+			c.Close()
+		}(c)
+	}
+	wg.Wait()
+}
+
+// mcpDialer returns how to (re)connect to a server. Remote servers share
+// one authorizer across reconnects so tokens are not requested again.
+func mcpDialer(server config.MCPServer, workspace string) mcp.Dialer {
+	if server.URL == "" {
+		opts := mcp.StdioOptions{Env: server.Environ(), Dir: server.Dir(workspace), MaxMessageSize: server.MaxMessageSize}
+		return func(ctx context.Context) (mcp.Transport, error) {
+			return mcp.NewStdioTransport(server.Command, server.Args, opts)
+		}
+	}
+
+	var auth mcp.Authorizer
+	switch {
+	case server.BearerToken != "":
+		auth = mcp.BearerToken(os.ExpandEnv(server.BearerToken))
+	case server.OAuth != nil:
+		auth = &mcp.OAuth{
+			ServerURL:    server.URL,
+			ClientID:     server.OAuth.ClientID,
+			ClientSecret: os.ExpandEnv(server.OAuth.ClientSecret),
+			Scopes:       server.OAuth.Scopes,
+			Store:        secrets.Keyring{},
+			OpenURL:      openBrowser,
+		}
+	}
+	if mcp.IsWebSocketURL(server.URL) {
+		opts := mcp.WebSocketOptions{Header: server.Header(), Auth: auth, MaxMessageSize: server.MaxMessageSize}
+		return func(ctx context.Context) (mcp.Transport, error) {
+			return mcp.DialWebSocket(ctx, server.URL, opts)
+		}
+	}
+	return func(ctx context.Context) (mcp.Transport, error) {
+		remote := mcp.NewHTTPTransport(server.URL)
+		remote.Header = server.Header()
+		remote.Auth = auth
+		remote.MaxMessageSize = server.MaxMessageSize
+		return remote, nil
+	}
+}
+
+// addMCPServers adds the servers of the -mcp-config files and the -mcp
+// commands to cfg, then disables those not named by -mcp-only when it is
+// given. Servers from later files replace those of the same name. The
+// -mcp servers are named "mcp", "mcp-2", and so on.
+func addMCPServers(cfg *config.Config, files, commands, only []string) error {
+	if cfg.MCPServers == nil {
+		cfg.MCPServers = make(map[string]config.MCPServer)
+	}
+	for _, path := range files {
+		extra, err := config.Load(path)
+		if err != nil {
+			return err
+		}
+		for name, server := range extra.MCPServers {
+			cfg.MCPServers[name] = server
+		}
+	}
+	for i, command := range commands {
+		name := "mcp"
+		if i > 0 {
+			name = fmt.Sprintf("mcp-%d", i+1)
+		}
+		if server, ok := mcpServerFromFlag(command); ok {
+			cfg.MCPServers[name] = server
+		}
+	}
+
+	if len(only) == 0 {
+		return nil
+	}
+	selected := make(map[string]bool)
+	for _, name := range only {
+		if _, ok := cfg.MCPServers[name]; !ok {
+			return fmt.Errorf("-mcp-only names unknown MCP server %q", name)
+		}
+		selected[name] = true
+	}
+	for name, server := range cfg.MCPServers {
+		server.Disabled = !selected[name]
+		cfg.MCPServers[name] = server
+	}
+	return nil
+}
+
+// mcpServerFromFlag converts the -mcp command string into a server entry.
+func mcpServerFromFlag(command string) (config.MCPServer, bool) {
+	parts := strings.Fields(command)
+	if len(parts) == 0 {
+		return config.MCPServer{}, false
+	}
+	return config.MCPServer{Command: parts[0], Args: parts[1:]}, true
+}
+
+// samplingApprover returns the approval gate for server sampling requests
+// under the given -mcp-sampling policy, or nil when sampling is disabled.
+// "ask" prompts on the terminal, which is not possible while the TUI owns
+// it, so requests are then denied.
+func samplingApprover(policy string, tuiMode bool) (mcp.SamplingApprover, error) {
+	switch policy {
+	case "deny":
+		return nil, nil
+	case "allow":
+		return func(ctx context.Context, req *mcp.SamplingRequest) (bool, error) {
+			return true, nil
+		}, nil
+	case "ask":
+		if tuiMode {
+			return nil, nil
+		}
+		var mu sync.Mutex
+		return func(ctx context.Context, req *mcp.SamplingRequest) (bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			fmt.Fprintln(os.Stderr, "\n[MCP server requests a completion]")
+			if req.SystemPrompt != "" {
+				fmt.Fprintf(os.Stderr, "System: %s\n", req.SystemPrompt)
+			}
+			for _, m := range req.Messages {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", m.Role, m.Content.Text)
+			}
+			fmt.Fprint(os.Stderr, "Allow? [y/N] ")
+
+			var answer string
+			fmt.Fscanln(os.Stdin, &answer)
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			return answer == "y" || answer == "yes", nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid -mcp-sampling %q (expected ask, allow, or deny)", policy)
+	}
+}
+
+// openBrowser shows url to the user and tries to open it in their browser.
+func openBrowser(url string) error {
+	fmt.Fprintf(os.Stderr, "Open this URL to sign in:\n  %s\n", url)
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	_ = cmd.Start() // The printed URL is the fallback
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// outputFormats are the values of -output.
+var outputFormats = []string{"text", "json", "stream-json"}
+
+// jsonEvent is one line of stream-json output. The json output is a single
+// "result" event, written once the run has finished.
+type jsonEvent struct {
+	// Type is "text", "tool_call", "progress", "tool_result", "usage",
+	// "error", "turn_limit", or "result".
+	Type string `json:"type"`
+
+	Text     string                 `json:"text,omitempty"` // Reply text, progress message, or final message
+	ID       string                 `json:"id,omitempty"`   // Tool call ID
+	Name     string                 `json:"name,omitempty"` // Tool name
+	Args     map[string]interface{} `json:"args,omitempty"`
+	Output   string                 `json:"output,omitempty"`
+	Progress float64                `json:"progress,omitempty"`
+	Total    float64                `json:"total,omitempty"`
+	Usage    *llm.Usage             `json:"usage,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+	Denied   bool                   `json:"denied,omitempty"` // The tool call was not approved
+
+	// TurnLimit is the limit the run stopped at, before the model
+	// answered its last tool results.
+	TurnLimit int `json:"turn_limit,omitempty"`
+
+	ToolCalls []jsonEvent `json:"tool_calls,omitempty"` // Results of the run's tool calls, in a result
+}
+
+// jsonOutput writes the events of a run as JSON, and collects what the
+// final result reports.
+type jsonOutput struct {
+	enc    *json.Encoder
+	stream bool // Write every event, not just the result
+
+	final  strings.Builder // Text since the last tool result
+	usage  llm.Usage
+	calls  []jsonEvent
+	err    string
+	limit  int
+	status runStatus
+}
+
+// newJSONOutput returns the output for the json or stream-json format.
+func newJSONOutput(w io.Writer, format string) *jsonOutput {
+	return &jsonOutput{enc: json.NewEncoder(w), stream: format == "stream-json"}
+}
+
+// record handles one event of the run.
+func (o *jsonOutput) record(event agent.Event) {
+	var events []jsonEvent
+	if event.Error != nil {
+		o.err = event.Error.Error()
+		o.status.providerErr = true
+		events = append(events, jsonEvent{Type: "error", Error: o.err})
+	}
+	if event.Delta != "" {
+		o.final.WriteString(event.Delta)
+		events = append(events, jsonEvent{Type: "text", Text: event.Delta})
+	}
+	for _, tc := range event.ToolCalls {
+		events = append(events, jsonEvent{Type: "tool_call", ID: tc.ID, Name: tc.Name, Args: tc.Args})
+	}
+	if p := event.Progress; p != nil {
+		events = append(events, jsonEvent{Type: "progress", ID: p.ToolCallID, Name: p.Tool, Progress: p.Progress, Total: p.Total, Text: p.Message})
+	}
+	if r := event.Result; r != nil {
+		result := jsonEvent{Type: "tool_result", ID: r.ToolCallID, Name: r.Tool, Args: r.Args, Output: r.Output}
+		if r.Err != nil {
+			result.Error = r.Err.Error()
+			result.Denied = errors.Is(r.Err, agent.ErrDenied)
+			o.status.toolFailed = true
+			o.status.denied = o.status.denied || result.Denied
+		}
+		o.calls = append(o.calls, result)
+		o.final.Reset() // The model replies again after its tools
+		events = append(events, result)
+	}
+	if u := event.Usage; u != nil {
+		o.usage = o.usage.Add(*u)
+		events = append(events, jsonEvent{Type: "usage", Usage: u})
+	}
+	if event.TurnLimit > 0 {
+		o.limit = event.TurnLimit
+		o.status.turnLimit = true
+		events = append(events, jsonEvent{Type: "turn_limit", TurnLimit: o.limit})
+	}
+	if o.stream {
+		for _, e := range events {
+			o.enc.Encode(e)
+		}
+	}
+}
+
+// finish writes the result of the run.
+func (o *jsonOutput) finish() {
+	o.enc.Encode(o.result())
+}
+
+// result returns the result event of the run.
+func (o *jsonOutput) result() jsonEvent {
+	result := jsonEvent{Type: "result", Text: o.final.String(), Usage: &o.usage, Error: o.err, TurnLimit: o.limit}
+	if !o.stream {
+		// Streamed tool results were already written
+		result.ToolCalls = o.calls
+	}
+	return result
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/prompts"
+)
+
+// runPromptTemplates implements `castor prompt`, which saves prompt
+// templates and runs them by name.
+func runPromptTemplates(args []string) {
+	flags := flag.NewFlagSet("prompt", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), `Usage: castor prompt save|list|run [flags] [name] [arguments]
+
+Saves prompt templates in the config directory and runs them by name.
+
+  prompt save <name> [text]       Save a template, reading it from stdin without text
+  prompt list                     List the saved templates
+  prompt run <name> [arguments]   Fill in a template and run it like castor run
+
+Templates use Go template syntax: {{.arg1}} and {{.arg2}} are the positional
+arguments, {{.args}} all of them, and {{.name}} the value of a name=value
+argument. Run "castor prompt <action> -h" for the flags of an action.
+`)
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		parseFlags(flags, args)
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	switch action, args := args[0], args[1:]; action {
+	case "save":
+		savePrompt(args)
+	case "list":
+		listPrompts(args)
+	case "run":
+		runSavedPrompt(args)
+	default:
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+}
+
+// addPromptsDirFlag registers -prompts-dir, which defaults to the prompts
+// directory in the user's config directory.
+func addPromptsDirFlag(flags *flag.FlagSet) *string {
+	return flags.String("prompts-dir", "", "Directory holding the prompt templates (defaults to castor/prompts in the user config dir)")
+}
+
+// promptStore returns the store in dir, or in the default directory.
+func promptStore(dir string) prompts.Store {
+	if dir == "" {
+		var err error
+		if dir, err = prompts.DefaultDir(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitError)
+		}
+	}
+	return prompts.Store{Dir: dir}
+}
+
+func savePrompt(args []string) {
+	flags := flag.NewFlagSet("prompt save", flag.ExitOnError)
+	dir := addPromptsDirFlag(flags)
+	flags.Usage = commandUsage(flags, `prompt save [flags] <name> ["<template>"]`, "Saves a prompt template, replacing any with the same name. Without a template on the command line it is read from stdin.")
+	parseFlags(flags, args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	name, text := flags.Arg(0), strings.Join(flags.Args()[1:], " ")
+	if flags.NArg() == 1 {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to read template: %v\n", err)
+			os.Exit(exitError)
+		}
+		text = string(data)
+	}
+	if err := promptStore(*dir).Save(name, text); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	fmt.Printf("Saved prompt %q.\n", name)
+}
+
+func listPrompts(args []string) {
+	flags := flag.NewFlagSet("prompt list", flag.ExitOnError)
+	dir := addPromptsDirFlag(flags)
+	flags.Usage = commandUsage(flags, "prompt list [flags]", "Lists the saved prompt templates with the first line of each.")
+	parseFlags(flags, args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	list, err := promptStore(*dir).List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if len(list) == 0 {
+		fmt.Println("No saved prompts.")
+		return
+	}
+	for _, p := range list {
+		first, _, _ := strings.Cut(strings.TrimSpace(p.Text), "\n")
+		fmt.Printf("%-24s %s\n", p.Name, truncateLine(first, 80))
+	}
+}
+
+func runSavedPrompt(args []string) {
+	flags := flag.NewFlagSet("prompt run", flag.ExitOnError)
+	f := addAgentFlags(flags)
+	opts := addOutputFlags(flags)
+	dir := addPromptsDirFlag(flags)
+	flags.Usage = commandUsage(flags, "prompt run [flags] <name> [arguments]", "Fills in a saved prompt template with the arguments and sends it to the agent, like castor run.")
+	parseFlags(flags, args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	opts.check()
+
+	tmpl, err := promptStore(*dir).Load(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	prompt, err := tmpl.Render(prompts.Args(flags.Args()[1:]))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	ctx := signalContext()
+	a := f.start(ctx, true, false)
+	opts.sessionPath = f.sessionPath
+	code := runOnce(ctx, a.ag, prompt, *opts)
+	a.close()
+	exit(code)
+}
+
+// truncateLine shortens s to at most n runes, marking the cut with "…".
+func truncateLine(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
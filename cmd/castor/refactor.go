@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/project"
+)
+
+// refactorStages are the progress lines that start the stages of a
+// refactor.
+var refactorStages = map[string]string{
+	"baseline": "Checking that the tests pass before the refactor",
+	"plan":     "Planning the refactor",
+	"edit":     "Step",
+}
+
+// runRefactor implements `castor refactor`, which refactors code step by
+// step, rolling back the steps that break the tests.
+func runRefactor(args []string) {
+	flags := flag.NewFlagSet("refactor", flag.ExitOnError)
+	f := addAgentFlags(flags)
+	testCmd := flags.String("test", "", "Shell command that runs the tests (defaults to the project's, such as go test ./...)")
+	testTimeout := flags.Duration("test-timeout", 10*time.Minute, "How long a test run may take")
+	attempts := flags.Int("attempts", agent.DefaultRefactorAttempts, "Tries of each step before it is rolled back for good")
+	quiet := flags.Bool("quiet", false, "Do not show the refactor's progress on stderr")
+	format := flags.String("format", "text", "Output format: text (summary, steps, test result, and diff) or json")
+	flags.Usage = commandUsage(flags, "refactor [flags] <refactor description>",
+		"Refactors code without changing what it does. The tests must pass first. The refactor is planned in steps, "+
+			"each made with transactional edits and followed by a test run; a step that breaks the build or the tests is rolled back "+
+			"and tried again, up to -attempts, then left out. Prints a summary, the steps and whether each was applied, and the diff. "+
+			"Exits with 7 if a step was left out.")
+	parseFlags(flags, args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if !slices.Contains(fixFormats, *format) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -format %q (expected %s)\n", *format, strings.Join(fixFormats, ", "))
+		os.Exit(exitUsage)
+	}
+	if *attempts < 1 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -attempts %d (expected at least 1)\n", *attempts)
+		os.Exit(exitUsage)
+	}
+	if f.dryRun {
+		fmt.Fprintln(os.Stderr, "Error: refactor cannot be run with -dry-run, as the tests run against the files on disk")
+		os.Exit(exitUsage)
+	}
+	if *testCmd == "" {
+		if info, err := project.Detect(f.workspace); err == nil {
+			for _, c := range info.Commands {
+				if c.Purpose == "test" {
+					*testCmd = c.Run
+				}
+			}
+		}
+		if *testCmd == "" {
+			fmt.Fprintln(os.Stderr, "Error: no test command found for the project; set one with -test")
+			os.Exit(exitUsage)
+		}
+	}
+
+	ctx := signalContext()
+	a := f.start(ctx, true, false)
+	defer a.close()
+
+	request := strings.Join(flags.Args(), " ")
+	refactorer := &agent.Refactorer{
+		Agent:       a.ag,
+		MaxAttempts: *attempts,
+		Test:        shellTest(a.workspace, *testCmd, *testTimeout),
+	}
+	if !*quiet {
+		fmt.Fprintf(os.Stderr, "🛠️ Refactoring: %s\n", request)
+	}
+
+	progress := make(chan agent.WorkflowEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range progress {
+			printWorkflowEvent(os.Stderr, event, refactorStages, *testCmd)
+		}
+	}()
+	if !*quiet {
+		refactorer.Progress = progress
+	}
+	report, err := refactorer.Refactor(ctx, request)
+	close(progress)
+	<-done
+	if errors.Is(err, agent.ErrBaselineFails) {
+		fmt.Fprintf(os.Stderr, "Error: %v; fix them first:\n\n%s\n", err, strings.TrimSpace(report.Baseline.Output))
+		a.close()
+		exit(exitError)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: refactor failed: %v\n", err)
+		a.close()
+		exit(exitError)
+	}
+
+	printRefactor(os.Stdout, report, *format)
+	if !report.Complete() {
+		a.close()
+		exit(exitUnfixed)
+	}
+}
+
+// printRefactor writes the report of a refactor in the given format.
+func printRefactor(w io.Writer, report *agent.RefactorReport, format string) {
+	if format == "json" {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Fprintln(w, string(data))
+		return
+	}
+
+	fmt.Fprintln(w, report.Summary)
+	fmt.Fprintln(w, "\nSteps:")
+	for i, step := range report.Steps {
+		if step.Applied {
+			fmt.Fprintf(w, "  %d. ✓ %s\n", i+1, step.Step)
+		} else {
+			fmt.Fprintf(w, "  %d. ↩ %s (rolled back after %d attempt(s))\n", i+1, step.Step, step.Attempts)
+		}
+	}
+	if report.Complete() {
+		fmt.Fprintln(w, "\nEvery step was applied, and the tests pass.")
+	} else {
+		fmt.Fprintln(w, "\nThe steps that broke the tests were rolled back; the tests pass without them.")
+	}
+	if len(report.FilesChanged) == 0 {
+		fmt.Fprintln(w, "\nNo files were changed.")
+		return
+	}
+	fmt.Fprintf(w, "\nChanged %s:\n\n%s", strings.Join(report.FilesChanged, ", "), report.Diff)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/techmuch/castor/pkg/agent"
+)
+
+// maxReviewDiff caps how much of a diff is sent to the reviewer.
+const maxReviewDiff = 200 << 10
+
+// reviewFormats are the values of review -format.
+var reviewFormats = []string{"text", "json", "github"}
+
+// runReview implements `castor review`, which reviews a diff and prints
+// the findings.
+func runReview(args []string) {
+	flags := flag.NewFlagSet("review", flag.ExitOnError)
+	f := addAgentFlags(flags)
+	ref := flags.String("ref", "", "Git revisions to diff, such as main..HEAD (defaults to the uncommitted changes)")
+	pr := flags.String("pr", "", "URL of a GitHub pull request to review instead of a local diff; set GITHUB_TOKEN for private repositories")
+	format := flags.String("format", "text", "Output format: text, json, or github (the body of a GitHub pull request review)")
+	quiet := flags.Bool("quiet", false, "Do not show which hunk is being reviewed on stderr")
+	flags.Usage = commandUsage(flags, "review [flags]",
+		"Reviews a diff hunk by hunk with a review-specialized agent and prints its findings, each with a file, line, severity (error, warning, or info), category, and suggestion, often as a patch. "+
+			"The agent may read the workspace for context but cannot change it.")
+	parseFlags(flags, args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if !slices.Contains(reviewFormats, *format) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -format %q (expected %s)\n", *format, strings.Join(reviewFormats, ", "))
+		os.Exit(exitUsage)
+	}
+	if *ref != "" && *pr != "" {
+		fmt.Fprintln(os.Stderr, "Error: -ref and -pr cannot be used together")
+		os.Exit(exitUsage)
+	}
+
+	var diff string
+	var err error
+	switch {
+	case *pr != "":
+		diff, err = pullRequestDiff(*pr)
+	case *ref != "":
+		diff, err = git(f.workspace, "diff", *ref)
+	default:
+		diff, err = git(f.workspace, "diff", "HEAD")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if strings.TrimSpace(diff) == "" {
+		fmt.Fprintln(os.Stderr, "Nothing to review: the diff is empty.")
+		return
+	}
+	if len(diff) > maxReviewDiff {
+		diff = diff[:maxReviewDiff] + "\n[diff truncated]\n"
+	}
+
+	ctx := signalContext()
+	a := f.start(ctx, true, false)
+	defer a.close()
+	a.ag.KeepReadOnly() // A review reads; it never changes the workspace
+
+	reviewer := &agent.Reviewer{Agent: a.ag}
+	progress := make(chan agent.ReviewEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range progress {
+			fmt.Fprintf(os.Stderr, "[Hunk %d/%d] %s\n", event.Hunk, event.Hunks, event.File)
+		}
+	}()
+	if !*quiet {
+		reviewer.Progress = progress
+	}
+	review, err := reviewer.Review(ctx, diff)
+	close(progress)
+	<-done
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: review failed: %v\n", err)
+		a.close()
+		exit(exitProvider)
+	}
+	printReview(os.Stdout, review, *format)
+}
+
+// printReview writes a review in the given format.
+func printReview(w io.Writer, review *agent.ReviewReport, format string) {
+	switch format {
+	case "json":
+		data, _ := json.MarshalIndent(review, "", "  ")
+		fmt.Fprintln(w, string(data))
+	case "github":
+		data, _ := json.MarshalIndent(githubReview(review), "", "  ")
+		fmt.Fprintln(w, string(data))
+	default:
+		fmt.Fprintln(w, review.Summary)
+		if len(review.Findings) == 0 {
+			fmt.Fprintln(w, "\nNo findings.")
+			return
+		}
+		for _, f := range review.Findings {
+			loc := f.File
+			if f.Line > 0 {
+				loc = fmt.Sprintf("%s:%d", f.File, f.Line)
+			}
+			fmt.Fprintf(w, "\n%s [%s/%s] %s\n", loc, f.Severity, f.Category, f.Message)
+			if f.Suggestion != "" {
+				fmt.Fprintf(w, "  Suggestion: %s\n", f.Suggestion)
+			}
+			if f.Patch != "" {
+				fmt.Fprintf(w, "  Patch:\n")
+				for _, line := range strings.Split(strings.TrimRight(f.Patch, "\n"), "\n") {
+					fmt.Fprintf(w, "    %s\n", line)
+				}
+			}
+		}
+	}
+}
+
+// githubReviewComment is a comment of a GitHub pull request review.
+type githubReviewComment struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Side string `json:"side"`
+	Body string `json:"body"`
+}
+
+// githubReviewBody is the request body of GitHub's "create a review for a
+// pull request" endpoint.
+type githubReviewBody struct {
+	Body     string                `json:"body"`
+	Event    string                `json:"event"`
+	Comments []githubReviewComment `json:"comments"`
+}
+
+// githubReview converts a review to a GitHub review. Findings without a
+// line cannot be attached to the diff, so they are listed in the body.
+func githubReview(review *agent.ReviewReport) githubReviewBody {
+	body := githubReviewBody{Body: review.Summary, Event: "COMMENT", Comments: []githubReviewComment{}}
+	for _, f := range review.Findings {
+		text := fmt.Sprintf("**%s** (%s): %s", f.Severity, f.Category, f.Message)
+		if f.Suggestion != "" {
+			text += "\n\n**Suggestion:** " + f.Suggestion
+		}
+		if f.Patch != "" {
+			text += "\n\n```diff\n" + strings.TrimRight(f.Patch, "\n") + "\n```"
+		}
+		if f.Line == 0 {
+			body.Body += fmt.Sprintf("\n\n`%s`: %s", f.File, text)
+			continue
+		}
+		body.Comments = append(body.Comments, githubReviewComment{Path: f.File, Line: f.Line, Side: "RIGHT", Body: text})
+	}
+	return body
+}
+
+// pullRequestDiff downloads the diff of a GitHub pull request given its
+// web URL, such as https://github.com/owner/repo/pull/123.
+func pullRequestDiff(prURL string) (string, error) {
+	u, err := url.Parse(prURL)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid pull request URL %q", prURL)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 4 || parts[2] != "pull" {
+		return "", fmt.Errorf("invalid pull request URL %q: expected https://HOST/OWNER/REPO/pull/NUMBER", prURL)
+	}
+	api := "https://api.github.com"
+	if u.Host != "github.com" {
+		api = u.Scheme + "://" + u.Host + "/api/v3" // GitHub Enterprise Server
+	}
+	endpoint := fmt.Sprintf("%s/repos/%s/%s/pulls/%s", api, parts[0], parts[1], parts[3])
+
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github.diff")
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := (&http.Client{Timeout: time.Minute}).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch pull request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch pull request: %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read pull request diff: %w", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/techmuch/castor/pkg/agent"
+)
+
+// runPrompt implements `castor run`, which sends one prompt and prints the
+// reply.
+func runPrompt(args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	f := addAgentFlags(flags)
+	opts := addOutputFlags(flags)
+	var files, dirs []string
+	flags.Func("file", "File to include in the prompt; may be repeated", appendFlag(&files))
+	flags.Func("dir", "Directory whose text files to include in the prompt, skipping ignored files; may be repeated", appendFlag(&dirs))
+	flags.Usage = commandUsage(flags, `run [flags] "<prompt>"`, "Sends one prompt to the agent and prints the reply as it streams in.")
+	parseFlags(flags, args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	opts.check()
+
+	prompt := strings.Join(flags.Args(), " ")
+	attached, err := attachments(files, dirs, f.maxReadBytes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if attached != "" {
+		prompt += "\n\n" + attached
+	}
+
+	ctx := signalContext()
+	a := f.start(ctx, true, false)
+	opts.sessionPath = f.sessionPath
+	code := runOnce(ctx, a.ag, prompt, *opts)
+	a.close()
+	exit(code)
+}
+
+// runOptions control how runOnce prints a reply.
+type runOptions struct {
+	sessionPath string // Saved after the reply, if set
+	output      string // One of outputFormats
+	quiet       bool   // Print only the final reply in text output
+}
+
+// addOutputFlags registers the flags that set how runOnce prints a reply.
+func addOutputFlags(flags *flag.FlagSet) *runOptions {
+	opts := &runOptions{}
+	flags.StringVar(&opts.output, "output", "text", "Output format: text, json (one result object), or stream-json (an event per line)")
+	flags.BoolVar(&opts.quiet, "q", false, "Print only the final reply, without tool calls or intermediate text")
+	flags.BoolVar(&opts.quiet, "quiet", false, "Same as -q")
+	return opts
+}
+
+// check exits with a usage error if the output format is unknown.
+func (opts *runOptions) check() {
+	if !slices.Contains(outputFormats, opts.output) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -output %q (expected %s)\n", opts.output, strings.Join(outputFormats, ", "))
+		os.Exit(exitUsage)
+	}
+}
+
+// runOnce sends prompt, prints the reply, and returns the exit code. Errors
+// go to stderr, so stdout holds only the reply.
+func runOnce(ctx context.Context, ag *agent.Agent, prompt string, opts runOptions) int {
+	stream, err := ag.Chat(ctx, prompt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	if opts.output != "text" {
+		out := newJSONOutput(os.Stdout, opts.output)
+		for event := range stream {
+			out.record(event)
+		}
+		out.finish()
+		if !out.status.providerErr && !saveRun(ag, opts.sessionPath) {
+			return exitError
+		}
+		return out.status.exitCode()
+	}
+
+	var status runStatus
+	var final strings.Builder // Text since the last tool result
+	for event := range stream {
+		if event.Error != nil {
+			fmt.Fprintf(os.Stderr, "\nError during generation: %v\n", event.Error)
+			return exitProvider
+		}
+		if event.TurnLimit > 0 {
+			status.turnLimit = true
+			fmt.Fprintf(os.Stderr, "\n[Stopped at the limit of %d turns; raise it with -max-turns]\n", event.TurnLimit)
+		}
+		if event.Result != nil && event.Result.Err != nil {
+			status.toolFailed = true
+			status.denied = status.denied || errors.Is(event.Result.Err, agent.ErrDenied)
+		}
+		if opts.quiet {
+			final.WriteString(event.Delta)
+			if event.Result != nil {
+				final.Reset() // The model replies again after its tools
+			}
+			continue
+		}
+		if event.Delta != "" {
+			fmt.Print(event.Delta)
+		}
+		if len(event.ToolCalls) > 0 {
+			for _, tc := range event.ToolCalls {
+				fmt.Printf("\n[Tool Call: %s(%v)]\n", tc.Name, tc.Args)
+			}
+		}
+		if event.Progress != nil {
+			fmt.Printf("[Progress %s]\n", event.Progress)
+		}
+	}
+	if opts.quiet {
+		fmt.Print(strings.TrimSpace(final.String()))
+	}
+	fmt.Println()
+
+	if !saveRun(ag, opts.sessionPath) {
+		return exitError
+	}
+	return status.exitCode()
+}
+
+// saveRun saves the conversation to path, if set, reporting whether that
+// succeeded.
+func saveRun(ag *agent.Agent, path string) bool {
+	if path == "" {
+		return true
+	}
+	nameSession(context.Background(), ag)
+	if err := ag.SaveSession(path); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving session: %v\n", err)
+		return false
+	}
+	return true
+}
+
+// titleTimeout bounds the request that names a session.
+const titleTimeout = 30 * time.Second
+
+// nameSession gives the session a generated title after its first turn.
+// If that fails, the session stays named after its first message.
+func nameSession(ctx context.Context, ag *agent.Agent) {
+	if !ag.NeedsTitle() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, titleTimeout)
+	defer cancel()
+	title, err := agent.GenerateTitle(ctx, ag.TitleProvider, ag.History)
+	if err != nil {
+		ag.Logger.Warn("failed to name the session", "error", err)
+		return
+	}
+	ag.Title = title
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/config"
+	"github.com/techmuch/castor/pkg/session"
+)
+
+// runSession implements `castor session`, which manages the sessions saved
+// in the workspace.
+func runSession(args []string) {
+	flags := flag.NewFlagSet("session", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), `Usage: castor session [list|show|resume|fork|rename|tag|delete|prune|import|repair] [flags] [session]
+
+Manages the sessions saved in the workspace. Sessions are named by the ID
+that session list shows, a prefix of it at least four characters long, or
+their title.
+
+  session list                       List the sessions, most recent first (the default)
+  session show <session>             Print a session's details and conversation
+  session resume <session> [flags]   Continue a session in castor chat, with chat's flags
+  session fork [-at n] <session>     Copy a session, up to the end of turn n, into a new one
+  session rename <session> <title>   Change a session's title
+  session tag <session> <tag>...     Add tags to a session, or remove them with -remove
+  session delete <session>           Delete a session and its edit log
+  session prune -older-than 30d      Delete the sessions not updated for a while
+  session import <file>...           Import conversations saved by another tool
+  session repair <file>              Recover what a damaged session file holds
+
+Run "castor session <action> -h" for the flags of an action.
+`)
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		listSessions(args)
+		return
+	}
+
+	switch action, args := args[0], args[1:]; action {
+	case "list":
+		listSessions(args)
+	case "show":
+		showSession(args)
+	case "resume":
+		resumeSession(args)
+	case "fork":
+		forkSession(args)
+	case "rename":
+		renameSession(args)
+	case "tag":
+		tagSession(args)
+	case "delete":
+		deleteSession(args)
+	case "prune":
+		pruneSessions(args)
+	case "import":
+		importSessions(args)
+	case "repair":
+		repairSession(args)
+	default:
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+}
+
+// addSessionFlags registers the workspace flag of the session actions.
+func addSessionFlags(flags *flag.FlagSet) *string {
+	return flags.String("w", ".", "Workspace root directory")
+}
+
+// sessionStore returns the store of the sessions saved in a workspace.
+func sessionStore(workspace string) session.Store {
+	return session.Store{Dir: agent.SessionDir(workspace)}
+}
+
+// sessionOrders are the orders session list sorts by: the last update,
+// the creation time, the cost, or the tokens used, each largest first.
+var sessionOrders = []string{"updated", "created", "cost", "tokens"}
+
+// formatCost shows a cost in USD, or "-" when it is unknown.
+func formatCost(cost float64) string {
+	if cost == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("$%.4f", cost)
+}
+
+// sessionTitle returns the title of a session for display.
+func sessionTitle(info session.Info) string {
+	if info.Title == "" {
+		return "(untitled)"
+	}
+	return info.Title
+}
+
+func listSessions(args []string) {
+	flags := flag.NewFlagSet("session list", flag.ExitOnError)
+	workspace := addSessionFlags(flags)
+	tag := flags.String("tag", "", "List only the sessions with this tag")
+	sortBy := flags.String("sort", "updated", "Order of the list: "+strings.Join(sessionOrders, ", "))
+	flags.Usage = commandUsage(flags, "session list [flags]",
+		"Lists the sessions saved in the workspace, most recent first, with their IDs, models, costs, and tags. "+
+			"Costs are known for hosted models with published prices.")
+	parseFlags(flags, args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if !slices.Contains(sessionOrders, *sortBy) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -sort %q (expected %s)\n", *sortBy, strings.Join(sessionOrders, ", "))
+		os.Exit(exitUsage)
+	}
+
+	sessions, err := sessionStore(*workspace).List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if *tag != "" {
+		sessions = slices.DeleteFunc(sessions, func(s session.Info) bool { return !slices.Contains(s.Tags, *tag) })
+	}
+	if len(sessions) == 0 {
+		fmt.Println("No saved sessions.")
+		return
+	}
+	switch *sortBy {
+	case "created":
+		slices.SortStableFunc(sessions, func(a, b session.Info) int { return b.Created.Compare(a.Created) })
+	case "cost":
+		slices.SortStableFunc(sessions, func(a, b session.Info) int { return cmp.Compare(b.Cost, a.Cost) })
+	case "tokens":
+		slices.SortStableFunc(sessions, func(a, b session.Info) int { return cmp.Compare(b.Usage.Total(), a.Usage.Total()) })
+	}
+	for _, s := range sessions {
+		model := s.Model
+		if model == "" {
+			model = "-"
+		}
+		line := fmt.Sprintf("%s  %s  %-16s %8s  %s", s.ID, s.Updated.Format("2006-01-02 15:04"), truncateLine(model, 16), formatCost(s.Cost), truncateLine(sessionTitle(s), 60))
+		if len(s.Tags) > 0 {
+			line += "  #" + strings.Join(s.Tags, " #")
+		}
+		fmt.Println(line)
+	}
+}
+
+func showSession(args []string) {
+	flags := flag.NewFlagSet("session show", flag.ExitOnError)
+	workspace := addSessionFlags(flags)
+	format := flags.String("format", "markdown", "Format of the conversation: "+strings.Join(agent.ExportFormats, ", "))
+	flags.Usage = commandUsage(flags, "session show [flags] <session>", "Prints a session's details and its conversation, including tool calls and their results.")
+	parseFlags(flags, args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if !slices.Contains(agent.ExportFormats, *format) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -format %q (expected %s)\n", *format, strings.Join(agent.ExportFormats, ", "))
+		os.Exit(exitUsage)
+	}
+
+	s, info, err := sessionStore(*workspace).Load(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if *format == "markdown" {
+		fmt.Printf("ID:        %s\nTitle:     %s\nFile:      %s\n", info.ID, sessionTitle(info), info.Path)
+		if info.Model != "" {
+			fmt.Printf("Model:     %s\n", info.Model)
+		}
+		if info.Workspace != "" {
+			fmt.Printf("Workspace: %s\n", info.Workspace)
+		}
+		if len(info.Tags) > 0 {
+			fmt.Printf("Tags:      %s\n", strings.Join(info.Tags, ", "))
+		}
+		if !info.Created.IsZero() {
+			fmt.Printf("Created:   %s\n", info.Created.Format("2006-01-02 15:04"))
+		}
+		fmt.Printf("Updated:   %s\nTurns:     %d\nTokens:    about %d\n", info.Updated.Format("2006-01-02 15:04"), info.Turns, info.Tokens)
+		if info.Usage.Total() > 0 {
+			fmt.Printf("Usage:     %d prompt + %d completion tokens, cost %s, last context %d tokens\n",
+				info.Usage.PromptTokens, info.Usage.CompletionTokens, formatCost(info.Cost), info.Context)
+			for _, t := range s.Usage {
+				fmt.Printf("  turn %-3d %-16s %d + %d tokens, %s\n", t.Turn, truncateLine(t.Model, 16), t.PromptTokens, t.CompletionTokens, formatCost(t.Cost))
+			}
+		}
+		fmt.Println()
+	}
+	if err := s.Export(os.Stdout, *format); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+}
+
+func resumeSession(args []string) {
+	flags := flag.NewFlagSet("session resume", flag.ExitOnError)
+	workspace := addSessionFlags(flags)
+	flags.Usage = commandUsage(flags, "session resume [-w dir] <session> [chat flags]",
+		"Continues a saved session in castor chat. The flags after the session are those of castor chat, such as -model or -repl.")
+	parseFlags(flags, args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	info, err := sessionStore(*workspace).Find(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	runChat(append([]string{"-w", *workspace, "-session", info.Path}, flags.Args()[1:]...))
+}
+
+func forkSession(args []string) {
+	flags := flag.NewFlagSet("session fork", flag.ExitOnError)
+	workspace := addSessionFlags(flags)
+	at := flags.Int("at", 0, "Copy the conversation up to the end of this turn (defaults to all of it)")
+	flags.Usage = commandUsage(flags, "session fork [flags] <session>",
+		"Copies a session into a new one, which can then be resumed to try another approach while the original stays as it was. "+
+			"A turn is a user message and everything that answers it; session show lists them.")
+	parseFlags(flags, args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if *at < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -at must be positive")
+		os.Exit(exitUsage)
+	}
+
+	info, err := sessionStore(*workspace).Fork(flags.Arg(0), *at)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	fmt.Printf("Forked into session %s (%s), with %d turns.\n", info.ID, sessionTitle(info), info.Turns)
+}
+
+func renameSession(args []string) {
+	flags := flag.NewFlagSet("session rename", flag.ExitOnError)
+	workspace := addSessionFlags(flags)
+	flags.Usage = commandUsage(flags, "session rename [flags] <session> <title>", "Changes the title of a session; it may then be named by its new title.")
+	parseFlags(flags, args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	info, err := sessionStore(*workspace).Rename(flags.Arg(0), strings.Join(flags.Args()[1:], " "))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	fmt.Printf("Renamed session %s to %q.\n", info.ID, info.Title)
+}
+
+func tagSession(args []string) {
+	flags := flag.NewFlagSet("session tag", flag.ExitOnError)
+	workspace := addSessionFlags(flags)
+	var remove []string
+	flags.Func("remove", "Remove this tag (repeatable)", func(tag string) error {
+		remove = append(remove, tag)
+		return nil
+	})
+	flags.Usage = commandUsage(flags, "session tag [flags] <session> [tag...]", "Adds tags to a session and removes those given with -remove. session list -tag lists the sessions with a tag.")
+	parseFlags(flags, args)
+	if flags.NArg() == 0 || flags.NArg() == 1 && len(remove) == 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	info, err := sessionStore(*workspace).Tag(flags.Arg(0), flags.Args()[1:], remove)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if len(info.Tags) == 0 {
+		fmt.Printf("Session %s has no tags.\n", info.ID)
+		return
+	}
+	fmt.Printf("Session %s is tagged %s.\n", info.ID, strings.Join(info.Tags, ", "))
+}
+
+func deleteSession(args []string) {
+	flags := flag.NewFlagSet("session delete", flag.ExitOnError)
+	workspace := addSessionFlags(flags)
+	flags.Usage = commandUsage(flags, "session delete [flags] <session>...", "Deletes saved sessions along with the log of the edits made in them.")
+	parseFlags(flags, args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	store := sessionStore(*workspace)
+	for _, ref := range flags.Args() {
+		info, err := store.Delete(ref)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		if err := deleteEditLog(*workspace, info.Path); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to delete the edit log: %v\n", err)
+		}
+		fmt.Printf("Deleted session %s (%s).\n", info.ID, sessionTitle(info))
+	}
+}
+
+func pruneSessions(args []string) {
+	flags := flag.NewFlagSet("session prune", flag.ExitOnError)
+	workspace := addSessionFlags(flags)
+	olderThan := flags.String("older-than", "", "Delete the sessions not updated for this long, such as 30d or 12h (defaults to the config's sessions.pruneAfter)")
+	keepTagged := flags.Bool("keep-tagged", false, "Keep the sessions with tags")
+	dryRun := flags.Bool("dry-run", false, "List the sessions that would be deleted without deleting them")
+	flags.Usage = commandUsage(flags, "session prune [flags]",
+		"Deletes the sessions not updated for a while, with their edit logs. Without -older-than, the sessions policy of the config applies, "+
+			"which castor also applies each time it starts.")
+	parseFlags(flags, args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	policy := config.Sessions{KeepTagged: *keepTagged}
+	if *olderThan != "" {
+		d, err := config.ParseDuration(*olderThan)
+		if err != nil || d <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: invalid -older-than %q (expected a duration such as 30d or 12h)\n", *olderThan)
+			os.Exit(exitUsage)
+		}
+		policy.PruneAfter = config.Duration(d)
+	} else if cfg, _, err := config.Find(*workspace); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	} else if policy = cfg.Sessions; policy.PruneAfter == 0 {
+		fmt.Fprintln(os.Stderr, "Error: -older-than is required when the config sets no sessions.pruneAfter")
+		os.Exit(exitUsage)
+	}
+
+	pruned, err := sessionStore(*workspace).Prune(session.PruneOptions{
+		OlderThan:  time.Duration(policy.PruneAfter),
+		KeepTagged: policy.KeepTagged,
+		DryRun:     *dryRun,
+	})
+	verb := "Deleted"
+	if *dryRun {
+		verb = "Would delete"
+	}
+	for _, info := range pruned {
+		if !*dryRun {
+			if err := deleteEditLog(*workspace, info.Path); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to delete the edit log: %v\n", err)
+			}
+		}
+		fmt.Printf("%s session %s (%s), last updated %s.\n", verb, info.ID, sessionTitle(info), info.Updated.Format("2006-01-02 15:04"))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if len(pruned) == 0 {
+		fmt.Println("No sessions to prune.")
+	}
+}
+
+// autoPrune applies the sessions policy of the config, keeping the session
+// in use. Failures are logged rather than stopping castor.
+func autoPrune(a *app, policy config.Sessions, current string) {
+	pruned, err := sessionStore(a.workspace).Prune(session.PruneOptions{
+		OlderThan:  time.Duration(policy.PruneAfter),
+		KeepTagged: policy.KeepTagged,
+		Keep:       []string{current},
+	})
+	for _, info := range pruned {
+		if err := deleteEditLog(a.workspace, info.Path); err != nil {
+			a.log.Warn("failed to delete the edit log of a pruned session", "session", info.Path, "error", err)
+		}
+	}
+	if err != nil {
+		a.log.Warn("failed to prune sessions", "error", err)
+	} else if len(pruned) > 0 {
+		a.log.Info("pruned sessions", "count", len(pruned), "older_than", time.Duration(policy.PruneAfter))
+	}
+}
+
+// deleteEditLog removes the edit log of a deleted session, if it has one.
+func deleteEditLog(workspace, sessionPath string) error {
+	if err := os.Remove(auditLogPath(workspace, sessionPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func importSessions(args []string) {
+	flags := flag.NewFlagSet("session import", flag.ExitOnError)
+	workspace := addSessionFlags(flags)
+	format := flags.String("format", "", "Format of the files: "+strings.Join(session.ImportFormats, ", ")+" (detected by default)")
+	flags.Usage = commandUsage(flags, "session import [flags] <file>...",
+		"Converts conversations saved by another tool into sessions of the workspace, which can then be resumed. "+
+			"It reads ChatGPT data exports (conversations.json), Claude Code transcripts (~/.claude/projects/*/*.jsonl), and aider chat histories (.aider.chat.history.md).")
+	parseFlags(flags, args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if *format != "" && !slices.Contains(session.ImportFormats, *format) {
+		fmt.Fprintf(os.Stderr, "Error: invalid -format %q (expected %s)\n", *format, strings.Join(session.ImportFormats, ", "))
+		os.Exit(exitUsage)
+	}
+
+	store := sessionStore(*workspace)
+	for _, path := range flags.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		infos, err := store.Import(data, *format)
+		for _, info := range infos {
+			fmt.Printf("Imported session %s (%s), with %d turns.\n", info.ID, sessionTitle(info), info.Turns)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", path, err)
+			os.Exit(exitError)
+		}
+		if len(infos) == 0 {
+			fmt.Printf("%s holds no conversations.\n", path)
+		}
+	}
+}
+
+func repairSession(args []string) {
+	flags := flag.NewFlagSet("session repair", flag.ExitOnError)
+	flags.Usage = commandUsage(flags, "session repair <file>",
+		"Recovers the messages of a damaged session file, such as one cut short by a crash, and saves them in its place. "+
+			"The damaged file is kept with a .bak suffix. Damaged sessions are left out of session list, so they are named by their file.")
+	parseFlags(flags, args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	path := flags.Arg(0)
+	if _, err := agent.ReadSession(path); err == nil {
+		fmt.Printf("Session %s is not damaged.\n", path)
+		return
+	}
+
+	s, err := agent.RepairSession(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if err := os.Rename(path, path+".bak"); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to keep the damaged file: %v\n", err)
+		os.Exit(exitError)
+	}
+	if err := s.Save(path); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	fmt.Printf("Recovered %d messages of session %s; the damaged file is kept as %s.bak.\n", len(s.History), path, path)
+}
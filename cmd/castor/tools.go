@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/config"
+)
+
+// filterTools removes the tools the -tools, -disable-tools, and -no-tools
+// flags leave out. Names in -tools that match no tool are reported, as
+// they are likely misspelled.
+func filterTools(ag *agent.Agent, filter config.ToolFilter, none bool) {
+	for _, name := range filter.Allow {
+		if _, ok := ag.Tools[name]; !ok && !strings.ContainsAny(name, "*?[") {
+			fmt.Fprintf(os.Stderr, "Warning: -tools names unknown tool %q\n", name)
+		}
+	}
+	for name := range ag.Tools {
+		if none || !filter.Allowed(name) {
+			delete(ag.Tools, name)
+		}
+	}
+}
+
+// runTools implements `castor tools`, which lists the built-in tools and
+// those of the configured MCP servers.
+func runTools(args []string) {
+	flags := flag.NewFlagSet("tools", flag.ExitOnError)
+	f := addAgentFlags(flags)
+	flags.Usage = commandUsage(flags, "tools [flags]", "Lists the tools the agent can use, including those of the configured MCP servers, after -tools and -disable-tools.")
+	parseFlags(flags, args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	a := f.start(signalContext(), false, false)
+	defer a.close()
+
+	var names []string
+	for name := range a.ag.Tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		description, _, _ := strings.Cut(a.ag.Tools[name].Description(), "\n")
+		fmt.Printf("%-24s %s\n", name, description)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, set by the linker, e.g.
+//
+//	go build -ldflags "-X main.version=v0.3.0 -X main.commit=$(git rev-parse HEAD)"
+//
+// Values left unset are taken from the build info Go embeds when possible.
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+// buildInfo describes the running binary.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+// currentBuild returns the metadata set at link time, falling back to the
+// module version and version control stamps in the binary.
+func currentBuild() buildInfo {
+	b := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		Date:      date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if b.Version == "" && info.Main.Version != "(devel)" {
+			b.Version = info.Main.Version
+		}
+		modified := false
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if b.Commit == "" {
+					b.Commit = s.Value
+				}
+			case "vcs.time":
+				if b.Date == "" {
+					b.Date = s.Value
+				}
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if modified && commit == "" && b.Commit != "" {
+			b.Commit += "-dirty"
+		}
+	}
+	if b.Version == "" {
+		b.Version = "dev"
+	}
+	return b
+}
+
+// runVersion implements `castor version`, which prints the build metadata
+// to include in bug reports.
+func runVersion(args []string) {
+	flags := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Print the metadata as JSON")
+	flags.Usage = commandUsage(flags, "version [flags]", "Prints castor's version, commit, build date, and Go version.")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	b := currentBuild()
+	if *asJSON {
+		data, _ := json.MarshalIndent(b, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+	fmt.Printf("castor %s\n", b.Version)
+	if b.Commit != "" {
+		fmt.Printf("commit:   %s\n", b.Commit)
+	}
+	if b.Date != "" {
+		fmt.Printf("built:    %s\n", b.Date)
+	}
+	fmt.Printf("go:       %s\n", b.GoVersion)
+	fmt.Printf("platform: %s\n", b.Platform)
+}
@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// ApprovalPolicy says which tool calls must be approved before they run.
+type ApprovalPolicy string
+
+const (
+	ApproveNever    ApprovalPolicy = "never"    // Run every call unasked
+	ApproveMutating ApprovalPolicy = "mutating" // Ask before tools that may change something
+	ApproveAlways   ApprovalPolicy = "always"   // Ask before every call
+)
+
+// ApprovalPolicies lists the policies ParseApprovalPolicy accepts.
+var ApprovalPolicies = []string{string(ApproveNever), string(ApproveMutating), string(ApproveAlways)}
+
+// ParseApprovalPolicy returns the policy called s.
+func ParseApprovalPolicy(s string) (ApprovalPolicy, error) {
+	for _, p := range ApprovalPolicies {
+		if s == p {
+			return ApprovalPolicy(s), nil
+		}
+	}
+	return "", fmt.Errorf("unknown approval policy %q (expected %s)", s, strings.Join(ApprovalPolicies, ", "))
+}
+
+// Approver asks the user whether a tool call may run.
+type Approver func(ctx context.Context, call llm.ToolCallPart) (bool, error)
+
+// ErrDenied is the error of a tool call that was not approved.
+var ErrDenied = errors.New("the user denied this tool call")
+
+// ReadOnly is implemented by tools that can tell whether they leave
+// everything as it was. Tools without it are taken to change something.
+type ReadOnly interface {
+	ReadOnly() bool
+}
+
+// approve checks that a call of tool may run under the agent's policy,
+// returning ErrDenied if it may not. Calls that need approval are denied
+// when there is no Approve function to ask.
+func (a *Agent) approve(ctx context.Context, tool Tool, call llm.ToolCallPart) error {
+	switch a.Approval {
+	case ApproveAlways:
+	case ApproveMutating:
+		if ro, ok := tool.(ReadOnly); ok && ro.ReadOnly() {
+			return nil
+		}
+	default:
+		return nil
+	}
+	if a.Approve == nil {
+		return ErrDenied
+	}
+	ok, err := a.Approve(ctx, call)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDenied, err)
+	}
+	if !ok {
+		return ErrDenied
+	}
+	return nil
+}
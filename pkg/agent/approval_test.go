@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+func TestParseApprovalPolicy(t *testing.T) {
+	for _, s := range ApprovalPolicies {
+		if p, err := ParseApprovalPolicy(s); err != nil || string(p) != s {
+			t.Errorf("ParseApprovalPolicy(%q) = %q, %v", s, p, err)
+		}
+	}
+	if _, err := ParseApprovalPolicy("sometimes"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func TestApprove(t *testing.T) {
+	yes := func(ctx context.Context, call llm.ToolCallPart) (bool, error) { return true, nil }
+	no := func(ctx context.Context, call llm.ToolCallPart) (bool, error) { return false, nil }
+	fails := func(ctx context.Context, call llm.ToolCallPart) (bool, error) { return false, context.Canceled }
+	readOnly := &fakeTool{name: "read", readOnly: true}
+	mutating := &fakeTool{name: "write"}
+
+	tests := []struct {
+		name    string
+		policy  ApprovalPolicy
+		tool    Tool
+		approve Approver
+		asked   bool
+		denied  bool
+	}{
+		{"Unset", "", mutating, nil, false, false},
+		{"Never", ApproveNever, mutating, no, false, false},
+		{"MutatingReadOnly", ApproveMutating, readOnly, no, false, false},
+		{"MutatingApproved", ApproveMutating, mutating, yes, true, false},
+		{"MutatingDenied", ApproveMutating, mutating, no, true, true},
+		{"MutatingNoApprover", ApproveMutating, mutating, nil, false, true},
+		{"AlwaysReadOnly", ApproveAlways, readOnly, no, true, true},
+		{"AlwaysApproved", ApproveAlways, readOnly, yes, true, false},
+		{"ApproverFails", ApproveAlways, mutating, fails, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asked := false
+			a := &Agent{Approval: tt.policy}
+			if tt.approve != nil {
+				a.Approve = func(ctx context.Context, call llm.ToolCallPart) (bool, error) {
+					asked = true
+					return tt.approve(ctx, call)
+				}
+			}
+			err := a.approve(context.Background(), tt.tool, llm.ToolCallPart{Name: tt.tool.Name()})
+			if asked != tt.asked {
+				t.Errorf("asked = %v, want %v", asked, tt.asked)
+			}
+			if (err != nil) != tt.denied || err != nil && !errors.Is(err, ErrDenied) {
+				t.Errorf("err = %v, want denied %v", err, tt.denied)
+			}
+		})
+	}
+}
+
+func TestChatDeniedCallIsNotStarted(t *testing.T) {
+	provider := &scriptedProvider{replies: [][]llm.StreamEvent{callTools("write")}}
+	a := New(provider, "")
+	write := &fakeTool{name: "write"}
+	a.RegisterTool(write)
+	a.Approval = ApproveMutating
+	asked := 0
+	a.Approve = func(ctx context.Context, call llm.ToolCallPart) (bool, error) {
+		asked++
+		return false, nil
+	}
+
+	events := collect(t, context.Background(), a, "Write.")
+	if asked != 1 {
+		t.Errorf("asked %d times, want 1", asked)
+	}
+	if write.calls != 0 {
+		t.Error("the denied tool ran")
+	}
+	var result *ToolResult
+	for _, event := range events {
+		if event.Started != nil {
+			t.Errorf("denied call was started: %+v", event.Started)
+		}
+		if event.Result != nil {
+			result = event.Result
+		}
+	}
+	if result == nil || !errors.Is(result.Err, ErrDenied) {
+		t.Fatalf("result = %+v, want ErrDenied", result)
+	}
+	// The model hears about the denial in the next request
+	last := provider.requests[1][len(provider.requests[1])-1]
+	if resp := last.Content[0].(llm.ToolResponsePart); resp.ID != "call_1" {
+		t.Errorf("last message of the next request answers %q", resp.ID)
+	}
+}
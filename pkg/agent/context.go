@@ -0,0 +1,34 @@
+package agent
+
+import "context"
+
+type toolCallIDKey struct{}
+
+// WithToolCallID returns a context carrying the ID of the tool call being
+// executed, so tools can attribute their side effects.
+func WithToolCallID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, toolCallIDKey{}, id)
+}
+
+// ToolCallID returns the ID of the tool call being executed, if any.
+func ToolCallID(ctx context.Context) string {
+	id, _ := ctx.Value(toolCallIDKey{}).(string)
+	return id
+}
+
+type progressKey struct{}
+
+// ProgressFunc receives progress updates from a running tool.
+type ProgressFunc func(progress, total float64, message string)
+
+// WithProgress returns a context through which tools can report progress.
+func WithProgress(ctx context.Context, report ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+// ProgressReporter returns the progress callback for the tool call being
+// executed, or nil if nobody is listening.
+func ProgressReporter(ctx context.Context) ProgressFunc {
+	report, _ := ctx.Value(progressKey{}).(ProgressFunc)
+	return report
+}
@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/vfs"
+)
+
+// DocGenerator is a specialized agent loop that documents a package: it
+// drafts doc comments for its exported symbols, updates those that are
+// out of date, and drafts or updates the package's README.
+type DocGenerator struct {
+	// Agent supplies the provider, tools, and settings. The work runs in
+	// a sub-agent, so Agent is left as it was.
+	Agent *Agent
+
+	// FS is the file system the Agent's tools write to, which the diff is
+	// taken from: an overlay when the changes are only to be reviewed. Nil
+	// means the disk.
+	FS vfs.FS
+
+	Readme bool // Also draft or update the README.md of the package
+
+	// Progress, if set, receives what the generator is doing as it goes,
+	// in the stages DocGenStages. Sends block, so the channel must be
+	// read until Generate returns; it is not closed.
+	Progress chan<- WorkflowEvent
+}
+
+// DocGenStages are the stages of documentation generation, in order.
+var DocGenStages = []string{"document"}
+
+// DocSymbol is an exported symbol of a package.
+type DocSymbol struct {
+	Name       string `json:"name"` // Methods are named Type.Method
+	Kind       string `json:"kind"` // Such as package, func, method, type, const, or var
+	File       string `json:"file"`
+	Line       int    `json:"line"`
+	Documented bool   `json:"documented"` // Whether it had a doc comment
+}
+
+// DocGenReport is the outcome of documentation generation.
+type DocGenReport struct {
+	Dir          string      `json:"dir"`
+	Symbols      []DocSymbol `json:"symbols,omitempty"`
+	Summary      string      `json:"summary"` // What was documented, by the model
+	FilesChanged []string    `json:"files_changed"`
+	Diff         string      `json:"diff"`
+}
+
+// docGenPrompt is the system prompt of documentation generation.
+const docGenPrompt = `You are a technical writer documenting a package for the people who use it. Read its code before you write about it.
+Give every exported symbol a doc comment in the language's convention, such as Go's, which starts with the symbol's name. Say what the symbol is or does, and what a caller has to know, in a sentence or two, matching the register of the comments the package already has; do not restate the code.
+Fix doc comments that no longer match the code, and leave the others alone. Change comments only, never the code, and only in the files of the package.`
+
+// docGenReadmePrompt is added to docGenPrompt when the README is wanted.
+const docGenReadmePrompt = `
+Then draft the package's README.md, or update it if it exists: what the package is for, how to use it with a short example, and its main types and functions.`
+
+// Generate documents the package in dir, an absolute path or one relative
+// to the workspace. symbols are its exported symbols, if the caller
+// knows them; otherwise the model finds them with its tools. Only the
+// files directly in dir may be changed.
+func (g *DocGenerator) Generate(ctx context.Context, dir string, symbols []DocSymbol) (*DocGenReport, error) {
+	report := &DocGenReport{Dir: dir, Symbols: symbols}
+	emit := func(event WorkflowEvent) { emitTo(ctx, g.Progress, event) }
+
+	prompt := docGenPrompt
+	if g.Readme {
+		prompt += docGenReadmePrompt
+	}
+	sub := g.Agent.subAgent(prompt)
+	snapshots := newFileSnapshots(g.Agent.Workspace)
+	snapshots.fs = g.FS
+	abs := snapshots.abs(dir)
+	snapshotTools(sub, snapshots, func(path string) bool {
+		return filepath.Dir(path) == abs
+	})
+
+	var input strings.Builder
+	fmt.Fprintf(&input, "Document the package in %s.", dir)
+	if len(symbols) > 0 {
+		input.WriteString(" Its exported symbols are:\n\n")
+		for _, s := range symbols {
+			state := "documented; check the comment"
+			if !s.Documented {
+				state = "undocumented"
+			}
+			fmt.Fprintf(&input, "- %s %s (%s:%d), %s\n", s.Kind, s.Name, s.File, s.Line, state)
+		}
+	} else {
+		input.WriteString(" Find its exported symbols first.")
+	}
+	if g.Readme {
+		fmt.Fprintf(&input, "\nThen draft or update %s.", filepath.ToSlash(filepath.Join(dir, "README.md")))
+	}
+
+	emit(WorkflowEvent{Stage: "document"})
+	if err := chatEvents(ctx, sub, input.String(), func(event Event) {
+		emit(WorkflowEvent{Stage: "document", Started: event.Started, Result: event.Result})
+	}); err != nil {
+		return nil, err
+	}
+
+	report.Diff, report.FilesChanged = snapshots.diff()
+	summary, err := summarize(ctx, sub, "Summarize the documentation you wrote or changed in a few sentences.")
+	if err != nil {
+		return nil, err
+	}
+	report.Summary = summary
+	return report, nil
+}
@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// Event is a single update from Chat: model output from the provider, or
+// the course of a tool call. A call the model asks for arrives in
+// ToolCalls, then in Started when it begins to run, in Progress while it
+// reports progress, and in Result once it has finished.
+type Event struct {
+	llm.StreamEvent
+
+	// Started is set when a tool call begins to run.
+	Started *llm.ToolCallPart
+
+	// Progress is set while a tool call reports progress.
+	Progress *ToolProgress
+
+	// Result is set when a tool call has finished.
+	Result *ToolResult
+
+	// TurnLimit is set to MaxTurns when the chat stops because it reached
+	// the limit, before the model has answered its last tool results. The
+	// next message lets it carry on.
+	TurnLimit int
+}
+
+// ToolResult describes a finished tool call.
+type ToolResult struct {
+	ToolCallID string
+	Tool       string
+	Args       map[string]interface{}
+	Output     string // Text of the result; string results are not JSON-quoted
+	Err        error  // Why the call failed, if it did
+}
+
+// ToolProgress describes how far a running tool call has got.
+type ToolProgress struct {
+	ToolCallID string
+	Tool       string
+	Progress   float64
+	Total      float64 // Zero when the total is unknown
+	Message    string
+}
+
+// String formats the progress as "tool: 3/10 message", or with a bare
+// count when the total is unknown.
+func (p ToolProgress) String() string {
+	s := fmt.Sprintf("%s: %g", p.Tool, p.Progress)
+	if p.Total > 0 {
+		s += fmt.Sprintf("/%g", p.Total)
+	}
+	if p.Message != "" {
+		s += " " + p.Message
+	}
+	return s
+}
@@ -0,0 +1,260 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// InvestigationEvidence is a place in the workspace that supports the
+// findings of an investigation, checked against what the tools returned.
+type InvestigationEvidence struct {
+	File       string    `json:"file"`
+	Lines      LineRange `json:"line_range,omitzero"`
+	Snippet    string    `json:"snippet,omitempty"`      // The code or text found there
+	Note       string    `json:"note,omitempty"`         // What it shows
+	ToolCallID string    `json:"tool_call_id,omitempty"` // The call whose result holds the snippet
+
+	// Verified is set when the snippet was found in the result of a tool
+	// call that read the file, at the lines cited where the result shows
+	// line numbers. Problem says why it was not.
+	Verified bool   `json:"verified"`
+	Problem  string `json:"problem,omitempty"`
+}
+
+// LineRange is a span of lines, counted from 1. End is Start for a single
+// line, and both are zero when no lines are cited.
+type LineRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// String formats the range as "12" or "12-18", or "" when it is zero.
+func (r LineRange) String() string {
+	switch {
+	case r.Start == 0:
+		return ""
+	case r.End <= r.Start:
+		return strconv.Itoa(r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// evidenceSchema is the schema of the evidence of report_findings.
+var evidenceSchema = map[string]interface{}{
+	"type": "array",
+	"items": map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"file": map[string]interface{}{"type": "string", "description": "Path of the file, relative to the workspace"},
+			"line_range": map[string]interface{}{
+				"type":        "object",
+				"description": "Lines the snippet spans, from 1",
+				"properties": map[string]interface{}{
+					"start": map[string]interface{}{"type": "integer"},
+					"end":   map[string]interface{}{"type": "integer"},
+				},
+			},
+			"snippet":      map[string]interface{}{"type": "string", "description": "A few lines copied verbatim from the tool result"},
+			"tool_call_id": map[string]interface{}{"type": "string", "description": "ID of the tool call whose result holds the snippet"},
+			"note":         map[string]interface{}{"type": "string", "description": "What it shows"},
+		},
+		"required": []string{"file", "snippet"},
+	},
+}
+
+// parseEvidence reads an evidence entry of report_findings.
+func parseEvidence(item interface{}) (InvestigationEvidence, error) {
+	m, ok := item.(map[string]interface{})
+	if !ok {
+		return InvestigationEvidence{}, fmt.Errorf("not an object")
+	}
+	var e InvestigationEvidence
+	e.File, _ = m["file"].(string)
+	e.Snippet, _ = m["snippet"].(string)
+	e.Note, _ = m["note"].(string)
+	e.ToolCallID, _ = m["tool_call_id"].(string)
+	if r, ok := m["line_range"].(map[string]interface{}); ok {
+		start, _ := r["start"].(float64)
+		end, _ := r["end"].(float64)
+		if start > 0 {
+			e.Lines = LineRange{Start: int(start), End: max(int(end), int(start))}
+		}
+	}
+	if e.File == "" {
+		return e, fmt.Errorf("a file is required")
+	}
+	return e, nil
+}
+
+// Verify checks each piece of evidence of the report against the tool
+// calls and results in history, setting Verified or Problem. Evidence
+// without a tool call ID is looked for in every result, and takes the ID
+// of the one it is found in; evidence without lines takes those found.
+// Paths are compared relative to workspace.
+func (r *InvestigationReport) Verify(workspace string, history []llm.Message) {
+	calls := make(map[string]llm.ToolCallPart)
+	var results []llm.ToolResponsePart
+	for _, msg := range history {
+		for _, part := range msg.Content {
+			switch p := part.(type) {
+			case llm.ToolCallPart:
+				calls[p.ID] = p
+			case llm.ToolResponsePart:
+				results = append(results, p)
+			}
+		}
+	}
+
+	for i := range r.Evidence {
+		e := &r.Evidence[i]
+		e.Verified, e.Problem = false, ""
+		if strings.TrimSpace(e.Snippet) == "" {
+			e.Problem = "no snippet is quoted"
+			continue
+		}
+		problem := "the snippet is not in any tool result"
+		if e.ToolCallID != "" {
+			problem = fmt.Sprintf("no tool call has the ID %q", e.ToolCallID)
+		}
+		for _, result := range results {
+			if e.ToolCallID != "" && result.ID != e.ToolCallID {
+				continue
+			}
+			p := e.check(workspace, calls[result.ID], result)
+			if p == "" {
+				e.Verified, e.ToolCallID = true, result.ID
+				break
+			}
+			if e.ToolCallID != "" || strings.HasPrefix(p, "the snippet is at") {
+				problem = p // Closer than not being found at all
+			}
+		}
+		if !e.Verified {
+			e.Problem = problem
+		}
+	}
+}
+
+// check returns why the result of call does not back the evidence, or ""
+// if it does. A read_file call must have read the cited file; found lines
+// fill in a range the evidence does not give.
+func (e *InvestigationEvidence) check(workspace string, call llm.ToolCallPart, result llm.ToolResponsePart) string {
+	if path, ok := call.Args["path"].(string); ok && call.Name == "read_file" && !samePath(workspace, path, e.File) {
+		return fmt.Sprintf("tool call %s read %s, not %s", result.ID, path, e.File)
+	}
+	lines := resultLines(call, result.Content)
+	found, ok := findSnippet(lines, e.Snippet)
+	if !ok {
+		return fmt.Sprintf("the snippet is not in the result of tool call %s", result.ID)
+	}
+	switch {
+	case found.Start == 0: // The result shows no line numbers
+	case e.Lines.Start == 0:
+		e.Lines = found
+	case e.Lines.Start != found.Start:
+		return fmt.Sprintf("the snippet is at line %s, not %s", found, e.Lines)
+	}
+	return ""
+}
+
+// numberedLine is a line of a tool result, with its number in the file
+// when the result shows it.
+type numberedLine struct {
+	n    int
+	text string
+}
+
+// resultLines splits a tool result into lines. The lines of read_file
+// results are numbered, from the prefixes of line_numbers or else from
+// the offset read from.
+func resultLines(call llm.ToolCallPart, content string) []numberedLine {
+	// String results are stored JSON-quoted
+	var s string
+	if json.Unmarshal([]byte(content), &s) == nil {
+		content = s
+	}
+
+	next := 0
+	if call.Name == "read_file" {
+		next = 1
+		if offset, ok := call.Args["offset"].(float64); ok && offset > 1 {
+			next = int(offset)
+		}
+	}
+	numbered, _ := call.Args["line_numbers"].(bool)
+	var lines []numberedLine
+	for _, text := range strings.Split(content, "\n") {
+		line := numberedLine{text: text}
+		if numbered && next > 0 {
+			if num, rest, ok := strings.Cut(text, "\t"); ok {
+				if n, err := strconv.Atoi(strings.TrimSpace(num)); err == nil {
+					line = numberedLine{n: n, text: rest}
+					next = n
+				}
+			}
+		} else if next > 0 {
+			line.n = next
+		}
+		if next > 0 {
+			next++
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// findSnippet looks for the lines of snippet among lines, ignoring
+// indentation and blank lines, and returns the range it spans.
+func findSnippet(lines []numberedLine, snippet string) (LineRange, bool) {
+	var want []string
+	for _, line := range strings.Split(snippet, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			want = append(want, line)
+		}
+	}
+	var have []numberedLine
+	for _, line := range lines {
+		if text := strings.TrimSpace(line.text); text != "" {
+			have = append(have, numberedLine{n: line.n, text: text})
+		}
+	}
+
+	for i := 0; i+len(want) <= len(have); i++ {
+		match := true
+		for j, w := range want {
+			got := have[i+j].text
+			// The first and last lines of a snippet may be cut short
+			if !(got == w || len(want) == 1 && strings.Contains(got, w) ||
+				j == 0 && strings.HasSuffix(got, w) || j == len(want)-1 && strings.HasPrefix(got, w)) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return LineRange{Start: have[i].n, End: have[i+len(want)-1].n}, true
+		}
+	}
+	return LineRange{}, false
+}
+
+// samePath reports whether two paths, each relative to workspace or
+// absolute, name the same file.
+func samePath(workspace, a, b string) bool {
+	return workspacePath(workspace, a) == workspacePath(workspace, b)
+}
+
+// workspacePath returns path relative to workspace, in clean slash form.
+func workspacePath(workspace, path string) string {
+	path = filepath.Clean(path)
+	if filepath.IsAbs(path) && workspace != "" {
+		if rel, err := filepath.Rel(workspace, path); err == nil {
+			path = rel
+		}
+	}
+	return filepath.ToSlash(path)
+}
@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// quoted returns s as the agent stores string tool results.
+func quoted(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+func TestResultLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		call    llm.ToolCallPart
+		content string
+		want    []numberedLine
+	}{
+		{
+			name:    "Plain",
+			call:    llm.ToolCallPart{Name: "read_file", Args: map[string]interface{}{"path": "a.go"}},
+			content: quoted("package a\nfunc A() {}"),
+			want:    []numberedLine{{1, "package a"}, {2, "func A() {}"}},
+		},
+		{
+			name:    "Offset",
+			call:    llm.ToolCallPart{Name: "read_file", Args: map[string]interface{}{"path": "a.go", "offset": float64(10)}},
+			content: quoted("x := 1\ny := 2"),
+			want:    []numberedLine{{10, "x := 1"}, {11, "y := 2"}},
+		},
+		{
+			name:    "LineNumbers",
+			call:    llm.ToolCallPart{Name: "read_file", Args: map[string]interface{}{"path": "a.go", "line_numbers": true}},
+			content: quoted("    41\tfunc A() {\n    42\t\treturn\n"),
+			want:    []numberedLine{{41, "func A() {"}, {42, "\treturn"}, {0, ""}},
+		},
+		{
+			name:    "OtherTool",
+			call:    llm.ToolCallPart{Name: "grep", Args: map[string]interface{}{"pattern": "A"}},
+			content: "a.go:3: func A() {}",
+			want:    []numberedLine{{0, "a.go:3: func A() {}"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resultLines(tt.call, tt.content)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d lines %v, want %v", len(got), got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("line %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFindSnippet(t *testing.T) {
+	lines := []numberedLine{
+		{1, "func Sum(xs []int) int {"},
+		{2, "\ttotal := 0"},
+		{3, ""},
+		{4, "\tfor _, x := range xs {"},
+		{5, "\t\ttotal += x"},
+		{6, "\t}"},
+		{7, "\treturn total"},
+	}
+	tests := []struct {
+		name    string
+		snippet string
+		want    LineRange
+		found   bool
+	}{
+		{"Exact", "func Sum(xs []int) int {", LineRange{1, 1}, true},
+		{"Indentation", "for _, x := range xs {\n    total += x", LineRange{4, 5}, true},
+		{"BlankLinesIgnored", "total := 0\nfor _, x := range xs {", LineRange{2, 4}, true},
+		{"SingleLinePart", "range xs", LineRange{4, 4}, true},
+		{"FirstLineTail", "x := range xs {\ntotal += x", LineRange{4, 5}, true},
+		{"LastLineHead", "total += x\n}\nreturn", LineRange{5, 7}, true},
+		{"MiddleLineCut", "for _, x := range xs {\ntotal +=\n}", LineRange{}, false},
+		{"Missing", "return 0", LineRange{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := findSnippet(lines, tt.snippet)
+			if found != tt.found || got != tt.want {
+				t.Errorf("findSnippet(%q) = %v, %v, want %v, %v", tt.snippet, got, found, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+func TestSamePath(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"pkg/a/b.go", "pkg/a/b.go", true},
+		{"./pkg/a/b.go", "pkg/a//b.go", true},
+		{"/work/pkg/a/b.go", "pkg/a/b.go", true},
+		{"pkg/a/b.go", "/work/pkg/a/b.go", true},
+		{"a/b.go", "b.go", false},
+		{"pkg/a/b.go", "a/b.go", false},
+		{"/other/pkg/a/b.go", "pkg/a/b.go", false},
+	}
+	for _, tt := range tests {
+		if got := samePath("/work", tt.a, tt.b); got != tt.want {
+			t.Errorf("samePath(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	content := "package calc\n\nfunc Sum(xs []int) int {\n\ttotal := 0\n\treturn total\n}\n"
+	history := []llm.Message{
+		{Role: llm.RoleModel, Content: []llm.Part{
+			llm.ToolCallPart{ID: "call_1", Name: "read_file", Args: map[string]interface{}{"path": "calc/sum.go"}},
+		}},
+		{Role: llm.RoleTool, Content: []llm.Part{
+			llm.ToolResponsePart{ID: "call_1", Name: "read_file", Content: quoted(content)},
+		}},
+	}
+
+	tests := []struct {
+		name     string
+		evidence InvestigationEvidence
+		lines    LineRange
+		problem  string // Empty when it verifies
+	}{
+		{
+			name:     "FillsLinesAndID",
+			evidence: InvestigationEvidence{File: "calc/sum.go", Snippet: "total := 0\nreturn total"},
+			lines:    LineRange{4, 5},
+		},
+		{
+			name:     "CitedLines",
+			evidence: InvestigationEvidence{File: "calc/sum.go", Lines: LineRange{3, 3}, Snippet: "func Sum(xs []int) int {", ToolCallID: "call_1"},
+			lines:    LineRange{3, 3},
+		},
+		{
+			name:     "WrongLines",
+			evidence: InvestigationEvidence{File: "calc/sum.go", Lines: LineRange{10, 11}, Snippet: "total := 0\nreturn total"},
+			lines:    LineRange{10, 11},
+			problem:  "the snippet is at line 4-5, not 10-11",
+		},
+		{
+			name:     "SuffixOfOtherFile",
+			evidence: InvestigationEvidence{File: "sum.go", Snippet: "return total", ToolCallID: "call_1"},
+			problem:  "tool call call_1 read calc/sum.go, not sum.go",
+		},
+		{
+			name:     "UnknownCall",
+			evidence: InvestigationEvidence{File: "calc/sum.go", Snippet: "return total", ToolCallID: "call_9"},
+			problem:  `no tool call has the ID "call_9"`,
+		},
+		{
+			name:     "NotFound",
+			evidence: InvestigationEvidence{File: "calc/sum.go", Snippet: "return 42"},
+			problem:  "the snippet is not in any tool result",
+		},
+		{
+			name:     "NoSnippet",
+			evidence: InvestigationEvidence{File: "calc/sum.go"},
+			problem:  "no snippet is quoted",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &InvestigationReport{Evidence: []InvestigationEvidence{tt.evidence}}
+			r.Verify("/work", history)
+			e := r.Evidence[0]
+			if e.Verified != (tt.problem == "") || e.Problem != tt.problem {
+				t.Fatalf("Verified = %v, Problem = %q, want problem %q", e.Verified, e.Problem, tt.problem)
+			}
+			if e.Verified && e.ToolCallID != "call_1" {
+				t.Errorf("ToolCallID = %q, want call_1", e.ToolCallID)
+			}
+			if e.Lines != tt.lines {
+				t.Errorf("Lines = %v, want %v", e.Lines, tt.lines)
+			}
+		})
+	}
+}
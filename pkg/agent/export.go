@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/llm"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// ExportFormats lists the formats Export writes.
+var ExportFormats = []string{"markdown", "html", "json"}
+
+// ExportFormat guesses the export format from a file name, defaulting to
+// markdown.
+func ExportFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		return "html"
+	case ".json":
+		return "json"
+	}
+	return "markdown"
+}
+
+// Export writes the conversation, including tool calls and their results,
+// to w. The json format is the same as a saved session.
+func (a *Agent) Export(w io.Writer, format string) error {
+	return a.session().Export(w, format)
+}
+
+// Export writes the session's conversation to w, like Agent.Export.
+func (s Session) Export(w io.Writer, format string) error {
+	title := s.Title
+	if title == "" {
+		title = defaultTitle(s.History)
+	}
+	if title == "" {
+		title = "Castor session"
+	}
+
+	switch format {
+	case "json":
+		s.Title = title
+		data, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal session: %w", err)
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	case "markdown":
+		_, err := io.WriteString(w, transcriptMarkdown(title, s.History))
+		return err
+	case "html":
+		var body bytes.Buffer
+		md := goldmark.New(goldmark.WithExtensions(extension.GFM))
+		if err := md.Convert([]byte(transcriptMarkdown(title, s.History)), &body); err != nil {
+			return fmt.Errorf("failed to render html: %w", err)
+		}
+		_, err := fmt.Fprintf(w, htmlTemplate, html.EscapeString(title), body.String())
+		return err
+	default:
+		return fmt.Errorf("unknown export format %q (expected %s)", format, strings.Join(ExportFormats, ", "))
+	}
+}
+
+// htmlTemplate wraps an exported transcript in a standalone page.
+const htmlTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: sans-serif; max-width: 52rem; margin: 2rem auto; padding: 0 1rem; line-height: 1.5; }
+pre { background: #f6f8fa; padding: 0.75rem; overflow-x: auto; }
+code { font-family: monospace; }
+h2 { border-bottom: 1px solid #ddd; }
+</style>
+</head>
+<body>
+%s</body>
+</html>
+`
+
+// transcriptMarkdown formats a history as markdown. The system prompt is
+// left out.
+func transcriptMarkdown(title string, history []llm.Message) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", title)
+	for _, msg := range history {
+		for _, part := range msg.Content {
+			switch p := part.(type) {
+			case llm.TextPart:
+				switch msg.Role {
+				case llm.RoleUser:
+					fmt.Fprintf(&b, "\n## You\n\n%s\n", p.Text)
+				case llm.RoleModel:
+					fmt.Fprintf(&b, "\n## Castor\n\n%s\n", p.Text)
+				}
+			case llm.ToolCallPart:
+				args, _ := json.MarshalIndent(p.Args, "", "  ")
+				fmt.Fprintf(&b, "\n### Tool call: %s\n\n%s\n", p.Name, fence(string(args), "json"))
+			case llm.ToolResponsePart:
+				fmt.Fprintf(&b, "\n### Result: %s\n\n%s\n", p.Name, formatResult(p.Content))
+			case llm.ImagePart:
+				fmt.Fprintf(&b, "\n*[%s image, %d bytes]*\n", p.MimeType, len(p.Data))
+			}
+		}
+	}
+	return b.String()
+}
+
+// formatResult presents a tool result for the transcript: diffs from
+// edits as diff blocks, and other output as it was returned.
+func formatResult(content string) string {
+	// String results are stored JSON-quoted
+	var s string
+	if json.Unmarshal([]byte(content), &s) == nil {
+		content = s
+	}
+
+	var edit struct {
+		Message string `json:"message"`
+		Diff    string `json:"diff"`
+	}
+	if json.Unmarshal([]byte(content), &edit) == nil && edit.Diff != "" {
+		return strings.TrimSpace(edit.Message + "\n\n" + fence(edit.Diff, "diff"))
+	}
+	if i := strings.Index(content, "--- "); i >= 0 && strings.Contains(content[i:], "\n+++ ") {
+		summary := strings.TrimSpace(content[:i])
+		if summary != "" {
+			summary += "\n\n"
+		}
+		return summary + fence(content[i:], "diff")
+	}
+	return fence(content, "")
+}
+
+// fence wraps text in a fenced code block, with a fence longer than any
+// run of backticks inside it.
+func fence(text, lang string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	marks := strings.Repeat("`", max(3, longest+1))
+	return marks + lang + "\n" + strings.TrimRight(text, "\n") + "\n" + marks
+}
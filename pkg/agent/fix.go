@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// DefaultFixAttempts is the number of times a fix is tested before the
+// fixer gives up, unless Fixer.MaxAttempts is set.
+const DefaultFixAttempts = 3
+
+// Fixer is a specialized agent loop that fixes a bug: it investigates
+// where the bug is, plans a fix, makes it, and runs the tests, feeding
+// failures back until they pass or its attempts run out.
+type Fixer struct {
+	// Agent supplies the provider, tools, and settings. Each stage runs
+	// in a sub-agent of its own, so Agent is left as it was.
+	Agent *Agent
+
+	Test        TestFunc // Runs the project's tests
+	MaxAttempts int      // Test runs before giving up; zero means DefaultFixAttempts
+
+	// Progress, if set, receives what the fixer is doing as it goes, in
+	// the stages FixStages. Sends block, so the channel must be read
+	// until Fix returns; it is not closed.
+	Progress chan<- WorkflowEvent
+}
+
+// FixStages are the stages of a fix, in order.
+var FixStages = []string{"investigate", "plan", "edit", "test"}
+
+// FixReport is the outcome of a fix.
+type FixReport struct {
+	Bug           string               `json:"bug"`
+	Investigation *InvestigationReport `json:"investigation"`
+	Plan          []string             `json:"plan"`
+	Summary       string               `json:"summary"` // What was changed, by the model
+	Attempts      int                  `json:"attempts"`
+	Tests         TestRun              `json:"tests"` // The last run
+	FilesChanged  []string             `json:"files_changed"`
+	Diff          string               `json:"diff"` // Unified diff of the changes to the workspace
+}
+
+// fixPrompt is the system prompt of the edit stage.
+const fixPrompt = `You are a software engineer fixing a bug. You are given the bug, an investigation of where it is, and a plan.
+Make the change the plan describes with your editing tools, reading the code first so your edits match it. Keep the change as small as the fix allows, and do not reformat code you do not change.
+When you are done, say so briefly. The tests are run after each of your attempts, and you are shown their output if they fail.`
+
+// planPrompt asks the model for the steps of a fix.
+const planPrompt = `You plan bug fixes. From the bug and the investigation of it you are given, decide the smallest change that fixes the bug.
+Call the 'submit_plan' tool with its steps: each names a file and what to change in it, in the order to do them. Include a step to add or update a test that shows the bug is fixed, if the project has tests.`
+
+// submitPlanSchema is the schema of the submit_plan tool.
+var submitPlanSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"steps": map[string]interface{}{
+			"type":        "array",
+			"items":       map[string]interface{}{"type": "string"},
+			"description": "The steps of the fix, each a file and the change to make in it",
+		},
+	},
+	"required": []string{"steps"},
+}
+
+// Fix fixes the bug described by bug, returning what was done. A report
+// is returned when the tests still fail after the last attempt; its
+// Tests tell whether they passed.
+func (f *Fixer) Fix(ctx context.Context, bug string) (*FixReport, error) {
+	if f.Test == nil {
+		return nil, fmt.Errorf("the fixer has no way to run the tests")
+	}
+	report := &FixReport{Bug: bug}
+	emit := func(event WorkflowEvent) { emitTo(ctx, f.Progress, event) }
+
+	// The investigation reads; only the edit stage may change anything
+	emit(WorkflowEvent{Stage: "investigate"})
+	progress := make(chan InvestigationEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range progress {
+			emit(WorkflowEvent{Stage: "investigate", Investigation: &event})
+		}
+	}()
+	inv := &Investigator{Agent: f.Agent, Tools: readOnlyToolNames(f.Agent), Progress: progress}
+	investigation, err := inv.Investigate(ctx, "Find the cause of this bug and the code that has to change to fix it: "+bug)
+	close(progress)
+	<-done
+	if err != nil {
+		return nil, fmt.Errorf("investigation failed: %w", err)
+	}
+	report.Investigation = investigation
+	findings, _ := json.MarshalIndent(investigation, "", "  ")
+
+	emit(WorkflowEvent{Stage: "plan"})
+	report.Plan, err = f.plan(ctx, bug, string(findings))
+	if err != nil {
+		return nil, err
+	}
+	emit(WorkflowEvent{Stage: "plan", Plan: report.Plan})
+
+	sub := f.Agent.subAgent(fixPrompt)
+	snapshots := newFileSnapshots(f.Agent.Workspace)
+	snapshotTools(sub, snapshots, nil)
+
+	var plan strings.Builder
+	for i, step := range report.Plan {
+		fmt.Fprintf(&plan, "%d. %s\n", i+1, step)
+	}
+	input := "Bug: " + bug + "\n\nInvestigation:\n\n```json\n" + string(findings) + "\n```\n\nPlan:\n\n" + plan.String()
+	maxAttempts := f.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultFixAttempts
+	}
+	report.Tests, report.Attempts, err = editUntilGreen(ctx, sub, input, maxAttempts, f.Test, func(output string) string {
+		return "The tests fail:\n\n```\n" + output + "\n```\n\nFix the code so they pass."
+	}, emit)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Diff, report.FilesChanged = snapshots.diff()
+	report.Summary, err = summarize(ctx, sub, "Summarize the change you made, and why it fixes the bug, in a few sentences. Say so if the tests still fail.")
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// plan asks the model for the steps of a fix.
+func (f *Fixer) plan(ctx context.Context, bug, findings string) ([]string, error) {
+	opts := f.Agent.Options
+	opts.Tools = []llm.ToolDefinition{{
+		Name:        "submit_plan",
+		Description: "Submit the steps of the fix.",
+		Schema:      submitPlanSchema,
+	}}
+	stream, err := f.Agent.Provider.GenerateContent(ctx, []llm.Message{
+		{Role: llm.RoleSystem, Content: []llm.Part{llm.TextPart{Text: planPrompt}}},
+		{Role: llm.RoleUser, Content: []llm.Part{llm.TextPart{Text: "Bug: " + bug + "\n\nInvestigation:\n\n```json\n" + findings + "\n```"}}},
+	}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan the fix: %w", err)
+	}
+
+	var steps []string
+	var text strings.Builder
+	for event := range stream {
+		if event.Error != nil {
+			return nil, fmt.Errorf("failed to plan the fix: %w", event.Error)
+		}
+		text.WriteString(event.Delta)
+		for _, call := range event.ToolCalls {
+			if call.Name != "submit_plan" {
+				continue
+			}
+			steps = append(steps, stringsArg(call.Args, "steps")...)
+		}
+	}
+	if len(steps) == 0 {
+		// A model that answers in prose still planned something
+		if plan := strings.TrimSpace(text.String()); plan != "" {
+			return []string{plan}, nil
+		}
+		return nil, fmt.Errorf("failed to plan the fix: the model made no plan")
+	}
+	return steps, nil
+}
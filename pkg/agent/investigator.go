@@ -3,77 +3,140 @@ package agent
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/techmuch/castor/pkg/llm"
 )
 
 // Investigator represents a specialized agent loop for research tasks.
 type Investigator struct {
+	// Agent supplies the provider, tools, and settings of the
+	// investigation. It runs in a sub-agent of its own, so Agent's history
+	// and system prompt are left as they were.
 	Agent *Agent
-}
 
-// InvestigationReport represents the structured output of an investigation.
-type InvestigationReport struct {
-	Goal          string   `json:"goal"`
-	Findings      []string `json:"findings"`
-	FilesExplored []string `json:"files_explored"`
-	Conclusion    string   `json:"conclusion"`
+	MaxTurns int // Rounds before giving up; zero means DefaultInvestigationTurns
+
+	// SystemPrompt replaces DefaultInvestigatorPrompt. The agent's own
+	// system prompt is appended to it either way.
+	SystemPrompt string
+
+	// Tools names the tools of Agent the investigation may use; nil means
+	// all of them. report_findings is always added.
+	Tools []string
+
+	// SubQuestions, if above 1, has the goal first split into at most that
+	// many sub-questions, which are investigated concurrently, each by a
+	// sub-agent with the same bounds, before their reports are combined.
+	// The tools must then be safe to run concurrently.
+	SubQuestions int
+
+	// Progress, if set, receives what the investigation is doing as it
+	// goes. Sends block, so the channel must be read until Investigate
+	// returns; it is not closed.
+	Progress chan<- InvestigationEvent
 }
 
-// Investigate executes the scratchpad loop to solve a complex query.
-func (inv *Investigator) Investigate(ctx context.Context, goal string) (*InvestigationReport, error) {
-	// 1. Setup System Prompt specialized for investigation
-	sysPrompt := `You are a Codebase Investigator. Your goal is to answer the user's query by exploring the codebase.
+// DefaultInvestigationTurns bounds the rounds of an investigation unless
+// Investigator.MaxTurns is set.
+const DefaultInvestigationTurns = 15
+
+// DefaultInvestigatorPrompt is the system prompt of an investigation
+// unless Investigator.SystemPrompt is set.
+const DefaultInvestigatorPrompt = `You are a Codebase Investigator. Your goal is to answer the user's query by exploring the codebase.
 You must maintain a structured thought process.
 Do not guess. Verify facts by reading files.
-You have access to 'ls', 'read_file', and 'grep' (if available). 
-Use them to explore the file structure and content.
+Use your tools to explore the file structure and content.
 
 When you have gathered enough information, call the 'report_findings' tool to finalize the task.
+Describe how you searched in the methodology, and back your findings with evidence: for each piece of code that supports them, its file, line range, a snippet copied verbatim from a tool result, and the ID of that tool call.
+Evidence is checked against the tool results, so cite only what you have read.
 `
-	reportTool := &ReportTool{}
-	inv.Agent.RegisterTool(reportTool)
-	
-	originalPrompt := inv.Agent.SystemPrompt
-	inv.Agent.SystemPrompt = sysPrompt + "\nOriginal Instructions: " + originalPrompt
-	originalHistory := inv.Agent.History
-	inv.Agent.History = []llm.Message{
-		{Role: llm.RoleSystem, Content: []llm.Part{llm.TextPart{Text: inv.Agent.SystemPrompt}}},
-		{Role: llm.RoleUser, Content: []llm.Part{llm.TextPart{Text: "Investigate: " + goal}}},
+
+// InvestigationEvent reports the progress of an investigation. An event
+// with only Turn set starts a round; the others carry a tool call that
+// starts or finishes, or what the model has found so far.
+type InvestigationEvent struct {
+	Turn     int // Round of the investigation, from 1
+	MaxTurns int
+
+	// Question is the sub-question the event belongs to, from 1, or zero
+	// for the goal itself. Questions, set once, lists them all when the
+	// goal has been split.
+	Question  int
+	Questions []string
+
+	Started *llm.ToolCallPart // A tool call begins
+	Result  *ToolResult       // A tool call finished
+	Finding string            // What the model said before its tool calls
+}
+
+// InvestigationReport represents the structured output of an investigation.
+type InvestigationReport struct {
+	Goal          string                  `json:"goal"`
+	Methodology   string                  `json:"methodology,omitempty"` // How the answer was looked for
+	Findings      []string                `json:"findings"`
+	Evidence      []InvestigationEvidence `json:"evidence,omitempty"`
+	FilesExplored []string                `json:"files_explored"`
+	Conclusion    string                  `json:"conclusion"`
+
+	// SubReports are the reports on the sub-questions the goal was split
+	// into, if it was. Their evidence is part of this report's.
+	SubReports []*InvestigationReport `json:"sub_reports,omitempty"`
+}
+
+// Investigate executes the scratchpad loop to solve a complex query.
+func (inv *Investigator) Investigate(ctx context.Context, goal string) (*InvestigationReport, error) {
+	if inv.SubQuestions > 1 {
+		return inv.investigateSplit(ctx, goal)
 	}
+	return inv.run(ctx, 0, inv.prompt(), inv.Tools, "Investigate: "+goal)
+}
 
-	defer func() {
-		// Restore agent state
-		inv.Agent.SystemPrompt = originalPrompt
-		inv.Agent.History = originalHistory
-		delete(inv.Agent.Tools, reportTool.Name())
-	}()
+// run investigates in a new sub-agent with the given prompt and tools,
+// starting from input, until the model reports its findings. Its events
+// belong to the given sub-question.
+func (inv *Investigator) run(ctx context.Context, question int, prompt string, tools []string, input string) (*InvestigationReport, error) {
+	sub, reportTool, err := inv.subAgent(prompt, tools)
+	if err != nil {
+		return nil, err
+	}
 
-	maxTurns := 15
+	maxTurns := inv.maxTurns()
 	for i := 0; i < maxTurns; i++ {
-		var stream <-chan llm.StreamEvent
-		var err error
-		
-		if i == 0 {
-			inv.Agent.History = []llm.Message{
-				{Role: llm.RoleSystem, Content: []llm.Part{llm.TextPart{Text: inv.Agent.SystemPrompt}}},
-			}
-			stream, err = inv.Agent.Chat(ctx, "Investigate: "+goal)
-		} else {
-			stream, err = inv.Agent.Chat(ctx, "Continue. If you have enough info, call report_findings.")
+		inv.emit(ctx, InvestigationEvent{Turn: i + 1, Question: question})
+		if i > 0 {
+			input = "Continue. If you have enough info, call report_findings."
 		}
-
+		stream, err := sub.Chat(ctx, input)
 		if err != nil {
 			return nil, err
 		}
 
+		var text strings.Builder
+		flush := func() {
+			if finding := strings.TrimSpace(text.String()); finding != "" {
+				inv.emit(ctx, InvestigationEvent{Turn: i + 1, Question: question, Finding: finding})
+			}
+			text.Reset()
+		}
 		for event := range stream {
 			if event.Error != nil {
 				return nil, event.Error
 			}
+			text.WriteString(event.Delta)
+			if event.Started != nil {
+				flush()
+				inv.emit(ctx, InvestigationEvent{Turn: i + 1, Question: question, Started: event.Started})
+			}
+			if event.Result != nil {
+				inv.emit(ctx, InvestigationEvent{Turn: i + 1, Question: question, Result: event.Result})
+			}
 		}
+		flush()
 
 		if reportTool.Report != nil {
+			reportTool.Report.Verify(sub.Workspace, sub.History)
 			return reportTool.Report, nil
 		}
 	}
@@ -81,19 +144,63 @@ When you have gathered enough information, call the 'report_findings' tool to fi
 	return nil, fmt.Errorf("investigation timed out after %d turns without a report", maxTurns)
 }
 
+// subAgent returns an agent for an investigation to run in: a copy of
+// Agent with the given prompt, the named tools (or all of them, for nil)
+// plus report_findings, and a history of its own.
+func (inv *Investigator) subAgent(prompt string, tools []string) (*Agent, *ReportTool, error) {
+	sub := inv.Agent.subAgent(prompt)
+	if tools != nil {
+		sub.Tools = make(map[string]Tool)
+	}
+	for _, name := range tools {
+		tool, ok := inv.Agent.Tools[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("the agent has no tool %q", name)
+		}
+		sub.Tools[name] = tool
+	}
+	reportTool := &ReportTool{}
+	sub.RegisterTool(reportTool)
+	return sub, reportTool, nil
+}
+
+// prompt returns the instructions of an investigation.
+func (inv *Investigator) prompt() string {
+	if inv.SystemPrompt != "" {
+		return inv.SystemPrompt
+	}
+	return DefaultInvestigatorPrompt
+}
+
+// maxTurns returns the rounds an investigation may take.
+func (inv *Investigator) maxTurns() int {
+	if inv.MaxTurns > 0 {
+		return inv.MaxTurns
+	}
+	return DefaultInvestigationTurns
+}
+
+// emit sends an event to Progress, if it is set.
+func (inv *Investigator) emit(ctx context.Context, event InvestigationEvent) {
+	event.MaxTurns = inv.maxTurns()
+	emitTo(ctx, inv.Progress, event)
+}
+
 // ReportTool is a special tool for the investigator to submit its final report.
 type ReportTool struct {
 	Report *InvestigationReport
 }
 
-func (t *ReportTool) Name() string { return "report_findings" }
+func (t *ReportTool) Name() string        { return "report_findings" }
 func (t *ReportTool) Description() string { return "Submit the final investigation report." }
 func (t *ReportTool) Schema() interface{} {
 	return map[string]interface{}{
 		"type": "object",
 		"properties": map[string]interface{}{
 			"goal":           map[string]interface{}{"type": "string"},
+			"methodology":    map[string]interface{}{"type": "string", "description": "How you looked for the answer: what you searched for and read"},
 			"findings":       map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"evidence":       evidenceSchema,
 			"files_explored": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
 			"conclusion":     map[string]interface{}{"type": "string"},
 		},
@@ -103,14 +210,15 @@ func (t *ReportTool) Schema() interface{} {
 
 func (t *ReportTool) Execute(ctx context.Context, args map[string]interface{}) (interface{}, error) {
 	report := &InvestigationReport{}
-	
+
 	if g, ok := args["goal"].(string); ok {
 		report.Goal = g
 	}
 	if c, ok := args["conclusion"].(string); ok {
 		report.Conclusion = c
 	}
-	
+	report.Methodology, _ = args["methodology"].(string)
+
 	if findings, ok := args["findings"].([]interface{}); ok {
 		for _, f := range findings {
 			if s, ok := f.(string); ok {
@@ -118,7 +226,7 @@ func (t *ReportTool) Execute(ctx context.Context, args map[string]interface{}) (
 			}
 		}
 	}
-	
+
 	if files, ok := args["files_explored"].([]interface{}); ok {
 		for _, f := range files {
 			if s, ok := f.(string); ok {
@@ -127,6 +235,15 @@ func (t *ReportTool) Execute(ctx context.Context, args map[string]interface{}) (
 		}
 	}
 
+	items, _ := args["evidence"].([]interface{})
+	for i, item := range items {
+		e, err := parseEvidence(item)
+		if err != nil {
+			return nil, fmt.Errorf("evidence %d: %w", i+1, err)
+		}
+		report.Evidence = append(report.Evidence, e)
+	}
+
 	t.Report = report
 	return "Report submitted successfully.", nil
-}
\ No newline at end of file
+}
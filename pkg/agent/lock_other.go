@@ -0,0 +1,36 @@
+//go:build !unix
+
+package agent
+
+import (
+	"os"
+	"time"
+)
+
+// staleLock is the age past which a lock file is taken to be left behind
+// by a process that died holding it.
+const staleLock = time.Minute
+
+// lockFile takes an exclusive lock by creating the file at path, which
+// must not exist yet, and returns the function that releases it.
+func lockFile(path string) (func(), error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLock {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errLocked
+		}
+		time.Sleep(lockPoll)
+	}
+}
@@ -4,28 +4,67 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"maps"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/techmuch/castor/pkg/llm"
 )
 
+// DefaultMaxTurns is the number of model requests a chat may make before
+// it stops, unless MaxTurns is changed.
+const DefaultMaxTurns = 10
+
+// DefaultTemperature is the sampling temperature of an agent's requests
+// unless Options changes it.
+const DefaultTemperature float32 = 0.7
+
 // Agent orchestrates the interaction between the user, the LLM, and tools.
 type Agent struct {
 	Provider     llm.Provider
 	Tools        map[string]Tool
 	History      []llm.Message
 	SystemPrompt string
-	MaxTurns     int
+	MaxTurns     int // Model requests per chat; see Event.TurnLimit
+
+	// Options are the sampling settings of each request. Chat fills in
+	// the tools.
+	Options llm.GenerateOptions
+
+	// Approval says which tool calls are first passed to Approve; the
+	// zero value runs them all unasked.
+	Approval ApprovalPolicy
+	Approve  Approver
+
+	// Logger, if set, receives a record of each model request and tool
+	// call. Failures are logged at warning level, the rest at debug.
+	Logger *slog.Logger
+
+	// TitleProvider, if set, names the session after its first turn; see
+	// NeedsTitle. It is typically a small, cheap model.
+	TitleProvider llm.Provider
+
+	// Session metadata, recorded by SaveSession
+	Model     string
+	Title     string
+	Workspace string // Absolute path of the workspace
+	Tags      []string
+	Created   time.Time
+	Usage     []TurnUsage // Tokens and cost of each turn, recorded by Chat
 }
 
 // New creates a new Agent instance.
 func New(provider llm.Provider, systemPrompt string) *Agent {
+	temperature := DefaultTemperature
 	agent := &Agent{
 		Provider:     provider,
 		Tools:        make(map[string]Tool),
 		SystemPrompt: systemPrompt,
 		History:      make([]llm.Message, 0),
-		MaxTurns:     10, // Default safety limit
+		MaxTurns:     DefaultMaxTurns, // Default safety limit
+		Options:      llm.GenerateOptions{Temperature: &temperature},
 	}
 
 	// Initialize history with system prompt if provided
@@ -44,9 +83,38 @@ func (a *Agent) RegisterTool(t Tool) {
 	a.Tools[t.Name()] = t
 }
 
+// KeepReadOnly removes the tools that may change something, leaving those
+// that implement ReadOnly and report true.
+func (a *Agent) KeepReadOnly() {
+	for name, tool := range a.Tools {
+		if ro, ok := tool.(ReadOnly); !ok || !ro.ReadOnly() {
+			delete(a.Tools, name)
+		}
+	}
+}
+
+// subAgent returns an agent for a specialized loop to run in: a copy of a
+// with the given system prompt, followed by a's own as the original
+// instructions, a history of its own, and a copy of a's tool registry.
+// Usage and session metadata are left behind.
+func (a *Agent) subAgent(prompt string) *Agent {
+	sub := *a
+	sub.Tools = maps.Clone(a.Tools)
+	sub.SystemPrompt = prompt
+	if a.SystemPrompt != "" {
+		sub.SystemPrompt += "\nOriginal Instructions: " + a.SystemPrompt
+	}
+	sub.History = []llm.Message{
+		{Role: llm.RoleSystem, Content: []llm.Part{llm.TextPart{Text: sub.SystemPrompt}}},
+	}
+	sub.Usage = nil
+	sub.Title, sub.Tags, sub.Created = "", nil, time.Time{}
+	return &sub
+}
+
 // Chat sends a message to the agent and returns a stream of events.
 // It handles the "Think-Act" loop: Model -> Tool Call -> Execution -> Model ...
-func (a *Agent) Chat(ctx context.Context, input string) (<-chan llm.StreamEvent, error) {
+func (a *Agent) Chat(ctx context.Context, input string) (<-chan Event, error) {
 	// Add user message to history
 	userMsg := llm.Message{
 		Role:    llm.RoleUser,
@@ -54,12 +122,16 @@ func (a *Agent) Chat(ctx context.Context, input string) (<-chan llm.StreamEvent,
 	}
 	a.History = append(a.History, userMsg)
 
-	outCh := make(chan llm.StreamEvent)
+	outCh := make(chan Event)
 
 	go func() {
 		defer close(outCh)
-		
+
 		for turn := 0; turn < a.MaxTurns; turn++ {
+			if ctx.Err() != nil {
+				return
+			}
+
 			// Prepare tools
 			var toolDefs []llm.ToolDefinition
 			for _, t := range a.Tools {
@@ -70,37 +142,46 @@ func (a *Agent) Chat(ctx context.Context, input string) (<-chan llm.StreamEvent,
 				})
 			}
 
-			opts := llm.GenerateOptions{
-				Temperature: 0.7,
-				Tools:       toolDefs,
-			}
+			opts := a.Options
+			opts.Tools = toolDefs
 
+			log := a.log().With("turn", turn+1)
+			log.Debug("model request", "messages", len(a.History), "tools", len(toolDefs))
+			start := time.Now()
 			stream, err := a.Provider.GenerateContent(ctx, a.History, opts)
 			if err != nil {
-				outCh <- llm.StreamEvent{Error: err}
+				log.Warn("model request failed", "error", err)
+				send(ctx, outCh, Event{StreamEvent: llm.StreamEvent{Error: err}})
 				return
 			}
 
 			var fullText strings.Builder
 			var toolCalls []llm.ToolCallPart
 
-			// Consume stream
+			// Consume stream. It is drained even after a cancel, so the
+			// provider is never left blocked on a send
 			for event := range stream {
 				if event.Error != nil {
-					outCh <- event
+					log.Warn("model response failed", "error", event.Error)
+					send(ctx, outCh, Event{StreamEvent: event})
 					return
 				}
-				
+
 				if event.Delta != "" {
 					fullText.WriteString(event.Delta)
 					// Pass text to user
-					outCh <- event
+					send(ctx, outCh, Event{StreamEvent: event})
 				}
-				
+
 				if len(event.ToolCalls) > 0 {
 					toolCalls = append(toolCalls, event.ToolCalls...)
 					// Pass tool calls to user (optional, for UI feedback)
-					outCh <- event
+					send(ctx, outCh, Event{StreamEvent: event})
+				}
+
+				if event.Usage != nil {
+					a.recordUsage(*event.Usage)
+					send(ctx, outCh, Event{StreamEvent: llm.StreamEvent{Usage: event.Usage}})
 				}
 			}
 
@@ -119,6 +200,7 @@ func (a *Agent) Chat(ctx context.Context, input string) (<-chan llm.StreamEvent,
 				modelMsg.Content = append(modelMsg.Content, tc)
 			}
 			a.History = append(a.History, modelMsg)
+			log.Debug("model response", "duration", time.Since(start), "text_bytes", fullText.Len(), "tool_calls", len(toolCalls))
 
 			// If no tool calls, we are done
 			if len(toolCalls) == 0 {
@@ -126,28 +208,73 @@ func (a *Agent) Chat(ctx context.Context, input string) (<-chan llm.StreamEvent,
 			}
 
 			// Execute Tools
-			for _, tc := range toolCalls {
+			for i, tc := range toolCalls {
+				if ctx.Err() != nil {
+					// Nothing more runs once the turn is canceled. The
+					// calls left over are answered so the history stays
+					// valid for the next request
+					log.Info("turn canceled", "skipped_tool_calls", len(toolCalls)-i)
+					a.skipCalls(toolCalls[i:])
+					return
+				}
 				tool, exists := a.Tools[tc.Name]
 				var resultStr string
-				
+				var attachments []llm.Part
+				result := &ToolResult{ToolCallID: tc.ID, Tool: tc.Name, Args: tc.Args}
+				var took time.Duration
+
+				// Ask before announcing the call, so it is not shown as
+				// running while it waits
+				var denied error
+				if exists {
+					denied = a.approve(ctx, tool, tc)
+				}
+				if denied == nil {
+					send(ctx, outCh, Event{Started: &tc})
+				}
+
 				if !exists {
 					resultStr = fmt.Sprintf("Error: Tool '%s' not found.", tc.Name)
+					result.Err = fmt.Errorf("tool %s not found", tc.Name)
+				} else if denied != nil {
+					resultStr = fmt.Sprintf("Error executing tool: %v", denied)
+					result.Err = denied
 				} else {
-					res, err := tool.Execute(ctx, tc.Args)
+					start := time.Now()
+					res, err := a.execute(ctx, tool, tc, outCh)
+					took = time.Since(start)
 					if err != nil {
 						resultStr = fmt.Sprintf("Error executing tool: %v", err)
+						result.Err = err
+					} else if parts, ok := res.([]llm.Part); ok {
+						// Rich results carry their text plus attachments such as images
+						resultStr, attachments = splitParts(parts)
+						result.Output = resultStr
 					} else {
 						// Marshal result to JSON string
 						resBytes, _ := json.Marshal(res)
 						resultStr = string(resBytes)
+						result.Output = resultStr
+						if s, ok := res.(string); ok {
+							result.Output = s
+						}
 					}
 				}
 
+				callLog := log.With("tool", tc.Name, "call_id", tc.ID, "duration", took)
+				if result.Err != nil {
+					callLog.Warn("tool call failed", "error", result.Err)
+				} else {
+					callLog.Debug("tool call", "result_bytes", len(resultStr))
+				}
+
+				send(ctx, outCh, Event{Result: result})
+
 				// Add tool result to history
 				// Note: Tool responses usually need to link back to the call ID.
 				// OpenAI expects role "tool", tool_call_id, and content.
 				// Our `ToolResponsePart` has ID.
-				
+
 				// We create a new message for EACH tool response?
 				// Usually yes, role="tool".
 				toolMsg := llm.Message{
@@ -160,11 +287,94 @@ func (a *Agent) Chat(ctx context.Context, input string) (<-chan llm.StreamEvent,
 						},
 					},
 				}
+				toolMsg.Content = append(toolMsg.Content, attachments...)
 				a.History = append(a.History, toolMsg)
 			}
 			// Loop continues to next turn to feed tool results back to LLM
 		}
+
+		// The model has not seen the results of the last tool calls
+		a.log().Info("turn limit reached", "max_turns", a.MaxTurns)
+		send(ctx, outCh, Event{TurnLimit: a.MaxTurns})
 	}()
 
 	return outCh, nil
-}
\ No newline at end of file
+}
+
+// send delivers ev unless ctx is canceled first.
+func send(ctx context.Context, outCh chan<- Event, ev Event) {
+	select {
+	case outCh <- ev:
+	case <-ctx.Done():
+	}
+}
+
+// skipCalls records a response for each tool call that was not run
+// because the turn was canceled.
+func (a *Agent) skipCalls(calls []llm.ToolCallPart) {
+	for _, tc := range calls {
+		a.History = append(a.History, llm.Message{
+			Role: llm.RoleTool,
+			Content: []llm.Part{
+				llm.ToolResponsePart{
+					ID:      tc.ID,
+					Name:    tc.Name,
+					Content: "Error: canceled by the user before the tool ran.",
+				},
+			},
+		})
+	}
+}
+
+// log returns Logger, or a logger that discards.
+func (a *Agent) log() *slog.Logger {
+	if a.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return a.Logger
+}
+
+// splitParts separates the text of a rich tool result from the parts that
+// are attached to the tool response as-is.
+func splitParts(parts []llm.Part) (string, []llm.Part) {
+	var text []string
+	var attachments []llm.Part
+	for _, p := range parts {
+		if t, ok := p.(llm.TextPart); ok {
+			text = append(text, t.Text)
+		} else {
+			attachments = append(attachments, p)
+		}
+	}
+	return strings.Join(text, "\n"), attachments
+}
+
+// execute runs a tool call, forwarding any progress it reports as events.
+// Progress that arrives after the tool has returned is dropped.
+func (a *Agent) execute(ctx context.Context, tool Tool, tc llm.ToolCallPart, outCh chan<- Event) (interface{}, error) {
+	var mu sync.Mutex
+	done := false
+	report := func(progress, total float64, message string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if done {
+			return
+		}
+		event := Event{Progress: &ToolProgress{
+			ToolCallID: tc.ID,
+			Tool:       tc.Name,
+			Progress:   progress,
+			Total:      total,
+			Message:    message,
+		}}
+		send(ctx, outCh, event)
+	}
+
+	toolCtx := WithProgress(WithToolCallID(ctx, tc.ID), report)
+	res, err := tool.Execute(toolCtx, tc.Args)
+
+	mu.Lock()
+	done = true
+	mu.Unlock()
+	return res, err
+}
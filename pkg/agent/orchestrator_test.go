@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// scriptedProvider answers each request with the next of its replies,
+// and with "Done." once they run out.
+type scriptedProvider struct {
+	mu       sync.Mutex
+	replies  [][]llm.StreamEvent
+	requests [][]llm.Message // The history of each request
+}
+
+func (p *scriptedProvider) GenerateContent(ctx context.Context, history []llm.Message, opts llm.GenerateOptions) (<-chan llm.StreamEvent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests = append(p.requests, append([]llm.Message(nil), history...))
+	reply := []llm.StreamEvent{{Delta: "Done."}}
+	if len(p.replies) > 0 {
+		reply, p.replies = p.replies[0], p.replies[1:]
+	}
+	ch := make(chan llm.StreamEvent, len(reply))
+	for _, event := range reply {
+		ch <- event
+	}
+	close(ch)
+	return ch, nil
+}
+
+func (p *scriptedProvider) EmbedContent(ctx context.Context, texts []string) ([][]float32, error) {
+	return nil, errors.New("not supported")
+}
+
+// callTools is a reply that calls the named tools, with IDs call_1 on.
+func callTools(names ...string) []llm.StreamEvent {
+	var calls []llm.ToolCallPart
+	for i, name := range names {
+		calls = append(calls, llm.ToolCallPart{ID: fmt.Sprintf("call_%d", i+1), Name: name, Args: map[string]interface{}{}})
+	}
+	return []llm.StreamEvent{{ToolCalls: calls}}
+}
+
+// fakeTool is a tool that runs run, or returns "ok".
+type fakeTool struct {
+	name     string
+	readOnly bool
+	run      func(ctx context.Context, args map[string]interface{}) (interface{}, error)
+	calls    int
+}
+
+func (t *fakeTool) Name() string        { return t.name }
+func (t *fakeTool) Description() string { return "A tool for tests." }
+func (t *fakeTool) Schema() interface{} { return map[string]interface{}{"type": "object"} }
+func (t *fakeTool) ReadOnly() bool      { return t.readOnly }
+func (t *fakeTool) Execute(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	t.calls++
+	if t.run != nil {
+		return t.run(ctx, args)
+	}
+	return "ok", nil
+}
+
+// collect runs a chat and returns its events.
+func collect(t *testing.T, ctx context.Context, a *Agent, input string) []Event {
+	t.Helper()
+	stream, err := a.Chat(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []Event
+	for event := range stream {
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestChatTurnLimit(t *testing.T) {
+	provider := &scriptedProvider{replies: [][]llm.StreamEvent{callTools("echo"), callTools("echo"), callTools("echo")}}
+	a := New(provider, "")
+	a.MaxTurns = 2
+	a.RegisterTool(&fakeTool{name: "echo", readOnly: true})
+
+	events := collect(t, context.Background(), a, "Loop.")
+	if len(provider.requests) != 2 {
+		t.Errorf("made %d requests, want 2", len(provider.requests))
+	}
+	if len(events) == 0 || events[len(events)-1].TurnLimit != 2 {
+		t.Fatalf("last event is not TurnLimit 2: %+v", events)
+	}
+	for _, event := range events[:len(events)-1] {
+		if event.TurnLimit != 0 {
+			t.Errorf("TurnLimit before the end: %+v", event)
+		}
+	}
+}
+
+func TestChatCancelAnswersRemainingCalls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	provider := &scriptedProvider{replies: [][]llm.StreamEvent{callTools("stop", "echo", "echo")}}
+	a := New(provider, "")
+	echo := &fakeTool{name: "echo", readOnly: true}
+	a.RegisterTool(echo)
+	a.RegisterTool(&fakeTool{name: "stop", readOnly: true, run: func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+		cancel()
+		return "stopped", nil
+	}})
+
+	collect(t, ctx, a, "Go.")
+	if echo.calls != 0 {
+		t.Errorf("echo ran %d times after the cancel", echo.calls)
+	}
+	if len(provider.requests) != 1 {
+		t.Errorf("made %d requests, want 1", len(provider.requests))
+	}
+
+	// Every call of the model message is answered, in order
+	answered := map[string]string{}
+	var order []string
+	for _, msg := range a.History {
+		if msg.Role != llm.RoleTool {
+			continue
+		}
+		resp := msg.Content[0].(llm.ToolResponsePart)
+		answered[resp.ID] = resp.Content
+		order = append(order, resp.ID)
+	}
+	if fmt.Sprint(order) != "[call_1 call_2 call_3]" {
+		t.Fatalf("answered calls %v, want call_1 to call_3", order)
+	}
+	for _, id := range []string{"call_2", "call_3"} {
+		if answered[id] != "Error: canceled by the user before the tool ran." {
+			t.Errorf("%s answered %q", id, answered[id])
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+)
+
+// DefaultRefactorAttempts is the number of times a step of a refactor is
+// tried before it is left out, unless Refactorer.MaxAttempts is set.
+const DefaultRefactorAttempts = 2
+
+// ErrBaselineFails is returned when the tests fail before a refactor, so
+// there is no way to tell whether the refactor broke something.
+var ErrBaselineFails = errors.New("the tests fail before the refactor")
+
+// transactionTool is the tool a refactor makes its edits with.
+const transactionTool = "edit_transaction"
+
+// Refactorer is a specialized agent loop that refactors code without
+// changing what it does: starting from passing tests, it plans the
+// refactor in steps, makes each step with transactional edits, and runs
+// the tests after it, rolling back a step that breaks them.
+type Refactorer struct {
+	// Agent supplies the provider, tools, and settings. Each stage runs
+	// in a sub-agent of its own, so Agent is left as it was. Its tools
+	// must include edit_transaction, the only tool the steps may change
+	// files with.
+	Agent *Agent
+
+	Test        TestFunc // Runs the project's tests
+	MaxAttempts int      // Tries of each step; zero means DefaultRefactorAttempts
+
+	// Progress, if set, receives what the refactorer is doing as it goes,
+	// in the stages RefactorStages. Sends block, so the channel must be
+	// read until Refactor returns; it is not closed.
+	Progress chan<- WorkflowEvent
+}
+
+// RefactorStages are the stages of a refactor, in order. Edit, test, and
+// rollback are repeated for each step.
+var RefactorStages = []string{"baseline", "plan", "edit", "test", "rollback"}
+
+// RefactorReport is the outcome of a refactor.
+type RefactorReport struct {
+	Request      string         `json:"request"`
+	Baseline     TestRun        `json:"baseline"` // The tests before the refactor
+	Plan         []string       `json:"plan"`
+	Steps        []RefactorStep `json:"steps"`
+	Summary      string         `json:"summary"` // What was changed, by the model
+	Tests        TestRun        `json:"tests"`   // The last passing run
+	FilesChanged []string       `json:"files_changed"`
+	Diff         string         `json:"diff"`
+}
+
+// RefactorStep is the outcome of one step of a refactor.
+type RefactorStep struct {
+	Step     string  `json:"step"`
+	Applied  bool    `json:"applied"` // False if every try broke the tests and was rolled back
+	Attempts int     `json:"attempts"`
+	Tests    TestRun `json:"tests"` // The last run
+}
+
+// Complete reports whether every step of the refactor was applied.
+func (r *RefactorReport) Complete() bool {
+	for _, step := range r.Steps {
+		if !step.Applied {
+			return false
+		}
+	}
+	return true
+}
+
+// refactorPlanPrompt is the system prompt of the planning of a refactor.
+const refactorPlanPrompt = `You plan refactors: changes to the structure of code that leave what it does unchanged. Read the code the refactor you are asked for touches, and its callers.
+Then call the 'submit_plan' tool with the steps of the refactor, in order. Each step must leave the code building and its tests passing on its own, so a step that breaks them can be rolled back alone: rename a symbol together with its callers, and move code in one step rather than deleting it in one and adding it in another.`
+
+// refactorPrompt is the system prompt of the steps of a refactor.
+const refactorPrompt = `You are a software engineer refactoring code one step at a time, from a plan you are given. Do not change what the code does.
+Make the changes of each step with the 'edit_transaction' tool: stage every change of the step, preview it, and commit it, reading the code first so your edits match it. Do only the step you are asked for.
+When you are done, say so briefly. The tests are run after each step; a step that breaks them is rolled back, and you are shown their output.`
+
+// Refactor makes the refactor described by request, returning what was
+// done. A report is returned when steps were rolled back; Complete tells
+// whether they all were applied. When the tests fail before the refactor,
+// the report has only its Baseline and the error is ErrBaselineFails.
+func (r *Refactorer) Refactor(ctx context.Context, request string) (*RefactorReport, error) {
+	if r.Test == nil {
+		return nil, fmt.Errorf("the refactorer has no way to run the tests")
+	}
+	if _, ok := r.Agent.Tools[transactionTool]; !ok {
+		return nil, fmt.Errorf("refactoring needs the %s tool", transactionTool)
+	}
+	report := &RefactorReport{Request: request}
+	emit := func(event WorkflowEvent) { emitTo(ctx, r.Progress, event) }
+
+	emit(WorkflowEvent{Stage: "baseline"})
+	baseline, err := runTests(ctx, r.Test)
+	if err != nil {
+		return nil, err
+	}
+	emit(WorkflowEvent{Stage: "baseline", Test: &baseline})
+	report.Baseline, report.Tests = baseline, baseline
+	if !baseline.Passed {
+		return report, ErrBaselineFails
+	}
+
+	emit(WorkflowEvent{Stage: "plan"})
+	planner := r.Agent.subAgent(refactorPlanPrompt)
+	planner.KeepReadOnly()
+	report.Plan, err = gatherList(ctx, planner, &listTool{
+		name:        "submit_plan",
+		description: "Submit the steps of the refactor.",
+		field:       "steps",
+		items:       "The steps of the refactor, each leaving the code building and its tests passing",
+	}, "Plan this refactor: "+request, func(event Event) {
+		emit(WorkflowEvent{Stage: "plan", Started: event.Started, Result: event.Result})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan the refactor: %w", err)
+	}
+	emit(WorkflowEvent{Stage: "plan", Plan: report.Plan})
+
+	// Steps read with any tool but change files only with transactions
+	sub := r.Agent.subAgent(refactorPrompt)
+	transaction := sub.Tools[transactionTool]
+	sub.KeepReadOnly()
+	sub.Tools[transactionTool] = transaction
+	snapshots := newFileSnapshots(r.Agent.Workspace)
+	snapshotTools(sub, snapshots, nil)
+	tools := sub.Tools
+
+	var note strings.Builder
+	fmt.Fprintf(&note, "Refactor: %s\n\nPlan:\n\n", request)
+	for i, step := range report.Plan {
+		fmt.Fprintf(&note, "%d. %s\n", i+1, step)
+	}
+	pending := note.String() + "\n"
+	maxAttempts := r.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultRefactorAttempts
+	}
+	for i, text := range report.Plan {
+		step := RefactorStep{Step: text}
+		input := pending + fmt.Sprintf("Do step %d: %s", i+1, text)
+		pending = ""
+		for step.Attempts < maxAttempts && !step.Applied {
+			step.Attempts++
+			// Each try is snapshotted on its own, to roll back just it
+			tries := newFileSnapshots(r.Agent.Workspace)
+			sub.Tools = maps.Clone(tools)
+			snapshotTools(sub, tries, nil)
+
+			emit(WorkflowEvent{Stage: "edit", Step: i + 1, Attempt: step.Attempts})
+			if err := chatEvents(ctx, sub, input, func(event Event) {
+				emit(WorkflowEvent{Stage: "edit", Step: i + 1, Attempt: step.Attempts, Started: event.Started, Result: event.Result})
+			}); err != nil {
+				return nil, err
+			}
+
+			emit(WorkflowEvent{Stage: "test", Step: i + 1, Attempt: step.Attempts})
+			if step.Tests, err = runTests(ctx, r.Test); err != nil {
+				return nil, err
+			}
+			run := step.Tests
+			emit(WorkflowEvent{Stage: "test", Step: i + 1, Attempt: step.Attempts, Test: &run})
+			if step.Tests.Passed {
+				step.Applied = true
+				report.Tests = step.Tests
+				break
+			}
+
+			restored, err := tries.restore()
+			if err != nil {
+				return nil, fmt.Errorf("failed to roll back step %d: %w", i+1, err)
+			}
+			emit(WorkflowEvent{Stage: "rollback", Step: i + 1, Attempt: step.Attempts, Restored: restored})
+			failure := fmt.Sprintf("Step %d broke the tests and was rolled back:\n\n```\n%s\n```\n\n", i+1, strings.TrimSpace(step.Tests.Output))
+			if step.Attempts < maxAttempts {
+				input = failure + "Try the step again, another way."
+			} else {
+				pending = failure + "Leave it out.\n\n"
+			}
+		}
+		report.Steps = append(report.Steps, step)
+	}
+	sub.Tools = tools
+
+	report.Diff, report.FilesChanged = snapshots.diff()
+	report.Summary, err = summarize(ctx, sub, "Summarize the refactor you made in a few sentences, naming any step that was rolled back.")
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// writeTool is an edit_transaction stand-in with path staged: a call
+// writes its content argument to path.
+type writeTool struct {
+	fakeTool
+	path string
+}
+
+func (t *writeTool) ChangedFiles(args map[string]interface{}) []string {
+	return []string{t.path}
+}
+
+func (t *writeTool) Execute(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	content, _ := args["content"].(string)
+	return "Committed.", os.WriteFile(t.path, []byte(content), 0644)
+}
+
+func TestRefactorRollsBackBrokenStep(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "a.go")
+	if err := os.WriteFile(file, []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	call := func(name string, args map[string]interface{}) []llm.StreamEvent {
+		return []llm.StreamEvent{{ToolCalls: []llm.ToolCallPart{{ID: "call_1", Name: name, Args: args}}}}
+	}
+	provider := &scriptedProvider{replies: [][]llm.StreamEvent{
+		call("submit_plan", map[string]interface{}{"steps": []interface{}{"Rename a.go's package"}}),
+		{{Delta: "Planned."}},
+		call(transactionTool, map[string]interface{}{"action": "commit", "content": "package b\n"}),
+		{{Delta: "Done."}},
+		{{Delta: "Nothing was kept."}},
+	}}
+	a := New(provider, "")
+	a.Workspace = root
+	a.RegisterTool(&writeTool{fakeTool: fakeTool{name: transactionTool}, path: file})
+
+	// The tests pass until a.go changes
+	test := func(ctx context.Context) (string, bool, error) {
+		data, _ := os.ReadFile(file)
+		if string(data) != "package a\n" {
+			return "FAIL: a.go: package b", false, nil
+		}
+		return "ok", true, nil
+	}
+	progress := make(chan WorkflowEvent, 100)
+	r := &Refactorer{Agent: a, Test: test, MaxAttempts: 1, Progress: progress}
+	report, err := r.Refactor(context.Background(), "Rename the package")
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(progress)
+
+	if data, _ := os.ReadFile(file); string(data) != "package a\n" {
+		t.Errorf("a.go = %q after the rollback", data)
+	}
+	if report.Complete() || len(report.Steps) != 1 || report.Steps[0].Tests.Passed {
+		t.Errorf("steps = %+v", report.Steps)
+	}
+	if report.Diff != "" || len(report.FilesChanged) != 0 {
+		t.Errorf("kept changes: %v\n%s", report.FilesChanged, report.Diff)
+	}
+	var restored []string
+	for event := range progress {
+		if event.Stage == "rollback" {
+			restored = append(restored, event.Restored...)
+		}
+	}
+	if strings.Join(restored, " ") != "a.go" {
+		t.Errorf("restored %v, want a.go", restored)
+	}
+}
@@ -0,0 +1,204 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/diff"
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// ReviewSeverities are the severities of review findings, most serious
+// first.
+var ReviewSeverities = []string{"error", "warning", "info"}
+
+// ReviewCategories are the kinds of problem a review finding can be.
+var ReviewCategories = []string{"bug", "security", "concurrency", "error-handling", "performance", "tests", "maintainability"}
+
+// Reviewer is a specialized agent loop that reviews a diff, one hunk at a
+// time.
+type Reviewer struct {
+	// Agent supplies the provider, tools, and settings. Each hunk is
+	// reviewed in a sub-agent of its own, so Agent is left as it was.
+	Agent *Agent
+
+	// Progress, if set, receives an event as each hunk's review starts.
+	// Sends block, so the channel must be read until Review returns; it
+	// is not closed.
+	Progress chan<- ReviewEvent
+}
+
+// ReviewEvent reports that the review of a hunk has started.
+type ReviewEvent struct {
+	Hunk  int // From 1
+	Hunks int
+	File  string
+}
+
+// ReviewReport is the structured output of a review.
+type ReviewReport struct {
+	Summary  string          `json:"summary"`
+	Hunks    int             `json:"hunks"` // Hunks reviewed
+	Findings []ReviewFinding `json:"findings"`
+}
+
+// ReviewFinding is a problem found in a reviewed change.
+type ReviewFinding struct {
+	File       string `json:"file"`
+	Line       int    `json:"line,omitempty"` // In the new version of the file; zero for the file as a whole
+	Hunk       int    `json:"hunk,omitempty"` // The hunk it was found in, from 1
+	Severity   string `json:"severity"`       // One of ReviewSeverities
+	Category   string `json:"category"`       // One of ReviewCategories
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion,omitempty"`
+	Patch      string `json:"patch,omitempty"` // Unified diff against the new version that makes the suggestion
+}
+
+// reviewPrompt is the system prompt of the review of a hunk.
+const reviewPrompt = `You are a senior code reviewer. You review a change one hunk of its unified diff at a time; you are given the list of all its hunks for orientation and the one to review.
+Look for bugs, security problems, race conditions, missing error handling, missing tests, and unclear code, in that order of importance. Do not comment on formatting that a formatter would fix, and do not praise.
+You may read files in the workspace to understand the code around the hunk, but the hunk is what you review. Other hunks are reviewed separately, so only report what this one gets wrong.
+Each finding names the file and the line in the new version of the file, a severity (error for bugs and security problems, warning for likely problems, info for improvements), a category, what is wrong, and optionally a suggested fix, as a unified diff against the new version where you can give one.
+When you are done, call the 'submit_review' tool once with the hunk's findings. Submit an empty list if the hunk looks right.`
+
+// reviewSummaryPrompt asks the model to sum up the findings of a review.
+const reviewSummaryPrompt = `You sum up code reviews. Given the hunks of a change and the findings of their review, describe the change and its overall quality in one or two sentences. Reply with the summary only.`
+
+// Review asks the agent to review diff, a unified diff, hunk by hunk, and
+// returns what it finds.
+func (r *Reviewer) Review(ctx context.Context, diffText string) (*ReviewReport, error) {
+	hunks := diff.ParseHunks(diffText)
+	report := &ReviewReport{Hunks: len(hunks), Findings: []ReviewFinding{}}
+	if len(hunks) == 0 {
+		report.Summary = "The diff has no text changes to review."
+		return report, nil
+	}
+
+	var overview strings.Builder
+	for i, h := range hunks {
+		header, _, _ := strings.Cut(h.Text, "\n")
+		fmt.Fprintf(&overview, "%d. %s %s\n", i+1, h.File, header)
+	}
+	for i, h := range hunks {
+		emitTo(ctx, r.Progress, ReviewEvent{Hunk: i + 1, Hunks: len(hunks), File: h.File})
+		findings, err := r.reviewHunk(ctx, overview.String(), i+1, h)
+		if err != nil {
+			return nil, fmt.Errorf("hunk %d of %s: %w", i+1, h.File, err)
+		}
+		report.Findings = append(report.Findings, findings...)
+	}
+
+	summary, err := r.summarize(ctx, overview.String(), report.Findings)
+	if err != nil {
+		return nil, err
+	}
+	report.Summary = summary
+	return report, nil
+}
+
+// reviewHunk reviews hunk n of a change in a sub-agent of its own.
+func (r *Reviewer) reviewHunk(ctx context.Context, overview string, n int, hunk diff.Hunk) ([]ReviewFinding, error) {
+	sub := r.Agent.subAgent(reviewPrompt)
+	tool := &ReviewTool{}
+	prompt := fmt.Sprintf("The change has these hunks:\n\n%s\nReview hunk %d, of %s:\n\n```diff\n%s\n```",
+		overview, n, hunk.File, strings.TrimRight(hunk.Text, "\n"))
+	if err := chatUntilSubmitted(ctx, sub, tool, prompt, nil); err != nil {
+		return nil, err
+	}
+	for i := range tool.Findings {
+		tool.Findings[i].Hunk = n
+	}
+	return tool.Findings, nil
+}
+
+// summarize asks the model for the summary of a review.
+func (r *Reviewer) summarize(ctx context.Context, overview string, findings []ReviewFinding) (string, error) {
+	data, _ := json.MarshalIndent(findings, "", "  ")
+	stream, err := r.Agent.Provider.GenerateContent(ctx, []llm.Message{
+		{Role: llm.RoleSystem, Content: []llm.Part{llm.TextPart{Text: reviewSummaryPrompt}}},
+		{Role: llm.RoleUser, Content: []llm.Part{llm.TextPart{Text: "Hunks:\n\n" + overview + "\nFindings:\n\n```json\n" + string(data) + "\n```"}}},
+	}, r.Agent.Options)
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize the review: %w", err)
+	}
+	var summary strings.Builder
+	for event := range stream {
+		if event.Error != nil {
+			return "", fmt.Errorf("failed to summarize the review: %w", event.Error)
+		}
+		summary.WriteString(event.Delta)
+	}
+	return strings.TrimSpace(summary.String()), nil
+}
+
+// ReviewTool is the tool the reviewer submits the findings of a hunk with.
+type ReviewTool struct {
+	Findings []ReviewFinding // Nil until submitted
+}
+
+func (t *ReviewTool) Name() string        { return "submit_review" }
+func (t *ReviewTool) Description() string { return "Submit the findings of the hunk's review." }
+func (t *ReviewTool) ReadOnly() bool      { return true }
+func (t *ReviewTool) submitted() bool     { return t.Findings != nil }
+func (t *ReviewTool) Schema() interface{} {
+	str := map[string]interface{}{"type": "string"}
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"findings": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"file":       map[string]interface{}{"type": "string", "description": "Path of the file, as in the diff"},
+						"line":       map[string]interface{}{"type": "integer", "description": "Line in the new version of the file"},
+						"severity":   map[string]interface{}{"type": "string", "enum": ReviewSeverities},
+						"category":   map[string]interface{}{"type": "string", "enum": ReviewCategories},
+						"message":    str,
+						"suggestion": str,
+						"patch":      map[string]interface{}{"type": "string", "description": "Unified diff against the new version of the file that makes the suggested fix"},
+					},
+					"required": []string{"file", "severity", "category", "message"},
+				},
+			},
+		},
+		"required": []string{"findings"},
+	}
+}
+
+func (t *ReviewTool) Execute(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	findings := []ReviewFinding{}
+	items, _ := args["findings"].([]interface{})
+	for i, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("finding %d is not an object", i+1)
+		}
+		var f ReviewFinding
+		f.File, _ = m["file"].(string)
+		f.Severity, _ = m["severity"].(string)
+		f.Category, _ = m["category"].(string)
+		f.Message, _ = m["message"].(string)
+		f.Suggestion, _ = m["suggestion"].(string)
+		f.Patch, _ = m["patch"].(string)
+		if line, ok := m["line"].(float64); ok && line > 0 {
+			f.Line = int(line)
+		}
+		if f.File == "" || f.Message == "" {
+			return nil, fmt.Errorf("finding %d needs a file and a message", i+1)
+		}
+		if !slices.Contains(ReviewSeverities, f.Severity) {
+			return nil, fmt.Errorf("finding %d has severity %q; expected %s", i+1, f.Severity, strings.Join(ReviewSeverities, ", "))
+		}
+		if !slices.Contains(ReviewCategories, f.Category) {
+			return nil, fmt.Errorf("finding %d has category %q; expected %s", i+1, f.Category, strings.Join(ReviewCategories, ", "))
+		}
+		findings = append(findings, f)
+	}
+
+	t.Findings = findings
+	return "Review submitted successfully.", nil
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+func TestReviewToolValidation(t *testing.T) {
+	finding := func(severity, category string) map[string]interface{} {
+		return map[string]interface{}{"file": "a.go", "line": float64(3), "severity": severity, "category": category, "message": "Unchecked error."}
+	}
+	tests := []struct {
+		name     string
+		findings []interface{}
+		err      string
+	}{
+		{"Valid", []interface{}{finding("error", "error-handling")}, ""},
+		{"Empty", []interface{}{}, ""},
+		{"Severity", []interface{}{finding("critical", "bug")}, `finding 1 has severity "critical"`},
+		{"Category", []interface{}{finding("info", "bug"), finding("info", "style")}, `finding 2 has category "style"`},
+		{"NoMessage", []interface{}{map[string]interface{}{"file": "a.go", "severity": "info", "category": "bug"}}, "finding 1 needs a file and a message"},
+		{"NotObject", []interface{}{"a.go is wrong"}, "finding 1 is not an object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool := &ReviewTool{}
+			_, err := tool.Execute(context.Background(), map[string]interface{}{"findings": tt.findings})
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tool.Findings == nil || len(tool.Findings) != len(tt.findings) {
+					t.Errorf("Findings = %+v", tool.Findings)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+			if tool.Findings != nil {
+				t.Errorf("invalid findings were kept: %+v", tool.Findings)
+			}
+		})
+	}
+}
+
+func TestReviewHunkByHunk(t *testing.T) {
+	diffText := `--- a/a.go
++++ b/a.go
+@@ -1,2 +1,2 @@
+ package a
+-var x = 1
++var x = 2
+--- a/b.go
++++ b/b.go
+@@ -5 +5 @@
+-	return nil
++	return err
+`
+	submit := func(file string, line float64) []llm.StreamEvent {
+		return []llm.StreamEvent{{ToolCalls: []llm.ToolCallPart{{ID: "call_1", Name: "submit_review", Args: map[string]interface{}{
+			"findings": []interface{}{map[string]interface{}{"file": file, "line": line, "severity": "warning", "category": "bug", "message": "Changed."}},
+		}}}}}
+	}
+	provider := &scriptedProvider{replies: [][]llm.StreamEvent{
+		submit("a.go", 2),
+		{{Delta: "Done."}},
+		{{Delta: "Looking."}}, // Ends without submitting, so it is nudged
+		submit("b.go", 5),
+		{{Delta: "Done."}},
+		{{Delta: "Two small changes."}}, // The summary
+	}}
+	progress := make(chan ReviewEvent, 4)
+	r := &Reviewer{Agent: New(provider, ""), Progress: progress}
+
+	report, err := r.Review(context.Background(), diffText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(progress)
+	if report.Hunks != 2 || len(report.Findings) != 2 {
+		t.Fatalf("report = %+v", report)
+	}
+	for i, want := range []string{"a.go", "b.go"} {
+		if f := report.Findings[i]; f.File != want || f.Hunk != i+1 {
+			t.Errorf("finding %d = %+v, want %s in hunk %d", i+1, f, want, i+1)
+		}
+	}
+	var files []string
+	for event := range progress {
+		files = append(files, event.File)
+	}
+	if strings.Join(files, " ") != "a.go b.go" {
+		t.Errorf("progress for %v", files)
+	}
+	if report.Summary != "Two small changes." {
+		t.Errorf("Summary = %q", report.Summary)
+	}
+
+	// The second hunk is reviewed in a history of its own
+	second := provider.requests[2]
+	for _, msg := range second {
+		for _, part := range msg.Content {
+			if text, ok := part.(llm.TextPart); ok && strings.Contains(text.Text, "```diff\n@@ -1,2") {
+				t.Errorf("second review sees the first hunk:\n%s", text.Text)
+			}
+		}
+	}
+}
@@ -3,45 +3,285 @@ package agent
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/techmuch/castor/pkg/llm"
 )
 
 // Session represents a persistable agent state.
 type Session struct {
+	Version      int           `json:"version"` // Format version; see SessionVersion
+	Title        string        `json:"title,omitempty"`
+	Model        string        `json:"model,omitempty"`
+	Workspace    string        `json:"workspace,omitempty"` // Absolute path of the workspace the session ran in
+	Tags         []string      `json:"tags,omitempty"`
+	Created      time.Time     `json:"created,omitzero"`
+	Updated      time.Time     `json:"updated,omitzero"`
+	Turns        int           `json:"turns"`             // Save writes a summary ahead of the history; see ListSessions
+	Tokens       int           `json:"tokens,omitempty"`  // Estimated size of the history
+	Context      int           `json:"context,omitempty"` // Size of the last request
+	TotalUsage   llm.Usage     `json:"total_usage,omitzero"`
+	Cost         float64       `json:"cost,omitempty"` // USD
 	SystemPrompt string        `json:"system_prompt"`
 	History      []llm.Message `json:"history"`
+	Usage        []TurnUsage   `json:"usage,omitempty"` // Tokens and cost of each turn
 }
 
-// SaveSession saves the agent's current state to a file.
+// maxTitleLen caps titles taken from the first user message.
+const maxTitleLen = 60
+
+// SaveSession saves the agent's current state to a file. Sessions without
+// a title are named after their first user message.
 func (a *Agent) SaveSession(path string) error {
-	session := Session{
+	now := time.Now()
+	if a.Created.IsZero() {
+		a.Created = now
+	}
+	if a.Title == "" {
+		a.Title = defaultTitle(a.History)
+	}
+	session := a.session()
+	session.Updated = now
+	return session.Save(path)
+}
+
+// session returns the agent's state as a Session.
+func (a *Agent) session() Session {
+	return Session{
+		Title:        a.Title,
+		Model:        a.Model,
+		Workspace:    a.Workspace,
+		Tags:         a.Tags,
+		Created:      a.Created,
+		Updated:      time.Now(),
 		SystemPrompt: a.SystemPrompt,
 		History:      a.History,
+		Usage:        a.Usage,
 	}
+}
 
-	data, err := json.MarshalIndent(session, "", "  ")
+// LoadSession loads an agent's state from a file. A session without a
+// system prompt, such as one imported from another tool, keeps the
+// agent's.
+func (a *Agent) LoadSession(path string) error {
+	session, err := ReadSession(path)
 	if err != nil {
-		return fmt.Errorf("failed to marshal session: %w", err)
+		return err
 	}
 
-	return os.WriteFile(path, data, 0644)
+	a.History = session.History
+	if session.SystemPrompt != "" || len(a.History) > 0 && a.History[0].Role == llm.RoleSystem {
+		a.SystemPrompt = session.SystemPrompt
+	} else if a.SystemPrompt != "" {
+		system := llm.Message{Role: llm.RoleSystem, Content: []llm.Part{llm.TextPart{Text: a.SystemPrompt}}}
+		a.History = append([]llm.Message{system}, a.History...)
+	}
+	a.Title = session.Title
+	a.Tags = session.Tags
+	a.Created = session.Created
+	a.Usage = session.Usage
+	return nil
 }
 
-// LoadSession loads an agent's state from a file.
-func (a *Agent) LoadSession(path string) error {
-	data, err := os.ReadFile(path)
+// SessionDir returns the directory holding saved sessions for a workspace.
+func SessionDir(workspaceRoot string) string {
+	absRoot, _ := filepath.Abs(workspaceRoot)
+	return filepath.Join(absRoot, ".castor", "sessions")
+}
+
+// NewSessionPath returns a path for a new session in dir, named after the
+// current time.
+func NewSessionPath(dir string) string {
+	return filepath.Join(dir, time.Now().Format("20060102-150405.000")+SessionExt)
+}
+
+// SessionInfo summarizes a saved session.
+type SessionInfo struct {
+	Path      string
+	Title     string
+	Model     string
+	Workspace string
+	Tags      []string
+	Created   time.Time
+	Updated   time.Time
+	Turns     int
+	Tokens    int // Estimated size of the history
+	Usage     llm.Usage
+	Cost      float64 // USD, for the models with known prices
+	Context   int     // Size of the last request, 0 if unreported
+}
+
+// ListSessions returns the sessions saved in dir, most recently updated
+// first. Only the summary ahead of each history is read, where Save wrote
+// one. Files that are not sessions are skipped.
+func ListSessions(dir string) ([]SessionInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to read session file: %w", err)
+		return nil, fmt.Errorf("failed to read session directory: %w", err)
 	}
 
-	var session Session
-	if err := json.Unmarshal(data, &session); err != nil {
-		return fmt.Errorf("failed to unmarshal session: %w", err)
+	var sessions []SessionInfo
+	for _, e := range entries {
+		if e.IsDir() || !isSessionFile(e.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		session, err := readSessionSummary(path)
+		if err != nil {
+			continue
+		}
+		updated := session.Updated
+		if updated.IsZero() {
+			if info, err := e.Info(); err == nil {
+				updated = info.ModTime()
+			}
+		}
+		sessions = append(sessions, SessionInfo{
+			Path:      path,
+			Title:     session.Title,
+			Model:     session.Model,
+			Workspace: session.Workspace,
+			Tags:      session.Tags,
+			Created:   session.Created,
+			Updated:   updated,
+			Turns:     session.Turns,
+			Tokens:    session.Tokens,
+			Usage:     session.TotalUsage,
+			Cost:      session.Cost,
+			Context:   session.Context,
+		})
 	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Updated.After(sessions[j].Updated) })
+	return sessions, nil
+}
 
-	a.SystemPrompt = session.SystemPrompt
-	a.History = session.History
-	return nil
+// ForkSession copies the session at src to dst, up to the end of its
+// first turns turns, so the copy can continue independently. Zero copies
+// the whole conversation. The copy's title is marked as a fork.
+func ForkSession(src, dst string, turns int) error {
+	session, err := ReadSession(src)
+	if err != nil {
+		return err
+	}
+	if session.History, err = historyAt(session.History, turns); err != nil {
+		return err
+	}
+	session.Usage = usageAt(session.Usage, CountTurns(session.History))
+	session.Title = forkTitle(session.Title, session.History)
+	session.Created = time.Now()
+	session.Updated = session.Created
+	return session.Save(dst)
+}
+
+// Fork returns a copy of the agent whose history ends after its first
+// turns turns, so another approach can be tried without changing the
+// original conversation. Zero keeps the whole history. The copy shares
+// the agent's provider and tools but not its tool registry.
+func (a *Agent) Fork(turns int) (*Agent, error) {
+	history, err := historyAt(a.History, turns)
+	if err != nil {
+		return nil, err
+	}
+	fork := *a
+	fork.Tools = maps.Clone(a.Tools)
+	fork.History = history
+	fork.Tags = slices.Clone(a.Tags)
+	fork.Usage = usageAt(a.Usage, CountTurns(history))
+	fork.Title = forkTitle(a.Title, history)
+	fork.Created = time.Time{}
+	return &fork, nil
+}
+
+// CountTurns returns the number of turns in a history: the user messages
+// and everything that answers them.
+func CountTurns(history []llm.Message) int {
+	n := 0
+	for _, msg := range history {
+		if msg.Role == llm.RoleUser {
+			n++
+		}
+	}
+	return n
+}
+
+// historyAt returns a copy of history up to the end of its first turns
+// turns, or all of it for zero.
+func historyAt(history []llm.Message, turns int) ([]llm.Message, error) {
+	total := CountTurns(history)
+	switch {
+	case turns < 0:
+		return nil, fmt.Errorf("invalid turn %d", turns)
+	case turns > total:
+		return nil, fmt.Errorf("the session has only %d turns", total)
+	case turns == 0:
+		turns = total
+	}
+	end, n := len(history), 0
+	for i, msg := range history {
+		if msg.Role != llm.RoleUser {
+			continue
+		}
+		if n == turns {
+			end = i
+			break
+		}
+		n++
+	}
+	return slices.Clone(history[:end]), nil
+}
+
+// forkTitle is the title of a fork of a session with the given title.
+func forkTitle(title string, history []llm.Message) string {
+	if title == "" {
+		title = defaultTitle(history)
+	}
+	return title + " (fork)"
+}
+
+// EstimateTokens approximates the number of tokens in a history, at about
+// four characters per token.
+func EstimateTokens(history []llm.Message) int {
+	chars := 0
+	for _, msg := range history {
+		for _, part := range msg.Content {
+			switch p := part.(type) {
+			case llm.TextPart:
+				chars += len(p.Text)
+			case llm.ToolResponsePart:
+				chars += len(p.Content)
+			case llm.ToolCallPart:
+				args, _ := json.Marshal(p.Args)
+				chars += len(p.Name) + len(args)
+			}
+		}
+	}
+	return (chars + 3) / 4
+}
+
+// defaultTitle names a session after its first user message.
+func defaultTitle(history []llm.Message) string {
+	for _, msg := range history {
+		if msg.Role != llm.RoleUser {
+			continue
+		}
+		for _, part := range msg.Content {
+			if p, ok := part.(llm.TextPart); ok {
+				title := strings.Join(strings.Fields(p.Text), " ")
+				if r := []rune(title); len(r) > maxTitleLen {
+					title = string(r[:maxTitleLen-1]) + "…"
+				}
+				return title
+			}
+		}
+	}
+	return ""
 }
@@ -0,0 +1,556 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// SessionExt is the file extension of new sessions, which are stored as
+// gzip-compressed JSON. Files with other names are compressed only if
+// they end in .gz.
+const SessionExt = ".json.gz"
+
+// SessionVersion is the version of the session format that Save writes.
+// Raise it, and add a step to sessionMigrations, with any change to Session
+// or to how llm.Message is serialized that older files would not load
+// under as they were meant to.
+const SessionVersion = 1
+
+// sessionMigrations upgrade the fields of a session from the version at
+// their index to the next one.
+var sessionMigrations = []func(fields map[string]json.RawMessage) error{
+	// Version 0 is every file saved before sessions had a version; its
+	// layout is that of version 1.
+	func(fields map[string]json.RawMessage) error { return nil },
+}
+
+// MaxStoredResult is the size above which a tool result is kept in a blob
+// beside the session file instead of in it.
+const MaxStoredResult = 16 << 10
+
+// resultPreview is how much of a result kept in a blob stays in the
+// session file, so it can be read without the blob.
+const resultPreview = 1 << 10
+
+// lockTimeout is how long Save waits for another process to finish
+// saving the same session, and lockPoll how often it checks.
+const (
+	lockTimeout = 10 * time.Second
+	lockPoll    = 20 * time.Millisecond
+)
+
+// errLocked is returned when a session stays locked past lockTimeout.
+var errLocked = errors.New("the session is being saved by another process")
+
+// blobRef is the line that ends a tool result kept in a blob.
+var blobRef = regexp.MustCompile(`\n\[castor blob ([0-9a-f]{64}), (\d+) bytes\]$`)
+
+// TrimSessionExt returns path without its session file extension.
+func TrimSessionExt(path string) string {
+	for _, ext := range []string{SessionExt, ".json"} {
+		if strings.HasSuffix(path, ext) {
+			return strings.TrimSuffix(path, ext)
+		}
+	}
+	return path
+}
+
+// isSessionFile reports whether name has a session file extension.
+func isSessionFile(name string) bool {
+	return strings.HasSuffix(name, ".json") || strings.HasSuffix(name, SessionExt)
+}
+
+// SessionBlobDir returns the directory holding the blobs of the session
+// saved at path.
+func SessionBlobDir(path string) string {
+	return TrimSessionExt(path) + ".blobs"
+}
+
+// Save writes the session to a file, creating its directory if needed.
+// Tool results longer than MaxStoredResult are moved to blobs, which
+// ReadSession puts back. Saves of the same file, by this process or
+// another, take turns, and the file is replaced in one step, so readers
+// never see it half written.
+func (s *Session) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create session directory: %w", err)
+	}
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock session: %w", err)
+	}
+	defer unlock()
+	return s.write(path)
+}
+
+// UpdateSession reads the session saved at path, changes it with update,
+// and saves it, keeping other saves of the file out until it is done.
+func UpdateSession(path string, update func(*Session) error) (*Session, error) {
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock session: %w", err)
+	}
+	defer unlock()
+	s, err := ReadSession(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := update(s); err != nil {
+		return nil, err
+	}
+	if err := s.write(path); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// write saves the session to path, whose lock the caller holds.
+func (s *Session) write(path string) error {
+	stored := *s
+	stored.Version = SessionVersion
+	// The summary ListSessions reads
+	if stored.Title == "" {
+		stored.Title = defaultTitle(s.History)
+	}
+	stored.Turns, stored.Tokens = CountTurns(s.History), EstimateTokens(s.History)
+	stored.Context = ContextSize(s.Usage)
+	stored.TotalUsage, stored.Cost = TotalUsage(s.Usage)
+	blobs := make(map[string]string)
+	stored.History = make([]llm.Message, len(s.History))
+	for i, msg := range s.History {
+		stored.History[i] = externalize(msg, blobs)
+	}
+
+	data, err := json.MarshalIndent(&stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+	if strings.HasSuffix(path, ".gz") {
+		if data, err = compress(data); err != nil {
+			return err
+		}
+	}
+
+	if len(blobs) > 0 {
+		if err := writeBlobs(SessionBlobDir(path), blobs); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data through a temporary
+// file, so that it holds either the old or the new contents even if the
+// process dies midway.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// DeleteSession removes the session saved at path along with its blobs.
+func DeleteSession(path string) error {
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	if err := os.RemoveAll(SessionBlobDir(path)); err != nil {
+		return fmt.Errorf("failed to delete session blobs: %w", err)
+	}
+	os.Remove(path + ".lock")
+	return nil
+}
+
+// SweepBlobs removes the blob directories in dir whose sessions no longer
+// exist, such as ones deleted by hand, and returns their paths. Recent
+// directories are left alone, as their session may still be being saved.
+func SweepBlobs(dir string, dryRun bool) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session directory: %w", err)
+	}
+	var swept []string
+	for _, e := range entries {
+		stem, ok := strings.CutSuffix(e.Name(), ".blobs")
+		if !ok || !e.IsDir() {
+			continue
+		}
+		if info, err := e.Info(); err != nil || time.Since(info.ModTime()) < time.Hour {
+			continue
+		}
+		owned := false
+		for _, ext := range []string{SessionExt, ".json"} {
+			if _, err := os.Stat(filepath.Join(dir, stem+ext)); err == nil {
+				owned = true
+			}
+		}
+		if owned {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if !dryRun {
+			if err := os.RemoveAll(path); err != nil {
+				return swept, fmt.Errorf("failed to delete session blobs: %w", err)
+			}
+		}
+		swept = append(swept, path)
+	}
+	return swept, nil
+}
+
+// ReadSession reads a saved session from a file.
+func ReadSession(path string) (*Session, error) {
+	session, err := readSession(path)
+	if err != nil {
+		return nil, err
+	}
+	internalize(session, SessionBlobDir(path))
+	return session, nil
+}
+
+// readSession reads a saved session from a file, leaving the tool results
+// kept in blobs as their previews.
+func readSession(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session file: %w", err)
+	}
+	if data, err = decompress(data, false); err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session: %w", err)
+	}
+	return decodeFields(fields)
+}
+
+// readSessionSummary reads the fields of a saved session up to its system
+// prompt and history, which it leaves out. Sessions saved before Save
+// wrote a summary there, or in another format version, are read whole
+// and summarized, without their blobs.
+func readSessionSummary(path string) (*Session, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session file: %w", err)
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress session: %w", err)
+		}
+		r = zr
+	}
+
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("failed to unmarshal session: not a JSON object")
+	}
+	fields := make(map[string]json.RawMessage)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal session: %w", err)
+		}
+		if key, _ := tok.(string); key == "system_prompt" || key == "history" {
+			break
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal session: %w", err)
+		}
+		fields[tok.(string)] = value
+	}
+
+	_, summarized := fields["turns"]
+	if summarized && string(fields["version"]) == strconv.Itoa(SessionVersion) {
+		return decodeFields(fields)
+	}
+
+	full, err := readSession(path)
+	if err != nil {
+		return nil, err
+	}
+	full.Turns, full.Tokens = CountTurns(full.History), EstimateTokens(full.History)
+	full.Context = ContextSize(full.Usage)
+	if full.Title == "" {
+		full.Title = defaultTitle(full.History)
+	}
+	return full, nil
+}
+
+// decodeFields decodes a session from its JSON fields, upgrading them to
+// SessionVersion first. Sessions saved by a newer castor are refused, as
+// they may hold what this one would drop.
+func decodeFields(fields map[string]json.RawMessage) (*Session, error) {
+	version := 0
+	if raw, ok := fields["version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil || version < 0 {
+			return nil, fmt.Errorf("invalid session version %s", raw)
+		}
+	}
+	if version > SessionVersion {
+		return nil, fmt.Errorf("the session was saved by a newer version of castor (format %d; this one reads up to %d)", version, SessionVersion)
+	}
+	for v := version; v < SessionVersion; v++ {
+		if err := sessionMigrations[v](fields); err != nil {
+			return nil, fmt.Errorf("failed to upgrade session from format %d: %w", v, err)
+		}
+	}
+	fields["version"] = json.RawMessage(strconv.Itoa(SessionVersion))
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session: %w", err)
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session: %w", err)
+	}
+	return &session, nil
+}
+
+// RepairSession reads what can be recovered of a damaged session file,
+// such as one cut short by a crash: every message that was written in
+// full, up to the last one whose tool calls were all answered.
+func RepairSession(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session file: %w", err)
+	}
+	if data, err = decompress(data, true); err != nil {
+		return nil, err
+	}
+
+	session, err := decodePartial(data)
+	if err != nil {
+		return nil, err
+	}
+	session.History = answeredHistory(session.History)
+	internalize(session, SessionBlobDir(path))
+	return session, nil
+}
+
+// decodePartial decodes a session from JSON that may be cut short,
+// keeping the fields and messages that were written in full.
+func decodePartial(data []byte) (*Session, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("not a session file")
+	}
+	fields := make(map[string]json.RawMessage)
+	var history []json.RawMessage
+fields:
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		key, _ := tok.(string)
+		if key != "history" {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				break
+			}
+			fields[key] = raw
+			continue
+		}
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+			break
+		}
+		for dec.More() {
+			var msg json.RawMessage
+			if err := dec.Decode(&msg); err != nil {
+				break fields
+			}
+			history = append(history, msg)
+		}
+		if _, err := dec.Token(); err != nil { // The closing bracket
+			break
+		}
+	}
+	if len(fields) == 0 && len(history) == 0 {
+		return nil, fmt.Errorf("nothing could be recovered from the session file")
+	}
+
+	fields["history"], _ = json.Marshal(history)
+	return decodeFields(fields)
+}
+
+// answeredHistory drops the end of a history from the last model message
+// whose tool calls were not all answered, as providers reject such a
+// history.
+func answeredHistory(history []llm.Message) []llm.Message {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role != llm.RoleModel {
+			continue
+		}
+		pending := make(map[string]bool)
+		for _, part := range history[i].Content {
+			if call, ok := part.(llm.ToolCallPart); ok {
+				pending[call.ID] = true
+			}
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		for _, msg := range history[i+1:] {
+			for _, part := range msg.Content {
+				if resp, ok := part.(llm.ToolResponsePart); ok {
+					delete(pending, resp.ID)
+				}
+			}
+		}
+		if len(pending) > 0 {
+			return history[:i]
+		}
+		break
+	}
+	return history
+}
+
+// externalize returns msg with its long tool results cut to a preview and
+// a reference to a blob, which is added to blobs by its hash.
+func externalize(msg llm.Message, blobs map[string]string) llm.Message {
+	var content []llm.Part
+	for i, part := range msg.Content {
+		resp, ok := part.(llm.ToolResponsePart)
+		if !ok || len(resp.Content) <= MaxStoredResult {
+			continue
+		}
+		if content == nil {
+			content = append([]llm.Part(nil), msg.Content...)
+		}
+		sum := sha256.Sum256([]byte(resp.Content))
+		hash := hex.EncodeToString(sum[:])
+		blobs[hash] = resp.Content
+		preview := strings.ToValidUTF8(resp.Content[:resultPreview], "")
+		resp.Content = fmt.Sprintf("%s\n[castor blob %s, %d bytes]", preview, hash, len(resp.Content))
+		content[i] = resp
+	}
+	if content != nil {
+		msg.Content = content
+	}
+	return msg
+}
+
+// internalize puts the tool results kept in blobs in dir back into the
+// session. Results whose blob is missing keep their preview.
+func internalize(session *Session, dir string) {
+	for _, msg := range session.History {
+		for i, part := range msg.Content {
+			resp, ok := part.(llm.ToolResponsePart)
+			if !ok {
+				continue
+			}
+			m := blobRef.FindStringSubmatch(resp.Content)
+			if m == nil {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(dir, m[1]+".gz"))
+			if err != nil {
+				continue
+			}
+			data, err = decompress(data, false)
+			if size, _ := strconv.Atoi(m[2]); err != nil || len(data) != size {
+				continue
+			}
+			resp.Content = string(data)
+			msg.Content[i] = resp
+		}
+	}
+}
+
+// writeBlobs stores blobs in dir, named by their hashes. Blobs already
+// stored are left alone, as a hash names the same content.
+func writeBlobs(dir string, blobs map[string]string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	for hash, content := range blobs {
+		path := filepath.Join(dir, hash+".gz")
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		data, err := compress([]byte(content))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return fmt.Errorf("failed to write blob: %w", err)
+		}
+	}
+	return nil
+}
+
+// compress gzips data.
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress session: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress session: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress returns data uncompressed if it is gzipped, and as it is
+// otherwise. If partial is set, a stream cut short yields what it holds.
+func decompress(data []byte, partial bool) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress session: %w", err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil && !(partial && len(out) > 0) {
+		return nil, fmt.Errorf("failed to decompress session: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// splitPrompt asks the model for the sub-questions of a goal, at most %d.
+const splitPrompt = `You plan codebase investigations. Split the user's question into at most %d sub-questions that can be investigated independently and together answer it.
+Make each one specific enough to answer by reading code, and do not let them overlap. A narrow question needs only one.
+Call the 'split_goal' tool with the sub-questions.`
+
+// synthesisPrompt asks the model to combine the reports on sub-questions.
+const synthesisPrompt = `You combine the reports of investigations into the sub-questions of a question into one report on the question.
+Base the findings and the conclusion only on the reports you are given, and say where they disagree or leave gaps.
+Call the 'report_findings' tool with the goal, a methodology summarizing how the sub-questions were investigated, the combined findings, and the conclusion. Leave out the evidence and the files explored: those of the reports are carried over.`
+
+// splitGoalSchema is the schema of the split_goal tool.
+var splitGoalSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"questions": map[string]interface{}{
+			"type":        "array",
+			"items":       map[string]interface{}{"type": "string"},
+			"description": "The sub-questions, each answerable on its own",
+		},
+	},
+	"required": []string{"questions"},
+}
+
+// investigateSplit investigates goal by its sub-questions, concurrently,
+// and combines their reports. A goal the model does not split is
+// investigated as a whole.
+func (inv *Investigator) investigateSplit(ctx context.Context, goal string) (*InvestigationReport, error) {
+	questions, err := inv.splitGoal(ctx, goal)
+	if err != nil {
+		return nil, err
+	}
+	if len(questions) < 2 {
+		return inv.run(ctx, 0, inv.prompt(), inv.Tools, "Investigate: "+goal)
+	}
+	inv.emit(ctx, InvestigationEvent{Questions: questions})
+
+	reports := make([]*InvestigationReport, len(questions))
+	errs := make([]error, len(questions))
+	var wg sync.WaitGroup
+	for i, question := range questions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reports[i], errs[i] = inv.run(ctx, i+1, inv.prompt(), inv.Tools, "Investigate: "+question)
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	answered := 0
+	for i, err := range errs {
+		if err != nil {
+			// The synthesis is told what could not be found out
+			reports[i] = &InvestigationReport{Goal: questions[i], Conclusion: "Not answered: " + err.Error()}
+			continue
+		}
+		if reports[i].Goal == "" {
+			reports[i].Goal = questions[i]
+		}
+		answered++
+	}
+	if answered == 0 {
+		return nil, fmt.Errorf("no sub-question could be answered: %w", errs[0])
+	}
+
+	data, _ := json.MarshalIndent(reports, "", "  ")
+	input := "Question: " + goal + "\n\nReports on its sub-questions:\n\n```json\n" + string(data) + "\n```"
+	report, err := inv.run(ctx, 0, synthesisPrompt, []string{}, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to combine the reports: %w", err)
+	}
+	report.Goal = goal
+	report.Evidence, report.FilesExplored = nil, nil
+	for _, sub := range reports {
+		report.Evidence = append(report.Evidence, sub.Evidence...)
+		report.FilesExplored = append(report.FilesExplored, sub.FilesExplored...)
+	}
+	slices.Sort(report.FilesExplored)
+	report.FilesExplored = slices.Compact(report.FilesExplored)
+	report.SubReports = reports
+	return report, nil
+}
+
+// splitGoal asks the model for the sub-questions of goal, at most
+// SubQuestions of them. It returns none if the model does not call
+// split_goal.
+func (inv *Investigator) splitGoal(ctx context.Context, goal string) ([]string, error) {
+	opts := inv.Agent.Options
+	opts.Tools = []llm.ToolDefinition{{
+		Name:        "split_goal",
+		Description: "Submit the sub-questions of the question.",
+		Schema:      splitGoalSchema,
+	}}
+	stream, err := inv.Agent.Provider.GenerateContent(ctx, []llm.Message{
+		{Role: llm.RoleSystem, Content: []llm.Part{llm.TextPart{Text: fmt.Sprintf(splitPrompt, inv.SubQuestions)}}},
+		{Role: llm.RoleUser, Content: []llm.Part{llm.TextPart{Text: goal}}},
+	}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split the goal: %w", err)
+	}
+
+	var questions []string
+	for event := range stream {
+		if event.Error != nil {
+			return nil, fmt.Errorf("failed to split the goal: %w", event.Error)
+		}
+		for _, call := range event.ToolCalls {
+			if call.Name != "split_goal" {
+				continue
+			}
+			items, _ := call.Args["questions"].([]interface{})
+			for _, item := range items {
+				q, _ := item.(string)
+				if q = strings.TrimSpace(q); q != "" && !slices.Contains(questions, q) {
+					questions = append(questions, q)
+				}
+			}
+		}
+	}
+	if len(questions) > inv.SubQuestions {
+		questions = questions[:inv.SubQuestions]
+	}
+	return questions, nil
+}
@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DefaultTestGenAttempts is the number of times generated tests are run
+// before the generator gives up, unless TestGenerator.MaxAttempts is set.
+const DefaultTestGenAttempts = 3
+
+// TestGenerator is a specialized agent loop that writes tests: it
+// analyzes the target code and proposes cases, writes them, and runs
+// them, repairing failures until they pass or its attempts run out.
+type TestGenerator struct {
+	// Agent supplies the provider, tools, and settings. Each stage runs
+	// in a sub-agent of its own, so Agent is left as it was.
+	Agent *Agent
+
+	Test        TestFunc // Runs the tests of the target
+	MaxAttempts int      // Test runs before giving up; zero means DefaultTestGenAttempts
+
+	// Coverage, if set, measures the target's test coverage, as a
+	// percentage, before and after. Coverage that cannot be measured is
+	// left out of the report.
+	Coverage func(ctx context.Context) (float64, error)
+
+	// IsTestFile, if set, limits the files the generator may change.
+	// It is given absolute paths.
+	IsTestFile func(path string) bool
+
+	// Progress, if set, receives what the generator is doing as it goes,
+	// in the stages TestGenStages. Sends block, so the channel must be
+	// read until Generate returns; it is not closed.
+	Progress chan<- WorkflowEvent
+}
+
+// TestGenStages are the stages of test generation, in order.
+var TestGenStages = []string{"analyze", "edit", "test"}
+
+// TestGenReport is the outcome of test generation.
+type TestGenReport struct {
+	Target         string   `json:"target"`
+	Cases          []string `json:"cases"`
+	Summary        string   `json:"summary"` // What was written, by the model
+	Attempts       int      `json:"attempts"`
+	Tests          TestRun  `json:"tests"` // The last run
+	FilesChanged   []string `json:"files_changed"`
+	Diff           string   `json:"diff"`
+	CoverageBefore *float64 `json:"coverage_before,omitempty"` // Percent; unset without Coverage
+	CoverageAfter  *float64 `json:"coverage_after,omitempty"`
+}
+
+// analyzePrompt is the system prompt of the analysis of the target.
+const analyzePrompt = `You are a test engineer. Read the code you are asked to test, and the tests it already has, and propose the test cases it lacks.
+Favor behavior that is likely to break or is easy to get wrong: edge cases, error paths, and boundaries. Do not propose cases the existing tests already cover.
+Call the 'propose_tests' tool with the cases, each a sentence naming the function and the behavior to check.`
+
+// writeTestsPrompt is the system prompt of the writing of tests.
+const writeTestsPrompt = `You are a test engineer writing tests for the code you are given, one case at a time from the list you are given.
+Follow the conventions of the project's existing tests: their files, package, helpers, and style. Change only test files; if a case shows a bug in the code, leave the code alone, leave the case out, and say so.
+When you are done, say so briefly. The tests are run after each of your attempts, and you are shown their output if they fail.`
+
+// Generate writes tests for target, a file or package, returning what
+// was done. A report is returned when the tests still fail after the
+// last attempt; its Tests tell whether they passed.
+func (g *TestGenerator) Generate(ctx context.Context, target string) (*TestGenReport, error) {
+	if g.Test == nil {
+		return nil, fmt.Errorf("the test generator has no way to run the tests")
+	}
+	report := &TestGenReport{Target: target}
+	emit := func(event WorkflowEvent) { emitTo(ctx, g.Progress, event) }
+
+	if g.Coverage != nil {
+		if coverage, err := g.Coverage(ctx); err == nil {
+			report.CoverageBefore = &coverage
+		}
+	}
+
+	emit(WorkflowEvent{Stage: "analyze"})
+	cases, err := g.analyze(ctx, target, emit)
+	if err != nil {
+		return nil, err
+	}
+	report.Cases = cases
+	emit(WorkflowEvent{Stage: "analyze", Plan: cases})
+
+	sub := g.Agent.subAgent(writeTestsPrompt)
+	snapshots := newFileSnapshots(g.Agent.Workspace)
+	snapshotTools(sub, snapshots, g.IsTestFile)
+
+	var input strings.Builder
+	fmt.Fprintf(&input, "Write tests for %s with these cases:\n\n", target)
+	for i, c := range cases {
+		fmt.Fprintf(&input, "%d. %s\n", i+1, c)
+	}
+	maxAttempts := g.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultTestGenAttempts
+	}
+	report.Tests, report.Attempts, err = editUntilGreen(ctx, sub, input.String(), maxAttempts, g.Test, func(output string) string {
+		return "The tests fail:\n\n```\n" + output + "\n```\n\nRepair the tests you wrote so they pass. Remove a case instead if it fails because of a bug in the code."
+	}, emit)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Diff, report.FilesChanged = snapshots.diff()
+	if g.Coverage != nil {
+		if coverage, err := g.Coverage(ctx); err == nil {
+			report.CoverageAfter = &coverage
+		}
+	}
+	report.Summary, err = summarize(ctx, sub, "Summarize the tests you wrote in a few sentences, naming any case you left out and any bug you found. Say so if the tests still fail.")
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// analyze has a sub-agent with read-only tools propose the cases to test
+// target with.
+func (g *TestGenerator) analyze(ctx context.Context, target string, emit func(WorkflowEvent)) ([]string, error) {
+	sub := g.Agent.subAgent(analyzePrompt)
+	sub.KeepReadOnly()
+	tool := &listTool{
+		name:        "propose_tests",
+		description: "Propose the test cases to write.",
+		field:       "cases",
+		items:       "The cases, each naming the function and the behavior to check",
+	}
+	return gatherList(ctx, sub, tool, "Propose test cases for "+target+".", func(event Event) {
+		emit(WorkflowEvent{Stage: "analyze", Started: event.Started, Result: event.Result})
+	})
+}
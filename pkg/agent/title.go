@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// titleExcerpt caps how much of each message of the first exchange is
+// sent to name a session.
+const titleExcerpt = 1000
+
+// titlePrompt is the system prompt of the request that names a session.
+const titlePrompt = `You name conversations between a user and a coding assistant.
+Reply with a title of at most six words that says what the user wants done, such as "Fix flaky TestSandboxing" or "Add retry to HTTP client".
+Reply with the title only, without quotes or a final period.`
+
+// NeedsTitle reports whether the session should be named by
+// GenerateTitle: TitleProvider is set, the first turn is done, and the
+// session has no title of its own.
+func (a *Agent) NeedsTitle() bool {
+	return a.TitleProvider != nil && CountTurns(a.History) == 1 &&
+		(a.Title == "" || a.Title == defaultTitle(a.History))
+}
+
+// GenerateTitle asks provider for a short title of the conversation in
+// history, from its first user message and the reply to it.
+func GenerateTitle(ctx context.Context, provider llm.Provider, history []llm.Message) (string, error) {
+	var question, answer string
+	for _, msg := range history {
+		if msg.Role == llm.RoleUser {
+			if question != "" {
+				break // The first turn is over
+			}
+			question = messageText(msg)
+		} else if text := messageText(msg); msg.Role == llm.RoleModel && text != "" {
+			answer = text // The last text of the first turn
+		}
+	}
+	if question == "" {
+		return "", fmt.Errorf("the conversation has no user message")
+	}
+
+	prompt := "User: " + excerpt(question) + "\n\nAssistant: " + excerpt(answer)
+	temperature := float32(0.2)
+	stream, err := provider.GenerateContent(ctx, []llm.Message{
+		{Role: llm.RoleSystem, Content: []llm.Part{llm.TextPart{Text: titlePrompt}}},
+		{Role: llm.RoleUser, Content: []llm.Part{llm.TextPart{Text: prompt}}},
+	}, llm.GenerateOptions{Temperature: &temperature, MaxTokens: 24})
+	if err != nil {
+		return "", err
+	}
+	var reply strings.Builder
+	for event := range stream {
+		if event.Error != nil {
+			return "", event.Error
+		}
+		reply.WriteString(event.Delta)
+	}
+
+	title, _, _ := strings.Cut(strings.TrimSpace(reply.String()), "\n")
+	title = strings.TrimSpace(strings.TrimPrefix(title, "Title:"))
+	title = strings.TrimRight(strings.Trim(title, "\"'`*"), ".")
+	if r := []rune(title); len(r) > maxTitleLen {
+		title = string(r[:maxTitleLen-1]) + "…"
+	}
+	if title == "" {
+		return "", fmt.Errorf("the model returned an empty title")
+	}
+	return title, nil
+}
+
+// messageText joins the text parts of a message.
+func messageText(msg llm.Message) string {
+	var parts []string
+	for _, part := range msg.Content {
+		if p, ok := part.(llm.TextPart); ok {
+			parts = append(parts, p.Text)
+		}
+	}
+	return strings.TrimSpace(strings.Join(parts, "\n"))
+}
+
+// excerpt cuts text to titleExcerpt bytes.
+func excerpt(text string) string {
+	if len(text) > titleExcerpt {
+		return strings.ToValidUTF8(text[:titleExcerpt], "") + "…"
+	}
+	return text
+}
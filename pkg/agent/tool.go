@@ -17,5 +17,7 @@ type Tool interface {
 	Schema() interface{}
 
 	// Execute runs the tool with the provided arguments.
+	// A []llm.Part result is returned to the model as its text parts plus
+	// attachments such as images; any other result is marshaled to JSON.
 	Execute(ctx context.Context, args map[string]interface{}) (interface{}, error)
 }
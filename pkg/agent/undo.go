@@ -0,0 +1,29 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Undoer is implemented by tools whose changes can be reverted.
+type Undoer interface {
+	// Undo reverts the last n changes and returns the affected paths.
+	Undo(n int) ([]string, error)
+}
+
+// UndoEdits reverts the last n edits using the first registered tool that
+// implements Undoer (in name order), returning the restored paths.
+func (a *Agent) UndoEdits(n int) ([]string, error) {
+	names := make([]string, 0, len(a.Tools))
+	for name := range a.Tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if u, ok := a.Tools[name].(Undoer); ok {
+			return u.Undo(n)
+		}
+	}
+	return nil, fmt.Errorf("no undo-capable tool registered")
+}
@@ -0,0 +1,58 @@
+package agent
+
+import "github.com/techmuch/castor/pkg/llm"
+
+// TurnUsage records the tokens and cost of the model requests of one turn.
+type TurnUsage struct {
+	Turn  int    `json:"turn"` // From 1, as counted by CountTurns
+	Model string `json:"model,omitempty"`
+	llm.Usage
+	Context int     `json:"context_tokens,omitempty"` // Size of the turn's last request
+	Cost    float64 `json:"cost,omitempty"`           // USD; zero for models without known prices
+}
+
+// TotalUsage sums the tokens and cost of turns.
+func TotalUsage(turns []TurnUsage) (llm.Usage, float64) {
+	var total llm.Usage
+	var cost float64
+	for _, t := range turns {
+		total = total.Add(t.Usage)
+		cost += t.Cost
+	}
+	return total, cost
+}
+
+// ContextSize returns the size of the last request of turns, as reported
+// by the provider, or zero if none was.
+func ContextSize(turns []TurnUsage) int {
+	if len(turns) == 0 {
+		return 0
+	}
+	return turns[len(turns)-1].Context
+}
+
+// recordUsage adds the usage of one model request to the current turn,
+// priced for the agent's model at the time.
+func (a *Agent) recordUsage(u llm.Usage) {
+	turn := CountTurns(a.History)
+	if n := len(a.Usage); n == 0 || a.Usage[n-1].Turn != turn {
+		a.Usage = append(a.Usage, TurnUsage{Turn: turn, Model: a.Model})
+	}
+	t := &a.Usage[len(a.Usage)-1]
+	t.Usage = t.Usage.Add(u)
+	t.Context = u.Total()
+	if info, ok := llm.LookupModel(t.Model); ok {
+		t.Cost += info.Cost(u)
+	}
+}
+
+// usageAt returns a copy of the usage of the first turns turns.
+func usageAt(usage []TurnUsage, turns int) []TurnUsage {
+	var kept []TurnUsage
+	for _, t := range usage {
+		if t.Turn <= turns {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,382 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/techmuch/castor/pkg/diff"
+	"github.com/techmuch/castor/pkg/llm"
+	"github.com/techmuch/castor/pkg/vfs"
+)
+
+// maxTestOutput caps how much of the tests' output, from the end, is
+// shown to the model and kept in reports.
+const maxTestOutput = 8 << 10
+
+// WorkflowEvent reports the progress of a workflow that edits code and
+// runs the tests, such as a Fixer. An event with only Stage and Attempt
+// set starts a stage; the others carry what happens in it.
+type WorkflowEvent struct {
+	Stage   string // Named by the workflow, such as "plan" or "test"
+	Step    int    // From 1, in workflows that go step by step
+	Attempt int    // From 1, in the stages that are retried
+
+	Investigation *InvestigationEvent // Progress of an investigation
+	Plan          []string            // The plan, or the cases, once made
+	Started       *llm.ToolCallPart   // A tool call begins
+	Result        *ToolResult         // A tool call finished
+	Test          *TestRun            // The tests ran
+	Restored      []string            // Files put back by a rollback
+}
+
+// TestRun is the outcome of running the tests.
+type TestRun struct {
+	Passed bool   `json:"passed"`
+	Output string `json:"output,omitempty"` // The end of it, if it is long
+}
+
+// TestFunc runs a project's tests, returning their output and whether
+// they passed. An error means they could not be run at all.
+type TestFunc func(ctx context.Context) (output string, passed bool, err error)
+
+// runTests runs test and caps its output.
+func runTests(ctx context.Context, test TestFunc) (TestRun, error) {
+	output, passed, err := test(ctx)
+	if err != nil {
+		return TestRun{}, fmt.Errorf("failed to run the tests: %w", err)
+	}
+	if len(output) > maxTestOutput {
+		output = "[...]\n" + strings.ToValidUTF8(output[len(output)-maxTestOutput:], "")
+	}
+	return TestRun{Passed: passed, Output: output}, nil
+}
+
+// editUntilGreen sends input to sub, then runs the tests, sending retry
+// with their output to sub while they fail, for at most attempts
+// attempts. It returns the last run and the attempts made.
+func editUntilGreen(ctx context.Context, sub *Agent, input string, attempts int, test TestFunc, retry func(output string) string, emit func(WorkflowEvent)) (TestRun, int, error) {
+	var run TestRun
+	attempt := 1
+	for ; ; attempt++ {
+		emit(WorkflowEvent{Stage: "edit", Attempt: attempt})
+		if err := chatEvents(ctx, sub, input, func(event Event) {
+			emit(WorkflowEvent{Stage: "edit", Attempt: attempt, Started: event.Started, Result: event.Result})
+		}); err != nil {
+			return run, attempt, err
+		}
+
+		emit(WorkflowEvent{Stage: "test", Attempt: attempt})
+		var err error
+		if run, err = runTests(ctx, test); err != nil {
+			return run, attempt, err
+		}
+		emit(WorkflowEvent{Stage: "test", Attempt: attempt, Test: &run})
+		if run.Passed || attempt == attempts {
+			return run, attempt, nil
+		}
+		input = retry(strings.TrimSpace(run.Output))
+	}
+}
+
+// chatEvents sends input to a and passes the tool calls of the reply,
+// as they start and finish, to tool, if it is set.
+func chatEvents(ctx context.Context, a *Agent, input string, tool func(Event)) error {
+	stream, err := a.Chat(ctx, input)
+	if err != nil {
+		return err
+	}
+	for event := range stream {
+		if event.Error != nil {
+			return event.Error
+		}
+		if tool != nil && (event.Started != nil || event.Result != nil) {
+			tool(event)
+		}
+	}
+	return nil
+}
+
+// summarize asks the model of sub the question, without tools, and
+// returns its answer.
+func summarize(ctx context.Context, sub *Agent, question string) (string, error) {
+	history := append(slices.Clip(sub.History), llm.Message{
+		Role:    llm.RoleUser,
+		Content: []llm.Part{llm.TextPart{Text: question}},
+	})
+	stream, err := sub.Provider.GenerateContent(ctx, history, sub.Options)
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize the change: %w", err)
+	}
+	var summary strings.Builder
+	for event := range stream {
+		if event.Error != nil {
+			return "", fmt.Errorf("failed to summarize the change: %w", event.Error)
+		}
+		summary.WriteString(event.Delta)
+	}
+	return strings.TrimSpace(summary.String()), nil
+}
+
+// emitTo sends an event to progress, if it is set.
+func emitTo[E any](ctx context.Context, progress chan<- E, event E) {
+	if progress == nil {
+		return
+	}
+	select {
+	case progress <- event:
+	case <-ctx.Done():
+	}
+}
+
+// fileSnapshots keeps the content of files as it was before their first
+// change, to diff them against afterwards.
+type fileSnapshots struct {
+	root string // Workspace that relative paths are in
+	fs   vfs.FS // The files are read and written through; nil means the disk
+
+	mu     sync.Mutex
+	before map[string]*string // By absolute path; nil for a file that did not exist
+}
+
+func newFileSnapshots(root string) *fileSnapshots {
+	return &fileSnapshots{root: root, before: make(map[string]*string)}
+}
+
+// abs returns the absolute, clean form of a path in the workspace.
+func (s *fileSnapshots) abs(path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.root, path)
+	}
+	return filepath.Clean(path)
+}
+
+// record keeps the content of path, if it has not been kept already.
+func (s *fileSnapshots) record(path string) {
+	path = s.abs(path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.before[path]; ok {
+		return
+	}
+	var content *string
+	if data, err := vfs.Or(s.fs).ReadFile(path); err == nil {
+		text := string(data)
+		content = &text
+	}
+	s.before[path] = content
+}
+
+// diff returns a unified diff of the recorded files from before to now,
+// and the files that changed, relative to the workspace.
+func (s *fileSnapshots) diff() (string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := make([]string, 0, len(s.before))
+	for path := range s.before {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	var patches strings.Builder
+	var changed []string
+	for _, path := range paths {
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil || s.root == "" {
+			rel = path
+		}
+		rel = filepath.ToSlash(rel)
+		from, to := "a/"+rel, "b/"+rel
+		var before, after string
+		if b := s.before[path]; b != nil {
+			before = *b
+		} else {
+			from = "/dev/null"
+		}
+		if data, err := vfs.Or(s.fs).ReadFile(path); err == nil {
+			after = string(data)
+		} else {
+			to = "/dev/null"
+		}
+		if patch := diff.Unified(from, to, before, after); patch != "" {
+			patches.WriteString(patch)
+			changed = append(changed, rel)
+		}
+	}
+	return patches.String(), changed
+}
+
+// restore puts the recorded files back as they were, removing those that
+// did not exist, and returns the files that changed, relative to the
+// workspace.
+func (s *fileSnapshots) restore() ([]string, error) {
+	_, changed := s.diff()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fsys := vfs.Or(s.fs)
+	for path, before := range s.before {
+		var err error
+		if before == nil {
+			err = fsys.Remove(path)
+		} else if info, statErr := fsys.Stat(path); statErr == nil {
+			err = fsys.WriteFile(path, []byte(*before), info.Mode().Perm())
+		} else {
+			err = fsys.WriteFile(path, []byte(*before), 0644)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return changed, fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+	}
+	return changed, nil
+}
+
+// FileChanger is implemented by tools that can tell which files a call
+// will change, so workflows can snapshot them first and roll them back.
+type FileChanger interface {
+	// ChangedFiles returns the paths a call with args may change.
+	ChangedFiles(args map[string]interface{}) []string
+}
+
+// snapshotTool records the files a call of a tool that may change them
+// will change before it runs. Tools that are not FileChangers are
+// refused, as their changes could not be rolled back. With allow set,
+// calls on other files are refused too.
+type snapshotTool struct {
+	Tool
+	snapshots *fileSnapshots
+	allow     func(path string) bool
+}
+
+func (t snapshotTool) ChangedFiles(args map[string]interface{}) []string {
+	if fc, ok := t.Tool.(FileChanger); ok {
+		return fc.ChangedFiles(args)
+	}
+	return nil
+}
+
+func (t snapshotTool) Execute(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if _, ok := t.Tool.(FileChanger); !ok {
+		return nil, fmt.Errorf("%s cannot be used here, as its changes could not be rolled back", t.Name())
+	}
+	paths := t.ChangedFiles(args)
+	for _, path := range paths {
+		if t.allow != nil && !t.allow(t.snapshots.abs(path)) {
+			return nil, fmt.Errorf("%s may not be changed here", path)
+		}
+	}
+	for _, path := range paths {
+		t.snapshots.record(path)
+	}
+	return t.Tool.Execute(ctx, args)
+}
+
+// snapshotTools wraps the tools of a that may change something in
+// snapshotTools recording to snapshots.
+func snapshotTools(a *Agent, snapshots *fileSnapshots, allow func(path string) bool) {
+	for name, tool := range a.Tools {
+		if ro, ok := tool.(ReadOnly); !ok || !ro.ReadOnly() {
+			a.Tools[name] = snapshotTool{tool, snapshots, allow}
+		}
+	}
+}
+
+// readOnlyToolNames returns the names of the tools of a that change
+// nothing.
+func readOnlyToolNames(a *Agent) []string {
+	var names []string
+	for name, tool := range a.Tools {
+		if ro, ok := tool.(ReadOnly); ok && ro.ReadOnly() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// submitNudges is how many times the model is reminded to call the tool
+// that ends a loop before chatUntilSubmitted gives up.
+const submitNudges = 3
+
+// submitter is a tool the model ends a loop by calling, with what the loop
+// was for.
+type submitter interface {
+	Tool
+	submitted() bool
+}
+
+// chatUntilSubmitted registers tool with sub and sends it input, reminding
+// it to call the tool until it has.
+func chatUntilSubmitted(ctx context.Context, sub *Agent, tool submitter, input string, events func(Event)) error {
+	sub.RegisterTool(tool)
+	for i := 0; i <= submitNudges; i++ {
+		if err := chatEvents(ctx, sub, input, events); err != nil {
+			return err
+		}
+		if tool.submitted() {
+			return nil
+		}
+		input = "Continue. When you are done, call " + tool.Name() + "."
+	}
+	return fmt.Errorf("%s was never called", tool.Name())
+}
+
+// gatherList registers tool with sub and sends it input until the model
+// calls it, and returns the list submitted.
+func gatherList(ctx context.Context, sub *Agent, tool *listTool, input string, events func(Event)) ([]string, error) {
+	if err := chatUntilSubmitted(ctx, sub, tool, input, events); err != nil {
+		return nil, err
+	}
+	return tool.values, nil
+}
+
+// listTool is a tool the model submits a list of strings with, such as
+// the cases to test or the steps of a refactor.
+type listTool struct {
+	name        string
+	description string
+	field       string   // The argument that holds the list
+	items       string   // Description of the list
+	values      []string // Nil until submitted
+}
+
+func (t *listTool) Name() string        { return t.name }
+func (t *listTool) Description() string { return t.description }
+func (t *listTool) ReadOnly() bool      { return true }
+func (t *listTool) submitted() bool     { return t.values != nil }
+func (t *listTool) Schema() interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			t.field: map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": t.items,
+			},
+		},
+		"required": []string{t.field},
+	}
+}
+
+func (t *listTool) Execute(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	values := stringsArg(args, t.field)
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%s needs at least one item", t.field)
+	}
+	t.values = values
+	return "Submitted.", nil
+}
+
+// stringsArg returns the non-empty strings of the array argument name.
+func stringsArg(args map[string]interface{}, name string) []string {
+	var values []string
+	items, _ := args[name].([]interface{})
+	for _, item := range items {
+		if s, _ := item.(string); strings.TrimSpace(s) != "" {
+			values = append(values, strings.TrimSpace(s))
+		}
+	}
+	return values
+}
@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotTool(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.go"), []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	snapshots := newFileSnapshots(root)
+	allow := func(path string) bool { return strings.HasSuffix(path, ".go") }
+	writeGo := snapshotTool{&writeTool{fakeTool: fakeTool{name: "write"}, path: filepath.Join(root, "a.go")}, snapshots, allow}
+	writeText := snapshotTool{&writeTool{fakeTool: fakeTool{name: "write"}, path: filepath.Join(root, "a.txt")}, snapshots, allow}
+	other := &fakeTool{name: "issue_write"}
+
+	if _, err := writeGo.Execute(context.Background(), map[string]interface{}{"content": "package b\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeText.Execute(context.Background(), map[string]interface{}{"content": "x"}); err == nil || !strings.Contains(err.Error(), "may not be changed here") {
+		t.Errorf("a.txt: err = %v", err)
+	}
+	_, err := snapshotTool{other, snapshots, nil}.Execute(context.Background(), map[string]interface{}{"path": "a.go"})
+	if err == nil || !strings.Contains(err.Error(), "could not be rolled back") || other.calls != 0 {
+		t.Errorf("tool without ChangedFiles: err = %v, calls = %d", err, other.calls)
+	}
+
+	restored, err := snapshots.restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(restored, " ") != "a.go" {
+		t.Errorf("restored %v, want a.go", restored)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "a.go")); string(data) != "package a\n" {
+		t.Errorf("a.go = %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(root, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("a.txt was written: %v", err)
+	}
+}
@@ -0,0 +1,372 @@
+// Package config loads castor's JSON configuration file.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileName is the name of the configuration file.
+const FileName = "config.json"
+
+// Config is the contents of a castor configuration file.
+type Config struct {
+	// MCPServers maps server names to how to reach them. The format matches
+	// the mcpServers section used by other MCP hosts.
+	MCPServers map[string]MCPServer `json:"mcpServers,omitempty"`
+
+	// TUI customizes the terminal interface.
+	TUI TUI `json:"tui,omitzero"`
+
+	// MaxTurns limits the model requests of one chat; zero keeps the
+	// agent's default. The -max-turns flag overrides it.
+	MaxTurns int `json:"maxTurns,omitempty"`
+
+	// Profiles are named bundles of settings chosen with -profile.
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+
+	// Sessions sets how long the sessions saved in the workspace are
+	// kept.
+	Sessions Sessions `json:"sessions,omitzero"`
+}
+
+// Sessions is the retention policy of saved sessions, applied each time
+// castor starts in the workspace.
+type Sessions struct {
+	// PruneAfter deletes the sessions not updated for this long, such as
+	// "30d". Zero keeps them all.
+	PruneAfter Duration `json:"pruneAfter,omitempty"`
+
+	// KeepTagged spares the sessions with tags from pruning.
+	KeepTagged bool `json:"keepTagged,omitempty"`
+}
+
+// Profile bundles the settings of one way of working, such as a local
+// model for coding or a hosted one for investigations. Empty fields keep
+// the defaults, and flags given on the command line win over the profile.
+type Profile struct {
+	URL         string     `json:"url,omitempty"` // Base URL of the OpenAI-compatible API
+	Model       string     `json:"model,omitempty"`
+	System      string     `json:"system,omitempty"` // System prompt
+	Temperature *float32   `json:"temperature,omitempty"`
+	MaxTurns    int        `json:"maxTurns,omitempty"`
+	Tools       ToolFilter `json:"tools,omitzero"`
+
+	// MCPServers names the only servers to connect to, like -mcp-only.
+	MCPServers []string `json:"mcpServers,omitempty"`
+
+	// MCPSampling is the policy for server completion requests: "ask",
+	// "allow", or "deny".
+	MCPSampling string `json:"mcpSampling,omitempty"`
+
+	// Approval says which tool calls must be confirmed first: "never",
+	// "mutating", or "always".
+	Approval string `json:"approval,omitempty"`
+}
+
+// Validate checks the profile's values.
+func (p Profile) Validate() error {
+	if p.Temperature != nil && *p.Temperature < 0 {
+		return fmt.Errorf("temperature must not be negative")
+	}
+	if p.MaxTurns < 0 {
+		return fmt.Errorf("maxTurns must not be negative")
+	}
+	switch p.MCPSampling {
+	case "", "ask", "allow", "deny":
+	default:
+		return fmt.Errorf("unknown mcpSampling policy %q", p.MCPSampling)
+	}
+	switch p.Approval {
+	case "", "never", "mutating", "always":
+	default:
+		return fmt.Errorf("unknown approval policy %q", p.Approval)
+	}
+	return p.Tools.Validate()
+}
+
+// ProfileNames returns the names of the profiles, sorted.
+func (c *Config) ProfileNames() []string {
+	var names []string
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TUI holds the appearance settings of the terminal interface.
+type TUI struct {
+	// Theme is a built-in theme: "dark", "light", or "auto" (the default),
+	// which follows the terminal background.
+	Theme string `json:"theme,omitempty"`
+
+	// Colors overrides single colors of the theme, such as
+	// {"user": "#ff8800"}.
+	Colors map[string]string `json:"colors,omitempty"`
+
+	// DisableMouse leaves the mouse to the terminal, so text can be
+	// selected without holding Shift, at the cost of wheel scrolling.
+	DisableMouse bool `json:"disableMouse,omitempty"`
+
+	// NotifyAfter is how many seconds a reply must take before its end
+	// rings the bell and posts a desktop notification, when the terminal
+	// is in the background. Zero means 10 seconds; a negative value turns
+	// notifications off.
+	NotifyAfter int `json:"notifyAfter,omitempty"`
+
+	// Keys remaps key bindings, such as {"submit": "ctrl+j", "newline":
+	// "enter"}. Actions are submit, newline, cancel, palette, scrollUp, and
+	// scrollDown; several keys are separated by spaces.
+	Keys map[string]string `json:"keys,omitempty"`
+}
+
+// MCPServer describes one MCP server: either a local command speaking
+// stdio, or a remote server reached over HTTP.
+type MCPServer struct {
+	Command  string            `json:"command,omitempty"`
+	Args     []string          `json:"args,omitempty"`
+	Env      map[string]string `json:"env,omitempty"` // Values may reference $VAR or ${VAR} from castor's environment
+	Cwd      string            `json:"cwd,omitempty"` // Working directory, relative to the workspace; may reference $VARS
+	URL      string            `json:"url,omitempty"`
+	Disabled bool              `json:"disabled,omitempty"`
+	Timeout  Duration          `json:"timeout,omitempty"`  // Per tool call, e.g. "90s"
+	LogLevel string            `json:"logLevel,omitempty"` // Minimum severity of server log messages, e.g. "debug"
+	Tools    ToolFilter        `json:"tools,omitzero"`
+
+	// ReadOnlyTools names the tools, or patterns like Tools, that the user
+	// trusts to change nothing, so they run without approval under
+	// -approve mutating. Every other tool of the server counts as
+	// mutating: the readOnlyHint annotations servers send are untrusted.
+	ReadOnlyTools []string `json:"readOnlyTools,omitempty"`
+
+	// StartupTimeout bounds how long a command server may take to start
+	// and answer the handshake.
+	StartupTimeout Duration `json:"startupTimeout,omitempty"`
+
+	// MaxMessageSize caps one message from the server, in bytes. Zero
+	// uses the transport default.
+	MaxMessageSize int `json:"maxMessageSize,omitempty"`
+
+	// Authentication for remote servers. Header and token values may
+	// reference $VARS like Env.
+	Headers     map[string]string `json:"headers,omitempty"`
+	BearerToken string            `json:"bearerToken,omitempty"`
+	OAuth       *OAuth            `json:"oauth,omitempty"`
+}
+
+// OAuth enables the MCP OAuth sign-in flow for a remote server. The client
+// is registered dynamically when ClientID is empty.
+type OAuth struct {
+	ClientID     string   `json:"clientId,omitempty"`
+	ClientSecret string   `json:"clientSecret,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+}
+
+// ToolFilter selects which of a server's tools are given to the agent.
+// Entries are tool names or path.Match patterns such as "delete_*". When
+// Allow is set only matching tools are kept; Deny always wins.
+type ToolFilter struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// Allowed reports whether the tool called name passes the filter.
+func (f ToolFilter) Allowed(name string) bool {
+	if matchAny(f.Deny, name) {
+		return false
+	}
+	return len(f.Allow) == 0 || matchAny(f.Allow, name)
+}
+
+// Validate checks that every entry is a valid pattern.
+func (f ToolFilter) Validate() error {
+	for _, p := range append(append([]string{}, f.Allow...), f.Deny...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid tool pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseDuration parses a duration like time.ParseDuration, and also whole
+// or fractional days, such as "30d".
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Duration is a time.Duration written in JSON as a string like "30s", or as
+// a number of seconds.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\" or a number of seconds")
+	}
+	parsed, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Validate checks that exactly one of Command and URL is set and that the
+// tool filter is well formed.
+func (s MCPServer) Validate() error {
+	switch {
+	case s.Command == "" && s.URL == "":
+		return fmt.Errorf("either command or url is required")
+	case s.Command != "" && s.URL != "":
+		return fmt.Errorf("command and url are mutually exclusive")
+	case s.URL == "" && (len(s.Headers) > 0 || s.BearerToken != "" || s.OAuth != nil):
+		return fmt.Errorf("headers, bearerToken, and oauth only apply to url servers")
+	case s.URL != "" && (len(s.Env) > 0 || s.Cwd != "" || s.StartupTimeout != 0):
+		return fmt.Errorf("env, cwd, and startupTimeout only apply to command servers")
+	case s.BearerToken != "" && s.OAuth != nil:
+		return fmt.Errorf("bearerToken and oauth are mutually exclusive")
+	case s.MaxMessageSize < 0:
+		return fmt.Errorf("maxMessageSize must not be negative")
+	}
+	if err := s.Tools.Validate(); err != nil {
+		return err
+	}
+	return ToolFilter{Allow: s.ReadOnlyTools}.Validate()
+}
+
+// ReadOnly reports whether the user listed the tool called name in
+// ReadOnlyTools.
+func (s MCPServer) ReadOnly(name string) bool {
+	return matchAny(s.ReadOnlyTools, name)
+}
+
+// Environ returns Env as KEY=VALUE pairs with environment references
+// expanded, sorted by key.
+func (s MCPServer) Environ() []string {
+	var env []string
+	for k, v := range s.Env {
+		env = append(env, k+"="+os.ExpandEnv(v))
+	}
+	sort.Strings(env)
+	return env
+}
+
+// Dir returns Cwd with environment references expanded, resolved against
+// the workspace root. It is empty when Cwd is.
+func (s MCPServer) Dir(workspace string) string {
+	if s.Cwd == "" {
+		return ""
+	}
+	dir := os.ExpandEnv(s.Cwd)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(workspace, dir)
+	}
+	return dir
+}
+
+// Header returns Headers with environment references expanded.
+func (s MCPServer) Header() http.Header {
+	h := make(http.Header)
+	for k, v := range s.Headers {
+		h.Set(k, os.ExpandEnv(v))
+	}
+	return h
+}
+
+// EnabledServers returns the names of servers that are not disabled, sorted.
+func (c *Config) EnabledServers() []string {
+	var names []string
+	for name, s := range c.MCPServers {
+		if !s.Disabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Load reads the configuration file at path.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	for name, s := range cfg.MCPServers {
+		if err := s.Validate(); err != nil {
+			return nil, fmt.Errorf("mcp server %q in %s: %w", name, path, err)
+		}
+	}
+	for name, p := range cfg.Profiles {
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("profile %q in %s: %w", name, path, err)
+		}
+	}
+	if cfg.Sessions.PruneAfter < 0 {
+		return nil, fmt.Errorf("sessions in %s: pruneAfter must not be negative", path)
+	}
+	return &cfg, nil
+}
+
+// Paths returns the configuration files consulted for a workspace, in order
+// of precedence: the workspace's .castor/config.json, then the user's
+// config directory.
+func Paths(workspaceRoot string) []string {
+	absRoot, _ := filepath.Abs(workspaceRoot)
+	paths := []string{filepath.Join(absRoot, ".castor", FileName)}
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "castor", FileName))
+	}
+	return paths
+}
+
+// Find loads the first configuration file that exists among Paths. It
+// returns an empty Config when there is none.
+func Find(workspaceRoot string) (*Config, string, error) {
+	for _, path := range Paths(workspaceRoot) {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		cfg, err := Load(path)
+		return cfg, path, err
+	}
+	return &Config{}, "", nil
+}
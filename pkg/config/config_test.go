@@ -0,0 +1,188 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, FileName)
+
+	t.Run("MCPServers", func(t *testing.T) {
+		t.Setenv("CASTOR_TEST_TOKEN", "secret")
+		data := `{
+			"mcpServers": {
+				"files": {"command": "mcp-files", "args": ["--root", "."], "env": {"TOKEN": "$CASTOR_TEST_TOKEN"}},
+				"remote": {"url": "https://example.com/mcp", "timeout": "90s"},
+				"old": {"command": "mcp-old", "disabled": true}
+			}
+		}`
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if got := cfg.EnabledServers(); !reflect.DeepEqual(got, []string{"files", "remote"}) {
+			t.Errorf("Unexpected enabled servers: %v", got)
+		}
+		if got := time.Duration(cfg.MCPServers["remote"].Timeout); got != 90*time.Second {
+			t.Errorf("Expected 90s timeout, got %v", got)
+		}
+		if got := cfg.MCPServers["files"].Environ(); !reflect.DeepEqual(got, []string{"TOKEN=secret"}) {
+			t.Errorf("Unexpected environment: %v", got)
+		}
+	})
+
+	t.Run("InvalidServer", func(t *testing.T) {
+		data := `{"mcpServers": {"both": {"command": "x", "url": "http://localhost"}}}`
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Error("Expected error for server with both command and url")
+		}
+	})
+
+	t.Run("StdioOptions", func(t *testing.T) {
+		t.Setenv("CASTOR_TEST_KEY", "k123")
+		data := `{"mcpServers": {"db": {"command": "db-mcp", "cwd": "tools/${CASTOR_TEST_KEY}", "env": {"API_KEY": "${CASTOR_TEST_KEY}"}, "startupTimeout": 5}}}`
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		server := cfg.MCPServers["db"]
+		if got, want := server.Dir("/ws"), filepath.Join("/ws", "tools", "k123"); got != want {
+			t.Errorf("Dir() = %q, want %q", got, want)
+		}
+		if got := server.Environ(); !reflect.DeepEqual(got, []string{"API_KEY=k123"}) {
+			t.Errorf("Unexpected environment: %v", got)
+		}
+		if got := time.Duration(server.StartupTimeout); got != 5*time.Second {
+			t.Errorf("Expected 5s startup timeout, got %v", got)
+		}
+
+		if err := (MCPServer{Command: "db-mcp", MaxMessageSize: -1}).Validate(); err == nil {
+			t.Error("Expected error for negative maxMessageSize")
+		}
+
+		remote := MCPServer{URL: "https://example.com/mcp", Cwd: "."}
+		if err := remote.Validate(); err == nil {
+			t.Error("Expected error for cwd on a url server")
+		}
+	})
+
+	t.Run("ToolFilter", func(t *testing.T) {
+		data := `{"mcpServers": {"github": {"command": "gh-mcp", "tools": {"deny": ["delete_*", "merge_pull_request"]}}}}`
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		filter := cfg.MCPServers["github"].Tools
+		for name, want := range map[string]bool{"create_issue": true, "delete_repo": false, "merge_pull_request": false} {
+			if got := filter.Allowed(name); got != want {
+				t.Errorf("Allowed(%q) = %v, want %v", name, got, want)
+			}
+		}
+
+		allow := ToolFilter{Allow: []string{"get_*", "list_*"}, Deny: []string{"get_secret"}}
+		for name, want := range map[string]bool{"get_file": true, "list_issues": true, "get_secret": false, "create_issue": false} {
+			if got := allow.Allowed(name); got != want {
+				t.Errorf("Allowed(%q) = %v, want %v", name, got, want)
+			}
+		}
+		if err := (ToolFilter{Deny: []string{"["}}).Validate(); err == nil {
+			t.Error("Expected error for malformed pattern")
+		}
+
+		server := MCPServer{Command: "gh-mcp", ReadOnlyTools: []string{"get_*"}}
+		if !server.ReadOnly("get_issue") || server.ReadOnly("create_issue") {
+			t.Error("ReadOnly does not follow readOnlyTools")
+		}
+		if err := (MCPServer{Command: "gh-mcp", ReadOnlyTools: []string{"["}}).Validate(); err == nil {
+			t.Error("Expected error for malformed readOnlyTools pattern")
+		}
+	})
+
+	t.Run("TUI", func(t *testing.T) {
+		data := `{"tui": {"theme": "light", "colors": {"user": "#ff8800"}, "notifyAfter": 30, "keys": {"palette": "ctrl+p"}}}`
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.TUI.Theme != "light" || cfg.TUI.Colors["user"] != "#ff8800" || cfg.TUI.NotifyAfter != 30 || cfg.TUI.Keys["palette"] != "ctrl+p" {
+			t.Errorf("Unexpected tui settings: %+v", cfg.TUI)
+		}
+	})
+
+	t.Run("Profiles", func(t *testing.T) {
+		data := `{"profiles": {
+			"local": {"url": "http://localhost:11434/v1", "model": "qwen2.5-coder", "temperature": 0, "tools": {"deny": ["replace"]}},
+			"cloud": {"model": "gpt-4o", "mcpServers": ["github"], "mcpSampling": "deny", "approval": "always"}
+		}}`
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if got := cfg.ProfileNames(); !reflect.DeepEqual(got, []string{"cloud", "local"}) {
+			t.Errorf("Unexpected profiles: %v", got)
+		}
+		local := cfg.Profiles["local"]
+		if local.Temperature == nil || *local.Temperature != 0 || local.Tools.Allowed("replace") {
+			t.Errorf("Unexpected local profile: %+v", local)
+		}
+
+		if err := (Profile{MCPSampling: "sometimes"}).Validate(); err == nil {
+			t.Error("Expected error for unknown sampling policy")
+		}
+		if err := (Profile{Approval: "yolo"}).Validate(); err == nil {
+			t.Error("Expected error for unknown approval policy")
+		}
+	})
+
+	t.Run("Sessions", func(t *testing.T) {
+		data := `{"sessions": {"pruneAfter": "30d", "keepTagged": true}}`
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if got := time.Duration(cfg.Sessions.PruneAfter); got != 30*24*time.Hour || !cfg.Sessions.KeepTagged {
+			t.Errorf("Unexpected sessions policy: %v, %+v", got, cfg.Sessions)
+		}
+		for _, bad := range []string{"-1d", "xd", "3 weeks"} {
+			if d, err := ParseDuration(bad); err == nil {
+				t.Errorf("ParseDuration(%q) = %v", bad, d)
+			}
+		}
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+		t.Setenv("HOME", t.TempDir())
+		cfg, path, err := Find(t.TempDir())
+		if err != nil || path != "" || len(cfg.MCPServers) != 0 {
+			t.Errorf("Expected empty config, got %v, %q, %v", cfg, path, err)
+		}
+	})
+}
@@ -0,0 +1,229 @@
+// Package diff computes line-based unified diffs.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultContext is the number of unchanged lines shown around each change.
+const DefaultContext = 3
+
+// OpKind identifies the type of a diff operation.
+type OpKind int
+
+const (
+	Equal OpKind = iota
+	Insert
+	Delete
+)
+
+// Op is a single line-level edit operation.
+type Op struct {
+	Kind OpKind
+	Line string
+}
+
+// Lines computes the shortest edit script turning a into b using Myers'
+// algorithm.
+func Lines(a, b []string) []Op {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+
+	offset := max
+	v := make([]int, 2*max+2)
+	var trace [][]int
+
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk the trace backwards to recover the edit script
+	var ops []Op
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			ops = append(ops, Op{Kind: Equal, Line: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, Op{Kind: Insert, Line: b[y-1]})
+			} else {
+				ops = append(ops, Op{Kind: Delete, Line: a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// Unified returns a unified diff between a and b, or an empty string when
+// they are identical.
+func Unified(fromName, toName, a, b string) string {
+	return UnifiedContext(fromName, toName, a, b, DefaultContext)
+}
+
+// UnifiedContext is like Unified with a configurable number of context lines.
+func UnifiedContext(fromName, toName, a, b string, context int) string {
+	if a == b {
+		return ""
+	}
+	ops := Lines(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Line numbers (0-based) in a and b at the start of each op
+	aLine := make([]int, len(ops)+1)
+	bLine := make([]int, len(ops)+1)
+	for i, op := range ops {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if op.Kind != Insert {
+			aLine[i+1]++
+		}
+		if op.Kind != Delete {
+			bLine[i+1]++
+		}
+	}
+
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == Equal {
+			i++
+			continue
+		}
+
+		// Extend the hunk until a run of more than 2*context equal lines
+		start := max(0, i-context)
+		end := i
+		for end < len(ops) {
+			if ops[end].Kind != Equal {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].Kind == Equal {
+				run++
+			}
+			if run == len(ops) || run-end > 2*context {
+				end = min(run, end+context)
+				break
+			}
+			end = run
+		}
+
+		aCount := aLine[end] - aLine[start]
+		bCount := bLine[end] - bLine[start]
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aLine[start], aCount), hunkRange(bLine[start], bCount))
+		for _, op := range ops[start:end] {
+			switch op.Kind {
+			case Equal:
+				out.WriteString(" ")
+			case Insert:
+				out.WriteString("+")
+			case Delete:
+				out.WriteString("-")
+			}
+			out.WriteString(op.Line)
+			out.WriteString("\n")
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// Stats counts inserted and deleted lines between a and b.
+func Stats(a, b string) (added, removed int) {
+	for _, op := range Lines(splitLines(a), splitLines(b)) {
+		switch op.Kind {
+		case Insert:
+			added++
+		case Delete:
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// ChangedLines returns the 1-based range of lines in b affected by the
+// change from a to b. For pure deletions the range is the line following
+// the removed block. ok is false when a and b are identical.
+func ChangedLines(a, b string) (start, end int, ok bool) {
+	line := 0
+	for _, op := range Lines(splitLines(a), splitLines(b)) {
+		switch op.Kind {
+		case Equal:
+			line++
+		case Insert:
+			line++
+			if !ok {
+				start, ok = line, true
+			}
+			end = line
+		case Delete:
+			if !ok {
+				start, ok = line+1, true
+			}
+			end = max(end, line+1)
+		}
+	}
+	return start, end, ok
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
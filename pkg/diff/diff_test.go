@@ -0,0 +1,99 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	a := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+	b := "one\ntwo\nTHREE\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven\n"
+
+	got := Unified("a/file.txt", "b/file.txt", a, b)
+	want := `--- a/file.txt
++++ b/file.txt
+@@ -1,6 +1,6 @@
+ one
+ two
+-three
++THREE
+ four
+ five
+ six
+@@ -8,3 +8,4 @@
+ eight
+ nine
+ ten
++eleven
+`
+	if got != want {
+		t.Errorf("unexpected diff.\nGot:\n%s\nWant:\n%s", got, want)
+	}
+}
+
+func TestUnifiedIdentical(t *testing.T) {
+	if d := Unified("a", "b", "same\n", "same\n"); d != "" {
+		t.Errorf("expected empty diff, got %q", d)
+	}
+}
+
+func TestUnifiedNewFile(t *testing.T) {
+	got := Unified("/dev/null", "b/new.txt", "", "hello\nworld\n")
+	if !strings.Contains(got, "@@ -0,0 +1,2 @@\n+hello\n+world\n") {
+		t.Errorf("unexpected diff for new file:\n%s", got)
+	}
+}
+
+func TestStats(t *testing.T) {
+	added, removed := Stats("a\nb\nc\n", "a\nc\nd\ne\n")
+	if added != 2 || removed != 1 {
+		t.Errorf("expected +2 -1, got +%d -%d", added, removed)
+	}
+}
+
+func TestParseHunks(t *testing.T) {
+	text := `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -1,3 +1,4 @@ package main
+ package main
++
+ func main() {
+ }
+@@ -10 +11,2 @@
+-	old()
++	new()
++	more()
+diff --git a/gone.txt b/gone.txt
+deleted file mode 100644
+--- a/gone.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-bye
+diff --git a/logo.png b/logo.png
+Binary files a/logo.png and b/logo.png differ
+`
+	hunks := ParseHunks(text)
+	if len(hunks) != 3 {
+		t.Fatalf("got %d hunks: %+v", len(hunks), hunks)
+	}
+	want := []Hunk{
+		{File: "main.go", OldStart: 1, OldLines: 3, NewStart: 1, NewLines: 4},
+		{File: "main.go", OldStart: 10, OldLines: 1, NewStart: 11, NewLines: 2},
+		{File: "gone.txt", OldStart: 1, OldLines: 1, NewStart: 0, NewLines: 0},
+	}
+	for i, h := range hunks {
+		text := h.Text
+		h.Text = ""
+		if h != want[i] {
+			t.Errorf("hunk %d = %+v, want %+v", i+1, h, want[i])
+		}
+		if !strings.HasPrefix(text, "@@ ") || strings.Contains(text, "diff --git") {
+			t.Errorf("hunk %d text:\n%s", i+1, text)
+		}
+	}
+	if !strings.HasSuffix(hunks[1].Text, "+\tmore()\n") {
+		t.Errorf("second hunk text:\n%s", hunks[1].Text)
+	}
+}
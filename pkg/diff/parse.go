@@ -0,0 +1,91 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Hunk is a hunk of a unified diff.
+type Hunk struct {
+	File     string // Path in the new version, or in the old for a deleted file
+	OldStart int    // 1-based first line in the old version
+	OldLines int
+	NewStart int // 1-based first line in the new version
+	NewLines int
+	Text     string // The hunk, from its @@ line
+}
+
+// ParseHunks splits a unified diff, such as git diff prints, into its
+// hunks. The "a/" and "b/" prefixes of git are removed from file names.
+// Files without hunks, such as binary files, are left out.
+func ParseHunks(text string) []Hunk {
+	var hunks []Hunk
+	var oldFile, newFile string
+	var hunk *Hunk
+	var body strings.Builder
+	done := func() {
+		if hunk != nil {
+			hunk.Text = body.String()
+			hunks = append(hunks, *hunk)
+			hunk = nil
+		}
+		body.Reset()
+	}
+
+	lines := strings.SplitAfter(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(trimmed, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			done()
+			oldFile = diffPath(trimmed[4:], "a/")
+			newFile = diffPath(strings.TrimRight(lines[i+1][4:], "\r\n"), "b/")
+			i++
+		case strings.HasPrefix(trimmed, "@@ "):
+			done()
+			hunk = &Hunk{File: newFile}
+			if hunk.File == "" {
+				hunk.File = oldFile
+			}
+			parseHunkHeader(trimmed, hunk)
+			body.WriteString(line)
+		case hunk != nil && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "+") ||
+			strings.HasPrefix(line, "-") || strings.HasPrefix(line, "\\") || trimmed == ""):
+			body.WriteString(line)
+		default:
+			// A header line of the next file, such as "diff --git"
+			done()
+		}
+	}
+	done()
+	return hunks
+}
+
+// diffPath returns the file name of a ---/+++ line, or "" for /dev/null.
+func diffPath(name, prefix string) string {
+	name, _, _ = strings.Cut(name, "\t") // Some diffs add a timestamp
+	if name == "/dev/null" {
+		return ""
+	}
+	return strings.TrimPrefix(name, prefix)
+}
+
+// parseHunkHeader reads the ranges of an "@@ -a,b +c,d @@" line.
+func parseHunkHeader(header string, h *Hunk) {
+	var oldRange, newRange string
+	fmt.Sscanf(header, "@@ %s %s @@", &oldRange, &newRange)
+	h.OldStart, h.OldLines = parseRange(strings.TrimPrefix(oldRange, "-"))
+	h.NewStart, h.NewLines = parseRange(strings.TrimPrefix(newRange, "+"))
+}
+
+// parseRange reads a "start,count" range; a missing count is 1.
+func parseRange(r string) (start, count int) {
+	count = 1
+	if s, c, ok := strings.Cut(r, ","); ok {
+		fmt.Sscanf(c, "%d", &count)
+		r = s
+	}
+	fmt.Sscanf(r, "%d", &start)
+	return start, count
+}
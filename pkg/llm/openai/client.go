@@ -4,10 +4,14 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/techmuch/castor/pkg/llm"
 )
@@ -17,6 +21,11 @@ type Client struct {
 	APIKey  string
 	Model   string
 	HTTP    *http.Client
+
+	// Logger, if set, receives a debug record of each request. With Dump
+	// the raw request body and every line of the response are logged too.
+	Logger *slog.Logger
+	Dump   bool
 }
 
 func NewClient(baseURL, apiKey, model string) *Client {
@@ -54,18 +63,52 @@ type openAIToolCall struct {
 
 type openAIMessage struct {
 	Role       string           `json:"role"`
-	Content    string           `json:"content,omitempty"`
+	Content    interface{}      `json:"content,omitempty"` // string or []contentPart
 	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
 	ToolCallID string           `json:"tool_call_id,omitempty"`
 }
 
+// contentPart is an element of multi-part message content, used to send
+// images.
+type contentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *imageURL `json:"image_url,omitempty"`
+}
+
+type imageURL struct {
+	URL string `json:"url"`
+}
+
+// multipart builds message content from text followed by images, which
+// are sent inline as data URLs.
+func multipart(text string, images []llm.ImagePart) []contentPart {
+	var parts []contentPart
+	if text != "" {
+		parts = append(parts, contentPart{Type: "text", Text: text})
+	}
+	for _, img := range images {
+		url := "data:" + img.MimeType + ";base64," + base64.StdEncoding.EncodeToString(img.Data)
+		parts = append(parts, contentPart{Type: "image_url", ImageURL: &imageURL{URL: url}})
+	}
+	return parts
+}
+
 type chatRequest struct {
 	Model       string          `json:"model"`
 	Messages    []openAIMessage `json:"messages"`
 	Stream      bool            `json:"stream"`
-	Temperature float32         `json:"temperature,omitempty"`
-	TopP        float32         `json:"top_p,omitempty"`
+	Temperature *float32        `json:"temperature,omitempty"`
+	TopP        *float32        `json:"top_p,omitempty"`
+	MaxTokens   int             `json:"max_tokens,omitempty"`
 	Tools       []openAITool    `json:"tools,omitempty"`
+
+	StreamOptions *streamOptions `json:"stream_options,omitempty"`
+}
+
+// streamOptions asks for token usage in the last chunk of a stream.
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type toolCallChunk struct {
@@ -88,17 +131,23 @@ type streamChoice struct {
 
 type streamResponse struct {
 	Choices []streamChoice `json:"choices"`
+	Usage   *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
 }
 
 func (c *Client) GenerateContent(ctx context.Context, history []llm.Message, opts llm.GenerateOptions) (<-chan llm.StreamEvent, error) {
 	msgs := make([]openAIMessage, 0, len(history))
+	var toolImages []openAIMessage
 	for _, m := range history {
 		msg := openAIMessage{
 			Role: string(m.Role),
 		}
 
 		var contentParts []string
-		
+		var images []llm.ImagePart
+
 		for _, p := range m.Content {
 			switch v := p.(type) {
 			case llm.TextPart:
@@ -120,16 +169,40 @@ func (c *Client) GenerateContent(ctx context.Context, history []llm.Message, opt
 			case llm.ToolResponsePart:
 				msg.ToolCallID = v.ID
 				contentParts = append(contentParts, v.Content)
+			case llm.ImagePart:
+				images = append(images, v)
 			}
 		}
-		
-		msg.Content = strings.Join(contentParts, "\n")
+
+		if text := strings.Join(contentParts, "\n"); text != "" {
+			msg.Content = text
+		}
 		// OpenAI Requirement: Content must be null if tool_calls are present and content is empty.
 		// But in Go json omitempty works if string is empty.
 		// However, for Assistant messages, content can be null.
 		
+		// Tool messages may only hold text, so images a tool returned follow
+		// in a user message once all of the turn's tool responses are in.
+		if msg.ToolCallID == "" {
+			msgs = append(msgs, toolImages...)
+			toolImages = nil
+		}
+		if len(images) == 0 {
+			msgs = append(msgs, msg)
+			continue
+		}
+		if id := msg.ToolCallID; id != "" {
+			msgs = append(msgs, msg)
+			toolImages = append(toolImages, openAIMessage{
+				Role:    string(llm.RoleUser),
+				Content: multipart(fmt.Sprintf("Images returned by tool call %s:", id), images),
+			})
+			continue
+		}
+		msg.Content = multipart(strings.Join(contentParts, "\n"), images)
 		msgs = append(msgs, msg)
 	}
+	msgs = append(msgs, toolImages...)
 
 	var tools []openAITool
 	if len(opts.Tools) > 0 {
@@ -155,7 +228,10 @@ func (c *Client) GenerateContent(ctx context.Context, history []llm.Message, opt
 		Stream:      true,
 		Temperature: opts.Temperature,
 		TopP:        opts.TopP,
+		MaxTokens:   opts.MaxTokens,
 		Tools:       tools,
+
+		StreamOptions: &streamOptions{IncludeUsage: true},
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -173,12 +249,26 @@ func (c *Client) GenerateContent(ctx context.Context, history []llm.Message, opt
 		req.Header.Set("Authorization", "Bearer "+c.APIKey)
 	}
 
+	logger := c.log()
+	if c.Dump {
+		logger.Debug("provider request body", "url", req.URL.String(), "body", string(jsonData))
+	}
+	start := time.Now()
 	resp, err := c.HTTP.Do(req)
 	if err != nil {
+		logger.Debug("provider request failed", "model", c.Model, "duration", time.Since(start), "error", err)
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
+	if id := resp.Header.Get("X-Request-Id"); id != "" {
+		logger = logger.With("request_id", id)
+	}
+	logger.Debug("provider request", "model", c.Model, "messages", len(msgs), "tools", len(tools), "status", resp.StatusCode, "duration", time.Since(start))
 
 	if resp.StatusCode != http.StatusOK {
+		if c.Dump {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, maxDumpError))
+			logger.Debug("provider response body", "body", string(body))
+		}
 		resp.Body.Close()
 		return nil, fmt.Errorf("api returned status: %s", resp.Status)
 	}
@@ -200,6 +290,9 @@ func (c *Client) GenerateContent(ctx context.Context, history []llm.Message, opt
 		scanner := bufio.NewScanner(resp.Body)
 		for scanner.Scan() {
 			line := scanner.Text()
+			if c.Dump && line != "" {
+				logger.Debug("provider response line", "line", line)
+			}
 			if line == "" || !strings.HasPrefix(line, "data: ") {
 				continue
 			}
@@ -214,6 +307,10 @@ func (c *Client) GenerateContent(ctx context.Context, history []llm.Message, opt
 				return
 			}
 
+			if u := streamResp.Usage; u != nil {
+				ch <- llm.StreamEvent{Usage: &llm.Usage{PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens}}
+			}
+
 			if len(streamResp.Choices) == 0 {
 				continue
 			}
@@ -272,6 +369,17 @@ pendingCalls = make(map[int]*pendingToolCall)
 	return ch, nil
 }
 
+// maxDumpError caps how much of an error response Dump logs.
+const maxDumpError = 64 << 10
+
+// log returns Logger, or a logger that discards.
+func (c *Client) log() *slog.Logger {
+	if c.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return c.Logger
+}
+
 func (c *Client) EmbedContent(ctx context.Context, texts []string) ([][]float32, error) {
 	return nil, fmt.Errorf("not implemented")
 }
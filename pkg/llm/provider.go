@@ -4,8 +4,10 @@ import "context"
 
 // GenerateOptions contains configuration for the generation request.
 type GenerateOptions struct {
-	Temperature float32
-	TopP        float32
+	// Temperature and TopP are left to the provider when nil.
+	Temperature *float32
+	TopP        *float32
+	MaxTokens   int // Limit on generated tokens; 0 leaves it to the provider
 	StopTokens  []string
 	Tools       []ToolDefinition
 	// JSONSchema can be added here when we implement structured output support
@@ -19,6 +21,8 @@ type StreamEvent struct {
 	ToolCalls []ToolCallPart
 	// Error indicates if an error occurred during streaming.
 	Error error
+	// Usage reports the tokens used by the request, usually at the end.
+	Usage *Usage
 }
 
 // Provider defines the interface that all LLM backends must implement.
@@ -45,6 +45,15 @@ type ToolResponsePart struct {
 
 func (ToolResponsePart) isPart() {}
 
+// ImagePart represents inline image content, such as a screenshot returned
+// by a tool.
+type ImagePart struct {
+	MimeType string `json:"mime_type"`
+	Data     []byte `json:"data"`
+}
+
+func (ImagePart) isPart() {}
+
 // Message represents a single message in the chat history.
 type Message struct {
 	Role    Role   `json:"role"`
@@ -53,10 +62,11 @@ type Message struct {
 
 // Custom Marshaling for Parts to handle interface type
 type partWrapper struct {
-	Type     string           `json:"type"`
-	Text     *TextPart        `json:"text_part,omitempty"`
-	ToolCall *ToolCallPart    `json:"tool_call_part,omitempty"`
+	Type     string            `json:"type"`
+	Text     *TextPart         `json:"text_part,omitempty"`
+	ToolCall *ToolCallPart     `json:"tool_call_part,omitempty"`
 	ToolResp *ToolResponsePart `json:"tool_resp_part,omitempty"`
+	Image    *ImagePart        `json:"image_part,omitempty"`
 }
 
 func (m *Message) MarshalJSON() ([]byte, error) {
@@ -70,6 +80,8 @@ func (m *Message) MarshalJSON() ([]byte, error) {
 			parts = append(parts, partWrapper{Type: "tool_call", ToolCall: &v})
 		case ToolResponsePart:
 			parts = append(parts, partWrapper{Type: "tool_resp", ToolResp: &v})
+		case ImagePart:
+			parts = append(parts, partWrapper{Type: "image", Image: &v})
 		default:
 			return nil, fmt.Errorf("unknown part type: %T", p)
 		}
@@ -85,10 +97,11 @@ func (m *Message) MarshalJSON() ([]byte, error) {
 
 func (m *Message) UnmarshalJSON(data []byte) error {
 	type partWrap struct {
-		Type     string           `json:"type"`
-		Text     *TextPart        `json:"text_part,omitempty"`
-		ToolCall *ToolCallPart    `json:"tool_call_part,omitempty"`
+		Type     string            `json:"type"`
+		Text     *TextPart         `json:"text_part,omitempty"`
+		ToolCall *ToolCallPart     `json:"tool_call_part,omitempty"`
 		ToolResp *ToolResponsePart `json:"tool_resp_part,omitempty"`
+		Image    *ImagePart        `json:"image_part,omitempty"`
 	}
 	var msg struct {
 		Role    Role       `json:"role"`
@@ -113,6 +126,10 @@ func (m *Message) UnmarshalJSON(data []byte) error {
 			if p.ToolResp != nil {
 				m.Content = append(m.Content, *p.ToolResp)
 			}
+		case "image":
+			if p.Image != nil {
+				m.Content = append(m.Content, *p.Image)
+			}
 		}
 	}
 	return nil
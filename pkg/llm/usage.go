@@ -0,0 +1,63 @@
+package llm
+
+import "strings"
+
+// Usage counts the tokens of a request.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// Add returns the sum of two usages.
+func (u Usage) Add(o Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + o.PromptTokens,
+		CompletionTokens: u.CompletionTokens + o.CompletionTokens,
+	}
+}
+
+// Total returns the number of tokens sent and generated.
+func (u Usage) Total() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// ModelInfo describes the limits and prices of a model.
+type ModelInfo struct {
+	ContextWindow int     // Maximum tokens of prompt and completion
+	InputPrice    float64 // USD per million prompt tokens
+	OutputPrice   float64 // USD per million completion tokens
+}
+
+// Cost returns the price of usage in USD.
+func (m ModelInfo) Cost(u Usage) float64 {
+	return (float64(u.PromptTokens)*m.InputPrice + float64(u.CompletionTokens)*m.OutputPrice) / 1e6
+}
+
+// knownModels lists well-known hosted models, by name prefix. More
+// specific prefixes come first.
+var knownModels = []struct {
+	prefix string
+	info   ModelInfo
+}{
+	{"gpt-4o-mini", ModelInfo{ContextWindow: 128000, InputPrice: 0.15, OutputPrice: 0.60}},
+	{"gpt-4o", ModelInfo{ContextWindow: 128000, InputPrice: 2.50, OutputPrice: 10.00}},
+	{"gpt-4.1-nano", ModelInfo{ContextWindow: 1047576, InputPrice: 0.10, OutputPrice: 0.40}},
+	{"gpt-4.1-mini", ModelInfo{ContextWindow: 1047576, InputPrice: 0.40, OutputPrice: 1.60}},
+	{"gpt-4.1", ModelInfo{ContextWindow: 1047576, InputPrice: 2.00, OutputPrice: 8.00}},
+	{"gpt-4-turbo", ModelInfo{ContextWindow: 128000, InputPrice: 10.00, OutputPrice: 30.00}},
+	{"gpt-4", ModelInfo{ContextWindow: 8192, InputPrice: 30.00, OutputPrice: 60.00}},
+	{"gpt-3.5-turbo", ModelInfo{ContextWindow: 16385, InputPrice: 0.50, OutputPrice: 1.50}},
+	{"o3-mini", ModelInfo{ContextWindow: 200000, InputPrice: 1.10, OutputPrice: 4.40}},
+	{"o4-mini", ModelInfo{ContextWindow: 200000, InputPrice: 1.10, OutputPrice: 4.40}},
+}
+
+// LookupModel returns what is known about the named model. Local and
+// unrecognized models are not listed.
+func LookupModel(name string) (ModelInfo, bool) {
+	for _, m := range knownModels {
+		if strings.HasPrefix(name, m.prefix) {
+			return m.info, true
+		}
+	}
+	return ModelInfo{}, false
+}
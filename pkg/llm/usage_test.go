@@ -0,0 +1,33 @@
+package llm
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLookupModel(t *testing.T) {
+	t.Run("MostSpecificPrefix", func(t *testing.T) {
+		mini, ok := LookupModel("gpt-4o-mini-2024-07-18")
+		if !ok {
+			t.Fatal("Expected gpt-4o-mini to be known")
+		}
+		full, _ := LookupModel("gpt-4o")
+		if mini.InputPrice >= full.InputPrice {
+			t.Errorf("Expected gpt-4o-mini pricing, got %+v", mini)
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		if _, ok := LookupModel("llama3"); ok {
+			t.Error("Expected local model to be unknown")
+		}
+	})
+
+	t.Run("Cost", func(t *testing.T) {
+		info := ModelInfo{InputPrice: 2, OutputPrice: 8}
+		u := Usage{PromptTokens: 1000, CompletionTokens: 500}.Add(Usage{PromptTokens: 1000})
+		if got, want := info.Cost(u), 0.008; math.Abs(got-want) > 1e-12 {
+			t.Errorf("Expected cost %v, got %v", want, got)
+		}
+	})
+}
@@ -0,0 +1,385 @@
+package mcp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/techmuch/castor/pkg/secrets"
+	"golang.org/x/oauth2"
+)
+
+// Authorizer adds credentials to requests sent by HTTPTransport.
+type Authorizer interface {
+	// Authorize adds credentials to an outgoing request.
+	Authorize(ctx context.Context, req *http.Request) error
+
+	// Unauthorized is called when the server rejects a request with 401.
+	// It reports whether new credentials were obtained and the request
+	// should be retried.
+	Unauthorized(ctx context.Context, resp *http.Response) (bool, error)
+}
+
+// BearerToken authorizes requests with a fixed token.
+type BearerToken string
+
+func (t BearerToken) Authorize(ctx context.Context, req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+string(t))
+	return nil
+}
+
+func (t BearerToken) Unauthorized(ctx context.Context, resp *http.Response) (bool, error) {
+	return false, fmt.Errorf("server rejected bearer token")
+}
+
+// OAuth authorizes requests using the MCP OAuth 2.1 flow: the authorization
+// server is discovered from the MCP server's metadata, a client is
+// registered dynamically unless ClientID is set, and the user signs in
+// through the browser with PKCE. Tokens are kept in Store and refreshed
+// automatically.
+type OAuth struct {
+	ServerURL    string // URL of the MCP server; also the key for stored tokens
+	ClientID     string // Optional: registered dynamically when empty
+	ClientSecret string
+	Scopes       []string
+	Store        secrets.Store
+
+	// OpenURL sends the user to the authorization page, typically by
+	// opening a browser.
+	OpenURL func(url string) error
+
+	// HTTPClient is used for discovery, registration, and token requests.
+	// Nil uses http.DefaultClient.
+	HTTPClient *http.Client
+
+	mu    sync.Mutex
+	saved *savedOAuth
+}
+
+// savedOAuth is what OAuth persists per server: enough to refresh the token
+// without repeating discovery.
+type savedOAuth struct {
+	ClientID     string        `json:"client_id"`
+	ClientSecret string        `json:"client_secret,omitempty"`
+	AuthURL      string        `json:"auth_url"`
+	TokenURL     string        `json:"token_url"`
+	Scopes       []string      `json:"scopes,omitempty"`
+	Token        *oauth2.Token `json:"token"`
+}
+
+func (s *savedOAuth) config(redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     s.ClientID,
+		ClientSecret: s.ClientSecret,
+		Endpoint:     oauth2.Endpoint{AuthURL: s.AuthURL, TokenURL: s.TokenURL},
+		RedirectURL:  redirectURL,
+		Scopes:       s.Scopes,
+	}
+}
+
+func (o *OAuth) storeKey() string {
+	return "mcp:" + o.ServerURL
+}
+
+func (o *OAuth) httpClient() *http.Client {
+	if o.HTTPClient != nil {
+		return o.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// Authorize adds the current access token, refreshing it if it expired.
+// Without a token the request is sent as is, so the server's 401 response
+// can start the sign-in flow.
+func (o *OAuth) Authorize(ctx context.Context, req *http.Request) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.saved == nil {
+		if data, err := o.Store.Get(o.storeKey()); err == nil {
+			var saved savedOAuth
+			if err := json.Unmarshal([]byte(data), &saved); err == nil && saved.Token != nil {
+				o.saved = &saved
+			}
+		} else if !errors.Is(err, secrets.ErrNotFound) {
+			return err
+		}
+	}
+	if o.saved == nil {
+		return nil
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, o.httpClient())
+	token, err := o.saved.config("").TokenSource(ctx, o.saved.Token).Token()
+	if err != nil {
+		// Refresh failed; fall back to signing in again
+		o.saved = nil
+		return nil
+	}
+	if token.AccessToken != o.saved.Token.AccessToken {
+		o.saved.Token = token
+		if err := o.save(); err != nil {
+			return err
+		}
+	}
+	token.SetAuthHeader(req)
+	return nil
+}
+
+// Unauthorized runs the interactive sign-in flow.
+func (o *OAuth) Unauthorized(ctx context.Context, resp *http.Response) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	meta, err := o.discover(ctx, resp.Header.Get("WWW-Authenticate"))
+	if err != nil {
+		return false, fmt.Errorf("oauth discovery failed: %w", err)
+	}
+	if err := o.login(ctx, meta); err != nil {
+		return false, fmt.Errorf("oauth sign-in failed: %w", err)
+	}
+	return true, nil
+}
+
+// authServerMetadata is the subset of RFC 8414 metadata castor uses.
+type authServerMetadata struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	RegistrationEndpoint  string `json:"registration_endpoint"`
+}
+
+// discover locates the authorization server for the MCP server, via the
+// protected resource metadata (RFC 9728) and then the authorization server
+// metadata (RFC 8414). Servers without metadata get the default endpoints
+// on the MCP server's origin.
+func (o *OAuth) discover(ctx context.Context, challenge string) (*authServerMetadata, error) {
+	server, err := url.Parse(httpURL(o.ServerURL))
+	if err != nil {
+		return nil, err
+	}
+	origin := server.Scheme + "://" + server.Host
+
+	issuer := origin
+	resourceURL := bearerParam(challenge, "resource_metadata")
+	if resourceURL == "" {
+		resourceURL = origin + "/.well-known/oauth-protected-resource"
+	}
+	var resource struct {
+		AuthorizationServers []string `json:"authorization_servers"`
+	}
+	if err := o.getJSON(ctx, resourceURL, &resource); err == nil && len(resource.AuthorizationServers) > 0 {
+		issuer = strings.TrimSuffix(resource.AuthorizationServers[0], "/")
+	}
+
+	issuerURL, err := url.Parse(issuer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization server %q: %w", issuer, err)
+	}
+	issuerOrigin := issuerURL.Scheme + "://" + issuerURL.Host
+	candidates := []string{
+		issuerOrigin + "/.well-known/oauth-authorization-server" + issuerURL.Path,
+		issuer + "/.well-known/openid-configuration",
+	}
+	for _, u := range candidates {
+		var meta authServerMetadata
+		if err := o.getJSON(ctx, u, &meta); err == nil && meta.AuthorizationEndpoint != "" && meta.TokenEndpoint != "" {
+			return &meta, nil
+		}
+	}
+
+	return &authServerMetadata{
+		AuthorizationEndpoint: issuerOrigin + "/authorize",
+		TokenEndpoint:         issuerOrigin + "/token",
+		RegistrationEndpoint:  issuerOrigin + "/register",
+	}, nil
+}
+
+// login runs the authorization code flow with PKCE, receiving the code on
+// a loopback redirect, and saves the resulting token.
+func (o *OAuth) login(ctx context.Context, meta *authServerMetadata) error {
+	if o.OpenURL == nil {
+		return fmt.Errorf("no way to open the authorization page")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("failed to start callback listener: %w", err)
+	}
+	defer listener.Close()
+	redirectURL := fmt.Sprintf("http://%s/callback", listener.Addr())
+
+	saved := &savedOAuth{
+		ClientID:     o.ClientID,
+		ClientSecret: o.ClientSecret,
+		AuthURL:      meta.AuthorizationEndpoint,
+		TokenURL:     meta.TokenEndpoint,
+		Scopes:       o.Scopes,
+	}
+	if saved.ClientID == "" {
+		if err := o.register(ctx, meta.RegistrationEndpoint, redirectURL, saved); err != nil {
+			return err
+		}
+	}
+	cfg := saved.config(redirectURL)
+
+	state, err := randomString()
+	if err != nil {
+		return err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	type callback struct {
+		code string
+		err  error
+	}
+	results := make(chan callback, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var res callback
+		switch {
+		case q.Get("state") != state:
+			res.err = fmt.Errorf("state mismatch in callback")
+		case q.Get("error") != "":
+			res.err = fmt.Errorf("authorization denied: %s %s", q.Get("error"), q.Get("error_description"))
+		default:
+			res.code = q.Get("code")
+		}
+		if res.err != nil {
+			http.Error(w, res.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "Signed in to castor. You can close this window.")
+		}
+		select {
+		case results <- res:
+		default:
+		}
+	})}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	authURL := cfg.AuthCodeURL(state,
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("resource", o.ServerURL))
+	if err := o.OpenURL(authURL); err != nil {
+		return fmt.Errorf("failed to open authorization page: %w", err)
+	}
+
+	var res callback
+	select {
+	case res = <-results:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if res.err != nil {
+		return res.err
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, o.httpClient())
+	token, err := cfg.Exchange(ctx, res.code,
+		oauth2.VerifierOption(verifier),
+		oauth2.SetAuthURLParam("resource", o.ServerURL))
+	if err != nil {
+		return fmt.Errorf("token exchange failed: %w", err)
+	}
+	saved.Token = token
+	o.saved = saved
+	return o.save()
+}
+
+// register performs dynamic client registration (RFC 7591).
+func (o *OAuth) register(ctx context.Context, endpoint, redirectURL string, saved *savedOAuth) error {
+	if endpoint == "" {
+		return fmt.Errorf("server does not support dynamic client registration; set a client ID")
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"client_name":                "castor",
+		"redirect_uris":              []string{redirectURL},
+		"grant_types":                []string{"authorization_code", "refresh_token"},
+		"response_types":             []string{"code"},
+		"token_endpoint_auth_method": "none",
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := o.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("client registration failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("client registration failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var client struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&client); err != nil || client.ClientID == "" {
+		return fmt.Errorf("invalid client registration response")
+	}
+	saved.ClientID, saved.ClientSecret = client.ClientID, client.ClientSecret
+	return nil
+}
+
+func (o *OAuth) save() error {
+	data, err := json.Marshal(o.saved)
+	if err != nil {
+		return fmt.Errorf("failed to marshal token: %w", err)
+	}
+	return o.Store.Set(o.storeKey(), string(data))
+}
+
+// Logout forgets the stored token for the server.
+func (o *OAuth) Logout() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.saved = nil
+	return o.Store.Delete(o.storeKey())
+}
+
+func (o *OAuth) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := o.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// bearerParam extracts a parameter from a WWW-Authenticate Bearer challenge.
+func bearerParam(challenge, name string) string {
+	for _, part := range strings.Split(strings.TrimPrefix(challenge, "Bearer"), ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok && strings.EqualFold(key, name) {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
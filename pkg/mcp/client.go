@@ -3,51 +3,116 @@ package mcp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/llm"
 )
 
+// NotificationHandler handles a notification sent by the server. Handlers
+// run on the client's read loop, so they should return quickly.
+type NotificationHandler func(params json.RawMessage)
+
+// RequestHandler answers a request sent by the server. Returning a
+// *JSONRPCError sends that error code; other errors are reported as
+// internal errors.
+type RequestHandler func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+// DefaultTimeout is the tool call timeout for servers that do not
+// configure one.
+const DefaultTimeout = 2 * time.Minute
+
 type MCPClient struct {
 	transport Transport
 	nextID    int64
+
+	// Timeout bounds each tool call unless the caller's context expires
+	// sooner. Zero means no limit beyond the caller's context.
+	Timeout time.Duration
+
+	// ReadOnlyTools reports which tools the user trusts to change
+	// nothing. The rest count as mutating whatever the server says: its
+	// readOnlyHint annotations are untrusted hints. Nil trusts none.
+	ReadOnlyTools func(name string) bool
+
+	ctx    context.Context // Cancelled by Close
+	cancel context.CancelFunc
+	done   chan struct{} // Closed when the read loop exits
+	err    error         // Why the read loop exited; valid after done is closed
+
+	mu            sync.Mutex
+	pending       map[int64]chan JSONRPCMessage
+	progress      map[int64]agent.ProgressFunc // Keyed by progress token
+	notifications map[string]NotificationHandler
+	requests      map[string]RequestHandler
+	roots         []Root
+	sampling      bool
+	initialized   bool
+	capabilities  map[string]json.RawMessage // Advertised by the server
+	logger        *slog.Logger
 }
 
+// NewClient returns a client for the server behind t and starts reading
+// its messages. Responses are matched to calls by ID, so servers may
+// interleave responses, notifications, and requests of their own.
 func NewClient(t Transport) *MCPClient {
-	return &MCPClient{
-		transport: t,
-		nextID:    1,
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &MCPClient{
+		transport:     t,
+		nextID:        1,
+		ctx:           ctx,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+		pending:       make(map[int64]chan JSONRPCMessage),
+		progress:      make(map[int64]agent.ProgressFunc),
+		notifications: make(map[string]NotificationHandler),
+		requests:      make(map[string]RequestHandler),
 	}
+	c.HandleRequest("ping", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		return struct{}{}, nil
+	})
+	c.HandleRequest("roots/list", c.listRoots)
+	c.OnNotification("notifications/progress", c.handleProgress)
+	go c.readLoop()
+	return c
 }
 
 func (c *MCPClient) Initialize(ctx context.Context) error {
 	// 1. Send initialize request
+	capabilities := map[string]interface{}{
+		"roots": map[string]interface{}{"listChanged": true},
+	}
+	c.mu.Lock()
+	if c.sampling {
+		capabilities["sampling"] = map[string]interface{}{}
+	}
+	c.mu.Unlock()
+
+	params, err := json.Marshal(map[string]interface{}{
+		"protocolVersion": "2024-11-05",
+		"capabilities":    capabilities,
+		"clientInfo": map[string]interface{}{
+			"name":    "castor",
+			"version": "0.1.0",
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal initialize params: %w", err)
+	}
 	req := JSONRPCMessage{
 		JSONRPC: "2.0",
 		Method:  "initialize",
-		Params: json.RawMessage(`{
-			"protocolVersion": "2024-11-05",
-			"capabilities": {
-				"roots": { "listChanged": false },
-				"sampling": {}
-			},
-			"clientInfo": {
-				"name": "castor",
-				"version": "0.1.0"
-			}
-		}`),
-		ID: c.newID(),
-	}
-
-	if err := c.transport.Send(ctx, req); err != nil {
-		return err
+		Params:  params,
+		ID:      c.newID(),
 	}
 
 	// 2. Wait for response
-	// TODO: In a real implementation, we need a dispatch loop to match IDs.
-	// For now, assuming synchronous response order for simple handshake.
-	resp, err := c.transport.Receive(ctx)
+	resp, err := c.call(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -55,13 +120,38 @@ func (c *MCPClient) Initialize(ctx context.Context) error {
 	if resp.Error != nil {
 		return fmt.Errorf("mcp init error: %s", resp.Error.Message)
 	}
+	var result struct {
+		Capabilities map[string]json.RawMessage `json:"capabilities"`
+	}
+	if len(resp.Result) > 0 {
+		if err := json.Unmarshal(resp.Result, &result); err != nil {
+			return fmt.Errorf("failed to parse initialize result: %w", err)
+		}
+	}
 
 	// 3. Send initialized notification
 	notif := JSONRPCMessage{
 		JSONRPC: "2.0",
 		Method:  "notifications/initialized",
 	}
-	return c.transport.Send(ctx, notif)
+	if err := c.transport.Send(ctx, notif); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.initialized = true
+	c.capabilities = result.Capabilities
+	c.mu.Unlock()
+	return nil
+}
+
+// HasCapability reports whether the server advertised the named capability
+// (e.g. "logging") during the handshake.
+func (c *MCPClient) HasCapability(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.capabilities[name]
+	return ok
 }
 
 func (c *MCPClient) ListTools(ctx context.Context) ([]agent.Tool, error) {
@@ -70,16 +160,12 @@ func (c *MCPClient) ListTools(ctx context.Context) ([]agent.Tool, error) {
 		Method:  "tools/list",
 		ID:      c.newID(),
 	}
-	
-	if err := c.transport.Send(ctx, req); err != nil {
-		return nil, err
-	}
 
-	resp, err := c.transport.Receive(ctx)
+	resp, err := c.call(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	if resp.Error != nil {
 		return nil, fmt.Errorf("list tools error: %s", resp.Error.Message)
 	}
@@ -91,18 +177,26 @@ func (c *MCPClient) ListTools(ctx context.Context) ([]agent.Tool, error) {
 			InputSchema json.RawMessage `json:"inputSchema"`
 		} `json:"tools"`
 	}
-	
+
 	if err := json.Unmarshal(resp.Result, &result); err != nil {
 		return nil, fmt.Errorf("failed to parse tools list: %w", err)
 	}
 
 	var tools []agent.Tool
 	for _, t := range result.Tools {
+		schema, warnings, err := NormalizeSchema(t.InputSchema)
+		if err != nil {
+			c.log().Warn("skipping MCP tool with unsupported input schema", "tool", t.Name, "error", err)
+			continue
+		}
+		for _, w := range warnings {
+			c.log().Warn("adjusted MCP tool input schema", "tool", t.Name, "change", w)
+		}
 		tools = append(tools, &mcpTool{
 			client: c,
 			name:   t.Name,
 			desc:   t.Description,
-			schema: t.InputSchema,
+			schema: schema,
 		})
 	}
 
@@ -110,59 +204,306 @@ func (c *MCPClient) ListTools(ctx context.Context) ([]agent.Tool, error) {
 }
 
 func (c *MCPClient) CallTool(ctx context.Context, name string, args map[string]interface{}) (interface{}, error) {
-	paramsJSON, _ := json.Marshal(map[string]interface{}{
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	params := map[string]interface{}{
 		"name":      name,
 		"arguments": args,
-	})
+	}
+
+	// Ask for progress notifications when the caller can show them
+	id := c.newID()
+	if report := agent.ProgressReporter(ctx); report != nil {
+		params["_meta"] = map[string]interface{}{"progressToken": *id}
+		c.mu.Lock()
+		c.progress[*id] = report
+		c.mu.Unlock()
+		defer func() {
+			c.mu.Lock()
+			delete(c.progress, *id)
+			c.mu.Unlock()
+		}()
+	}
 
+	paramsJSON, _ := json.Marshal(params)
 	req := JSONRPCMessage{
 		JSONRPC: "2.0",
 		Method:  "tools/call",
 		Params:  paramsJSON,
-		ID:      c.newID(),
+		ID:      id,
 	}
 
-	if err := c.transport.Send(ctx, req); err != nil {
-		return nil, err
+	resp, err := c.call(ctx, req)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, fmt.Errorf("tool %s timed out; the server was asked to cancel it: %w", name, err)
 	}
-
-	resp, err := c.transport.Receive(ctx)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	if resp.Error != nil {
 		return nil, fmt.Errorf("tool call error: %s (data: %v)", resp.Error.Message, resp.Error.Data)
 	}
 
 	// MCP returns { content: [ { type: "text", text: "..." } ], isError: bool }
 	var result struct {
-		Content []struct {
-			Type string `json:"type"`
-			Text string `json:"text"`
-		} `json:"content"`
-		IsError bool `json:"isError"`
+		Content []Content `json:"content"`
+		IsError bool      `json:"isError"`
 	}
-	
+
 	if err := json.Unmarshal(resp.Result, &result); err != nil {
 		return nil, fmt.Errorf("failed to parse tool result: %w. Raw: %s", err, string(resp.Result))
 	}
 
-	var output string
-	for _, c := range result.Content {
-		if c.Type == "text" {
-			output += c.Text
-		}
-	}
-	
+	output, images := convertContent(result.Content)
 	if result.IsError {
 		return nil, fmt.Errorf("tool reported error: %s", output)
 	}
 
-	return output, nil
+	// Keep plain string results for text-only tools
+	if len(images) == 0 {
+		return output, nil
+	}
+	parts := []llm.Part{llm.TextPart{Text: output}}
+	for _, img := range images {
+		parts = append(parts, img)
+	}
+	return parts, nil
+}
+
+// OnNotification registers a handler for notifications with the given
+// method, replacing any previous one.
+func (c *MCPClient) OnNotification(method string, h NotificationHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notifications[method] = h
+}
+
+// HandleRequest registers a handler for server requests with the given
+// method, replacing any previous one.
+func (c *MCPClient) HandleRequest(method string, h RequestHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests[method] = h
+}
+
+// call sends a request and waits for the response with the same ID.
+func (c *MCPClient) call(ctx context.Context, req JSONRPCMessage) (JSONRPCMessage, error) {
+	ch := make(chan JSONRPCMessage, 1)
+	c.mu.Lock()
+	c.pending[*req.ID] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, *req.ID)
+		c.mu.Unlock()
+	}()
+
+	if err := c.transport.Send(ctx, req); err != nil {
+		return JSONRPCMessage{}, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-ctx.Done():
+		if req.Method != "initialize" {
+			go c.cancelRequest(*req.ID, ctx.Err())
+		}
+		return JSONRPCMessage{}, ctx.Err()
+	case <-c.done:
+		return JSONRPCMessage{}, fmt.Errorf("connection closed: %w", c.err)
+	}
+}
+
+// request calls method and decodes the result into result, which may be
+// nil. JSON-RPC errors are returned as *JSONRPCError.
+func (c *MCPClient) request(ctx context.Context, method string, params, result interface{}) error {
+	req := JSONRPCMessage{JSONRPC: "2.0", Method: method, ID: c.newID()}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s params: %w", method, err)
+		}
+		req.Params = data
+	}
+
+	resp, err := c.call(ctx, req)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result == nil || len(resp.Result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("failed to parse %s result: %w", method, err)
+	}
+	return nil
+}
+
+// cancelRequest tells the server to stop working on an abandoned request.
+func (c *MCPClient) cancelRequest(id int64, reason error) {
+	params, _ := json.Marshal(map[string]interface{}{
+		"requestId": id,
+		"reason":    reason.Error(),
+	})
+	ctx, cancel := context.WithTimeout(c.ctx, 5*time.Second)
+	defer cancel()
+	_ = c.transport.Send(ctx, JSONRPCMessage{
+		JSONRPC: "2.0",
+		Method:  "notifications/cancelled",
+		Params:  params,
+	})
+}
+
+// readLoop dispatches incoming messages until the transport fails.
+func (c *MCPClient) readLoop() {
+	defer close(c.done)
+	for {
+		msg, err := c.transport.Receive(c.ctx)
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		switch {
+		case msg.Method != "" && msg.ID != nil:
+			go c.handleRequest(msg)
+		case msg.Method != "":
+			c.mu.Lock()
+			h := c.notifications[msg.Method]
+			c.mu.Unlock()
+			if h != nil {
+				h(msg.Params)
+			}
+		case msg.ID != nil:
+			// Each ID is delivered once; a duplicate or late response
+			// must not block the loop
+			c.mu.Lock()
+			ch := c.pending[*msg.ID]
+			delete(c.pending, *msg.ID)
+			c.mu.Unlock()
+			if ch != nil {
+				select {
+				case ch <- msg:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// handleRequest answers a request sent by the server.
+func (c *MCPClient) handleRequest(req JSONRPCMessage) {
+	resp := JSONRPCMessage{JSONRPC: "2.0", ID: req.ID}
+
+	c.mu.Lock()
+	h := c.requests[req.Method]
+	c.mu.Unlock()
+
+	if h == nil {
+		resp.Error = &JSONRPCError{Code: -32601, Message: "method not found: " + req.Method}
+	} else if result, err := h(c.ctx, req.Params); err != nil {
+		rpcErr, ok := err.(*JSONRPCError)
+		if !ok {
+			rpcErr = &JSONRPCError{Code: -32603, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+	} else if data, err := json.Marshal(result); err != nil {
+		resp.Error = &JSONRPCError{Code: -32603, Message: fmt.Sprintf("failed to marshal result: %v", err)}
+	} else {
+		resp.Result = data
+	}
+
+	_ = c.transport.Send(c.ctx, resp)
+}
+
+// handleProgress forwards a progress notification to the call that
+// requested it.
+func (c *MCPClient) handleProgress(params json.RawMessage) {
+	var p struct {
+		ProgressToken json.RawMessage `json:"progressToken"`
+		Progress      float64         `json:"progress"`
+		Total         float64         `json:"total"`
+		Message       string          `json:"message"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return
+	}
+	var token int64
+	if err := json.Unmarshal(p.ProgressToken, &token); err != nil {
+		return // Not one of ours
+	}
+
+	c.mu.Lock()
+	report := c.progress[token]
+	c.mu.Unlock()
+	if report != nil {
+		report(p.Progress, p.Total, p.Message)
+	}
+}
+
+func (c *MCPClient) listRoots(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	roots := append([]Root{}, c.roots...)
+	return map[string]interface{}{"roots": roots}, nil
+}
+
+// SetRoots sets the workspace roots advertised to the server. If the
+// connection is already initialized, the server is notified that the list
+// changed so it can request it again.
+func (c *MCPClient) SetRoots(ctx context.Context, roots []Root) error {
+	c.mu.Lock()
+	c.roots = append([]Root{}, roots...)
+	initialized := c.initialized
+	c.mu.Unlock()
+
+	if !initialized {
+		return nil
+	}
+	return c.transport.Send(ctx, JSONRPCMessage{
+		JSONRPC: "2.0",
+		Method:  "notifications/roots/list_changed",
+	})
+}
+
+// Ping checks that the server is responsive.
+func (c *MCPClient) Ping(ctx context.Context) error {
+	resp, err := c.call(ctx, JSONRPCMessage{JSONRPC: "2.0", Method: "ping", ID: c.newID()})
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	return nil
+}
+
+// Done is closed when the connection to the server is lost or closed.
+func (c *MCPClient) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection was lost, once Done is closed.
+func (c *MCPClient) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
 }
 
 func (c *MCPClient) Close() error {
+	c.cancel()
 	return c.transport.Close()
 }
 
@@ -176,16 +517,15 @@ type mcpTool struct {
 	client *MCPClient
 	name   string
 	desc   string
-	schema json.RawMessage
+	schema map[string]interface{} // Normalized
 }
 
-func (t *mcpTool) Name() string { return t.name }
+func (t *mcpTool) Name() string        { return t.name }
 func (t *mcpTool) Description() string { return t.desc }
-func (t *mcpTool) Schema() interface{} {
-	var s interface{}
-	_ = json.Unmarshal(t.schema, &s)
-	return s
+func (t *mcpTool) Schema() interface{} { return t.schema }
+func (t *mcpTool) ReadOnly() bool {
+	return t.client.ReadOnlyTools != nil && t.client.ReadOnlyTools(t.name)
 }
 func (t *mcpTool) Execute(ctx context.Context, args map[string]interface{}) (interface{}, error) {
 	return t.client.CallTool(ctx, t.name, args)
-}
\ No newline at end of file
+}
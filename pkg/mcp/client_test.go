@@ -0,0 +1,936 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/llm"
+	"github.com/techmuch/castor/pkg/secrets"
+)
+
+// pipeTransport connects a client to an in-process fake server.
+type pipeTransport struct {
+	toServer chan JSONRPCMessage
+	toClient chan JSONRPCMessage
+}
+
+func newPipeTransport() *pipeTransport {
+	return &pipeTransport{
+		toServer: make(chan JSONRPCMessage, 16),
+		toClient: make(chan JSONRPCMessage, 16),
+	}
+}
+
+func (p *pipeTransport) Send(ctx context.Context, msg JSONRPCMessage) error {
+	p.toServer <- msg
+	return nil
+}
+
+func (p *pipeTransport) Receive(ctx context.Context) (JSONRPCMessage, error) {
+	select {
+	case msg, ok := <-p.toClient:
+		if !ok {
+			return JSONRPCMessage{}, fmt.Errorf("transport closed")
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return JSONRPCMessage{}, ctx.Err()
+	}
+}
+
+func (p *pipeTransport) Close() error { return nil }
+
+func TestRoots(t *testing.T) {
+	ctx := context.Background()
+	p := newPipeTransport()
+	client := NewClient(p)
+
+	root, err := RootFromPath(t.TempDir())
+	if err != nil {
+		t.Fatalf("RootFromPath failed: %v", err)
+	}
+	if err := client.SetRoots(ctx, []Root{root}); err != nil {
+		t.Fatalf("SetRoots failed: %v", err)
+	}
+
+	// Fake server: ask for roots before answering initialize
+	done := make(chan []Root, 1)
+	go func() {
+		req := <-p.toServer
+		serverID := int64(99)
+		p.toClient <- JSONRPCMessage{JSONRPC: "2.0", ID: &serverID, Method: "roots/list"}
+
+		resp := <-p.toServer
+		var result struct {
+			Roots []Root `json:"roots"`
+		}
+		json.Unmarshal(resp.Result, &result)
+		done <- result.Roots
+
+		p.toClient <- JSONRPCMessage{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(`{}`)}
+	}()
+
+	if err := client.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	roots := <-done
+	if len(roots) != 1 || roots[0].URI != root.URI {
+		t.Errorf("Expected roots [%s], got %v", root.URI, roots)
+	}
+
+	if initialized := <-p.toServer; initialized.Method != "notifications/initialized" {
+		t.Errorf("Expected initialized notification, got %q", initialized.Method)
+	}
+
+	// Changing roots after initialization notifies the server
+	if err := client.SetRoots(ctx, nil); err != nil {
+		t.Fatalf("SetRoots failed: %v", err)
+	}
+	if notif := <-p.toServer; notif.Method != "notifications/roots/list_changed" {
+		t.Errorf("Expected list_changed notification, got %q", notif.Method)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	ctx := context.Background()
+	p := newPipeTransport()
+	client := NewClient(p)
+	defer client.Close()
+
+	notified := make(chan string, 1)
+	client.OnNotification("notifications/message", func(params json.RawMessage) {
+		notified <- string(params)
+	})
+
+	// Fake server: collect two calls, then answer them in reverse order
+	// after an unrelated notification.
+	go func() {
+		first := <-p.toServer
+		second := <-p.toServer
+		p.toClient <- JSONRPCMessage{JSONRPC: "2.0", Method: "notifications/message", Params: json.RawMessage(`"hello"`)}
+		for _, req := range []JSONRPCMessage{second, first} {
+			var params struct {
+				Name string `json:"name"`
+			}
+			json.Unmarshal(req.Params, &params)
+			result := fmt.Sprintf(`{"content":[{"type":"text","text":%q}]}`, params.Name)
+			p.toClient <- JSONRPCMessage{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(result)}
+		}
+	}()
+
+	results := make(chan string, 2)
+	errs := make(chan error, 2)
+	for _, name := range []string{"a", "b"} {
+		go func(name string) {
+			out, err := client.CallTool(ctx, name, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if out != name {
+				errs <- fmt.Errorf("call %s got result %v", name, out)
+				return
+			}
+			results <- name
+		}(name)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-results:
+		case err := <-errs:
+			t.Fatal(err)
+		}
+	}
+	if got := <-notified; got != `"hello"` {
+		t.Errorf("Expected notification params \"hello\", got %s", got)
+	}
+
+	// Unknown server requests get a method-not-found error
+	id := int64(7)
+	p.toClient <- JSONRPCMessage{JSONRPC: "2.0", ID: &id, Method: "unknown/method"}
+	resp := <-p.toServer
+	if resp.Error == nil || resp.Error.Code != -32601 || *resp.ID != id {
+		t.Errorf("Expected method-not-found response, got %+v", resp)
+	}
+
+	// Duplicate responses are dropped without stalling later calls
+	go func() {
+		for i := 0; i < 2; i++ {
+			req := <-p.toServer
+			for j := 0; j < 3; j++ {
+				p.toClient <- JSONRPCMessage{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(`{"content":[{"type":"text","text":"dup"}]}`)}
+			}
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		callCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		out, err := client.CallTool(callCtx, "dup", nil)
+		cancel()
+		if err != nil || out != "dup" {
+			t.Fatalf("Call %d after duplicate responses got %v, %v", i, out, err)
+		}
+	}
+}
+
+func TestHTTPTransport(t *testing.T) {
+	var sessions []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessions = append(sessions, r.Header.Get("Mcp-Session-Id"))
+		var req JSONRPCMessage
+		json.NewDecoder(r.Body).Decode(&req)
+
+		switch req.Method {
+		case "initialize":
+			w.Header().Set("Mcp-Session-Id", "s1")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(JSONRPCMessage{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(`{}`)})
+		case "tools/list":
+			// Answer as an event stream, preceded by a notification
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/message\"}\n\n")
+			data, _ := json.Marshal(JSONRPCMessage{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(`{"tools":[{"name":"echo"}]}`)})
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+		default:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	client := NewClient(NewHTTPTransport(srv.URL))
+	defer client.Close()
+
+	if err := client.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		t.Fatalf("ListTools failed: %v", err)
+	}
+	if len(tools) != 1 || tools[0].Name() != "echo" {
+		t.Errorf("Unexpected tools: %v", tools)
+	}
+	if len(sessions) < 3 || sessions[0] != "" || sessions[2] != "s1" {
+		t.Errorf("Expected session ID on requests after initialize, got %v", sessions)
+	}
+}
+
+// echoProvider replies with the text of the last message.
+type echoProvider struct{}
+
+func (echoProvider) GenerateContent(ctx context.Context, history []llm.Message, opts llm.GenerateOptions) (<-chan llm.StreamEvent, error) {
+	ch := make(chan llm.StreamEvent, 1)
+	last := history[len(history)-1].Content[0].(llm.TextPart)
+	ch <- llm.StreamEvent{Delta: "echo: " + last.Text}
+	close(ch)
+	return ch, nil
+}
+
+func (echoProvider) EmbedContent(ctx context.Context, texts []string) ([][]float32, error) {
+	return nil, nil
+}
+
+func TestSampling(t *testing.T) {
+	p := newPipeTransport()
+	client := NewClient(p)
+	defer client.Close()
+
+	allow := true
+	client.EnableSampling(echoProvider{}, "test-model", func(ctx context.Context, req *SamplingRequest) (bool, error) {
+		return allow, nil
+	})
+
+	request := func(id int64) JSONRPCMessage {
+		params := `{"messages":[{"role":"user","content":{"type":"text","text":"ping"}}],"maxTokens":10}`
+		p.toClient <- JSONRPCMessage{JSONRPC: "2.0", ID: &id, Method: "sampling/createMessage", Params: json.RawMessage(params)}
+		return <-p.toServer
+	}
+
+	resp := request(1)
+	var result SamplingResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		t.Fatalf("Failed to parse sampling result %s: %v", resp.Result, err)
+	}
+	if result.Content.Text != "echo: ping" || result.Model != "test-model" || result.Role != "assistant" {
+		t.Errorf("Unexpected sampling result: %+v", result)
+	}
+
+	allow = false
+	if resp := request(2); resp.Error == nil {
+		t.Error("Expected error when the user rejects sampling")
+	}
+}
+
+func TestProgress(t *testing.T) {
+	p := newPipeTransport()
+	client := NewClient(p)
+	defer client.Close()
+
+	go func() {
+		req := <-p.toServer
+		var params struct {
+			Meta struct {
+				ProgressToken json.RawMessage `json:"progressToken"`
+			} `json:"_meta"`
+		}
+		json.Unmarshal(req.Params, &params)
+		progress := fmt.Sprintf(`{"progressToken":%s,"progress":1,"total":2,"message":"halfway"}`, params.Meta.ProgressToken)
+		p.toClient <- JSONRPCMessage{JSONRPC: "2.0", Method: "notifications/progress", Params: json.RawMessage(progress)}
+		p.toClient <- JSONRPCMessage{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(`{"content":[{"type":"text","text":"done"}]}`)}
+	}()
+
+	var updates []string
+	ctx := agent.WithProgress(context.Background(), func(progress, total float64, message string) {
+		updates = append(updates, fmt.Sprintf("%g/%g %s", progress, total, message))
+	})
+	if _, err := client.CallTool(ctx, "build", nil); err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if len(updates) != 1 || updates[0] != "1/2 halfway" {
+		t.Errorf("Unexpected progress updates: %v", updates)
+	}
+}
+
+func TestOAuth(t *testing.T) {
+	var srv *httptest.Server
+	registered := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mcp", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer at1" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer resource_metadata="%s/.well-known/oauth-protected-resource"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req JSONRPCMessage
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.ID == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(JSONRPCMessage{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(`{}`)})
+	})
+	mux.HandleFunc("/.well-known/oauth-protected-resource", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"authorization_servers":["%s/auth"]}`, srv.URL)
+	})
+	mux.HandleFunc("/.well-known/oauth-authorization-server/auth", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"authorization_endpoint":"%[1]s/auth/authorize","token_endpoint":"%[1]s/auth/token","registration_endpoint":"%[1]s/auth/register"}`, srv.URL)
+	})
+	mux.HandleFunc("/auth/register", func(w http.ResponseWriter, r *http.Request) {
+		registered = true
+		fmt.Fprint(w, `{"client_id":"dyn-client"}`)
+	})
+	mux.HandleFunc("/auth/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("client_id") != "dyn-client" || q.Get("code_challenge") == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code=c1&state="+q.Get("state"), http.StatusFound)
+	})
+	mux.HandleFunc("/auth/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "c1" || r.Form.Get("code_verifier") == "" {
+			http.Error(w, "bad grant", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"at1","token_type":"Bearer","expires_in":3600}`)
+	})
+	srv = httptest.NewServer(mux)
+	defer srv.Close()
+
+	store := &secrets.Memory{}
+	transport := NewHTTPTransport(srv.URL + "/mcp")
+	transport.Auth = &OAuth{
+		ServerURL: srv.URL + "/mcp",
+		Store:     store,
+		OpenURL: func(u string) error {
+			// Stand in for the browser: follow the redirect to the callback
+			resp, err := http.Get(u)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			return nil
+		},
+	}
+
+	client := NewClient(transport)
+	defer client.Close()
+	if err := client.Initialize(context.Background()); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	if !registered {
+		t.Error("Expected dynamic client registration")
+	}
+	if saved, err := store.Get("mcp:" + srv.URL + "/mcp"); err != nil || !strings.Contains(saved, "at1") {
+		t.Errorf("Expected token to be stored, got %q (%v)", saved, err)
+	}
+}
+
+// fakeServer answers initialize, tools, prompts, and completion requests
+// on p until crash is closed, at which point the connection drops.
+func fakeServer(p *pipeTransport, crash <-chan struct{}) {
+	for {
+		var req JSONRPCMessage
+		select {
+		case req = <-p.toServer:
+		case <-crash:
+			close(p.toClient)
+			return
+		}
+		if req.ID == nil {
+			continue
+		}
+		result := `{}`
+		switch req.Method {
+		case "tools/list":
+			result = `{"tools":[{"name":"echo","description":"Echo","inputSchema":{"type":"object"}}]}`
+		case "tools/call":
+			result = `{"content":[{"type":"text","text":"ok"}]}`
+		case "prompts/list":
+			result = `{"prompts":[{"name":"review","arguments":[{"name":"lang","required":true}]}]}`
+		case "prompts/get":
+			result = `{"messages":[{"role":"user","content":{"type":"text","text":"Review this code."}}]}`
+		case "completion/complete":
+			var params struct {
+				Argument struct{ Value string } `json:"argument"`
+			}
+			json.Unmarshal(req.Params, &params)
+			var values []string
+			for _, v := range []string{"go", "golang", "python"} {
+				if strings.HasPrefix(v, params.Argument.Value) {
+					values = append(values, v)
+				}
+			}
+			data, _ := json.Marshal(map[string]interface{}{"completion": map[string]interface{}{"values": values}})
+			result = string(data)
+		}
+		p.toClient <- JSONRPCMessage{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(result)}
+	}
+}
+
+func TestConnRestart(t *testing.T) {
+	ctx := context.Background()
+	var crashes []chan struct{}
+	conn := &Conn{
+		Name: "fake",
+		Dial: func(ctx context.Context) (Transport, error) {
+			p := newPipeTransport()
+			crash := make(chan struct{})
+			crashes = append(crashes, crash)
+			go fakeServer(p, crash)
+			return p, nil
+		},
+	}
+	if err := conn.Connect(ctx); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	tools := conn.Tools()
+	if len(tools) != 1 || tools[0].Description() != "Echo" {
+		t.Fatalf("Unexpected tools: %v", tools)
+	}
+
+	// Kill the server; the next call runs against a restarted one
+	conn.mu.Lock()
+	client := conn.client
+	conn.mu.Unlock()
+	close(crashes[0])
+	<-client.Done()
+
+	res, err := tools[0].Execute(ctx, nil)
+	if err != nil || res != "ok" {
+		t.Fatalf("Expected call to succeed after restart, got %v, %v", res, err)
+	}
+	conn.mu.Lock()
+	dials := len(crashes)
+	conn.mu.Unlock()
+	if dials != 2 {
+		t.Errorf("Expected exactly one restart, got %d dials", dials)
+	}
+}
+
+func TestConnRestartDoesNotBlock(t *testing.T) {
+	ctx := context.Background()
+	crash := make(chan struct{})
+	dialing := make(chan struct{})
+	first := true
+	conn := &Conn{
+		Name: "fake",
+		Dial: func(ctx context.Context) (Transport, error) {
+			if first {
+				first = false
+				p := newPipeTransport()
+				go fakeServer(p, crash)
+				return p, nil
+			}
+			// The restarted server hangs until the attempt is canceled
+			close(dialing)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	if err := conn.Connect(ctx); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	tools := conn.Tools()
+	close(crash)
+	<-dialing
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if tools[0].Description() != "Echo" {
+			t.Errorf("Unexpected description %q", tools[0].Description())
+		}
+		conn.Close()
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Description and Close blocked on a restart in progress")
+	}
+
+	if _, err := tools[0].Execute(ctx, nil); err == nil {
+		t.Error("Expected call to fail after Close")
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	p := newPipeTransport()
+	client := NewClient(p)
+	client.Timeout = 50 * time.Millisecond
+	defer client.Close()
+
+	start := time.Now()
+	_, err := client.CallTool(context.Background(), "hang", nil)
+	if err == nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("CallTool took %v to time out", elapsed)
+	}
+
+	req := <-p.toServer
+	notif := <-p.toServer
+	var params struct {
+		RequestID int64 `json:"requestId"`
+	}
+	json.Unmarshal(notif.Params, &params)
+	if notif.Method != "notifications/cancelled" || params.RequestID != *req.ID {
+		t.Errorf("Expected cancellation of request %d, got %s %s", *req.ID, notif.Method, notif.Params)
+	}
+}
+
+func TestListToolsReadOnly(t *testing.T) {
+	ctx := context.Background()
+	p := newPipeTransport()
+	client := NewClient(p)
+	defer client.Close()
+
+	go func() {
+		req := <-p.toServer
+		result := `{"tools":[
+			{"name":"get_issue","inputSchema":{"type":"object"},"annotations":{"readOnlyHint":true}},
+			{"name":"close_issue","inputSchema":{"type":"object"}}
+		]}`
+		p.toClient <- JSONRPCMessage{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(result)}
+	}()
+
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		t.Fatalf("ListTools failed: %v", err)
+	}
+
+	// The server's hint alone does not make a tool read-only; only the
+	// user's list does
+	for _, trusted := range []string{"", "close_issue"} {
+		client.ReadOnlyTools = func(name string) bool { return name == trusted }
+		for _, tool := range tools {
+			ro, ok := tool.(agent.ReadOnly)
+			if !ok {
+				t.Fatalf("%s does not implement agent.ReadOnly", tool.Name())
+			}
+			if got, want := ro.ReadOnly(), tool.Name() == trusted; got != want {
+				t.Errorf("%s trusting %q: ReadOnly() = %v, want %v", tool.Name(), trusted, got, want)
+			}
+		}
+	}
+}
+
+func TestToolContent(t *testing.T) {
+	ctx := context.Background()
+	p := newPipeTransport()
+	client := NewClient(p)
+	defer client.Close()
+
+	go func() {
+		req := <-p.toServer
+		result := `{"content":[
+			{"type":"text","text":"Captured."},
+			{"type":"image","data":"iVBORw0K","mimeType":"image/png"},
+			{"type":"resource","resource":{"uri":"db://export.csv","mimeType":"text/csv","text":"id,name\n1,a"}},
+			{"type":"resource","resource":{"uri":"db://dump.bin","blob":"AAEC"}}
+		]}`
+		p.toClient <- JSONRPCMessage{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(result)}
+	}()
+
+	out, err := client.CallTool(ctx, "screenshot", nil)
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	parts, ok := out.([]llm.Part)
+	if !ok || len(parts) != 2 {
+		t.Fatalf("Expected text and image parts, got %#v", out)
+	}
+
+	text := parts[0].(llm.TextPart).Text
+	for _, want := range []string{"Captured.", "[Image 1 attached (image/png)]", "[Resource db://export.csv]\nid,name\n1,a", "db://dump.bin (application/octet-stream"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected result text to contain %q, got:\n%s", want, text)
+		}
+	}
+
+	img, ok := parts[1].(llm.ImagePart)
+	if !ok || img.MimeType != "image/png" || string(img.Data[:4]) != "\x89PNG" {
+		t.Errorf("Unexpected image part: %#v", parts[1])
+	}
+}
+
+func TestLogging(t *testing.T) {
+	ctx := context.Background()
+	p := newPipeTransport()
+	client := NewClient(p)
+	defer client.Close()
+
+	logged := make(chan string, 1)
+	var buf strings.Builder
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client.SetLogger(logger.With("mcp_server", "test"))
+
+	levels := make(chan string, 1)
+	go func() {
+		init := <-p.toServer
+		p.toClient <- JSONRPCMessage{JSONRPC: "2.0", ID: init.ID, Result: json.RawMessage(`{"capabilities":{"logging":{}}}`)}
+		<-p.toServer // notifications/initialized
+
+		req := <-p.toServer
+		var params struct {
+			Level string `json:"level"`
+		}
+		json.Unmarshal(req.Params, &params)
+		levels <- req.Method + " " + params.Level
+		p.toClient <- JSONRPCMessage{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(`{}`)}
+
+		p.toClient <- JSONRPCMessage{JSONRPC: "2.0", Method: "notifications/message", Params: json.RawMessage(`{"level":"warning","logger":"db","data":"pool exhausted"}`)}
+		serverID := int64(7)
+		p.toClient <- JSONRPCMessage{JSONRPC: "2.0", ID: &serverID, Method: "ping"}
+		<-p.toServer
+		logged <- buf.String()
+	}()
+
+	if err := client.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	if !client.HasCapability("logging") || client.HasCapability("sampling") {
+		t.Errorf("Capabilities not recorded from the initialize result")
+	}
+	if err := client.SetLogLevel(ctx, "verbose"); err == nil {
+		t.Errorf("Expected an invalid level to be rejected")
+	}
+	if err := client.SetLogLevel(ctx, "debug"); err != nil {
+		t.Fatalf("SetLogLevel failed: %v", err)
+	}
+	if got := <-levels; got != "logging/setLevel debug" {
+		t.Errorf("Expected logging/setLevel debug, got %q", got)
+	}
+
+	// The ping reply is sent after the log message was handled
+	out := <-logged
+	for _, want := range []string{"level=WARN", `msg="pool exhausted"`, "mcp_server=test", "logger=db"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected log to contain %s, got %q", want, out)
+		}
+	}
+}
+
+func TestNormalizeSchema(t *testing.T) {
+	t.Run("InlinesRefsAndFlattensNullable", func(t *testing.T) {
+		raw := `{
+			"$schema": "http://json-schema.org/draft-07/schema#",
+			"type": "object",
+			"properties": {
+				"item": {"$ref": "#/$defs/Item", "description": "The item"},
+				"note": {"type": ["string", "null"]},
+				"mode": {"oneOf": [{"const": "fast"}, {"type": "null"}]}
+			},
+			"$defs": {"Item": {"type": "object", "properties": {"id": {"type": "integer"}}}}
+		}`
+		schema, warnings, err := NormalizeSchema(json.RawMessage(raw))
+		if err != nil {
+			t.Fatalf("NormalizeSchema failed: %v", err)
+		}
+		if len(warnings) != 0 {
+			t.Errorf("Expected no warnings for lossless changes, got %v", warnings)
+		}
+		got, _ := json.Marshal(schema)
+		want := `{"properties":{"item":{"description":"The item","properties":{"id":{"type":"integer"}},"type":"object"},"mode":{"enum":["fast"]},"note":{"type":"string"}},"type":"object"}`
+		if string(got) != want {
+			t.Errorf("Unexpected schema:\n got %s\nwant %s", got, want)
+		}
+	})
+
+	t.Run("WarnsOnLossyChanges", func(t *testing.T) {
+		raw := `{
+			"properties": {
+				"target": {"oneOf": [{"type": "string"}, {"type": "integer"}]},
+				"config": {"type": "object", "if": {"required": ["a"]}, "then": {"required": ["b"]}}
+			},
+			"allOf": [{"required": ["target"]}, {"required": ["config"]}]
+		}`
+		schema, warnings, err := NormalizeSchema(json.RawMessage(raw))
+		if err != nil {
+			t.Fatalf("NormalizeSchema failed: %v", err)
+		}
+		if len(warnings) != 3 {
+			t.Errorf("Expected 3 warnings, got %v", warnings)
+		}
+		target := schema["properties"].(map[string]interface{})["target"].(map[string]interface{})
+		if _, ok := target["anyOf"]; !ok {
+			t.Errorf("Expected oneOf to become anyOf, got %v", target)
+		}
+		if required := schema["required"].([]interface{}); len(required) != 2 {
+			t.Errorf("Expected allOf required lists to merge, got %v", required)
+		}
+	})
+
+	t.Run("Rejects", func(t *testing.T) {
+		for name, raw := range map[string]string{
+			"recursive":  `{"type":"object","properties":{"node":{"$ref":"#/$defs/Node"}},"$defs":{"Node":{"type":"object","properties":{"next":{"$ref":"#/$defs/Node"}}}}}`,
+			"external":   `{"type":"object","properties":{"x":{"$ref":"https://example.com/x.json"}}}`,
+			"missing":    `{"type":"object","properties":{"x":{"$ref":"#/$defs/Nope"}}}`,
+			"non-object": `{"type":"string"}`,
+		} {
+			if _, _, err := NormalizeSchema(json.RawMessage(raw)); err == nil {
+				t.Errorf("Expected %s schema to be rejected", name)
+			}
+		}
+	})
+}
+
+func TestPrompts(t *testing.T) {
+	ctx := context.Background()
+	conn := &Conn{
+		Name: "fake",
+		Dial: func(ctx context.Context) (Transport, error) {
+			p := newPipeTransport()
+			go fakeServer(p, make(chan struct{}))
+			return p, nil
+		},
+	}
+	if err := conn.Connect(ctx); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	prompts, err := conn.ListPrompts(ctx)
+	if err != nil || len(prompts) != 1 || prompts[0].Arguments[0].Name != "lang" {
+		t.Fatalf("Unexpected prompts: %v, %v", prompts, err)
+	}
+
+	completion, err := conn.Complete(ctx, PromptRef("review"), "lang", "go")
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if !reflect.DeepEqual(completion.Values, []string{"go", "golang"}) {
+		t.Errorf("Unexpected completions: %v", completion.Values)
+	}
+
+	messages, err := conn.GetPrompt(ctx, "review", map[string]string{"lang": "go"})
+	if err != nil {
+		t.Fatalf("GetPrompt failed: %v", err)
+	}
+	if got := PromptText(messages); got != "Review this code." {
+		t.Errorf("Unexpected prompt text: %q", got)
+	}
+}
+
+func TestStdioClose(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("needs /proc to inspect processes")
+	}
+
+	// The server starts a helper, reports its PID, and exits once its
+	// input is closed, leaving the helper behind.
+	script := `sleep 60 & echo "{\"jsonrpc\":\"2.0\",\"method\":\"pid\",\"params\":$!}"; cat >/dev/null`
+	transport, err := NewStdioTransport("sh", []string{"-c", script}, StdioOptions{})
+	if err != nil {
+		t.Fatalf("NewStdioTransport failed: %v", err)
+	}
+	msg, err := transport.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	var pid int
+	json.Unmarshal(msg.Params, &pid)
+
+	start := time.Now()
+	transport.Close()
+	if elapsed := time.Since(start); elapsed > DefaultShutdownTimeout {
+		t.Errorf("Expected a prompt exit after closing input, took %v", elapsed)
+	}
+
+	// The helper is killed with the group; wait for it to be reaped
+	deadline := time.Now().Add(2 * time.Second)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Helper process %d outlived the server", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// processAlive reports whether pid is running and not a zombie.
+func processAlive(pid int) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(data[bytes.LastIndexByte(data, ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestStdioMessageSize(t *testing.T) {
+	// One 200KB message, well past bufio.Scanner's default 64KB limit
+	script := `printf '{"jsonrpc":"2.0","method":"big","params":"'; head -c 200000 /dev/zero | tr '\0' a; printf '"}\n'; cat >/dev/null`
+
+	transport, err := NewStdioTransport("sh", []string{"-c", script}, StdioOptions{})
+	if err != nil {
+		t.Fatalf("NewStdioTransport failed: %v", err)
+	}
+	msg, err := transport.Receive(context.Background())
+	transport.Close()
+	if err != nil || msg.Method != "big" || len(msg.Params) != 200002 {
+		t.Fatalf("Expected the large message, got %d bytes, %v", len(msg.Params), err)
+	}
+
+	transport, err = NewStdioTransport("sh", []string{"-c", script}, StdioOptions{MaxMessageSize: 1024})
+	if err != nil {
+		t.Fatalf("NewStdioTransport failed: %v", err)
+	}
+	defer transport.Close()
+	_, err = transport.Receive(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "exceeds the 1024 byte limit") {
+		t.Errorf("Expected a size limit error, got %v", err)
+	}
+}
+
+func TestStartupTimeout(t *testing.T) {
+	conn := &Conn{
+		Name:           "silent",
+		StartupTimeout: 50 * time.Millisecond,
+		Dial: func(ctx context.Context) (Transport, error) {
+			return newPipeTransport(), nil // Never answers
+		},
+	}
+	err := conn.Connect(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "not ready within 50ms") {
+		t.Fatalf("Expected a startup timeout, got %v", err)
+	}
+}
+
+func TestWebSocketTransport(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	var sessions []*websocket.Conn
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		ws, err := websocket.Accept(w, r, &websocket.AcceptOptions{Subprotocols: []string{"mcp"}})
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		sessions = append(sessions, ws)
+		mu.Unlock()
+
+		p := newPipeTransport()
+		go fakeServer(p, make(chan struct{}))
+		go func() {
+			for msg := range p.toClient {
+				data, _ := json.Marshal(msg)
+				ws.Write(context.Background(), websocket.MessageText, data)
+			}
+		}()
+		for {
+			_, data, err := ws.Read(context.Background())
+			if err != nil {
+				return
+			}
+			var msg JSONRPCMessage
+			json.Unmarshal(data, &msg)
+			p.toServer <- msg
+		}
+	}))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	if _, err := DialWebSocket(ctx, url, WebSocketOptions{}); err == nil {
+		t.Fatal("Expected the handshake to fail without credentials")
+	}
+
+	conn := &Conn{
+		Name: "ws",
+		Dial: func(ctx context.Context) (Transport, error) {
+			return DialWebSocket(ctx, url, WebSocketOptions{Auth: BearerToken("secret"), KeepAlive: 50 * time.Millisecond})
+		},
+	}
+	if err := conn.Connect(ctx); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer conn.Close()
+	tools := conn.Tools()
+	if res, err := tools[0].Execute(ctx, nil); err != nil || res != "ok" {
+		t.Fatalf("Unexpected result %v, %v", res, err)
+	}
+
+	// Keepalive pings run alongside; then the server drops the connection
+	// and the next call reconnects.
+	time.Sleep(150 * time.Millisecond)
+	conn.mu.Lock()
+	client := conn.client
+	conn.mu.Unlock()
+	mu.Lock()
+	sessions[0].Close(websocket.StatusGoingAway, "restarting")
+	mu.Unlock()
+	<-client.Done()
+
+	if res, err := tools[0].Execute(ctx, nil); err != nil || res != "ok" {
+		t.Fatalf("Expected call to succeed after reconnecting, got %v, %v", res, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sessions) != 2 {
+		t.Errorf("Expected one reconnect, got %d sessions", len(sessions))
+	}
+}
@@ -0,0 +1,375 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/techmuch/castor/pkg/agent"
+)
+
+// DefaultStartupTimeout is the startup timeout suggested for local
+// servers.
+const DefaultStartupTimeout = 30 * time.Second
+
+// DefaultMaxRestarts is how many consecutive times Conn tries to restart a
+// failed server before giving up.
+const DefaultMaxRestarts = 3
+
+// Dialer opens a fresh transport to a server, starting its process if
+// needed.
+type Dialer func(ctx context.Context) (Transport, error)
+
+// Conn is a supervised connection to one MCP server. It restarts the
+// server and repeats the handshake when the transport dies or stops
+// answering pings, and its tools stay valid across restarts.
+type Conn struct {
+	Name string
+	Dial Dialer
+
+	// Setup, if set, configures each new client before the handshake.
+	Setup func(*MCPClient)
+
+	// Timeout bounds each tool call; see MCPClient.Timeout.
+	Timeout time.Duration
+
+	// StartupTimeout bounds starting the server, the handshake, and
+	// listing its tools. Zero means no limit, which suits remote servers
+	// whose sign-in may wait on the user.
+	StartupTimeout time.Duration
+
+	// MaxRestarts bounds consecutive restart attempts. Zero uses
+	// DefaultMaxRestarts.
+	MaxRestarts int
+
+	// LogLevel, if set, is requested with logging/setLevel after each
+	// handshake from servers that support logging. Route the messages
+	// with MCPClient.SetLogger in Setup.
+	LogLevel string
+
+	// HealthInterval is how often the server is pinged. Zero disables
+	// pings; a dead transport is still detected immediately.
+	HealthInterval time.Duration
+
+	mu     sync.Mutex // Guards the fields below; never held while dialing
+	client *MCPClient
+	tools  map[string]*mcpTool
+	failed error // Set once restarts are exhausted
+	closed bool
+	stop   context.Context // Canceled by Close
+	cancel context.CancelFunc
+
+	restartMu sync.Mutex // Serializes connecting and restarting
+	backoff   func(attempt int) time.Duration
+}
+
+// Connect starts the server, performs the handshake, lists its tools, and
+// begins supervising the connection.
+func (c *Conn) Connect(ctx context.Context) error {
+	c.restartMu.Lock()
+	defer c.restartMu.Unlock()
+
+	client, tools, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		client.Close()
+		return fmt.Errorf("MCP server %s is closed", c.Name)
+	}
+	c.client, c.tools, c.failed = client, tools, nil
+	c.stop, c.cancel = context.WithCancel(context.Background())
+	go c.supervise(c.stop)
+	return nil
+}
+
+// connect starts a freshly initialized client, explaining startup
+// timeouts.
+func (c *Conn) connect(ctx context.Context) (*MCPClient, map[string]*mcpTool, error) {
+	client, tools, err := c.start(ctx)
+	if err != nil && c.StartupTimeout > 0 && errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return nil, nil, fmt.Errorf("not ready within %v: %w", c.StartupTimeout, err)
+	}
+	return client, tools, err
+}
+
+// start dials the server and completes the handshake within
+// StartupTimeout.
+func (c *Conn) start(ctx context.Context) (*MCPClient, map[string]*mcpTool, error) {
+	if c.StartupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.StartupTimeout)
+		defer cancel()
+	}
+
+	transport, err := c.Dial(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start: %w", err)
+	}
+	client := NewClient(transport)
+	client.Timeout = c.Timeout
+	if c.Setup != nil {
+		c.Setup(client)
+	}
+	if err := client.Initialize(ctx); err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("failed to initialize: %w", err)
+	}
+	if c.LogLevel != "" && client.HasCapability("logging") {
+		_ = client.SetLogLevel(ctx, c.LogLevel) // Logging is best effort
+	}
+
+	list, err := client.ListTools(ctx)
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("failed to list tools: %w", err)
+	}
+	tools := make(map[string]*mcpTool)
+	for _, t := range list {
+		if mt, ok := t.(*mcpTool); ok {
+			tools[mt.name] = mt
+		}
+	}
+	return client, tools, nil
+}
+
+// restart replaces dead with a new client, with bounded retries and
+// backoff. If another caller already replaced dead, its client is
+// returned. Only restartMu is held while waiting and dialing, so tool
+// listing and Close are not blocked, and Close cancels the attempt.
+func (c *Conn) restart(ctx context.Context, dead *MCPClient) (*MCPClient, error) {
+	c.restartMu.Lock()
+	defer c.restartMu.Unlock()
+
+	c.mu.Lock()
+	switch {
+	case c.closed:
+		c.mu.Unlock()
+		return nil, fmt.Errorf("MCP server %s is closed", c.Name)
+	case c.failed != nil:
+		c.mu.Unlock()
+		return nil, c.failed
+	case c.client != dead:
+		client := c.client
+		c.mu.Unlock()
+		return client, nil
+	}
+	c.client = nil
+	stop := c.stop
+	c.mu.Unlock()
+	if dead != nil {
+		dead.Close()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if stop != nil {
+		defer context.AfterFunc(stop, cancel)()
+	}
+
+	maxRestarts := c.MaxRestarts
+	if maxRestarts <= 0 {
+		maxRestarts = DefaultMaxRestarts
+	}
+	var err error
+	for attempt := 0; attempt < maxRestarts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(c.delay(attempt)):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		client, tools, cerr := c.connect(ctx)
+		if err = cerr; err != nil {
+			continue
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.closed {
+			client.Close()
+			return nil, fmt.Errorf("MCP server %s is closed", c.Name)
+		}
+		c.client, c.tools, c.failed = client, tools, nil
+		return client, nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failed = fmt.Errorf("MCP server %s is down after %d restart attempts: %w", c.Name, maxRestarts, err)
+	return nil, c.failed
+}
+
+func (c *Conn) delay(attempt int) time.Duration {
+	if c.backoff != nil {
+		return c.backoff(attempt)
+	}
+	return time.Duration(1<<attempt) * 250 * time.Millisecond
+}
+
+// supervise restarts the server when its transport dies or it stops
+// responding to pings, until stop is canceled.
+func (c *Conn) supervise(stop context.Context) {
+	var tick <-chan time.Time
+	if c.HealthInterval > 0 {
+		ticker := time.NewTicker(c.HealthInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		c.mu.Lock()
+		client := c.client
+		c.mu.Unlock()
+
+		var done <-chan struct{}
+		if client != nil {
+			done = client.Done()
+		}
+
+		select {
+		case <-stop.Done():
+			return
+		case <-done:
+		case <-tick:
+			if client == nil {
+				break
+			}
+			ctx, cancel := context.WithTimeout(stop, c.HealthInterval)
+			err := client.Ping(ctx)
+			cancel()
+			if err == nil {
+				continue
+			}
+		}
+
+		if client != nil {
+			c.restart(stop, client)
+		}
+	}
+}
+
+// current returns a live client, restarting the server if it has died.
+func (c *Conn) current(ctx context.Context) (*MCPClient, error) {
+	c.mu.Lock()
+	client, closed := c.client, c.closed
+	c.mu.Unlock()
+
+	if closed {
+		return nil, fmt.Errorf("MCP server %s is closed", c.Name)
+	}
+	if client != nil && client.Err() == nil {
+		return client, nil
+	}
+	return c.restart(ctx, client)
+}
+
+// CallTool calls a tool on the current server process. A call that is cut
+// off by a crash is not retried, since it may already have had effects;
+// the server is restarted for the next call.
+func (c *Conn) CallTool(ctx context.Context, name string, args map[string]interface{}) (interface{}, error) {
+	client, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.CallTool(ctx, name, args)
+	if err != nil && client.Err() != nil {
+		return nil, fmt.Errorf("MCP server %s stopped during the call and will be restarted: %w", c.Name, err)
+	}
+	return res, err
+}
+
+// Tools returns the server's tools. They call through the Conn, so they
+// keep working after a restart.
+func (c *Conn) Tools() []agent.Tool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var names []string
+	for name := range c.tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tools := make([]agent.Tool, len(names))
+	for i, name := range names {
+		tools[i] = &connTool{conn: c, name: name}
+	}
+	return tools
+}
+
+// Close stops supervision, cancels any restart in progress, and shuts the
+// server down.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	if c.cancel != nil {
+		c.cancel()
+	}
+	client := c.client
+	c.client = nil
+	c.mu.Unlock()
+
+	if client != nil {
+		return client.Close()
+	}
+	return nil
+}
+
+// connTool is a tool of a supervised server. Its description and schema
+// follow the server across restarts.
+type connTool struct {
+	conn *Conn
+	name string
+}
+
+func (t *connTool) tool() *mcpTool {
+	t.conn.mu.Lock()
+	defer t.conn.mu.Unlock()
+	return t.conn.tools[t.name]
+}
+
+func (t *connTool) Name() string { return t.name }
+
+func (t *connTool) Description() string {
+	if mt := t.tool(); mt != nil {
+		return mt.Description()
+	}
+	return ""
+}
+
+func (t *connTool) Schema() interface{} {
+	if mt := t.tool(); mt != nil {
+		return mt.Schema()
+	}
+	return map[string]interface{}{"type": "object"}
+}
+
+func (t *connTool) ReadOnly() bool {
+	mt := t.tool()
+	return mt != nil && mt.ReadOnly()
+}
+
+func (t *connTool) Execute(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if _, err := t.conn.current(ctx); err != nil {
+		return nil, err
+	}
+	if t.tool() == nil {
+		return nil, fmt.Errorf("tool %s is no longer provided by MCP server %s", t.name, t.conn.Name)
+	}
+	return t.conn.CallTool(ctx, t.name, args)
+}
@@ -0,0 +1,115 @@
+package mcp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// Content is one block of a tool result: text, image, audio, an embedded
+// resource, or a link to a resource.
+type Content struct {
+	Type        string    `json:"type"`
+	Text        string    `json:"text,omitempty"`
+	Data        string    `json:"data,omitempty"` // Base64, for image and audio
+	MimeType    string    `json:"mimeType,omitempty"`
+	Resource    *Resource `json:"resource,omitempty"`
+	URI         string    `json:"uri,omitempty"` // For resource_link
+	Name        string    `json:"name,omitempty"`
+	Description string    `json:"description,omitempty"`
+}
+
+// Resource is the contents of an embedded resource. Exactly one of Text
+// or Blob (base64) is set.
+type Resource struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"`
+}
+
+// convertContent flattens tool result content into text the model can read
+// and the images to attach alongside it. Text resources are attached under
+// a header naming their URI; content the model cannot use is summarized.
+func convertContent(content []Content) (string, []llm.ImagePart) {
+	var out strings.Builder
+	var images []llm.ImagePart
+	attached := false // Separate text that follows an attachment
+	attach := func(format string, args ...interface{}) {
+		if out.Len() > 0 {
+			out.WriteString("\n\n")
+		}
+		fmt.Fprintf(&out, format, args...)
+		attached = true
+	}
+
+	for _, c := range content {
+		switch c.Type {
+		case "text":
+			if attached {
+				out.WriteString("\n\n")
+				attached = false
+			}
+			out.WriteString(c.Text)
+		case "image":
+			img, err := decodeImage(c.MimeType, c.Data)
+			if err != nil {
+				attach("[Image omitted: %v]", err)
+				continue
+			}
+			images = append(images, img)
+			attach("[Image %d attached (%s)]", len(images), c.MimeType)
+		case "audio":
+			attach("[Audio content (%s) omitted]", c.MimeType)
+		case "resource":
+			r := c.Resource
+			if r == nil {
+				continue
+			}
+			switch {
+			case r.Blob == "":
+				attach("[Resource %s]\n%s", r.URI, r.Text)
+			case strings.HasPrefix(r.MimeType, "image/"):
+				img, err := decodeImage(r.MimeType, r.Blob)
+				if err != nil {
+					attach("[Resource %s omitted: %v]", r.URI, err)
+					continue
+				}
+				images = append(images, img)
+				attach("[Resource %s attached as image %d (%s)]", r.URI, len(images), r.MimeType)
+			default:
+				size := base64.StdEncoding.DecodedLen(len(r.Blob))
+				attach("[Resource %s (%s, about %d bytes) omitted: binary content]", r.URI, mimeOrUnknown(r.MimeType), size)
+			}
+		case "resource_link":
+			link := c.URI
+			if c.Name != "" {
+				link = fmt.Sprintf("%s (%s)", c.Name, c.URI)
+			}
+			if c.Description != "" {
+				link += ": " + c.Description
+			}
+			attach("[Resource link: %s]", link)
+		default:
+			attach("[Unsupported %q content omitted]", c.Type)
+		}
+	}
+	return out.String(), images
+}
+
+func decodeImage(mimeType, data string) (llm.ImagePart, error) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return llm.ImagePart{}, fmt.Errorf("invalid base64 data: %w", err)
+	}
+	return llm.ImagePart{MimeType: mimeOrUnknown(mimeType), Data: raw}, nil
+}
+
+func mimeOrUnknown(mimeType string) string {
+	if mimeType == "" {
+		return "application/octet-stream"
+	}
+	return mimeType
+}
@@ -0,0 +1,240 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// HTTPTransport implements Transport using the MCP Streamable HTTP
+// protocol: each message is POSTed to the server URL, which answers with
+// either a JSON body or an event stream of messages.
+type HTTPTransport struct {
+	url    string
+	client *http.Client
+
+	// Header is added to every request, e.g. for API keys.
+	Header http.Header
+	// Auth, if set, authorizes requests and handles 401 responses.
+	Auth Authorizer
+	// MaxMessageSize caps one incoming message. Zero uses
+	// DefaultMaxMessageSize.
+	MaxMessageSize int
+
+	mu        sync.Mutex
+	sessionID string
+
+	incoming chan JSONRPCMessage
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// NewHTTPTransport returns a transport for the MCP server at url.
+func NewHTTPTransport(url string) *HTTPTransport {
+	return &HTTPTransport{
+		url:      url,
+		client:   http.DefaultClient,
+		incoming: make(chan JSONRPCMessage, 64),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (t *HTTPTransport) Send(ctx context.Context, msg JSONRPCMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+
+	resp, err := t.post(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	if id := resp.Header.Get("Mcp-Session-Id"); id != "" {
+		t.mu.Lock()
+		t.sessionID = id
+		t.mu.Unlock()
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if resp.StatusCode == http.StatusAccepted || resp.ContentLength == 0 {
+		resp.Body.Close()
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "text/event-stream" {
+		// The stream may stay open for server requests and notifications
+		// related to this message, so read it in the background.
+		go t.readEvents(resp.Body)
+		return nil
+	}
+
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(t.maxSize())+1))
+	if err != nil {
+		return fmt.Errorf("read error: %w", err)
+	}
+	if len(body) > t.maxSize() {
+		return fmt.Errorf("response exceeds the %d byte limit (raise maxMessageSize to allow it)", t.maxSize())
+	}
+	return t.deliver(body)
+}
+
+// post sends one message, retrying once if the authorizer obtained new
+// credentials after a 401.
+func (t *HTTPTransport) post(ctx context.Context, data []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := t.newRequest(ctx, http.MethodPost, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json, text/event-stream")
+
+		resp, err := t.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("request failed: %w", err)
+		}
+		if resp.StatusCode != http.StatusUnauthorized || t.Auth == nil || attempt > 0 {
+			return resp, nil
+		}
+
+		retry, err := t.Auth.Unauthorized(ctx, resp)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if !retry {
+			return nil, fmt.Errorf("server returned %s", resp.Status)
+		}
+	}
+}
+
+// newRequest builds a request carrying the session, headers, and
+// credentials.
+func (t *HTTPTransport) newRequest(ctx context.Context, method string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, t.url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range t.Header {
+		req.Header[k] = v
+	}
+	t.mu.Lock()
+	if t.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", t.sessionID)
+	}
+	t.mu.Unlock()
+	if t.Auth != nil {
+		if err := t.Auth.Authorize(ctx, req); err != nil {
+			return nil, fmt.Errorf("failed to authorize request: %w", err)
+		}
+	}
+	return req, nil
+}
+
+// readEvents forwards the messages in a server-sent event stream.
+func (t *HTTPTransport) readEvents(body io.ReadCloser) {
+	defer body.Close()
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), t.maxSize())
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if data.Len() > 0 {
+				_ = t.deliver(data.Bytes())
+				data.Reset()
+			}
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if data.Len() > 0 {
+		_ = t.deliver(data.Bytes())
+	}
+}
+
+func (t *HTTPTransport) maxSize() int {
+	if t.MaxMessageSize > 0 {
+		return t.MaxMessageSize
+	}
+	return DefaultMaxMessageSize
+}
+
+// deliver queues a JSON-RPC message or batch for Receive.
+func (t *HTTPTransport) deliver(data []byte) error {
+	data = bytes.TrimSpace(data)
+	var msgs []JSONRPCMessage
+	if bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &msgs); err != nil {
+			return fmt.Errorf("unmarshal error: %w", err)
+		}
+	} else {
+		var msg JSONRPCMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return fmt.Errorf("unmarshal error: %w", err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	for _, msg := range msgs {
+		select {
+		case t.incoming <- msg:
+		case <-t.closed:
+			return io.EOF
+		}
+	}
+	return nil
+}
+
+func (t *HTTPTransport) Receive(ctx context.Context) (JSONRPCMessage, error) {
+	select {
+	case msg := <-t.incoming:
+		return msg, nil
+	case <-t.closed:
+		return JSONRPCMessage{}, io.EOF
+	case <-ctx.Done():
+		return JSONRPCMessage{}, ctx.Err()
+	}
+}
+
+// Close ends the session. Servers that track sessions are told to release
+// it with a DELETE request.
+func (t *HTTPTransport) Close() error {
+	t.once.Do(func() {
+		close(t.closed)
+
+		t.mu.Lock()
+		sessionID := t.sessionID
+		t.mu.Unlock()
+		if sessionID == "" {
+			return
+		}
+		req, err := t.newRequest(context.Background(), http.MethodDelete, nil)
+		if err != nil {
+			return
+		}
+		if resp, err := t.client.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	})
+	return nil
+}
@@ -0,0 +1,91 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+)
+
+// LogLevels are the MCP log severities, from least to most severe.
+var LogLevels = []string{"debug", "info", "notice", "warning", "error", "critical", "alert", "emergency"}
+
+// LogMessage is the params of a notifications/message notification.
+type LogMessage struct {
+	Level  string          `json:"level"`
+	Logger string          `json:"logger,omitempty"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// ValidLogLevel reports whether level is one of LogLevels.
+func ValidLogLevel(level string) bool {
+	for _, l := range LogLevels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// SetLogLevel asks the server to send log messages at level and above.
+// Servers that do not advertise the logging capability may reject it.
+func (c *MCPClient) SetLogLevel(ctx context.Context, level string) error {
+	if !ValidLogLevel(level) {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	if err := c.request(ctx, "logging/setLevel", map[string]string{"level": level}, nil); err != nil {
+		return fmt.Errorf("failed to set log level: %w", err)
+	}
+	return nil
+}
+
+// SetLogger routes the server's log messages, and the client's own
+// warnings about the server, to logger.
+func (c *MCPClient) SetLogger(logger *slog.Logger) {
+	c.mu.Lock()
+	c.logger = logger
+	c.mu.Unlock()
+
+	c.OnNotification("notifications/message", func(params json.RawMessage) {
+		var msg LogMessage
+		if err := json.Unmarshal(params, &msg); err != nil {
+			logger.Warn("malformed log message", "error", err)
+			return
+		}
+
+		// Data may be any JSON value; strings are used as the message
+		text := "server log"
+		var attrs []any
+		if err := json.Unmarshal(msg.Data, &text); err != nil && len(msg.Data) > 0 {
+			attrs = append(attrs, "data", string(msg.Data))
+		}
+		if msg.Logger != "" {
+			attrs = append(attrs, "logger", msg.Logger)
+		}
+		logger.Log(context.Background(), slogLevel(msg.Level), text, attrs...)
+	})
+}
+
+// log returns the logger set with SetLogger, or one that discards.
+func (c *MCPClient) log() *slog.Logger {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return c.logger
+}
+
+// slogLevel maps an MCP severity onto the nearest slog level.
+func slogLevel(level string) slog.Level {
+	switch level {
+	case "debug":
+		return slog.LevelDebug
+	case "info", "notice":
+		return slog.LevelInfo
+	case "warning":
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
@@ -0,0 +1,307 @@
+package mcptest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/llm"
+	"github.com/techmuch/castor/pkg/mcp"
+)
+
+// Conformance checks that a transport carries the whole protocol between
+// MCPClient and a Server: the handshake, tool calls and their errors, rich
+// content, progress, concurrent and cancelled requests, requests from the
+// server, prompts, and logging. connect returns the client's end of a new
+// connection to s; it is closed when each subtest ends.
+func Conformance(t *testing.T, connect func(t *testing.T, s *Server) mcp.Transport) {
+	// start connects a client to s and completes the handshake.
+	start := func(t *testing.T, s *Server, setup func(*mcp.MCPClient)) *mcp.MCPClient {
+		t.Helper()
+		c := mcp.NewClient(connect(t, s))
+		t.Cleanup(func() { c.Close() })
+		if setup != nil {
+			setup(c)
+		}
+		if err := c.Initialize(testContext(t)); err != nil {
+			t.Fatalf("Initialize failed: %v", err)
+		}
+		return c
+	}
+
+	t.Run("Handshake", func(t *testing.T) {
+		s := NewServer("test")
+		c := start(t, s, nil)
+		if !c.HasCapability("tools") {
+			t.Error("Tools capability not received")
+		}
+		if err := c.Ping(testContext(t)); err != nil {
+			t.Errorf("Ping failed: %v", err)
+		}
+		got := s.Requests()
+		want := []string{"initialize", "notifications/initialized", "ping"}
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("Server received %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Tools", func(t *testing.T) {
+		s := NewServer("test")
+		s.AddTool("echo", "Echoes its input", map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"text": map[string]interface{}{"type": "string"}},
+		}, func(call *Call) ([]mcp.Content, error) {
+			return []mcp.Content{Text(fmt.Sprint(call.Args["text"]))}, nil
+		})
+		c := start(t, s, nil)
+
+		tools, err := c.ListTools(testContext(t))
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(tools) != 1 || tools[0].Name() != "echo" || tools[0].Description() != "Echoes its input" {
+			t.Fatalf("Unexpected tools: %v", tools)
+		}
+		got, err := tools[0].Execute(testContext(t), map[string]interface{}{"text": "hello"})
+		if err != nil {
+			t.Fatalf("Call failed: %v", err)
+		}
+		if got != "hello" {
+			t.Errorf("Got %v, want hello", got)
+		}
+	})
+
+	t.Run("ToolError", func(t *testing.T) {
+		s := NewServer("test")
+		s.AddTool("fail", "", nil, func(call *Call) ([]mcp.Content, error) {
+			return nil, errors.New("disk full")
+		})
+		c := start(t, s, nil)
+
+		_, err := c.CallTool(testContext(t), "fail", nil)
+		if err == nil || !strings.Contains(err.Error(), "disk full") {
+			t.Errorf("Expected tool error, got %v", err)
+		}
+	})
+
+	t.Run("ProtocolError", func(t *testing.T) {
+		s := NewServer("test")
+		s.AddTool("broken", "", nil, func(call *Call) ([]mcp.Content, error) {
+			return nil, &mcp.JSONRPCError{Code: -32000, Message: "backend unavailable"}
+		})
+		c := start(t, s, nil)
+
+		_, err := c.CallTool(testContext(t), "broken", nil)
+		if err == nil || !strings.Contains(err.Error(), "backend unavailable") {
+			t.Errorf("Expected protocol error, got %v", err)
+		}
+		if _, err := c.CallTool(testContext(t), "missing", nil); err == nil {
+			t.Error("Expected error for unknown tool")
+		}
+	})
+
+	t.Run("ImageContent", func(t *testing.T) {
+		s := NewServer("test")
+		s.AddTool("screenshot", "", nil, func(call *Call) ([]mcp.Content, error) {
+			return []mcp.Content{Text("the page"), Image("image/png", []byte("png"))}, nil
+		})
+		c := start(t, s, nil)
+
+		got, err := c.CallTool(testContext(t), "screenshot", nil)
+		if err != nil {
+			t.Fatalf("Call failed: %v", err)
+		}
+		parts, ok := got.([]llm.Part)
+		if !ok || len(parts) != 2 {
+			t.Fatalf("Expected text and image parts, got %#v", got)
+		}
+		img, ok := parts[1].(llm.ImagePart)
+		if !ok || img.MimeType != "image/png" || string(img.Data) != "png" {
+			t.Errorf("Unexpected image part: %#v", parts[1])
+		}
+	})
+
+	t.Run("Progress", func(t *testing.T) {
+		s := NewServer("test")
+		s.AddTool("build", "", nil, func(call *Call) ([]mcp.Content, error) {
+			for i := 1; i <= 3; i++ {
+				if err := call.Progress(float64(i), 3, fmt.Sprintf("step %d", i)); err != nil {
+					return nil, err
+				}
+			}
+			return []mcp.Content{Text("built")}, nil
+		})
+		c := start(t, s, nil)
+
+		var mu sync.Mutex
+		var steps []string
+		ctx := agent.WithProgress(testContext(t), func(progress, total float64, message string) {
+			mu.Lock()
+			defer mu.Unlock()
+			steps = append(steps, message)
+		})
+		if _, err := c.CallTool(ctx, "build", nil); err != nil {
+			t.Fatalf("Call failed: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.Join(steps, ",") != "step 1,step 2,step 3" {
+			t.Errorf("Unexpected progress: %v", steps)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		// Each call waits for all the others to arrive, so the test only
+		// passes if the transport carries them at the same time.
+		const n = 8
+		var wg sync.WaitGroup
+		wg.Add(n)
+		arrived := make(chan struct{})
+		go func() { wg.Wait(); close(arrived) }()
+
+		s := NewServer("test")
+		s.AddTool("wait", "", nil, func(call *Call) ([]mcp.Content, error) {
+			wg.Done()
+			select {
+			case <-arrived:
+			case <-call.Context().Done():
+				return nil, call.Context().Err()
+			}
+			return []mcp.Content{Text(fmt.Sprint(call.Args["n"]))}, nil
+		})
+		c := start(t, s, nil)
+
+		errs := make(chan error, n)
+		for i := 0; i < n; i++ {
+			go func(i int) {
+				got, err := c.CallTool(testContext(t), "wait", map[string]interface{}{"n": i})
+				if err == nil && got != fmt.Sprint(i) {
+					err = fmt.Errorf("call %d got %v", i, got)
+				}
+				errs <- err
+			}(i)
+		}
+		for i := 0; i < n; i++ {
+			if err := <-errs; err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	t.Run("Cancellation", func(t *testing.T) {
+		cancelled := make(chan struct{})
+		s := NewServer("test")
+		s.AddTool("hang", "", nil, func(call *Call) ([]mcp.Content, error) {
+			<-call.Context().Done()
+			close(cancelled)
+			return nil, call.Context().Err()
+		})
+		c := start(t, s, nil)
+		c.Timeout = 50 * time.Millisecond
+
+		_, err := c.CallTool(testContext(t), "hang", nil)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected timeout, got %v", err)
+		}
+		select {
+		case <-cancelled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Server was not told to cancel the call")
+		}
+	})
+
+	t.Run("ServerRequest", func(t *testing.T) {
+		s := NewServer("test")
+		s.AddTool("roots", "", nil, func(call *Call) ([]mcp.Content, error) {
+			result, err := call.Request("roots/list", nil)
+			if err != nil {
+				return nil, err
+			}
+			return []mcp.Content{Text(string(result))}, nil
+		})
+		c := start(t, s, func(c *mcp.MCPClient) {
+			c.SetRoots(context.Background(), []mcp.Root{{URI: "file:///work", Name: "work"}})
+		})
+
+		got, err := c.CallTool(testContext(t), "roots", nil)
+		if err != nil {
+			t.Fatalf("Call failed: %v", err)
+		}
+		if !strings.Contains(fmt.Sprint(got), "file:///work") {
+			t.Errorf("Roots not returned to the server: %v", got)
+		}
+	})
+
+	t.Run("Prompts", func(t *testing.T) {
+		s := NewServer("test")
+		s.AddPrompt(mcp.Prompt{
+			Name:      "review",
+			Arguments: []mcp.PromptArgument{{Name: "lang", Required: true}},
+		}, func(args map[string]string) ([]mcp.PromptMessage, error) {
+			return []mcp.PromptMessage{{Role: "user", Content: Text("Review this " + args["lang"] + " code")}}, nil
+		})
+		s.AddCompletions("lang", "go", "python", "golang")
+		c := start(t, s, nil)
+
+		prompts, err := c.ListPrompts(testContext(t))
+		if err != nil || len(prompts) != 1 || prompts[0].Name != "review" {
+			t.Fatalf("Unexpected prompts %v: %v", prompts, err)
+		}
+		messages, err := c.GetPrompt(testContext(t), "review", map[string]string{"lang": "go"})
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if got := mcp.PromptText(messages); !strings.Contains(got, "Review this go code") {
+			t.Errorf("Unexpected prompt text %q", got)
+		}
+		completion, err := c.Complete(testContext(t), mcp.PromptRef("review"), "lang", "go")
+		if err != nil {
+			t.Fatalf("Complete failed: %v", err)
+		}
+		if strings.Join(completion.Values, ",") != "go,golang" {
+			t.Errorf("Unexpected completions %v", completion.Values)
+		}
+	})
+
+	t.Run("Logging", func(t *testing.T) {
+		s := NewServer("test")
+		s.AddTool("noisy", "", nil, func(call *Call) ([]mcp.Content, error) {
+			call.Log("info", "routine detail")
+			call.Log("error", "something broke")
+			return nil, nil
+		})
+		var buf bytes.Buffer
+		c := start(t, s, func(c *mcp.MCPClient) {
+			c.SetLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+		})
+		if err := c.SetLogLevel(testContext(t), "warning"); err != nil {
+			t.Fatalf("Set level failed: %v", err)
+		}
+
+		if _, err := c.CallTool(testContext(t), "noisy", nil); err != nil {
+			t.Fatalf("Call failed: %v", err)
+		}
+		logs := buf.String()
+		if !strings.Contains(logs, "something broke") {
+			t.Errorf("Error message not logged: %q", logs)
+		}
+		if strings.Contains(logs, "routine detail") {
+			t.Errorf("Message below the requested level was sent: %q", logs)
+		}
+	})
+}
+
+// testContext bounds a step of a conformance test so a broken transport
+// fails rather than hangs.
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
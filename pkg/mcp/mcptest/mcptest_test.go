@@ -0,0 +1,116 @@
+package mcptest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/techmuch/castor/pkg/mcp"
+)
+
+func TestPipe(t *testing.T) {
+	Conformance(t, func(t *testing.T, s *Server) mcp.Transport {
+		client, server := Pipe()
+		go s.Serve(context.Background(), server)
+		t.Cleanup(func() { client.Close() })
+		return client
+	})
+}
+
+func TestWebSocket(t *testing.T) {
+	Conformance(t, func(t *testing.T, s *Server) mcp.Transport {
+		srv := httptest.NewServer(s.WebSocketHandler())
+		t.Cleanup(srv.Close)
+		url := "ws" + strings.TrimPrefix(srv.URL, "http")
+		transport, err := mcp.DialWebSocket(context.Background(), url, mcp.WebSocketOptions{})
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		t.Cleanup(func() { transport.Close() })
+		return transport
+	})
+}
+
+func TestHTTP(t *testing.T) {
+	Conformance(t, func(t *testing.T, s *Server) mcp.Transport {
+		srv := httptest.NewServer(s.HTTPHandler())
+		t.Cleanup(srv.Close)
+		transport := mcp.NewHTTPTransport(srv.URL)
+		t.Cleanup(func() { transport.Close() })
+		return transport
+	})
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Notify", func(t *testing.T) {
+		s := NewServer("test")
+		client, server := Pipe()
+		go s.Serve(ctx, server)
+		c := mcp.NewClient(client)
+		defer c.Close()
+
+		got := make(chan string, 1)
+		c.OnNotification("notifications/tools/list_changed", func(params json.RawMessage) {
+			got <- "list_changed"
+		})
+		if err := c.Initialize(ctx); err != nil {
+			t.Fatalf("Initialize failed: %v", err)
+		}
+		if err := s.Notify("notifications/tools/list_changed", nil); err != nil {
+			t.Fatalf("Notify failed: %v", err)
+		}
+		select {
+		case <-got:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Notification not received")
+		}
+	})
+
+	t.Run("Handle", func(t *testing.T) {
+		s := NewServer("test")
+		s.Handle("initialize", func(call *Call, params json.RawMessage) (interface{}, error) {
+			return nil, &mcp.JSONRPCError{Code: -32602, Message: "unsupported protocol version"}
+		})
+		client, server := Pipe()
+		go s.Serve(ctx, server)
+		c := mcp.NewClient(client)
+		defer c.Close()
+
+		err := c.Initialize(ctx)
+		if err == nil || !strings.Contains(err.Error(), "unsupported protocol version") {
+			t.Errorf("Expected scripted error, got %v", err)
+		}
+	})
+
+	t.Run("Restart", func(t *testing.T) {
+		s := NewServer("test")
+		s.AddTool("ping", "", nil, func(call *Call) ([]mcp.Content, error) {
+			return []mcp.Content{Text("pong")}, nil
+		})
+		conn := &mcp.Conn{Name: "test", Dial: s.Dialer()}
+		if err := conn.Connect(ctx); err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		defer conn.Close()
+
+		// Drop the connection and wait for the Conn to reconnect
+		s.CloseSessions()
+		deadline := time.Now().Add(5 * time.Second)
+		for strings.Count(strings.Join(s.Requests(), " "), "notifications/initialized") < 2 {
+			if time.Now().After(deadline) {
+				t.Fatal("Conn did not reconnect")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		callCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if res, err := conn.CallTool(callCtx, "ping", nil); err != nil || res != "pong" {
+			t.Errorf("Unexpected result after restart %v, %v", res, err)
+		}
+	})
+}
@@ -0,0 +1,448 @@
+// Package mcptest provides an in-process MCP server for testing clients and
+// transports without external binaries, and a conformance suite to run
+// against it.
+package mcptest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/techmuch/castor/pkg/mcp"
+)
+
+// ProtocolVersion is the MCP revision the server reports.
+const ProtocolVersion = "2024-11-05"
+
+// Handler answers a request. Returning a *mcp.JSONRPCError sends that
+// error; other errors are sent as internal errors.
+type Handler func(call *Call, params json.RawMessage) (interface{}, error)
+
+// ToolFunc implements a tool. A returned error becomes a tool result with
+// isError set, unless it is a *mcp.JSONRPCError, which fails the request.
+type ToolFunc func(call *Call) ([]mcp.Content, error)
+
+// PromptFunc fills in a prompt.
+type PromptFunc func(args map[string]string) ([]mcp.PromptMessage, error)
+
+// Server is a scriptable MCP server. Register tools, resources, and
+// prompts, then connect clients with Dialer, Serve, or the HTTP handlers.
+// It is safe for concurrent use.
+type Server struct {
+	Name string
+
+	mu          sync.Mutex
+	tools       map[string]tool
+	resources   map[string]mcp.Resource
+	prompts     map[string]prompt
+	completions map[string][]string // Argument name to values
+	handlers    map[string]Handler
+	requests    []string
+	sessions    map[*session]bool
+}
+
+type tool struct {
+	desc   string
+	schema interface{}
+	fn     ToolFunc
+}
+
+type prompt struct {
+	mcp.Prompt
+	fn PromptFunc
+}
+
+// NewServer returns a server with no tools.
+func NewServer(name string) *Server {
+	return &Server{
+		Name:        name,
+		tools:       make(map[string]tool),
+		resources:   make(map[string]mcp.Resource),
+		prompts:     make(map[string]prompt),
+		completions: make(map[string][]string),
+		handlers:    make(map[string]Handler),
+		sessions:    make(map[*session]bool),
+	}
+}
+
+// AddTool registers a tool. A nil schema accepts any object.
+func (s *Server) AddTool(name, description string, schema interface{}, fn ToolFunc) {
+	if schema == nil {
+		schema = map[string]interface{}{"type": "object"}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tools[name] = tool{desc: description, schema: schema, fn: fn}
+}
+
+// AddResource registers a resource for resources/list and resources/read.
+func (s *Server) AddResource(r mcp.Resource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resources[r.URI] = r
+}
+
+// AddPrompt registers a prompt.
+func (s *Server) AddPrompt(p mcp.Prompt, fn PromptFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prompts[p.Name] = prompt{Prompt: p, fn: fn}
+}
+
+// AddCompletions sets the values offered when completing the argument
+// called arg of any prompt or resource.
+func (s *Server) AddCompletions(arg string, values ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.completions[arg] = values
+}
+
+// Handle answers method with h, replacing the built-in behavior. Use it to
+// script errors or methods the server does not implement.
+func (s *Server) Handle(method string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method] = h
+}
+
+// Requests returns the methods of all requests and notifications received,
+// in order.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Notify sends a notification to every connected client that can receive
+// unsolicited messages.
+func (s *Server) Notify(method string, params interface{}) error {
+	msg, err := notification(method, params)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	var sessions []*session
+	for sess := range s.sessions {
+		if sess.send != nil {
+			sessions = append(sessions, sess)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		if err := sess.send(context.Background(), msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CloseSessions drops every connection, as if the server had crashed.
+func (s *Server) CloseSessions() {
+	s.mu.Lock()
+	sessions := s.sessions
+	s.sessions = make(map[*session]bool)
+	s.mu.Unlock()
+	for sess := range sessions {
+		sess.close()
+	}
+}
+
+// Serve answers messages from t, the server's end of a connection, until
+// it fails or ctx is cancelled.
+func (s *Server) Serve(ctx context.Context, t mcp.Transport) error {
+	sess := s.newSession(ctx, t.Send)
+	sess.closer = func() { t.Close() }
+	defer s.endSession(sess)
+
+	for {
+		msg, err := t.Receive(sess.ctx)
+		if err != nil {
+			return err
+		}
+		switch {
+		case msg.ID != nil && msg.Method == "":
+			sess.deliver(msg)
+		case msg.ID != nil:
+			go sess.handleRequest(msg, t.Send)
+		default:
+			sess.handleNotification(msg)
+		}
+	}
+}
+
+// Dialer returns a dialer that connects to the server in-process.
+func (s *Server) Dialer() mcp.Dialer {
+	return func(ctx context.Context) (mcp.Transport, error) {
+		client, server := Pipe()
+		go s.Serve(context.Background(), server)
+		return client, nil
+	}
+}
+
+func (s *Server) newSession(ctx context.Context, send func(context.Context, mcp.JSONRPCMessage) error) *session {
+	ctx, cancel := context.WithCancel(ctx)
+	sess := &session{
+		server:   s,
+		send:     send,
+		ctx:      ctx,
+		cancel:   cancel,
+		pending:  make(map[int64]chan mcp.JSONRPCMessage),
+		inflight: make(map[string]context.CancelFunc),
+	}
+	s.mu.Lock()
+	s.sessions[sess] = true
+	s.mu.Unlock()
+	return sess
+}
+
+func (s *Server) endSession(sess *session) {
+	s.mu.Lock()
+	delete(s.sessions, sess)
+	s.mu.Unlock()
+	sess.close()
+}
+
+func (s *Server) record(method string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, method)
+}
+
+// dispatch answers one request.
+func (s *Server) dispatch(call *Call, method string, params json.RawMessage) (interface{}, error) {
+	s.mu.Lock()
+	h := s.handlers[method]
+	s.mu.Unlock()
+	if h != nil {
+		return h(call, params)
+	}
+
+	switch method {
+	case "initialize":
+		return s.initialize(), nil
+	case "ping":
+		return struct{}{}, nil
+	case "logging/setLevel":
+		var p struct {
+			Level string `json:"level"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil || !mcp.ValidLogLevel(p.Level) {
+			return nil, invalidParams("invalid log level")
+		}
+		call.session.setLogLevel(p.Level)
+		return struct{}{}, nil
+	case "tools/list":
+		return s.listTools(), nil
+	case "tools/call":
+		return s.callTool(call, params)
+	case "resources/list":
+		return s.listResources(), nil
+	case "resources/read":
+		return s.readResource(params)
+	case "prompts/list":
+		return s.listPrompts(), nil
+	case "prompts/get":
+		return s.getPrompt(params)
+	case "completion/complete":
+		return s.complete(params)
+	}
+	return nil, &mcp.JSONRPCError{Code: -32601, Message: "method not found: " + method}
+}
+
+func (s *Server) initialize() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	capabilities := map[string]interface{}{
+		"tools":   map[string]interface{}{},
+		"logging": map[string]interface{}{},
+	}
+	if len(s.resources) > 0 {
+		capabilities["resources"] = map[string]interface{}{}
+	}
+	if len(s.prompts) > 0 {
+		capabilities["prompts"] = map[string]interface{}{}
+	}
+	if len(s.completions) > 0 {
+		capabilities["completions"] = map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"protocolVersion": ProtocolVersion,
+		"capabilities":    capabilities,
+		"serverInfo":      map[string]string{"name": s.Name, "version": "0.0.0"},
+	}
+}
+
+func (s *Server) listTools() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tools []map[string]interface{}
+	for _, name := range sortedKeys(s.tools) {
+		t := s.tools[name]
+		tools = append(tools, map[string]interface{}{
+			"name":        name,
+			"description": t.desc,
+			"inputSchema": t.schema,
+		})
+	}
+	return map[string]interface{}{"tools": tools}
+}
+
+func (s *Server) callTool(call *Call, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+		Meta      struct {
+			ProgressToken json.RawMessage `json:"progressToken"`
+		} `json:"_meta"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, invalidParams(err.Error())
+	}
+	s.mu.Lock()
+	t, ok := s.tools[p.Name]
+	s.mu.Unlock()
+	if !ok {
+		return nil, invalidParams("unknown tool: " + p.Name)
+	}
+
+	call.Args = p.Arguments
+	call.progressToken = p.Meta.ProgressToken
+	content, err := t.fn(call)
+	var rpcErr *mcp.JSONRPCError
+	if errors.As(err, &rpcErr) {
+		return nil, rpcErr
+	}
+	if err != nil {
+		return map[string]interface{}{"content": []mcp.Content{Text(err.Error())}, "isError": true}, nil
+	}
+	if content == nil {
+		content = []mcp.Content{}
+	}
+	return map[string]interface{}{"content": content}, nil
+}
+
+func (s *Server) listResources() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var resources []map[string]string
+	for _, uri := range sortedKeys(s.resources) {
+		r := s.resources[uri]
+		resources = append(resources, map[string]string{"uri": r.URI, "name": r.URI, "mimeType": r.MimeType})
+	}
+	return map[string]interface{}{"resources": resources}
+}
+
+func (s *Server) readResource(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, invalidParams(err.Error())
+	}
+	s.mu.Lock()
+	r, ok := s.resources[p.URI]
+	s.mu.Unlock()
+	if !ok {
+		return nil, &mcp.JSONRPCError{Code: -32002, Message: "resource not found: " + p.URI}
+	}
+	return map[string]interface{}{"contents": []mcp.Resource{r}}, nil
+}
+
+func (s *Server) listPrompts() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var prompts []mcp.Prompt
+	for _, name := range sortedKeys(s.prompts) {
+		prompts = append(prompts, s.prompts[name].Prompt)
+	}
+	return map[string]interface{}{"prompts": prompts}
+}
+
+func (s *Server) getPrompt(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Name      string            `json:"name"`
+		Arguments map[string]string `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, invalidParams(err.Error())
+	}
+	s.mu.Lock()
+	pr, ok := s.prompts[p.Name]
+	s.mu.Unlock()
+	if !ok {
+		return nil, invalidParams("unknown prompt: " + p.Name)
+	}
+	messages, err := pr.fn(p.Arguments)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"messages": messages}, nil
+}
+
+func (s *Server) complete(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Argument struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"argument"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, invalidParams(err.Error())
+	}
+	s.mu.Lock()
+	candidates := s.completions[p.Argument.Name]
+	s.mu.Unlock()
+
+	values := []string{}
+	for _, v := range candidates {
+		if strings.HasPrefix(v, p.Argument.Value) {
+			values = append(values, v)
+		}
+	}
+	return map[string]interface{}{"completion": mcp.Completion{Values: values, Total: len(values)}}, nil
+}
+
+// Text returns text content.
+func Text(text string) mcp.Content {
+	return mcp.Content{Type: "text", Text: text}
+}
+
+// Image returns image content.
+func Image(mimeType string, data []byte) mcp.Content {
+	return mcp.Content{Type: "image", MimeType: mimeType, Data: base64.StdEncoding.EncodeToString(data)}
+}
+
+func invalidParams(message string) *mcp.JSONRPCError {
+	return &mcp.JSONRPCError{Code: -32602, Message: message}
+}
+
+func notification(method string, params interface{}) (mcp.JSONRPCMessage, error) {
+	msg := mcp.JSONRPCMessage{JSONRPC: "2.0", Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return msg, fmt.Errorf("failed to marshal %s params: %w", method, err)
+		}
+		msg.Params = data
+	}
+	return msg, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,216 @@
+package mcptest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/techmuch/castor/pkg/mcp"
+)
+
+// sendFunc delivers a message to the client.
+type sendFunc func(ctx context.Context, msg mcp.JSONRPCMessage) error
+
+// session is one client connection.
+type session struct {
+	server *Server
+	send   sendFunc // Nil when the transport cannot push messages
+	closer func()
+
+	ctx    context.Context // Cancelled when the session ends
+	cancel context.CancelFunc
+	once   sync.Once
+	nextID int64
+
+	mu       sync.Mutex
+	pending  map[int64]chan mcp.JSONRPCMessage // Requests sent to the client
+	inflight map[string]context.CancelFunc     // Client requests, keyed by ID
+	logLevel string
+}
+
+func (s *session) close() {
+	s.once.Do(func() {
+		s.cancel()
+		if s.closer != nil {
+			s.closer()
+		}
+	})
+}
+
+// deliver routes a response from the client to the request awaiting it.
+func (s *session) deliver(msg mcp.JSONRPCMessage) {
+	s.mu.Lock()
+	ch := s.pending[*msg.ID]
+	s.mu.Unlock()
+	if ch != nil {
+		ch <- msg
+	}
+}
+
+// handleRequest answers a client request through reply. Requests the
+// client cancels get no response, as the protocol requires.
+func (s *session) handleRequest(req mcp.JSONRPCMessage, reply sendFunc) {
+	s.server.record(req.Method)
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	key := string(mustMarshal(req.ID))
+	s.mu.Lock()
+	s.inflight[key] = cancel
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.inflight, key)
+		s.mu.Unlock()
+	}()
+
+	call := &Call{ctx: ctx, session: s, reply: reply}
+	result, err := s.server.dispatch(call, req.Method, req.Params)
+	if ctx.Err() != nil {
+		return
+	}
+
+	resp := mcp.JSONRPCMessage{JSONRPC: "2.0", ID: req.ID}
+	if err != nil {
+		rpcErr, ok := err.(*mcp.JSONRPCError)
+		if !ok {
+			rpcErr = &mcp.JSONRPCError{Code: -32603, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+	} else if data, err := json.Marshal(result); err != nil {
+		resp.Error = &mcp.JSONRPCError{Code: -32603, Message: fmt.Sprintf("failed to marshal result: %v", err)}
+	} else {
+		resp.Result = data
+	}
+	_ = reply(context.Background(), resp)
+}
+
+// handleNotification records a client notification and acts on
+// cancellations.
+func (s *session) handleNotification(msg mcp.JSONRPCMessage) {
+	s.server.record(msg.Method)
+	if msg.Method != "notifications/cancelled" {
+		return
+	}
+	var p struct {
+		RequestID json.RawMessage `json:"requestId"`
+	}
+	if err := json.Unmarshal(msg.Params, &p); err != nil {
+		return
+	}
+	s.mu.Lock()
+	cancel := s.inflight[string(p.RequestID)]
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func (s *session) setLogLevel(level string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logLevel = level
+}
+
+// logs reports whether the client asked for messages at level.
+func (s *session) logs(level string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.logLevel == "" {
+		return true
+	}
+	return slices.Index(mcp.LogLevels, level) >= slices.Index(mcp.LogLevels, s.logLevel)
+}
+
+// Call is a request being handled. Tools use it to read their arguments
+// and to talk to the client while they run.
+type Call struct {
+	// Args holds the arguments of a tools/call request.
+	Args map[string]interface{}
+
+	ctx           context.Context
+	session       *session
+	reply         sendFunc
+	progressToken json.RawMessage
+}
+
+// Context is cancelled when the client cancels the request or the
+// connection ends.
+func (c *Call) Context() context.Context {
+	return c.ctx
+}
+
+// Notify sends a notification related to this request.
+func (c *Call) Notify(method string, params interface{}) error {
+	msg, err := notification(method, params)
+	if err != nil {
+		return err
+	}
+	return c.reply(c.ctx, msg)
+}
+
+// Progress reports progress if the client asked for it.
+func (c *Call) Progress(progress, total float64, message string) error {
+	if len(c.progressToken) == 0 {
+		return nil
+	}
+	return c.Notify("notifications/progress", map[string]interface{}{
+		"progressToken": c.progressToken,
+		"progress":      progress,
+		"total":         total,
+		"message":       message,
+	})
+}
+
+// Log sends a log message unless the client set a higher log level.
+func (c *Call) Log(level string, data interface{}) error {
+	if !c.session.logs(level) {
+		return nil
+	}
+	return c.Notify("notifications/message", mcp.LogMessage{Level: level, Data: mustMarshal(data)})
+}
+
+// Request sends a request to the client, such as roots/list, and waits for
+// its result.
+func (c *Call) Request(method string, params interface{}) (json.RawMessage, error) {
+	msg, err := notification(method, params)
+	if err != nil {
+		return nil, err
+	}
+	id := atomic.AddInt64(&c.session.nextID, 1) + 1<<32 // Apart from client IDs
+	msg.ID = &id
+
+	ch := make(chan mcp.JSONRPCMessage, 1)
+	c.session.mu.Lock()
+	c.session.pending[id] = ch
+	c.session.mu.Unlock()
+	defer func() {
+		c.session.mu.Lock()
+		delete(c.session.pending, id)
+		c.session.mu.Unlock()
+	}()
+
+	if err := c.reply(c.ctx, msg); err != nil {
+		return nil, err
+	}
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		return resp.Result, nil
+	case <-c.ctx.Done():
+		return nil, c.ctx.Err()
+	}
+}
+
+func mustMarshal(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -0,0 +1,188 @@
+package mcptest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/coder/websocket"
+	"github.com/techmuch/castor/pkg/mcp"
+)
+
+// Pipe returns the two ends of an in-process connection.
+func Pipe() (client, server mcp.Transport) {
+	a := make(chan mcp.JSONRPCMessage, 64)
+	b := make(chan mcp.JSONRPCMessage, 64)
+	done := make(chan struct{})
+	var once sync.Once
+	closeFn := func() { once.Do(func() { close(done) }) }
+	return &pipeEnd{in: a, out: b, done: done, close: closeFn},
+		&pipeEnd{in: b, out: a, done: done, close: closeFn}
+}
+
+// pipeEnd is one side of a Pipe. Closing either side closes both.
+type pipeEnd struct {
+	in    <-chan mcp.JSONRPCMessage
+	out   chan<- mcp.JSONRPCMessage
+	done  chan struct{}
+	close func()
+}
+
+func (p *pipeEnd) Send(ctx context.Context, msg mcp.JSONRPCMessage) error {
+	select {
+	case p.out <- msg:
+		return nil
+	case <-p.done:
+		return io.ErrClosedPipe
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *pipeEnd) Receive(ctx context.Context) (mcp.JSONRPCMessage, error) {
+	select {
+	case msg := <-p.in:
+		return msg, nil
+	case <-p.done:
+		return mcp.JSONRPCMessage{}, io.EOF
+	case <-ctx.Done():
+		return mcp.JSONRPCMessage{}, ctx.Err()
+	}
+}
+
+func (p *pipeEnd) Close() error {
+	p.close()
+	return nil
+}
+
+// WebSocketHandler serves the server over WebSockets, one session per
+// connection.
+func (s *Server) WebSocketHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{Subprotocols: []string{"mcp"}})
+		if err != nil {
+			return
+		}
+		s.Serve(r.Context(), &wsEnd{conn: conn})
+	})
+}
+
+// wsEnd is the server side of a WebSocket connection.
+type wsEnd struct {
+	conn *websocket.Conn
+}
+
+func (w *wsEnd) Send(ctx context.Context, msg mcp.JSONRPCMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return w.conn.Write(ctx, websocket.MessageText, data)
+}
+
+func (w *wsEnd) Receive(ctx context.Context) (mcp.JSONRPCMessage, error) {
+	var msg mcp.JSONRPCMessage
+	_, data, err := w.conn.Read(ctx)
+	if err != nil {
+		return msg, err
+	}
+	err = json.Unmarshal(data, &msg)
+	return msg, err
+}
+
+func (w *wsEnd) Close() error {
+	return w.conn.Close(websocket.StatusNormalClosure, "")
+}
+
+// HTTPHandler serves the server over Streamable HTTP. Requests are
+// answered with an event stream carrying the messages sent while handling
+// them, then the response. Sessions are tracked with Mcp-Session-Id.
+// Server.Notify does not reach HTTP clients, which have no open stream.
+func (s *Server) HTTPHandler() http.Handler {
+	var mu sync.Mutex
+	sessions := make(map[string]*session)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("Mcp-Session-Id")
+		mu.Lock()
+		sess := sessions[id]
+		mu.Unlock()
+
+		switch r.Method {
+		case http.MethodDelete:
+			if sess != nil {
+				mu.Lock()
+				delete(sessions, id)
+				mu.Unlock()
+				s.endSession(sess)
+			}
+			return
+		case http.MethodPost:
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var msg mcp.JSONRPCMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if sess == nil {
+			if msg.Method != "initialize" {
+				http.Error(w, "unknown session", http.StatusNotFound)
+				return
+			}
+			id = newSessionID()
+			sess = s.newSession(context.Background(), nil)
+			mu.Lock()
+			sessions[id] = sess
+			mu.Unlock()
+		}
+
+		switch {
+		case msg.ID != nil && msg.Method == "":
+			sess.deliver(msg)
+			w.WriteHeader(http.StatusAccepted)
+		case msg.ID == nil:
+			sess.handleNotification(msg)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Mcp-Session-Id", id)
+			w.WriteHeader(http.StatusOK)
+			flusher, _ := w.(http.Flusher)
+			if flusher != nil {
+				flusher.Flush() // Let the client read the stream while the request runs
+			}
+
+			var writeMu sync.Mutex
+			sess.handleRequest(msg, func(ctx context.Context, out mcp.JSONRPCMessage) error {
+				data, err := json.Marshal(out)
+				if err != nil {
+					return err
+				}
+				writeMu.Lock()
+				defer writeMu.Unlock()
+				if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+					return err
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+				return nil
+			})
+		}
+	})
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
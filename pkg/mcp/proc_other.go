@@ -0,0 +1,21 @@
+//go:build !unix && !windows
+
+package mcp
+
+import "os/exec"
+
+// setProcessGroup is a no-op where process groups are unsupported.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminateGroup kills cmd, which is the closest this platform offers.
+func terminateGroup(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
+
+// killGroup kills cmd.
+func killGroup(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
+
+// reapGroup does nothing; there is no group to clean up.
+func reapGroup(cmd *exec.Cmd) {}
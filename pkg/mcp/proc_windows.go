@@ -0,0 +1,28 @@
+//go:build windows
+
+package mcp
+
+import (
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// setProcessGroup starts cmd as the root of a new process group.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// terminateGroup asks cmd and its descendants to exit.
+func terminateGroup(cmd *exec.Cmd) {
+	_ = exec.Command("taskkill", "/T", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
+
+// killGroup kills cmd and its descendants.
+func killGroup(cmd *exec.Cmd) {
+	_ = exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
+
+// reapGroup does nothing: once cmd has exited its descendants can no
+// longer be found safely, since its PID may have been reused.
+func reapGroup(cmd *exec.Cmd) {}
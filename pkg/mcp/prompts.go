@@ -0,0 +1,151 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Prompt is a prompt template offered by a server.
+type Prompt struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Arguments   []PromptArgument `json:"arguments,omitempty"`
+}
+
+// PromptArgument is a named value used to fill in a prompt.
+type PromptArgument struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+// PromptMessage is one message of a filled-in prompt.
+type PromptMessage struct {
+	Role    string  `json:"role"` // "user" or "assistant"
+	Content Content `json:"content"`
+}
+
+// CompletionRef identifies what an argument being completed belongs to:
+// a prompt by name, or a resource template by URI.
+type CompletionRef struct {
+	Type string `json:"type"` // "ref/prompt" or "ref/resource"
+	Name string `json:"name,omitempty"`
+	URI  string `json:"uri,omitempty"`
+}
+
+// PromptRef refers to the prompt called name.
+func PromptRef(name string) CompletionRef {
+	return CompletionRef{Type: "ref/prompt", Name: name}
+}
+
+// ResourceRef refers to the resource template uri.
+func ResourceRef(uri string) CompletionRef {
+	return CompletionRef{Type: "ref/resource", URI: uri}
+}
+
+// Completion holds suggested values for an argument.
+type Completion struct {
+	Values  []string `json:"values"`
+	Total   int      `json:"total,omitempty"`
+	HasMore bool     `json:"hasMore,omitempty"`
+}
+
+// errMethodNotFound is the JSON-RPC code for unsupported methods.
+const errMethodNotFound = -32601
+
+// PromptText flattens filled-in prompt messages into text. Embedded text
+// resources are included; images are noted but not attached.
+func PromptText(messages []PromptMessage) string {
+	var parts []string
+	for _, m := range messages {
+		text, _ := convertContent([]Content{m.Content})
+		parts = append(parts, text)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// ListPrompts returns every prompt the server offers.
+func (c *MCPClient) ListPrompts(ctx context.Context) ([]Prompt, error) {
+	var prompts []Prompt
+	cursor := ""
+	for {
+		params := map[string]interface{}{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var result struct {
+			Prompts    []Prompt `json:"prompts"`
+			NextCursor string   `json:"nextCursor"`
+		}
+		if err := c.request(ctx, "prompts/list", params, &result); err != nil {
+			return nil, fmt.Errorf("failed to list prompts: %w", err)
+		}
+		prompts = append(prompts, result.Prompts...)
+		if result.NextCursor == "" {
+			return prompts, nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+// GetPrompt fills in the prompt called name with args.
+func (c *MCPClient) GetPrompt(ctx context.Context, name string, args map[string]string) ([]PromptMessage, error) {
+	var result struct {
+		Messages []PromptMessage `json:"messages"`
+	}
+	params := map[string]interface{}{"name": name, "arguments": args}
+	if err := c.request(ctx, "prompts/get", params, &result); err != nil {
+		return nil, fmt.Errorf("failed to get prompt %s: %w", name, err)
+	}
+	return result.Messages, nil
+}
+
+// Complete asks the server for values of the argument called arg that
+// start from value. Servers without completion support return no values.
+func (c *MCPClient) Complete(ctx context.Context, ref CompletionRef, arg, value string) (Completion, error) {
+	var result struct {
+		Completion Completion `json:"completion"`
+	}
+	params := map[string]interface{}{
+		"ref":      ref,
+		"argument": map[string]string{"name": arg, "value": value},
+	}
+	err := c.request(ctx, "completion/complete", params, &result)
+	var rpcErr *JSONRPCError
+	if errors.As(err, &rpcErr) && rpcErr.Code == errMethodNotFound {
+		return Completion{}, nil
+	}
+	if err != nil {
+		return Completion{}, fmt.Errorf("failed to complete %s: %w", arg, err)
+	}
+	return result.Completion, nil
+}
+
+// ListPrompts returns the prompts of the current server process.
+func (c *Conn) ListPrompts(ctx context.Context) ([]Prompt, error) {
+	client, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.ListPrompts(ctx)
+}
+
+// GetPrompt fills in one of the server's prompts.
+func (c *Conn) GetPrompt(ctx context.Context, name string, args map[string]string) ([]PromptMessage, error) {
+	client, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetPrompt(ctx, name, args)
+}
+
+// Complete asks the server to complete a prompt or resource argument.
+func (c *Conn) Complete(ctx context.Context, ref CompletionRef, arg, value string) (Completion, error) {
+	client, err := c.current(ctx)
+	if err != nil {
+		return Completion{}, err
+	}
+	return client.Complete(ctx, ref, arg, value)
+}
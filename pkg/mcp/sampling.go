@@ -0,0 +1,115 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// SamplingMessage is one message in a sampling request.
+type SamplingMessage struct {
+	Role    string          `json:"role"` // "user" or "assistant"
+	Content SamplingContent `json:"content"`
+}
+
+// SamplingContent is the content of a sampling message. Only text content
+// is forwarded to the model.
+type SamplingContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	Data     string `json:"data,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+}
+
+// SamplingRequest is the params of a sampling/createMessage request, in
+// which a server asks the host to run a completion on its behalf.
+type SamplingRequest struct {
+	Messages      []SamplingMessage `json:"messages"`
+	SystemPrompt  string            `json:"systemPrompt,omitempty"`
+	MaxTokens     int               `json:"maxTokens,omitempty"`
+	Temperature   *float32          `json:"temperature,omitempty"`
+	StopSequences []string          `json:"stopSequences,omitempty"`
+}
+
+// SamplingResult is the response to a sampling request.
+type SamplingResult struct {
+	Role       string          `json:"role"`
+	Content    SamplingContent `json:"content"`
+	Model      string          `json:"model"`
+	StopReason string          `json:"stopReason,omitempty"`
+}
+
+// SamplingApprover decides whether a server's sampling request may run.
+// It typically asks the user.
+type SamplingApprover func(ctx context.Context, req *SamplingRequest) (bool, error)
+
+// EnableSampling lets the server request completions from provider. Each
+// request must be allowed by approve before it reaches the model. Call it
+// before Initialize so the capability is advertised.
+func (c *MCPClient) EnableSampling(provider llm.Provider, model string, approve SamplingApprover) {
+	c.mu.Lock()
+	c.sampling = true
+	c.mu.Unlock()
+
+	c.HandleRequest("sampling/createMessage", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		var req SamplingRequest
+		if err := json.Unmarshal(params, &req); err != nil {
+			return nil, &JSONRPCError{Code: -32602, Message: fmt.Sprintf("invalid sampling request: %v", err)}
+		}
+
+		ok, err := approve(ctx, &req)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, &JSONRPCError{Code: -1, Message: "user rejected sampling request"}
+		}
+
+		text, err := sample(ctx, provider, &req)
+		if err != nil {
+			return nil, err
+		}
+		return SamplingResult{
+			Role:       "assistant",
+			Content:    SamplingContent{Type: "text", Text: text},
+			Model:      model,
+			StopReason: "endTurn",
+		}, nil
+	})
+}
+
+// sample runs a sampling request through provider and returns the text.
+func sample(ctx context.Context, provider llm.Provider, req *SamplingRequest) (string, error) {
+	var history []llm.Message
+	if req.SystemPrompt != "" {
+		history = append(history, llm.Message{Role: llm.RoleSystem, Content: []llm.Part{llm.TextPart{Text: req.SystemPrompt}}})
+	}
+	for _, m := range req.Messages {
+		if m.Content.Type != "text" {
+			return "", &JSONRPCError{Code: -32602, Message: fmt.Sprintf("unsupported sampling content type %q", m.Content.Type)}
+		}
+		role := llm.RoleUser
+		if m.Role == "assistant" {
+			role = llm.RoleModel
+		}
+		history = append(history, llm.Message{Role: role, Content: []llm.Part{llm.TextPart{Text: m.Content.Text}}})
+	}
+
+	opts := llm.GenerateOptions{Temperature: req.Temperature, MaxTokens: req.MaxTokens, StopTokens: req.StopSequences}
+	stream, err := provider.GenerateContent(ctx, history, opts)
+	if err != nil {
+		return "", fmt.Errorf("sampling failed: %w", err)
+	}
+
+	var out strings.Builder
+	for event := range stream {
+		if event.Error != nil {
+			return "", fmt.Errorf("sampling failed: %w", event.Error)
+		}
+		out.WriteString(event.Delta)
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,313 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// droppedKeywords are JSON Schema keywords that OpenAI-compatible backends
+// reject or ignore. They are removed with a warning.
+var droppedKeywords = []string{
+	"if", "then", "else", "not",
+	"dependencies", "dependentSchemas", "dependentRequired",
+	"patternProperties", "propertyNames", "unevaluatedProperties", "contains",
+}
+
+// NormalizeSchema rewrites a tool's input schema into the subset of JSON
+// Schema that OpenAI-compatible backends accept. Local $refs are inlined,
+// nullable unions and type lists are collapsed to their non-null type,
+// allOf schemas are merged, oneOf is relaxed to anyOf, and keywords the
+// backends reject are dropped. Lossy changes are described in the returned
+// warnings. Schemas that cannot be expressed, such as recursive or external
+// $refs or a root that is not an object, are rejected with an error.
+func NormalizeSchema(raw json.RawMessage) (map[string]interface{}, []string, error) {
+	n := &normalizer{resolving: make(map[string]bool)}
+	if len(raw) == 0 || string(raw) == "null" {
+		n.warnf("missing input schema; assuming the tool takes no arguments")
+		raw = json.RawMessage(`{"type":"object"}`)
+	}
+	if err := json.Unmarshal(raw, &n.root); err != nil {
+		return nil, nil, fmt.Errorf("invalid input schema: %w", err)
+	}
+
+	out, err := n.node("#", n.root)
+	if err != nil {
+		return nil, nil, err
+	}
+	schema, _ := out.(map[string]interface{})
+	if schema == nil {
+		return nil, nil, fmt.Errorf("input schema must be an object schema")
+	}
+	if _, ok := schema["type"]; !ok {
+		schema["type"] = "object"
+	}
+	if schema["type"] != "object" {
+		return nil, nil, fmt.Errorf("input schema must have type object, got %v", schema["type"])
+	}
+	if _, ok := schema["properties"]; !ok {
+		schema["properties"] = map[string]interface{}{}
+	}
+	return schema, n.warnings, nil
+}
+
+type normalizer struct {
+	root      interface{}
+	resolving map[string]bool // $refs being inlined, to detect cycles
+	warnings  []string
+}
+
+func (n *normalizer) warnf(format string, args ...interface{}) {
+	n.warnings = append(n.warnings, fmt.Sprintf(format, args...))
+}
+
+// node normalizes the schema at path.
+func (n *normalizer) node(path string, v interface{}) (interface{}, error) {
+	switch s := v.(type) {
+	case bool:
+		if !s {
+			return nil, fmt.Errorf("schema at %s accepts no value", path)
+		}
+		return map[string]interface{}{}, nil
+	case map[string]interface{}:
+		return n.object(path, s)
+	default:
+		return nil, fmt.Errorf("schema at %s is not an object", path)
+	}
+}
+
+func (n *normalizer) object(path string, in map[string]interface{}) (interface{}, error) {
+	if ref, ok := in["$ref"].(string); ok {
+		return n.ref(path, ref, in)
+	}
+
+	m := make(map[string]interface{}, len(in))
+	for k, v := range in {
+		m[k] = v
+	}
+	for _, k := range []string{"$schema", "$id", "$defs", "definitions", "$comment"} {
+		delete(m, k)
+	}
+	for _, k := range droppedKeywords {
+		if _, ok := m[k]; ok {
+			delete(m, k)
+			n.warnf("removed unsupported %q at %s", k, path)
+		}
+	}
+
+	if c, ok := m["const"]; ok {
+		delete(m, "const")
+		m["enum"] = []interface{}{c}
+	}
+
+	if types, ok := m["type"].([]interface{}); ok {
+		var kept []interface{}
+		for _, t := range types {
+			if t != "null" {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == 1 {
+			m["type"] = kept[0]
+		} else {
+			delete(m, "type")
+			n.warnf("removed type list %v at %s", types, path)
+		}
+	}
+
+	if props, ok := m["properties"].(map[string]interface{}); ok {
+		out := make(map[string]interface{}, len(props))
+		for _, name := range sortedKeys(props) {
+			p, err := n.node(path+"/properties/"+name, props[name])
+			if err != nil {
+				return nil, err
+			}
+			out[name] = p
+		}
+		m["properties"] = out
+	}
+
+	switch items := m["items"].(type) {
+	case map[string]interface{}, bool:
+		normalized, err := n.node(path+"/items", items)
+		if err != nil {
+			return nil, err
+		}
+		m["items"] = normalized
+	case []interface{}:
+		delete(m, "items")
+		n.warnf("removed tuple items at %s", path)
+	}
+
+	if additional, ok := m["additionalProperties"].(map[string]interface{}); ok {
+		normalized, err := n.node(path+"/additionalProperties", additional)
+		if err != nil {
+			return nil, err
+		}
+		m["additionalProperties"] = normalized
+	}
+
+	if all, ok := m["allOf"].([]interface{}); ok {
+		delete(m, "allOf")
+		for i, sub := range all {
+			normalized, err := n.node(fmt.Sprintf("%s/allOf/%d", path, i), sub)
+			if err != nil {
+				return nil, err
+			}
+			if err := mergeSchema(m, normalized.(map[string]interface{})); err != nil {
+				return nil, fmt.Errorf("cannot merge allOf at %s: %w", path, err)
+			}
+		}
+	}
+
+	// anyOf comes first so a relaxed oneOf is not normalized twice
+	for _, key := range []string{"anyOf", "oneOf"} {
+		variants, ok := m[key].([]interface{})
+		if !ok {
+			continue
+		}
+		delete(m, key)
+
+		var kept []interface{}
+		for i, sub := range variants {
+			normalized, err := n.node(fmt.Sprintf("%s/%s/%d", path, key, i), sub)
+			if err != nil {
+				return nil, err
+			}
+			if normalized.(map[string]interface{})["type"] == "null" {
+				continue
+			}
+			kept = append(kept, normalized)
+		}
+		switch {
+		case len(kept) == 1:
+			if err := mergeSchema(m, kept[0].(map[string]interface{})); err != nil {
+				return nil, fmt.Errorf("cannot flatten %s at %s: %w", key, path, err)
+			}
+		case len(kept) > 1 && key == "anyOf":
+			m["anyOf"] = kept
+		case len(kept) > 1:
+			if _, ok := m["anyOf"]; ok {
+				n.warnf("removed oneOf alongside anyOf at %s", path)
+				continue
+			}
+			n.warnf("relaxed oneOf to anyOf at %s", path)
+			m["anyOf"] = kept
+		}
+	}
+	return m, nil
+}
+
+// ref inlines the local reference ref. Sibling keywords such as description
+// override the referenced schema's.
+func (n *normalizer) ref(path, ref string, in map[string]interface{}) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported external $ref %q at %s", ref, path)
+	}
+	if n.resolving[ref] {
+		return nil, fmt.Errorf("recursive $ref %q at %s", ref, path)
+	}
+	target, err := lookupPointer(n.root, strings.TrimPrefix(ref, "#"))
+	if err != nil {
+		return nil, fmt.Errorf("unresolvable $ref %q at %s: %w", ref, path, err)
+	}
+
+	n.resolving[ref] = true
+	resolved, err := n.node(path, target)
+	delete(n.resolving, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	out := resolved.(map[string]interface{})
+	if len(in) == 1 {
+		return out, nil
+	}
+	siblings := make(map[string]interface{}, len(in)-1)
+	for k, v := range in {
+		if k != "$ref" {
+			siblings[k] = v
+		}
+	}
+	normalized, err := n.object(path, siblings)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range normalized.(map[string]interface{}) {
+		out[k] = v
+	}
+	return out, nil
+}
+
+// lookupPointer resolves a JSON pointer such as "/$defs/Item" in doc.
+func lookupPointer(doc interface{}, pointer string) (interface{}, error) {
+	if pointer == "" {
+		return doc, nil
+	}
+	cur := doc
+	for _, tok := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		tok = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%q not found", tok)
+		}
+		if cur, ok = m[tok]; !ok {
+			return nil, fmt.Errorf("%q not found", tok)
+		}
+	}
+	return cur, nil
+}
+
+// mergeSchema adds src's constraints to dst. Properties and required lists
+// are combined; other keywords must agree.
+func mergeSchema(dst, src map[string]interface{}) error {
+	for k, v := range src {
+		switch k {
+		case "properties":
+			props, _ := dst[k].(map[string]interface{})
+			if props == nil {
+				props = make(map[string]interface{})
+			}
+			for name, p := range v.(map[string]interface{}) {
+				props[name] = p
+			}
+			dst[k] = props
+		case "required":
+			seen := make(map[interface{}]bool)
+			var required []interface{}
+			for _, r := range append(asSlice(dst[k]), asSlice(v)...) {
+				if !seen[r] {
+					seen[r] = true
+					required = append(required, r)
+				}
+			}
+			dst[k] = required
+		case "description", "title":
+			if _, ok := dst[k]; !ok {
+				dst[k] = v
+			}
+		default:
+			if existing, ok := dst[k]; ok && !reflect.DeepEqual(existing, v) {
+				return fmt.Errorf("conflicting %q", k)
+			}
+			dst[k] = v
+		}
+	}
+	return nil
+}
+
+func asSlice(v interface{}) []interface{} {
+	s, _ := v.([]interface{})
+	return s
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
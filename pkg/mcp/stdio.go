@@ -4,58 +4,105 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"sync"
+	"time"
 )
 
+// DefaultShutdownTimeout is how long Close waits at each step of shutting
+// down a stdio server.
+const DefaultShutdownTimeout = 2 * time.Second
+
+// DefaultMaxMessageSize caps the size of one incoming message for
+// transports that are not configured otherwise.
+const DefaultMaxMessageSize = 16 << 20
+
 // StdioTransport implements Transport over stdin/stdout of a subprocess.
 type StdioTransport struct {
 	cmd    *exec.Cmd
 	stdin  io.WriteCloser
-	stdout io.ReadCloser
-	stderr io.ReadCloser
-	
-	scanner *bufio.Scanner
-	mu      sync.Mutex
+	stdout *os.File
+
+	// ShutdownTimeout is how long Close waits for the server to exit after
+	// closing its input, and again after asking it to terminate. Zero uses
+	// DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+
+	scanner   *bufio.Scanner
+	maxSize   int
+	mu        sync.Mutex
+	exited    chan struct{} // Closed when the process has exited
+	closeOnce sync.Once
+}
+
+// StdioOptions configures the server process.
+type StdioOptions struct {
+	Env []string // Extra KEY=VALUE entries added to the inherited environment
+	Dir string   // Working directory; empty uses castor's
+
+	// MaxMessageSize caps one line of server output. Zero uses
+	// DefaultMaxMessageSize.
+	MaxMessageSize int
 }
 
 // NewStdioTransport starts a subprocess and returns a transport connected to it.
-func NewStdioTransport(command string, args []string) (*StdioTransport, error) {
+// The server runs in its own process group so that Close can also stop any
+// processes it spawns.
+func NewStdioTransport(command string, args []string, opts StdioOptions) (*StdioTransport, error) {
 	cmd := exec.Command(command, args...)
-	
+	if len(opts.Env) > 0 {
+		cmd.Env = append(os.Environ(), opts.Env...)
+	}
+	cmd.Dir = opts.Dir
+	setProcessGroup(cmd)
+
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get stdin pipe: %w", err)
 	}
-	
-	stdout, err := cmd.StdoutPipe()
+
+	// Wait closes pipes made by StdoutPipe as soon as the process exits,
+	// which could drop its last messages, so the read end is kept here.
+	stdout, stdoutW, err := os.Pipe()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get stdout pipe: %w", err)
 	}
-	
-	stderr, err := cmd.StderrPipe()
+	cmd.Stdout = stdoutW
+
+	// Forward stderr to parent stderr for debugging
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Start()
+	stdoutW.Close()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get stderr pipe: %w", err)
-	}
-	
-	if err := cmd.Start(); err != nil {
+		stdout.Close()
 		return nil, fmt.Errorf("failed to start command: %w", err)
 	}
 
-	// Forward stderr to parent stderr for debugging
-	go io.Copy(os.Stderr, stderr)
+	maxSize := opts.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSize)
 
-	return &StdioTransport{
+	t := &StdioTransport{
 		cmd:     cmd,
 		stdin:   stdin,
 		stdout:  stdout,
-		stderr:  stderr,
-		scanner: bufio.NewScanner(stdout),
-	},
-	nil
+		scanner: scanner,
+		maxSize: maxSize,
+		exited:  make(chan struct{}),
+	}
+	go func() {
+		cmd.Wait()
+		close(t.exited)
+	}()
+	return t, nil
 }
 
 func (t *StdioTransport) Send(ctx context.Context, msg JSONRPCMessage) error {
@@ -66,7 +113,7 @@ func (t *StdioTransport) Send(ctx context.Context, msg JSONRPCMessage) error {
 	if err != nil {
 		return fmt.Errorf("marshal error: %w", err)
 	}
-	
+
 	// MCP uses JSON-RPC over stdio, typically newline delimited
 	if _, err := t.stdin.Write(append(data, '\n')); err != nil {
 		return fmt.Errorf("write error: %w", err)
@@ -77,25 +124,58 @@ func (t *StdioTransport) Send(ctx context.Context, msg JSONRPCMessage) error {
 func (t *StdioTransport) Receive(ctx context.Context) (JSONRPCMessage, error) {
 	// Note: This implementation is synchronous and blocking on scanner.Scan().
 	// A more robust implementation would handle context cancellation.
-	
+
 	if !t.scanner.Scan() {
-		if err := t.scanner.Err(); err != nil {
+		err := t.scanner.Err()
+		if errors.Is(err, bufio.ErrTooLong) {
+			// The rest of the stream cannot be framed, so the connection is lost
+			return JSONRPCMessage{}, fmt.Errorf("message from server exceeds the %d byte limit (raise maxMessageSize to allow it): %w", t.maxSize, err)
+		}
+		if err != nil {
 			return JSONRPCMessage{}, fmt.Errorf("scan error: %w", err)
 		}
 		return JSONRPCMessage{}, io.EOF
 	}
-	
+
 	var msg JSONRPCMessage
 	if err := json.Unmarshal(t.scanner.Bytes(), &msg); err != nil {
 		return JSONRPCMessage{}, fmt.Errorf("unmarshal error: %w", err)
 	}
-	
+
 	return msg, nil
 }
 
+// Close shuts the server down as the MCP stdio transport specifies: its
+// input is closed, then it is asked to terminate, then killed, waiting
+// ShutdownTimeout between steps. Whatever remains of its process group is
+// killed last, so helpers the server started do not outlive it.
 func (t *StdioTransport) Close() error {
-	t.stdin.Close()
-	t.stdout.Close()
-	t.stderr.Close()
-	return t.cmd.Process.Kill()
+	t.closeOnce.Do(func() {
+		timeout := t.ShutdownTimeout
+		if timeout <= 0 {
+			timeout = DefaultShutdownTimeout
+		}
+
+		t.stdin.Close()
+		if !t.waitExit(timeout) {
+			terminateGroup(t.cmd)
+			if !t.waitExit(timeout) {
+				killGroup(t.cmd)
+				<-t.exited
+			}
+		}
+		reapGroup(t.cmd)
+		t.stdout.Close()
+	})
+	return nil
+}
+
+// waitExit reports whether the process exits within timeout.
+func (t *StdioTransport) waitExit(timeout time.Duration) bool {
+	select {
+	case <-t.exited:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
@@ -3,6 +3,9 @@ package mcp
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
 
 	"github.com/techmuch/castor/pkg/agent"
 )
@@ -11,13 +14,13 @@ import (
 type Client interface {
 	// Initialize performs the handshake with the server.
 	Initialize(ctx context.Context) error
-	
+
 	// ListTools retrieves the tools available on the server.
 	ListTools(ctx context.Context) ([]agent.Tool, error)
-	
+
 	// CallTool calls a specific tool on the server.
 	CallTool(ctx context.Context, name string, args map[string]interface{}) (interface{}, error)
-	
+
 	// Close terminates the connection.
 	Close() error
 }
@@ -32,7 +35,7 @@ type Transport interface {
 // JSONRPCMessage represents a JSON-RPC 2.0 message.
 type JSONRPCMessage struct {
 	JSONRPC string          `json:"jsonrpc"`
-	Method  string          `json:"method"`
+	Method  string          `json:"method,omitempty"`
 	Params  json.RawMessage `json:"params,omitempty"`
 	ID      *int64          `json:"id,omitempty"`
 	Result  json.RawMessage `json:"result,omitempty"`
@@ -44,3 +47,23 @@ type JSONRPCError struct {
 	Message string `json:"message"`
 	Data    any    `json:"data,omitempty"`
 }
+
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+// Root is a filesystem location the client exposes to servers.
+type Root struct {
+	URI  string `json:"uri"`
+	Name string `json:"name,omitempty"`
+}
+
+// RootFromPath returns a file:// root for a local directory.
+func RootFromPath(path string) (Root, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return Root{}, fmt.Errorf("invalid root path: %w", err)
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}
+	return Root{URI: u.String(), Name: filepath.Base(abs)}, nil
+}
@@ -0,0 +1,181 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// DefaultKeepAlive is how often WebSocketTransport pings the server.
+const DefaultKeepAlive = 30 * time.Second
+
+// WebSocketOptions configures a WebSocket connection.
+type WebSocketOptions struct {
+	// Header is sent with the opening handshake, e.g. for API keys.
+	Header http.Header
+	// Auth, if set, authorizes the handshake and handles a 401 response.
+	Auth Authorizer
+	// KeepAlive is how often the server is pinged; a missed pong closes
+	// the connection. Zero uses DefaultKeepAlive.
+	KeepAlive time.Duration
+	// HTTPClient is used for the handshake. Nil uses http.DefaultClient.
+	HTTPClient *http.Client
+	// MaxMessageSize caps one incoming message. Zero uses
+	// DefaultMaxMessageSize.
+	MaxMessageSize int
+}
+
+// WebSocketTransport implements Transport over a WebSocket, one JSON-RPC
+// message per text frame. It does not reconnect by itself: when the
+// connection drops Receive fails, and a supervising Conn dials again and
+// repeats the handshake.
+type WebSocketTransport struct {
+	conn   *websocket.Conn
+	ctx    context.Context // Cancelled by Close
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+// DialWebSocket connects to the MCP server at url (ws:// or wss://).
+func DialWebSocket(ctx context.Context, url string, opts WebSocketOptions) (*WebSocketTransport, error) {
+	for attempt := 0; ; attempt++ {
+		header := make(http.Header)
+		for k, v := range opts.Header {
+			header[k] = v
+		}
+		if opts.Auth != nil {
+			// Authorizers work on requests, so collect the headers they set
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpURL(url), nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create request: %w", err)
+			}
+			if err := opts.Auth.Authorize(ctx, req); err != nil {
+				return nil, fmt.Errorf("failed to authorize request: %w", err)
+			}
+			for k, v := range req.Header {
+				header[k] = v
+			}
+		}
+
+		conn, resp, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+			HTTPClient:   opts.HTTPClient,
+			HTTPHeader:   header,
+			Subprotocols: []string{"mcp"},
+		})
+		if err == nil {
+			return newWebSocketTransport(conn, opts), nil
+		}
+		if resp == nil || resp.StatusCode != http.StatusUnauthorized || opts.Auth == nil || attempt > 0 {
+			return nil, fmt.Errorf("websocket handshake failed: %w", err)
+		}
+
+		retry, authErr := opts.Auth.Unauthorized(ctx, resp)
+		if authErr != nil {
+			return nil, authErr
+		}
+		if !retry {
+			return nil, fmt.Errorf("server returned %s", resp.Status)
+		}
+	}
+}
+
+func newWebSocketTransport(conn *websocket.Conn, opts WebSocketOptions) *WebSocketTransport {
+	keepAlive := opts.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = DefaultKeepAlive
+	}
+	maxSize := opts.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	conn.SetReadLimit(int64(maxSize))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &WebSocketTransport{conn: conn, ctx: ctx, cancel: cancel}
+	go t.keepAlive(keepAlive)
+	return t
+}
+
+// keepAlive pings the server until the transport is closed. Pongs are
+// read by Receive, which the client's read loop calls continuously.
+func (t *WebSocketTransport) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.ctx.Done():
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(t.ctx, interval)
+		err := t.conn.Ping(ctx)
+		cancel()
+		if err != nil && t.ctx.Err() == nil {
+			t.conn.Close(websocket.StatusGoingAway, "keepalive timed out")
+			return
+		}
+	}
+}
+
+func (t *WebSocketTransport) Send(ctx context.Context, msg JSONRPCMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+	if err := t.conn.Write(ctx, websocket.MessageText, data); err != nil {
+		return fmt.Errorf("write error: %w", err)
+	}
+	return nil
+}
+
+func (t *WebSocketTransport) Receive(ctx context.Context) (JSONRPCMessage, error) {
+	typ, data, err := t.conn.Read(ctx)
+	if err != nil {
+		if status := websocket.CloseStatus(err); status == websocket.StatusNormalClosure {
+			return JSONRPCMessage{}, fmt.Errorf("connection closed by server")
+		}
+		return JSONRPCMessage{}, fmt.Errorf("read error: %w", err)
+	}
+	if typ != websocket.MessageText {
+		return JSONRPCMessage{}, errors.New("unexpected binary message")
+	}
+
+	var msg JSONRPCMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return JSONRPCMessage{}, fmt.Errorf("unmarshal error: %w", err)
+	}
+	return msg, nil
+}
+
+func (t *WebSocketTransport) Close() error {
+	t.once.Do(func() {
+		t.cancel()
+		t.conn.Close(websocket.StatusNormalClosure, "")
+	})
+	return nil
+}
+
+// httpURL maps a ws:// or wss:// URL onto the matching http(s) URL, for
+// authorization which is defined in terms of HTTP.
+func httpURL(url string) string {
+	switch {
+	case strings.HasPrefix(url, "ws://"):
+		return "http://" + strings.TrimPrefix(url, "ws://")
+	case strings.HasPrefix(url, "wss://"):
+		return "https://" + strings.TrimPrefix(url, "wss://")
+	}
+	return url
+}
+
+// IsWebSocketURL reports whether url uses the ws or wss scheme.
+func IsWebSocketURL(url string) bool {
+	return strings.HasPrefix(url, "ws://") || strings.HasPrefix(url, "wss://")
+}
@@ -0,0 +1,211 @@
+// Package project inspects a workspace to describe how it is built and
+// tested, and reads the project instructions kept in CASTOR.md.
+package project
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/tools/workspace"
+)
+
+// InstructionsFile is the file, at the workspace root, whose contents are
+// added to the agent's system prompt.
+const InstructionsFile = "CASTOR.md"
+
+// MaxInstructionsBytes caps how much of the instructions file is read, so
+// a runaway file cannot crowd out the conversation.
+const MaxInstructionsBytes = 32 << 10
+
+// Command is a shell command that does one job in the project.
+type Command struct {
+	Purpose string // Such as "build" or "test"
+	Run     string
+}
+
+// Info is what Detect finds out about a project.
+type Info struct {
+	Name      string
+	Languages []string
+	Commands  []Command
+	Dirs      []string // Directories of the project, slash-separated and relative to the root
+}
+
+// maxDirs caps how many directories Info.Dirs lists.
+const maxDirs = 40
+
+// makeTargets are the Makefile targets listed as commands, by purpose.
+var makeTargets = []string{"build", "test", "lint", "fmt", "check"}
+
+// makeTarget matches a rule's target at the start of a Makefile line.
+var makeTarget = regexp.MustCompile(`^([A-Za-z0-9_.-]+)\s*:([^=]|$)`)
+
+// Detect inspects the project at root: its languages from their manifest
+// files, the commands that build and test it, and its directories two
+// levels deep, skipping ignored ones.
+func Detect(root string) (*Info, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid root path: %w", err)
+	}
+	if info, err := os.Stat(absRoot); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	p := &Info{Name: filepath.Base(absRoot)}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(absRoot, name))
+		return err == nil
+	}
+	add := func(purpose, run string) {
+		for _, c := range p.Commands {
+			if c.Purpose == purpose {
+				return // The Makefile's command comes first and wins
+			}
+		}
+		p.Commands = append(p.Commands, Command{Purpose: purpose, Run: run})
+	}
+
+	if data, err := os.ReadFile(filepath.Join(absRoot, "Makefile")); err == nil {
+		targets := make(map[string]bool)
+		for _, line := range strings.Split(string(data), "\n") {
+			if m := makeTarget.FindStringSubmatch(line); m != nil {
+				targets[m[1]] = true
+			}
+		}
+		for _, t := range makeTargets {
+			if targets[t] {
+				add(t, "make "+t)
+			}
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(absRoot, "go.mod")); err == nil {
+		p.Languages = append(p.Languages, "Go")
+		if m := regexp.MustCompile(`(?m)^module\s+(\S+)`).FindSubmatch(data); m != nil {
+			p.Name = string(m[1])
+		}
+		add("build", "go build ./...")
+		add("test", "go test ./...")
+		add("lint", "go vet ./...")
+		add("fmt", "gofmt -l -w .")
+	}
+	if data, err := os.ReadFile(filepath.Join(absRoot, "package.json")); err == nil {
+		p.Languages = append(p.Languages, "JavaScript/TypeScript")
+		var pkg struct {
+			Name    string            `json:"name"`
+			Scripts map[string]string `json:"scripts"`
+		}
+		json.Unmarshal(data, &pkg)
+		if pkg.Name != "" {
+			p.Name = pkg.Name
+		}
+		runner := "npm run"
+		switch {
+		case exists("pnpm-lock.yaml"):
+			runner = "pnpm run"
+		case exists("yarn.lock"):
+			runner = "yarn"
+		}
+		for _, s := range []string{"build", "test", "lint", "fmt", "format", "check"} {
+			if _, ok := pkg.Scripts[s]; ok {
+				add(s, runner+" "+s)
+			}
+		}
+	}
+	if exists("Cargo.toml") {
+		p.Languages = append(p.Languages, "Rust")
+		add("build", "cargo build")
+		add("test", "cargo test")
+		add("lint", "cargo clippy")
+		add("fmt", "cargo fmt")
+	}
+	if exists("pyproject.toml") || exists("setup.py") || exists("requirements.txt") {
+		p.Languages = append(p.Languages, "Python")
+		if exists("tests") || exists("pytest.ini") || exists("conftest.py") {
+			add("test", "pytest")
+		}
+	}
+
+	p.Dirs = dirs(absRoot)
+	return p, nil
+}
+
+// dirs lists the directories below root two levels deep, skipping hidden
+// and ignored ones.
+func dirs(root string) []string {
+	ig := workspace.NewIgnorer(root)
+	var found []string
+	var walk func(rel string, depth int)
+	walk = func(rel string, depth int) {
+		entries, err := os.ReadDir(filepath.Join(root, rel))
+		if err != nil {
+			return
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if !e.IsDir() || strings.HasPrefix(name, ".") || name == "vendor" || name == "testdata" {
+				continue
+			}
+			child := filepath.Join(rel, name)
+			if ig.Ignored(child, true) {
+				continue
+			}
+			found = append(found, filepath.ToSlash(child))
+			if depth < 2 {
+				walk(child, depth+1)
+			}
+		}
+	}
+	walk("", 1)
+	sort.Strings(found)
+	if len(found) > maxDirs {
+		found = found[:maxDirs]
+	}
+	return found
+}
+
+// Markdown renders the information as the starting point of a CASTOR.md.
+func (p *Info) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", p.Name)
+	b.WriteString("Instructions for coding agents working in this repository.\n")
+	if len(p.Languages) > 0 {
+		fmt.Fprintf(&b, "\nLanguages: %s.\n", strings.Join(p.Languages, ", "))
+	}
+	if len(p.Commands) > 0 {
+		b.WriteString("\n## Commands\n\n")
+		for _, c := range p.Commands {
+			fmt.Fprintf(&b, "- %s: `%s`\n", c.Purpose, c.Run)
+		}
+	}
+	if len(p.Dirs) > 0 {
+		b.WriteString("\n## Structure\n\n")
+		for _, d := range p.Dirs {
+			indent := strings.Repeat("  ", strings.Count(d, "/"))
+			fmt.Fprintf(&b, "%s- `%s/`\n", indent, d)
+		}
+	}
+	b.WriteString("\n## Conventions\n\n- Follow the style of the surrounding code.\n- Run the tests before finishing a change.\n")
+	return b.String()
+}
+
+// LoadInstructions returns the contents of the workspace's CASTOR.md, or
+// an empty string when there is none. Files longer than
+// MaxInstructionsBytes are cut short.
+func LoadInstructions(root string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(root, InstructionsFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", InstructionsFile, err)
+	}
+	if len(data) > MaxInstructionsBytes {
+		data = append(data[:MaxInstructionsBytes], "\n[truncated]"...)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
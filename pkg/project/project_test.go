@@ -0,0 +1,79 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func write(t *testing.T, root, name, content string) {
+	t.Helper()
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	root := t.TempDir()
+	write(t, root, "go.mod", "module example.com/widget\n\ngo 1.25\n")
+	write(t, root, "Makefile", ".PHONY: build test\nGOFLAGS := -v\n\nbuild:\n\tgo build ./cmd/widget\n\ntest: build\n\tgo test ./...\n")
+	write(t, root, ".gitignore", "dist/\n")
+	write(t, root, "cmd/widget/main.go", "package main\n")
+	write(t, root, "pkg/a/b/c/deep.go", "package c\n")
+	write(t, root, "dist/out/bin", "")
+	write(t, root, ".github/workflows/ci.yml", "")
+
+	p, err := Detect(root)
+	if err != nil {
+		t.Fatalf("Detect failed: %v", err)
+	}
+	if p.Name != "example.com/widget" {
+		t.Errorf("Name = %q", p.Name)
+	}
+	if !reflect.DeepEqual(p.Languages, []string{"Go"}) {
+		t.Errorf("Languages = %v", p.Languages)
+	}
+	want := []Command{
+		{"build", "make build"},
+		{"test", "make test"},
+		{"lint", "go vet ./..."},
+		{"fmt", "gofmt -l -w ."},
+	}
+	if !reflect.DeepEqual(p.Commands, want) {
+		t.Errorf("Commands = %v, want %v", p.Commands, want)
+	}
+	if want := []string{"cmd", "cmd/widget", "pkg", "pkg/a"}; !reflect.DeepEqual(p.Dirs, want) {
+		t.Errorf("Dirs = %v, want %v", p.Dirs, want)
+	}
+
+	md := p.Markdown()
+	for _, s := range []string{"# example.com/widget", "- test: `make test`", "  - `cmd/widget/`"} {
+		if !strings.Contains(md, s) {
+			t.Errorf("Markdown lacks %q:\n%s", s, md)
+		}
+	}
+}
+
+func TestLoadInstructions(t *testing.T) {
+	root := t.TempDir()
+	if got, err := LoadInstructions(root); err != nil || got != "" {
+		t.Errorf("LoadInstructions without a file = %q, %v", got, err)
+	}
+
+	write(t, root, InstructionsFile, "\nUse tabs.\n")
+	if got, err := LoadInstructions(root); err != nil || got != "Use tabs." {
+		t.Errorf("LoadInstructions = %q, %v", got, err)
+	}
+
+	write(t, root, InstructionsFile, strings.Repeat("x", MaxInstructionsBytes+10))
+	got, err := LoadInstructions(root)
+	if err != nil || !strings.HasSuffix(got, "[truncated]") || len(got) > MaxInstructionsBytes+20 {
+		t.Errorf("LoadInstructions of a long file: %d bytes, %v", len(got), err)
+	}
+}
@@ -0,0 +1,149 @@
+// Package prompts stores reusable prompt templates, so that recurring
+// tasks can be run by name.
+package prompts
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// ext is the file extension of stored templates.
+const ext = ".tmpl"
+
+// ErrNotFound is returned for a template that is not stored.
+var ErrNotFound = errors.New("prompt not found")
+
+// validName matches the names templates may be saved under.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Template is a stored prompt. Its text is a Go template whose fields are
+// the arguments it is run with: {{.arg1}}, {{.arg2}}, and so on for
+// positional arguments, {{.args}} for all of them, and {{.name}} for
+// name=value arguments.
+type Template struct {
+	Name string
+	Text string
+}
+
+// Store keeps templates as files in a directory, one per template.
+type Store struct {
+	Dir string
+}
+
+// DefaultDir returns the prompts directory in the user's config directory.
+func DefaultDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find config directory: %w", err)
+	}
+	return filepath.Join(dir, "castor", "prompts"), nil
+}
+
+// Save stores a template under name, replacing any with the same name.
+// The text must parse as a template.
+func (s Store) Save(name, text string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid prompt name %q: use letters, digits, '.', '_', and '-'", name)
+	}
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("prompt %q is empty", name)
+	}
+	if _, err := parse(name, text); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create prompts directory: %w", err)
+	}
+	if err := os.WriteFile(s.path(name), []byte(text), 0644); err != nil {
+		return fmt.Errorf("failed to save prompt: %w", err)
+	}
+	return nil
+}
+
+// Load returns the template stored under name.
+func (s Store) Load(name string) (Template, error) {
+	if !validName.MatchString(name) {
+		return Template{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	data, err := os.ReadFile(s.path(name))
+	if os.IsNotExist(err) {
+		return Template{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return Template{}, fmt.Errorf("failed to read prompt: %w", err)
+	}
+	return Template{Name: name, Text: string(data)}, nil
+}
+
+// List returns the stored templates, sorted by name. A missing directory
+// holds none.
+func (s Store) List() ([]Template, error) {
+	matches, err := filepath.Glob(filepath.Join(s.Dir, "*"+ext))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list prompts: %w", err)
+	}
+	sort.Strings(matches)
+
+	var templates []Template
+	for _, path := range matches {
+		t, err := s.Load(strings.TrimSuffix(filepath.Base(path), ext))
+		if err != nil {
+			continue // Not a template we could have saved
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+func (s Store) path(name string) string {
+	return filepath.Join(s.Dir, name+ext)
+}
+
+// Args builds the data a template is run with from command-line
+// arguments. Arguments of the form name=value set {{.name}}; the others
+// are numbered from {{.arg1}}, and are joined by spaces in {{.args}}.
+func Args(args []string) map[string]string {
+	data := make(map[string]string)
+	var positional []string
+	for _, arg := range args {
+		if name, value, ok := strings.Cut(arg, "="); ok && validName.MatchString(name) {
+			data[name] = value
+			continue
+		}
+		positional = append(positional, arg)
+		data["arg"+strconv.Itoa(len(positional))] = arg
+	}
+	if _, ok := data["args"]; !ok {
+		data["args"] = strings.Join(positional, " ")
+	}
+	return data
+}
+
+// Render fills in the template with data. Fields missing from data are an
+// error, so a forgotten argument is not sent as "<no value>".
+func (t Template) Render(data map[string]string) (string, error) {
+	tmpl, err := parse(t.Name, t.Text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to fill in prompt %q: %w", t.Name, err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func parse(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt template: %w", err)
+	}
+	return tmpl, nil
+}
@@ -0,0 +1,78 @@
+package prompts
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	s := Store{Dir: t.TempDir()}
+
+	t.Run("SaveAndLoad", func(t *testing.T) {
+		if err := s.Save("commit", "Write a commit message for {{.arg1}}"); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		if err := s.Save("review", "Review {{.args}}"); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		got, err := s.Load("commit")
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if got.Text != "Write a commit message for {{.arg1}}" {
+			t.Errorf("Unexpected text: %q", got.Text)
+		}
+
+		list, err := s.List()
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		var names []string
+		for _, p := range list {
+			names = append(names, p.Name)
+		}
+		if !reflect.DeepEqual(names, []string{"commit", "review"}) {
+			t.Errorf("Unexpected names: %v", names)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		if _, err := s.Load("nope"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+		if list, err := (Store{Dir: t.TempDir() + "/missing"}).List(); err != nil || len(list) != 0 {
+			t.Errorf("Expected no prompts in a missing directory, got %v, %v", list, err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for name, text := range map[string]string{
+			"../escape": "hi",
+			"empty":     "  ",
+			"broken":    "{{.arg1",
+		} {
+			if err := s.Save(name, text); err == nil {
+				t.Errorf("Save(%q, %q) succeeded", name, text)
+			}
+		}
+	})
+}
+
+func TestRender(t *testing.T) {
+	tmpl := Template{Name: "t", Text: "Fix {{.arg1}} in {{.file}} ({{.args}})\n"}
+
+	got, err := tmpl.Render(Args([]string{"the bug", "file=main.go", "quickly"}))
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if want := "Fix the bug in main.go (the bug quickly)"; got != want {
+		t.Errorf("Render = %q, want %q", got, want)
+	}
+
+	_, err = tmpl.Render(Args([]string{"the bug"}))
+	if err == nil || !strings.Contains(err.Error(), "file") {
+		t.Errorf("Expected an error naming the missing field, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+// Package secrets stores credentials such as OAuth tokens outside of
+// castor's plain-text files, in the operating system's keyring.
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/zalando/go-keyring"
+)
+
+// Service is the keyring service name under which castor stores secrets.
+const Service = "castor"
+
+// ErrNotFound is returned when no secret is stored under a key.
+var ErrNotFound = errors.New("secret not found")
+
+// Store saves and retrieves secrets by key.
+type Store interface {
+	Get(key string) (string, error)
+	Set(key, value string) error
+	Delete(key string) error
+}
+
+// Keyring is the Store backed by the OS keyring (Keychain, Secret Service,
+// or Windows Credential Manager).
+type Keyring struct{}
+
+func (Keyring) Get(key string) (string, error) {
+	value, err := keyring.Get(Service, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read keyring: %w", err)
+	}
+	return value, nil
+}
+
+func (Keyring) Set(key, value string) error {
+	if err := keyring.Set(Service, key, value); err != nil {
+		return fmt.Errorf("failed to write keyring: %w", err)
+	}
+	return nil
+}
+
+func (Keyring) Delete(key string) error {
+	err := keyring.Delete(Service, key)
+	if err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("failed to delete from keyring: %w", err)
+	}
+	return nil
+}
+
+// Memory is an in-process Store, for tests and for systems without a
+// keyring.
+type Memory struct {
+	mu      sync.Mutex
+	secrets map[string]string
+}
+
+func (m *Memory) Get(key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.secrets[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (m *Memory) Set(key, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.secrets == nil {
+		m.secrets = make(map[string]string)
+	}
+	m.secrets[key] = value
+	return nil
+}
+
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.secrets, key)
+	return nil
+}
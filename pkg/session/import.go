@@ -0,0 +1,390 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// ImportFormats lists the formats of other tools that Import reads:
+// ChatGPT data exports (conversations.json), Claude Code project
+// transcripts (~/.claude/projects/*/*.jsonl), and aider chat histories
+// (.aider.chat.history.md).
+var ImportFormats = []string{"openai", "claude", "aider"}
+
+// Import converts the conversations another tool saved in data into
+// sessions. An empty format is detected from data. Conversations without
+// messages are skipped.
+func Import(data []byte, format string) ([]*agent.Session, error) {
+	if format == "" {
+		format = DetectFormat(data)
+		if format == "" {
+			return nil, fmt.Errorf("unrecognized import format (expected %s)", strings.Join(ImportFormats, ", "))
+		}
+	}
+	var sessions []*agent.Session
+	var err error
+	switch format {
+	case "openai":
+		sessions, err = importOpenAI(data)
+	case "claude":
+		sessions, err = importClaude(data)
+	case "aider":
+		sessions, err = importAider(data)
+	default:
+		return nil, fmt.Errorf("invalid import format %q (expected %s)", format, strings.Join(ImportFormats, ", "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to import %s conversations: %w", format, err)
+	}
+	return slices.DeleteFunc(sessions, func(s *agent.Session) bool { return len(s.History) == 0 }), nil
+}
+
+// DetectFormat guesses which of ImportFormats data is in, or returns "".
+func DetectFormat(data []byte) string {
+	data = bytes.TrimSpace(data)
+	if json.Valid(data) && len(data) > 0 && (data[0] == '[' || bytes.Contains(data, []byte(`"mapping"`))) {
+		return "openai"
+	}
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	if json.Valid(line) && len(line) > 0 && line[0] == '{' {
+		return "claude"
+	}
+	if bytes.HasPrefix(data, []byte(aiderStart)) || bytes.Contains(data, []byte("\n"+aiderUser)) {
+		return "aider"
+	}
+	return ""
+}
+
+// Import converts the conversations in data, as Import does, and saves
+// them as new sessions in the store.
+func (s Store) Import(data []byte, format string) ([]Info, error) {
+	sessions, err := Import(data, format)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]Info, 0, len(sessions))
+	for _, session := range sessions {
+		path := s.newPath()
+		if err := session.Save(path); err != nil {
+			return infos, err
+		}
+		info, err := s.Find(path)
+		if err != nil {
+			return infos, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// newPath returns a path for a new session that no file has yet, even
+// when several are saved within the same millisecond.
+func (s Store) newPath() string {
+	path := agent.NewSessionPath(s.Dir)
+	stem := agent.TrimSessionExt(path)
+	for n := 2; ; n++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = fmt.Sprintf("%s-%d%s", stem, n, agent.SessionExt)
+	}
+}
+
+// appendText adds text from role to history, joining it to the last
+// message when that is from the same role, as the tools imported from
+// split one reply into several records.
+func appendText(history []llm.Message, role llm.Role, text string) []llm.Message {
+	if strings.TrimSpace(text) == "" {
+		return history
+	}
+	part := llm.TextPart{Text: text}
+	if n := len(history); n > 0 && history[n-1].Role == role && role != llm.RoleTool {
+		history[n-1].Content = append(history[n-1].Content, part)
+		return history
+	}
+	return append(history, llm.Message{Role: role, Content: []llm.Part{part}})
+}
+
+// stamp fills in the update time of an imported session, which falls back
+// to its creation time and then to now.
+func stamp(s *agent.Session) {
+	if s.Updated.IsZero() {
+		s.Updated = s.Created
+	}
+	if s.Updated.IsZero() {
+		s.Updated = time.Now()
+	}
+}
+
+// openAIConversation is a conversation of a ChatGPT data export, whose
+// messages form a tree of edits and regenerations.
+type openAIConversation struct {
+	Title       string  `json:"title"`
+	CreateTime  float64 `json:"create_time"`
+	UpdateTime  float64 `json:"update_time"`
+	CurrentNode string  `json:"current_node"`
+	Model       string  `json:"default_model_slug"`
+	Mapping     map[string]struct {
+		Parent  string `json:"parent"`
+		Message *struct {
+			Author struct {
+				Role string `json:"role"`
+			} `json:"author"`
+			Content struct {
+				Parts []json.RawMessage `json:"parts"`
+				Text  string            `json:"text"`
+			} `json:"content"`
+		} `json:"message"`
+	} `json:"mapping"`
+}
+
+// importOpenAI reads a ChatGPT export, a list of conversations or a single
+// one. Only the branch of each conversation that was last shown is kept,
+// and system and tool messages are left out.
+func importOpenAI(data []byte) ([]*agent.Session, error) {
+	var conversations []openAIConversation
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		conversations = make([]openAIConversation, 1)
+		if err := json.Unmarshal(data, &conversations[0]); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(data, &conversations); err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*agent.Session, 0, len(conversations))
+	for _, c := range conversations {
+		if c.Mapping == nil {
+			return nil, fmt.Errorf("conversation %q has no messages mapping", c.Title)
+		}
+		var branch []string
+		for id, seen := c.CurrentNode, 0; id != "" && seen <= len(c.Mapping); seen++ {
+			branch = append(branch, id)
+			id = c.Mapping[id].Parent
+		}
+		slices.Reverse(branch)
+
+		s := &agent.Session{Title: c.Title, Model: c.Model, Created: unixTime(c.CreateTime), Updated: unixTime(c.UpdateTime)}
+		for _, id := range branch {
+			msg := c.Mapping[id].Message
+			if msg == nil {
+				continue
+			}
+			role := llm.RoleUser
+			switch msg.Author.Role {
+			case "user":
+			case "assistant":
+				role = llm.RoleModel
+			default:
+				continue
+			}
+			texts := []string{msg.Content.Text}
+			for _, raw := range msg.Content.Parts {
+				var text string
+				if json.Unmarshal(raw, &text) == nil { // Images and files are objects
+					texts = append(texts, text)
+				}
+			}
+			s.History = appendText(s.History, role, strings.TrimSpace(strings.Join(texts, "\n")))
+		}
+		stamp(s)
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+// unixTime converts the fractional Unix times of ChatGPT exports.
+func unixTime(seconds float64) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(int64(seconds * 1000))
+}
+
+// claudeRecord is a line of a Claude Code transcript.
+type claudeRecord struct {
+	Type      string    `json:"type"`
+	Summary   string    `json:"summary"`
+	Timestamp time.Time `json:"timestamp"`
+	Cwd       string    `json:"cwd"`
+	Sidechain bool      `json:"isSidechain"`
+	Meta      bool      `json:"isMeta"`
+	Message   struct {
+		Model   string          `json:"model"`
+		Content json.RawMessage `json:"content"`
+	} `json:"message"`
+}
+
+// claudeBlock is a content block of a Claude Code message.
+type claudeBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Input     map[string]any  `json:"input"`
+	ToolUseID string          `json:"tool_use_id"`
+	Content   json.RawMessage `json:"content"`
+}
+
+// importClaude reads a Claude Code transcript, a JSON record per line, as
+// one session. Tool calls and results are kept; the messages of subagents
+// and thinking are left out.
+func importClaude(data []byte) ([]*agent.Session, error) {
+	s := &agent.Session{}
+	toolNames := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 64<<20)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec claudeRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if rec.Type == "summary" && s.Title == "" {
+			s.Title = rec.Summary
+		}
+		if rec.Type != "user" && rec.Type != "assistant" || rec.Sidechain || rec.Meta {
+			continue
+		}
+		if s.Created.IsZero() {
+			s.Created = rec.Timestamp
+		}
+		if rec.Timestamp.After(s.Updated) {
+			s.Updated = rec.Timestamp
+		}
+		if s.Workspace == "" {
+			s.Workspace = rec.Cwd
+		}
+		if rec.Message.Model != "" && !strings.HasPrefix(rec.Message.Model, "<") {
+			s.Model = rec.Message.Model
+		}
+
+		role := llm.RoleUser
+		if rec.Type == "assistant" {
+			role = llm.RoleModel
+		}
+		var text string
+		if json.Unmarshal(rec.Message.Content, &text) == nil {
+			s.History = appendText(s.History, role, text)
+			continue
+		}
+		var blocks []claudeBlock
+		if err := json.Unmarshal(rec.Message.Content, &blocks); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		for _, b := range blocks {
+			switch b.Type {
+			case "text":
+				s.History = appendText(s.History, role, b.Text)
+			case "tool_use":
+				toolNames[b.ID] = b.Name
+				call := llm.ToolCallPart{ID: b.ID, Name: b.Name, Args: b.Input}
+				if n := len(s.History); n > 0 && s.History[n-1].Role == llm.RoleModel {
+					s.History[n-1].Content = append(s.History[n-1].Content, call)
+				} else {
+					s.History = append(s.History, llm.Message{Role: llm.RoleModel, Content: []llm.Part{call}})
+				}
+			case "tool_result":
+				s.History = append(s.History, llm.Message{Role: llm.RoleTool, Content: []llm.Part{
+					llm.ToolResponsePart{ID: b.ToolUseID, Name: toolNames[b.ToolUseID], Content: claudeResult(b.Content)},
+				}})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	stamp(s)
+	return []*agent.Session{s}, nil
+}
+
+// claudeResult returns the text of a tool result, which is a string or a
+// list of blocks.
+func claudeResult(content json.RawMessage) string {
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return text
+	}
+	var blocks []claudeBlock
+	_ = json.Unmarshal(content, &blocks)
+	var texts []string
+	for _, b := range blocks {
+		if b.Type == "text" {
+			texts = append(texts, b.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// The markers of an aider chat history: each chat starts with a heading,
+// the user's lines are level-four headings, and aider's own output is
+// quoted. Everything else is the model's replies.
+const (
+	aiderStart = "# aider chat started at "
+	aiderUser  = "#### "
+	aiderQuote = ">"
+	aiderModel = "> Model: "
+)
+
+// importAider reads an aider chat history, in which each chat becomes a
+// session. aider's own output, such as the edits it applied, is left out.
+func importAider(data []byte) ([]*agent.Session, error) {
+	var sessions []*agent.Session
+	var s *agent.Session
+	var role llm.Role
+	var lines []string
+	flush := func() {
+		if s != nil {
+			s.History = appendText(s.History, role, strings.TrimSpace(strings.Join(lines, "\n")))
+		}
+		lines = nil
+	}
+	add := func(r llm.Role, line string) {
+		if r != role {
+			flush()
+			role = r
+		}
+		lines = append(lines, line)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.HasPrefix(line, aiderStart):
+			flush()
+			s = &agent.Session{}
+			if t, err := time.ParseInLocation("2006-01-02 15:04:05", strings.TrimPrefix(line, aiderStart), time.Local); err == nil {
+				s.Created = t
+			}
+			sessions = append(sessions, s)
+		case s == nil:
+			// Text before the first chat
+		case strings.HasPrefix(line, aiderUser) || line == strings.TrimSpace(aiderUser):
+			add(llm.RoleUser, strings.TrimPrefix(line, aiderUser))
+		case strings.HasPrefix(line, aiderModel):
+			if model, _, _ := strings.Cut(strings.TrimPrefix(line, aiderModel), " "); s.Model == "" {
+				s.Model = model
+			}
+		case strings.HasPrefix(line, aiderQuote):
+		default:
+			add(llm.RoleModel, line)
+		}
+	}
+	flush()
+	for _, s := range sessions {
+		stamp(s)
+	}
+	return sessions, nil
+}
@@ -0,0 +1,132 @@
+package session
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+const openAIExport = `[{
+  "title": "Regex help",
+  "create_time": 1700000000.5,
+  "update_time": 1700000100.0,
+  "default_model_slug": "gpt-4o",
+  "current_node": "c",
+  "mapping": {
+    "root": {"message": null, "parent": null},
+    "s": {"parent": "root", "message": {"author": {"role": "system"}, "content": {"content_type": "text", "parts": [""]}}},
+    "u": {"parent": "s", "message": {"author": {"role": "user"}, "content": {"content_type": "text", "parts": ["Match digits?"]}}},
+    "old": {"parent": "u", "message": {"author": {"role": "assistant"}, "content": {"content_type": "text", "parts": ["A regenerated reply"]}}},
+    "c": {"parent": "u", "message": {"author": {"role": "assistant"}, "content": {"content_type": "text", "parts": ["Use \\d+."]}}}
+  }
+}]`
+
+const claudeTranscript = `{"type":"summary","summary":"Fix the build"}
+{"type":"user","cwd":"/src/app","timestamp":"2025-01-02T10:00:00Z","message":{"role":"user","content":"The build fails"}}
+{"type":"assistant","timestamp":"2025-01-02T10:00:05Z","message":{"model":"claude-sonnet-4","content":[{"type":"thinking","thinking":"hmm"},{"type":"text","text":"Let me look."}]}}
+{"type":"assistant","timestamp":"2025-01-02T10:00:06Z","message":{"model":"claude-sonnet-4","content":[{"type":"tool_use","id":"t1","name":"Bash","input":{"command":"make"}}]}}
+{"type":"user","timestamp":"2025-01-02T10:00:09Z","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":[{"type":"text","text":"missing import"}]}]}}
+{"type":"assistant","isSidechain":true,"timestamp":"2025-01-02T10:00:10Z","message":{"content":[{"type":"text","text":"a subagent"}]}}
+{"type":"assistant","timestamp":"2025-01-02T10:00:12Z","message":{"model":"claude-sonnet-4","content":[{"type":"text","text":"Fixed the import."}]}}
+`
+
+const aiderHistory = `
+# aider chat started at 2024-05-01 10:00:00
+
+> Aider v0.50.0
+> Model: gpt-4o with diff edit format
+
+#### add a docstring
+#### to foo
+
+Here is the docstring.
+
+> Applied edit to foo.py
+
+# aider chat started at 2024-05-02 09:00:00
+
+#### hello
+
+Hi!
+`
+
+func TestImport(t *testing.T) {
+	for _, tc := range []struct{ data, format string }{
+		{openAIExport, "openai"},
+		{claudeTranscript, "claude"},
+		{aiderHistory, "aider"},
+		{"plain notes", ""},
+	} {
+		if got := DetectFormat([]byte(tc.data)); got != tc.format {
+			t.Errorf("DetectFormat of %s = %q", tc.format, got)
+		}
+	}
+
+	t.Run("openai", func(t *testing.T) {
+		sessions, err := Import([]byte(openAIExport), "")
+		if err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+		s := sessions[0]
+		if len(sessions) != 1 || s.Title != "Regex help" || s.Model != "gpt-4o" || s.Created.Unix() != 1700000000 {
+			t.Fatalf("sessions = %+v", sessions)
+		}
+		if len(s.History) != 2 || s.History[1].Content[0].(llm.TextPart).Text != `Use \d+.` {
+			t.Errorf("history = %+v", s.History)
+		}
+	})
+
+	t.Run("claude", func(t *testing.T) {
+		sessions, err := Import([]byte(claudeTranscript), "claude")
+		if err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+		s := sessions[0]
+		if s.Title != "Fix the build" || s.Workspace != "/src/app" || s.Model != "claude-sonnet-4" || s.Updated.Second() != 12 {
+			t.Errorf("session = %+v", s)
+		}
+		roles := make([]llm.Role, len(s.History))
+		for i, msg := range s.History {
+			roles[i] = msg.Role
+		}
+		if fmt.Sprint(roles) != "[user model tool model]" {
+			t.Fatalf("roles = %v", roles)
+		}
+		if call := s.History[1].Content[1].(llm.ToolCallPart); call.Name != "Bash" || call.Args["command"] != "make" {
+			t.Errorf("tool call = %+v", call)
+		}
+		if result := s.History[2].Content[0].(llm.ToolResponsePart); result.Name != "Bash" || result.Content != "missing import" {
+			t.Errorf("tool result = %+v", result)
+		}
+	})
+
+	t.Run("aider", func(t *testing.T) {
+		sessions, err := Import([]byte(aiderHistory), "")
+		if err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+		if len(sessions) != 2 || sessions[0].Model != "gpt-4o" || sessions[1].Created.Day() != 2 {
+			t.Fatalf("sessions = %+v", sessions)
+		}
+		h := sessions[0].History
+		if len(h) != 2 || h[0].Content[0].(llm.TextPart).Text != "add a docstring\nto foo" || h[1].Content[0].(llm.TextPart).Text != "Here is the docstring." {
+			t.Errorf("history = %+v", h)
+		}
+	})
+
+	t.Run("store", func(t *testing.T) {
+		store := Store{Dir: t.TempDir()}
+		infos, err := store.Import([]byte(aiderHistory), "")
+		if err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+		if len(infos) != 2 || infos[0].Path == infos[1].Path || infos[1].Title != "hello" {
+			t.Errorf("imported = %+v", infos)
+		}
+	})
+
+	if _, err := Import([]byte("plain notes"), ""); err == nil {
+		t.Error("Import of an unknown format succeeded")
+	}
+}
@@ -0,0 +1,233 @@
+// Package session manages the sessions saved in a workspace, which are
+// named by short IDs or titles rather than by their file paths.
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/techmuch/castor/pkg/agent"
+)
+
+// ErrNotFound is returned for a reference that names no saved session.
+var ErrNotFound = errors.New("session not found")
+
+// idLen is the length of session IDs.
+const idLen = 8
+
+// minPrefix is the shortest ID prefix a session may be named by.
+const minPrefix = 4
+
+// ID returns the short ID of the session saved at path. It is derived from
+// the file name, so it stays the same as the session is updated.
+func ID(path string) string {
+	name := agent.TrimSessionExt(filepath.Base(path))
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])[:idLen]
+}
+
+// Info summarizes a saved session along with its ID.
+type Info struct {
+	agent.SessionInfo
+	ID string
+}
+
+// Store finds and changes the sessions saved in a directory.
+type Store struct {
+	Dir string
+}
+
+// List returns the saved sessions, most recently updated first.
+func (s Store) List() ([]Info, error) {
+	sessions, err := agent.ListSessions(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]Info, len(sessions))
+	for i, session := range sessions {
+		infos[i] = Info{SessionInfo: session, ID: ID(session.Path)}
+	}
+	return infos, nil
+}
+
+// Find returns the session ref names. A reference is an ID, a prefix of one
+// at least four characters long, a title (ignoring case), or the path of a
+// session file.
+func (s Store) Find(ref string) (Info, error) {
+	infos, err := s.List()
+	if err != nil {
+		return Info{}, err
+	}
+	match := func(pred func(Info) bool) ([]Info, bool) {
+		var found []Info
+		for _, info := range infos {
+			if pred(info) {
+				found = append(found, info)
+			}
+		}
+		return found, len(found) > 0
+	}
+
+	found, ok := match(func(i Info) bool { return i.ID == ref })
+	if !ok {
+		found, ok = match(func(i Info) bool { return sameFile(i.Path, ref) })
+	}
+	if !ok {
+		found, ok = match(func(i Info) bool { return strings.EqualFold(i.Title, ref) })
+	}
+	if !ok && len(ref) >= minPrefix {
+		found, ok = match(func(i Info) bool { return strings.HasPrefix(i.ID, strings.ToLower(ref)) })
+	}
+	switch {
+	case !ok:
+		return Info{}, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	case len(found) > 1:
+		ids := make([]string, len(found))
+		for i, info := range found {
+			ids[i] = info.ID
+		}
+		return Info{}, fmt.Errorf("%q names %d sessions (%s); use an ID", ref, len(found), strings.Join(ids, ", "))
+	}
+	return found[0], nil
+}
+
+// Load reads the session ref names.
+func (s Store) Load(ref string) (*agent.Session, Info, error) {
+	info, err := s.Find(ref)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	session, err := agent.ReadSession(info.Path)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	return session, info, nil
+}
+
+// Rename sets the title of the session ref names. Its update time is kept,
+// so renaming does not reorder the list.
+func (s Store) Rename(ref, title string) (Info, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return Info{}, fmt.Errorf("session title is empty")
+	}
+	info, err := s.Find(ref)
+	if err != nil {
+		return Info{}, err
+	}
+	_, err = agent.UpdateSession(info.Path, func(session *agent.Session) error {
+		session.Title = title
+		return nil
+	})
+	if err != nil {
+		return Info{}, err
+	}
+	info.Title = title
+	return info, nil
+}
+
+// Tag adds and removes tags of the session ref names. Tags are kept
+// sorted, without repeats.
+func (s Store) Tag(ref string, add, remove []string) (Info, error) {
+	for _, tag := range add {
+		if tag == "" || strings.HasPrefix(tag, "-") || strings.ContainsFunc(tag, func(r rune) bool { return unicode.IsSpace(r) || r == ',' }) {
+			return Info{}, fmt.Errorf("invalid tag %q: tags cannot be empty, start with '-', or hold spaces or commas", tag)
+		}
+	}
+	info, err := s.Find(ref)
+	if err != nil {
+		return Info{}, err
+	}
+	session, err := agent.UpdateSession(info.Path, func(session *agent.Session) error {
+		tags := append(slices.Clone(session.Tags), add...)
+		tags = slices.DeleteFunc(tags, func(tag string) bool { return slices.Contains(remove, tag) })
+		slices.Sort(tags)
+		session.Tags = slices.Compact(tags)
+		return nil
+	})
+	if err != nil {
+		return Info{}, err
+	}
+	info.Tags = session.Tags
+	return info, nil
+}
+
+// Fork copies the session ref names, up to the end of its first turns
+// turns or all of it for zero, into a new session in the store.
+func (s Store) Fork(ref string, turns int) (Info, error) {
+	info, err := s.Find(ref)
+	if err != nil {
+		return Info{}, err
+	}
+	path := agent.NewSessionPath(s.Dir)
+	if err := agent.ForkSession(info.Path, path, turns); err != nil {
+		return Info{}, err
+	}
+	return s.Find(path)
+}
+
+// Delete removes the session ref names and returns what it was.
+func (s Store) Delete(ref string) (Info, error) {
+	info, err := s.Find(ref)
+	if err != nil {
+		return Info{}, err
+	}
+	if err := agent.DeleteSession(info.Path); err != nil {
+		return Info{}, err
+	}
+	return info, nil
+}
+
+// PruneOptions selects the sessions Prune deletes.
+type PruneOptions struct {
+	OlderThan  time.Duration // Delete the sessions not updated for this long
+	KeepTagged bool          // Keep the sessions with tags
+	Keep       []string      // Paths of sessions to keep, such as the one in use
+	DryRun     bool          // Only report what would be deleted
+}
+
+// Prune deletes the sessions not updated within opts.OlderThan, along
+// with the blobs left by sessions deleted by hand, and returns the
+// sessions it deleted. Damaged sessions are never deleted, since they
+// cannot be listed.
+func (s Store) Prune(opts PruneOptions) ([]Info, error) {
+	if opts.OlderThan <= 0 {
+		return nil, fmt.Errorf("the age of sessions to prune must be positive")
+	}
+	infos, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-opts.OlderThan)
+	var pruned []Info
+	for _, info := range infos {
+		if !info.Updated.Before(cutoff) || opts.KeepTagged && len(info.Tags) > 0 ||
+			slices.ContainsFunc(opts.Keep, func(path string) bool { return sameFile(path, info.Path) }) {
+			continue
+		}
+		if !opts.DryRun {
+			if err := agent.DeleteSession(info.Path); err != nil {
+				return pruned, err
+			}
+		}
+		pruned = append(pruned, info)
+	}
+	if _, err := agent.SweepBlobs(s.Dir, opts.DryRun); err != nil {
+		return pruned, err
+	}
+	return pruned, nil
+}
+
+// sameFile reports whether two paths name the same file.
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
@@ -0,0 +1,367 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+func save(t *testing.T, dir, name, title string, updated time.Time) string {
+	t.Helper()
+	path := filepath.Join(dir, name+".json")
+	s := &agent.Session{Title: title, Created: updated, Updated: updated}
+	if err := s.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	older := save(t, dir, "a", "Fix the parser", now.Add(-time.Hour))
+	newer := save(t, dir, "b", "Write docs", now)
+	save(t, dir, "c", "write docs", now.Add(-2*time.Hour))
+	store := Store{Dir: dir}
+
+	list, err := store.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 3 || list[0].Path != newer || list[0].ID != ID(newer) || len(list[0].ID) != idLen {
+		t.Fatalf("List = %+v", list)
+	}
+
+	t.Run("find", func(t *testing.T) {
+		for _, ref := range []string{ID(older), ID(older)[:minPrefix], "fix the PARSER", older} {
+			info, err := store.Find(ref)
+			if err != nil || info.Path != older {
+				t.Errorf("Find(%q) = %v, %v", ref, info.Path, err)
+			}
+		}
+		if _, err := store.Find("Write docs"); err == nil || !strings.Contains(err.Error(), "names 2 sessions") {
+			t.Errorf("Find of a shared title: %v", err)
+		}
+		if _, err := store.Find("nope"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Find of an unknown session: %v", err)
+		}
+		if _, err := store.Find(ID(older)[:minPrefix-1]); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Find of a short prefix: %v", err)
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		if _, err := store.Rename(ID(older), "Parser fix"); err != nil {
+			t.Fatalf("Rename failed: %v", err)
+		}
+		session, _, err := store.Load("parser fix")
+		if err != nil || session.Title != "Parser fix" || !session.Updated.Equal(now.Add(-time.Hour)) {
+			t.Errorf("renamed session = %+v, %v", session, err)
+		}
+		if _, err := store.Rename(ID(older), " "); err == nil {
+			t.Error("Rename to an empty title succeeded")
+		}
+	})
+
+	t.Run("tag", func(t *testing.T) {
+		if _, err := store.Tag(ID(older), []string{"bug", "parser", "bug"}, nil); err != nil {
+			t.Fatalf("Tag failed: %v", err)
+		}
+		info, err := store.Tag(ID(older), []string{"ui"}, []string{"parser"})
+		if err != nil || !slices.Equal(info.Tags, []string{"bug", "ui"}) {
+			t.Errorf("Tag = %v, %v", info.Tags, err)
+		}
+		if found, _ := store.Find(ID(older)); !slices.Equal(found.Tags, []string{"bug", "ui"}) {
+			t.Errorf("saved tags = %v", found.Tags)
+		}
+		for _, tag := range []string{"", "-x", "a b", "a,b"} {
+			if _, err := store.Tag(ID(older), []string{tag}, nil); err == nil {
+				t.Errorf("Tag %q succeeded", tag)
+			}
+		}
+	})
+
+	t.Run("fork", func(t *testing.T) {
+		s := &agent.Session{Title: "Three turns", History: []llm.Message{
+			{Role: llm.RoleSystem, Content: []llm.Part{llm.TextPart{Text: "Be brief."}}},
+			{Role: llm.RoleUser, Content: []llm.Part{llm.TextPart{Text: "one"}}},
+			{Role: llm.RoleModel, Content: []llm.Part{llm.TextPart{Text: "1"}}},
+			{Role: llm.RoleUser, Content: []llm.Part{llm.TextPart{Text: "two"}}},
+			{Role: llm.RoleModel, Content: []llm.Part{llm.TextPart{Text: "2"}}},
+			{Role: llm.RoleUser, Content: []llm.Part{llm.TextPart{Text: "three"}}},
+		}}
+		if err := s.Save(filepath.Join(dir, "turns.json")); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := store.Fork("three turns", 2)
+		if err != nil {
+			t.Fatalf("Fork failed: %v", err)
+		}
+		fork, _, err := store.Load(info.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fork.Title != "Three turns (fork)" || len(fork.History) != 5 || info.Turns != 2 {
+			t.Errorf("fork = %q with %d messages, %d turns", fork.Title, len(fork.History), info.Turns)
+		}
+		if _, err := store.Fork("three turns", 4); err == nil {
+			t.Error("Fork past the last turn succeeded")
+		}
+		if err := os.Remove(info.Path); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if _, err := store.Delete(ID(newer)); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if _, err := os.Stat(newer); !os.IsNotExist(err) {
+			t.Errorf("session file still exists: %v", err)
+		}
+		if _, err := store.Find("write docs"); err != nil {
+			t.Errorf("Find after deleting a namesake: %v", err)
+		}
+	})
+}
+
+func TestStoreListsSummaries(t *testing.T) {
+	dir := t.TempDir()
+	store := Store{Dir: dir}
+	s := &agent.Session{History: []llm.Message{
+		{Role: llm.RoleUser, Content: []llm.Part{llm.TextPart{Text: "Fix the parser"}}},
+		{Role: llm.RoleModel, Content: []llm.Part{llm.TextPart{Text: "Done."}}},
+	}}
+	path := filepath.Join(dir, "a.json")
+	if err := s.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := agent.New(nil, "")
+	if err := loaded.LoadSession(path); err != nil || len(loaded.History) != 2 {
+		t.Fatalf("LoadSession read %d messages, %v", len(loaded.History), err)
+	}
+
+	// Listing stops at the history, so a damaged one goes unnoticed
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cut := strings.Index(string(data), `"system_prompt"`)
+	if cut < 0 {
+		t.Fatalf("no system prompt in:\n%s", data)
+	}
+	if err := os.WriteFile(path, append(data[:cut], `"system_prompt": "`...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := store.Find("fix the parser")
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if info.Turns != 1 || info.Tokens == 0 {
+		t.Errorf("summary = %+v", info.SessionInfo)
+	}
+	if _, err := agent.ReadSession(path); err == nil {
+		t.Error("ReadSession of a damaged history succeeded")
+	}
+}
+
+func TestStoreBlobs(t *testing.T) {
+	dir := t.TempDir()
+	store := Store{Dir: dir}
+	result := strings.Repeat("a long tool result\n", agent.MaxStoredResult/10)
+	s := &agent.Session{Title: "Blobs", History: []llm.Message{
+		{Role: llm.RoleUser, Content: []llm.Part{llm.TextPart{Text: "read it"}}},
+		{Role: llm.RoleModel, Content: []llm.Part{llm.ToolCallPart{ID: "1", Name: "read_file"}}},
+		{Role: llm.RoleTool, Content: []llm.Part{llm.ToolResponsePart{ID: "1", Name: "read_file", Content: result}}},
+	}}
+	path := agent.NewSessionPath(dir)
+	if err := s.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() > int64(len(result))/4 {
+		t.Errorf("session file: %v, %v", info, err)
+	}
+
+	loaded, info, err := store.Load("blobs")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := loaded.History[2].Content[0].(llm.ToolResponsePart).Content; got != result {
+		t.Errorf("tool result read back with %d bytes, want %d", len(got), len(result))
+	}
+
+	if _, err := store.Delete(info.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+		t.Errorf("Delete left %s behind", entries[0].Name())
+	}
+}
+
+func TestStoreVersions(t *testing.T) {
+	dir := t.TempDir()
+	store := Store{Dir: dir}
+	unversioned := `{"title": "Old", "system_prompt": "", "history": [{"role": "user", "content": [{"type": "text", "text_part": {"text": "hi"}}]}]}`
+	if err := os.WriteFile(filepath.Join(dir, "old.json"), []byte(unversioned), 0644); err != nil {
+		t.Fatal(err)
+	}
+	newer := `{"version": 99, "title": "New", "history": []}`
+	if err := os.WriteFile(filepath.Join(dir, "new.json"), []byte(newer), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, info, err := store.Load("old")
+	if err != nil {
+		t.Fatalf("Load of an unversioned session failed: %v", err)
+	}
+	if s.Version != agent.SessionVersion || len(s.History) != 1 {
+		t.Errorf("unversioned session loaded as version %d with %d messages", s.Version, len(s.History))
+	}
+	if _, err := store.Rename(info.ID, "Old one"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(info.Path); !strings.Contains(string(data), fmt.Sprintf(`"version": %d`, agent.SessionVersion)) {
+		t.Errorf("saved session lacks its version:\n%s", data)
+	}
+
+	if _, err := agent.ReadSession(filepath.Join(dir, "new.json")); err == nil || !strings.Contains(err.Error(), "newer version") {
+		t.Errorf("ReadSession of a newer session: %v", err)
+	}
+	if _, err := store.Find("New"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Find of a newer session: %v", err)
+	}
+}
+
+func TestStoreConcurrentSaves(t *testing.T) {
+	dir := t.TempDir()
+	store := Store{Dir: dir}
+	path := agent.NewSessionPath(dir)
+	if err := (&agent.Session{Title: "Busy"}).Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s := &agent.Session{Title: "Busy", History: []llm.Message{
+				{Role: llm.RoleUser, Content: []llm.Part{llm.TextPart{Text: strings.Repeat("x", i*1000)}}},
+			}}
+			if err := s.Save(path); err != nil {
+				t.Errorf("Save failed: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := store.Tag("busy", []string{fmt.Sprint("t", i)}, nil); err != nil {
+				t.Errorf("Tag failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := agent.ReadSession(path); err != nil {
+		t.Errorf("ReadSession after concurrent saves: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			t.Errorf("a save left %s behind", entry.Name())
+		}
+	}
+}
+
+func TestStoreUsage(t *testing.T) {
+	dir := t.TempDir()
+	store := Store{Dir: dir}
+	s := &agent.Session{Title: "Costly", History: []llm.Message{
+		{Role: llm.RoleUser, Content: []llm.Part{llm.TextPart{Text: "one"}}},
+		{Role: llm.RoleModel, Content: []llm.Part{llm.TextPart{Text: "1"}}},
+		{Role: llm.RoleUser, Content: []llm.Part{llm.TextPart{Text: "two"}}},
+		{Role: llm.RoleModel, Content: []llm.Part{llm.TextPart{Text: "2"}}},
+	}, Usage: []agent.TurnUsage{
+		{Turn: 1, Model: "gpt-4o", Usage: llm.Usage{PromptTokens: 100, CompletionTokens: 10}, Context: 110, Cost: 0.5},
+		{Turn: 2, Model: "gpt-4o", Usage: llm.Usage{PromptTokens: 200, CompletionTokens: 20}, Context: 220, Cost: 1},
+	}}
+	if err := s.Save(filepath.Join(dir, "costly.json")); err != nil {
+		t.Fatal(err)
+	}
+	save(t, dir, "free", "Free", time.Now())
+
+	list, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := list[slices.IndexFunc(list, func(i Info) bool { return i.Title == "Costly" })]
+	if info.Usage.Total() != 330 || info.Cost != 1.5 || info.Context != 220 {
+		t.Errorf("usage = %+v, cost %v, context %d", info.Usage, info.Cost, info.Context)
+	}
+
+	forked, err := store.Fork("costly", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forked.Cost != 0.5 || forked.Context != 110 {
+		t.Errorf("fork usage = %+v, cost %v, context %d", forked.Usage, forked.Cost, forked.Context)
+	}
+}
+
+func TestStorePrune(t *testing.T) {
+	dir := t.TempDir()
+	store := Store{Dir: dir}
+	now := time.Now()
+	old := save(t, dir, "old", "Old", now.Add(-40*24*time.Hour))
+	save(t, dir, "recent", "Recent", now.Add(-time.Hour))
+	current := save(t, dir, "current", "Current", now.Add(-40*24*time.Hour))
+	save(t, dir, "tagged", "Tagged", now.Add(-40*24*time.Hour))
+	if _, err := store.Tag("tagged", []string{"keep"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	orphan := filepath.Join(dir, "gone.blobs")
+	if err := os.Mkdir(orphan, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(orphan, now.Add(-2*time.Hour), now.Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := PruneOptions{OlderThan: 30 * 24 * time.Hour, KeepTagged: true, Keep: []string{current}, DryRun: true}
+	pruned, err := store.Prune(opts)
+	if err != nil || len(pruned) != 1 || pruned[0].Path != old {
+		t.Fatalf("dry run pruned %+v, %v", pruned, err)
+	}
+	if _, err := os.Stat(old); err != nil {
+		t.Errorf("dry run deleted the session: %v", err)
+	}
+
+	opts.DryRun = false
+	if _, err := store.Prune(opts); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	list, _ := store.List()
+	var titles []string
+	for _, info := range list {
+		titles = append(titles, info.Title)
+	}
+	slices.Sort(titles)
+	if !slices.Equal(titles, []string{"Current", "Recent", "Tagged"}) {
+		t.Errorf("kept %v", titles)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("orphaned blobs were kept: %v", err)
+	}
+	if _, err := store.Prune(PruneOptions{}); err == nil {
+		t.Error("Prune without an age succeeded")
+	}
+}
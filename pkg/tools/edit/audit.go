@@ -0,0 +1,121 @@
+package edit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/techmuch/castor/pkg/agent"
+)
+
+// AuditEntry records a single successful edit.
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	ToolCallID string    `json:"tool_call_id,omitempty"`
+	Path       string    `json:"path"`
+	BeforeHash string    `json:"before_hash,omitempty"`
+	AfterHash  string    `json:"after_hash"`
+	Summary    string    `json:"summary,omitempty"`
+	Diff       string    `json:"diff"`
+}
+
+// AuditLog appends edit records to a JSON Lines file.
+type AuditLog struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// NewAuditLog returns an audit log writing to path.
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{Path: path}
+}
+
+// AuditDir returns the directory holding per-run audit logs for a workspace.
+func AuditDir(workspaceRoot string) string {
+	absRoot, _ := filepath.Abs(workspaceRoot)
+	return filepath.Join(absRoot, ".castor", "edits")
+}
+
+// Append writes an entry to the log.
+func (l *AuditLog) Append(entry AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	f, err := os.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return nil
+}
+
+// ReadAuditLog loads all entries from an audit log file.
+func ReadAuditLog(path string) ([]AuditEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return entries, fmt.Errorf("failed to parse audit entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// hashContent returns the hex SHA-256 of content, matching expected_hash.
+func hashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// audit records an edit from before to after in the audit log, if one is
+// configured. Failures are reported but never undo the edit itself.
+func (t *EditTool) audit(ctx context.Context, path string, before []byte, existed bool, after, summary string) error {
+	if t.Audit == nil {
+		return nil
+	}
+
+	rel := t.relPath(path)
+	entry := AuditEntry{
+		Time:       time.Now(),
+		ToolCallID: agent.ToolCallID(ctx),
+		Path:       rel,
+		AfterHash:  hashContent([]byte(after)),
+		Summary:    summary,
+		Diff:       fileDiff(rel, string(before), existed, after),
+	}
+	if existed {
+		entry.BeforeHash = hashContent(before)
+	}
+	return t.Audit.Append(entry)
+}
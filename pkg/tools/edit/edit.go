@@ -11,22 +11,60 @@ import (
 	"strings"
 
 	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/diff"
 	"github.com/techmuch/castor/pkg/llm"
+	"github.com/techmuch/castor/pkg/tools/workspace"
+	"github.com/techmuch/castor/pkg/vfs"
 )
 
-// Ensure EditTool implements agent.Tool
+// Ensure EditTool implements agent.Tool and agent.FileChanger
 var _ agent.Tool = (*EditTool)(nil)
+var _ agent.FileChanger = (*EditTool)(nil)
+
+// Result describes a completed edit. It is returned to the model as JSON so
+// that new_hash can be passed as expected_hash in follow-up edits.
+type Result struct {
+	Path      string   `json:"path"`
+	Strategy  string   `json:"strategy"`
+	Message   string   `json:"message"`
+	LineRange []int    `json:"line_range,omitempty"` // First and last changed line, 1-based
+	Diff      string   `json:"diff"`
+	NewHash   string   `json:"new_hash"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
 
 // EditTool performs text replacements in files.
 type EditTool struct {
 	WorkspaceRoot string
 	Provider      llm.Provider // Optional: for self-correction
+	FixerProvider llm.Provider // Optional: cheaper model for self-correction, defaults to Provider
+	Journal       *Journal     // Optional: defaults to the workspace undo journal
+	FS            vfs.FS       // Optional: defaults to the local disk
+
+	// FuzzyThreshold is the minimum similarity (0-1) accepted by the local
+	// fuzzy matcher. Zero uses DefaultFuzzyThreshold; negative disables it.
+	FuzzyThreshold float64
+
+	// Validators maps file extensions (e.g. ".go") to post-edit syntax
+	// checks. Nil uses DefaultValidators; an empty map disables validation.
+	Validators map[string]Validator
+
+	// RevertOnInvalid restores the previous content when validation fails.
+	RevertOnInvalid bool
+
+	// FixerMaxContent caps the bytes of file content sent to the fixer.
+	// Larger files are truncated around the most likely match. Zero uses
+	// DefaultFixerMaxContent.
+	FixerMaxContent int
+
+	// Audit, if set, records every successful edit for later review.
+	Audit *AuditLog
 }
 
 func (t *EditTool) Name() string { return "replace" }
 
 func (t *EditTool) Description() string {
-	return "Replaces text within a file. Provide unique old_string to target the change. Supports exact, flexible, and self-correcting matching."
+	return "Replaces text within a file. Provide unique old_string to target the change. Supports exact, flexible, fuzzy, and self-correcting matching. To create a new file, pass an empty old_string and the full content as new_string."
 }
 
 func (t *EditTool) Schema() interface{} {
@@ -37,20 +75,50 @@ func (t *EditTool) Schema() interface{} {
 				"type": "string",
 			},
 			"old_string": map[string]interface{}{
-				"type": "string",
+				"type":        "string",
+				"description": "Text to replace. Leave empty to create a new file.",
 			},
 			"new_string": map[string]interface{}{
 				"type": "string",
 			},
+			"regex": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Treat old_string as a Go regular expression and replace every match. new_string may reference capture groups as $1 or ${name}.",
+			},
+			"replace_all": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Replace every occurrence of old_string instead of requiring a unique match.",
+			},
+			"expected_count": map[string]interface{}{
+				"type":        "integer",
+				"description": "Number of occurrences expected to be replaced with replace_all or regex. The edit is rejected if the actual count differs.",
+			},
+			"start_line": map[string]interface{}{
+				"type":        "integer",
+				"description": "Optional 1-based first line of the region to search, as shown by read_file with line_numbers.",
+			},
+			"end_line": map[string]interface{}{
+				"type":        "integer",
+				"description": "Optional 1-based last line (inclusive) of the region to search.",
+			},
 			"expected_hash": map[string]interface{}{
 				"type":        "string",
-				"description": "SHA-256 hash of the file content before editing. Optional but recommended for safety.",
+				"description": "SHA-256 hash of the file content before editing (e.g. new_hash from a previous edit result). Optional but recommended for safety.",
 			},
 		},
 		"required": []string{"path", "old_string", "new_string"},
 	}
 }
 
+// ChangedFiles implements agent.FileChanger.
+func (t *EditTool) ChangedFiles(args map[string]interface{}) []string {
+	pathStr, _ := args["path"].(string)
+	if targetPath, err := t.resolve(pathStr); err == nil {
+		return []string{targetPath}
+	}
+	return nil
+}
+
 func (t *EditTool) Execute(ctx context.Context, args map[string]interface{}) (interface{}, error) {
 	pathStr, ok := args["path"].(string)
 	if !ok {
@@ -64,21 +132,24 @@ func (t *EditTool) Execute(ctx context.Context, args map[string]interface{}) (in
 	if !ok {
 		return nil, fmt.Errorf("missing new_string")
 	}
-	
+
 	// Optional hash check
 	expectedHash, _ := args["expected_hash"].(string)
 
-	absRoot, _ := filepath.Abs(t.WorkspaceRoot)
-	targetPath := filepath.Join(absRoot, pathStr) 
-	if !strings.HasPrefix(targetPath, absRoot) {
-		return nil, fmt.Errorf("access denied: path outside workspace")
+	targetPath, err := t.resolve(pathStr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create mode: empty old_string targets a file that does not exist yet
+	if oldStr == "" {
+		return t.create(ctx, targetPath, newStr)
 	}
 
-	contentBytes, err := os.ReadFile(targetPath)
+	contentBytes, err := t.fs().ReadFile(targetPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
-	content := string(contentBytes)
 
 	// 0. Verify Hash
 	if expectedHash != "" {
@@ -90,43 +161,242 @@ func (t *EditTool) Execute(ctx context.Context, args map[string]interface{}) (in
 		}
 	}
 
+	newContent, result, err := t.replace(ctx, contentBytes, oldStr, newStr, args)
+	if err != nil {
+		return nil, err
+	}
+	return t.commit(ctx, targetPath, newContent, result)
+}
+
+// resolve maps a workspace-relative path to an absolute path in the workspace.
+func (t *EditTool) resolve(pathStr string) (string, error) {
+	return workspace.ResolveFS(t.fs(), t.WorkspaceRoot, pathStr)
+}
+
+// replace computes the edited file content without touching disk and
+// returns it together with a partial Result naming the strategy that matched.
+func (t *EditTool) replace(ctx context.Context, contentBytes []byte, oldStr, newStr string, args map[string]interface{}) (string, *Result, error) {
+	useRegex, _ := args["regex"].(bool)
+	replaceAll, _ := args["replace_all"].(bool)
+	expectedCount := intArg(args, "expected_count")
+
+	format, content := detectFormat(contentBytes)
+	oldStr, newStr = normalizeNewlines(oldStr), normalizeNewlines(newStr)
+
+	// Constrain matching to the requested line region, if any
+	regionStart, regionEnd, err := lineRegion(content, intArg(args, "start_line"), intArg(args, "end_line"))
+	if err != nil {
+		return "", nil, err
+	}
+	prefix, region, suffix := content[:regionStart], content[regionStart:regionEnd], content[regionEnd:]
+
+	// Regex mode bypasses the matching strategies entirely
+	if useRegex {
+		newRegion, count, err := replaceRegex(region, oldStr, newStr)
+		if err != nil {
+			return "", nil, err
+		}
+		if err := checkCount(count, expectedCount); err != nil {
+			return "", nil, err
+		}
+		return format.apply(prefix + newRegion + suffix), &Result{Strategy: "regex", Message: fmt.Sprintf("Successfully replaced %d occurrence(s) (regex match).", count)}, nil
+	}
+
+	// Bulk mode: replace every exact occurrence, guarded by expected_count
+	if replaceAll {
+		count := strings.Count(region, oldStr)
+		if count == 0 {
+			return "", nil, fmt.Errorf("old_string not found")
+		}
+		if err := checkCount(count, expectedCount); err != nil {
+			return "", nil, err
+		}
+		return format.apply(prefix + strings.ReplaceAll(region, oldStr, newStr) + suffix), &Result{Strategy: "replace_all", Message: fmt.Sprintf("Successfully replaced %d occurrence(s) (exact match).", count)}, nil
+	}
+	if expectedCount > 1 {
+		return "", nil, fmt.Errorf("expected_count %d requires replace_all or regex", expectedCount)
+	}
+
 	// Strategy 1: Exact Match
-	if t.tryExact(targetPath, content, oldStr, newStr) {
-		return "Successfully replaced text (exact match).", nil
+	if newRegion, ok := tryExact(region, oldStr, newStr); ok {
+		return format.apply(prefix + newRegion + suffix), &Result{Strategy: "exact", Message: "Successfully replaced text (exact match)."}, nil
 	}
 
 	// Strategy 2: Flexible Match (Ignore Whitespace)
-	if t.tryFlexible(targetPath, content, oldStr, newStr) {
-		return "Successfully replaced text (flexible match).", nil
+	if newRegion, ok := tryFlexible(region, oldStr, newStr); ok {
+		return format.apply(prefix + newRegion + suffix), &Result{Strategy: "flexible", Message: "Successfully replaced text (flexible match)."}, nil
+	}
+
+	// Strategy 3: Fuzzy Match (Levenshtein over trimmed lines)
+	if threshold := t.fuzzyThreshold(); threshold > 0 {
+		if newRegion, ok := tryFuzzy(region, oldStr, newStr, threshold); ok {
+			return format.apply(prefix + newRegion + suffix), &Result{Strategy: "fuzzy", Message: "Successfully replaced text (fuzzy match)."}, nil
+		}
 	}
 
-	// Strategy 3: Self-Correction (Fixer LLM)
-	if t.Provider != nil {
-		fixedOldStr, err := t.runFixer(ctx, content, oldStr)
+	// Strategy 4: Self-Correction (Fixer LLM)
+	if fixer := t.fixer(); fixer != nil {
+		fixedOldStr, err := t.runFixer(ctx, fixer, fixerContext(region, oldStr, t.fixerMaxContent()), oldStr)
 		if err == nil && fixedOldStr != "" && fixedOldStr != oldStr {
-			if t.tryExact(targetPath, content, fixedOldStr, newStr) {
-				return fmt.Sprintf("Successfully replaced text (auto-corrected old_string)."), nil
+			if newRegion, ok := tryExact(region, fixedOldStr, newStr); ok {
+				return format.apply(prefix + newRegion + suffix), &Result{Strategy: "fixer", Message: "Successfully replaced text (auto-corrected old_string)."}, nil
+			}
+		}
+	}
+
+	if count := strings.Count(region, oldStr); count > 1 {
+		return "", nil, fmt.Errorf("old_string matches %d times; add surrounding context, use start_line/end_line, or set replace_all", count)
+	}
+	return "", nil, fmt.Errorf("old_string not found (tried exact, flexible, fuzzy, and fixer)")
+}
+
+// checkCount enforces the optional expected_count safety check.
+func checkCount(count, expected int) error {
+	if expected > 0 && count != expected {
+		return fmt.Errorf("expected %d occurrence(s) but found %d; no changes made", expected, count)
+	}
+	return nil
+}
+
+func (t *EditTool) fuzzyThreshold() float64 {
+	if t.FuzzyThreshold == 0 {
+		return DefaultFuzzyThreshold
+	}
+	return t.FuzzyThreshold
+}
+
+func (t *EditTool) create(ctx context.Context, path, content string) (interface{}, error) {
+	if _, err := t.fs().Stat(path); err == nil {
+		return nil, fmt.Errorf("file already exists; provide old_string to modify it")
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	if err := t.fs().MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create parent directories: %w", err)
+	}
+	return t.commit(ctx, path, content, &Result{Strategy: "create", Message: "Successfully created file."})
+}
+
+// commit writes the edited content, then runs the post-edit validator for
+// the file type. Validation problems are added to the result's warnings so
+// the model sees them immediately; with RevertOnInvalid the edit is undone
+// instead.
+func (t *EditTool) commit(ctx context.Context, path, content string, result *Result) (interface{}, error) {
+	before, readErr := t.fs().ReadFile(path)
+	if err := t.write(path, content); err != nil {
+		return nil, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	if err := t.validate(ctx, path, []byte(content)); err != nil {
+		if t.RevertOnInvalid {
+			if _, undoErr := t.journal().Undo(1); undoErr != nil {
+				return nil, fmt.Errorf("edit produced invalid syntax and could not be reverted: %v (revert error: %v)", err, undoErr)
 			}
+			return nil, fmt.Errorf("edit reverted because it produced invalid syntax:\n%v", err)
+		}
+		result.Warnings = append(result.Warnings, fmt.Sprintf("the file now has syntax errors:\n%v", err))
+	}
+
+	result.Path = t.relPath(path)
+	result.NewHash = hashContent([]byte(content))
+	result.Diff = fileDiff(result.Path, string(before), readErr == nil, content)
+	if start, end, ok := diff.ChangedLines(string(before), content); ok {
+		result.LineRange = []int{start, end}
+	}
+
+	if err := t.audit(ctx, path, before, readErr == nil, content, result.Message); err != nil {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("failed to record edit in audit log: %v", err))
+	}
+	return result, nil
+}
+
+// relPath returns path relative to the workspace root when possible.
+func (t *EditTool) relPath(path string) string {
+	if absRoot, err := filepath.Abs(t.WorkspaceRoot); err == nil {
+		if rel, err := filepath.Rel(absRoot, path); err == nil {
+			return rel
+		}
+	}
+	return path
+}
+
+// fileDiff returns a unified diff for a workspace file.
+func fileDiff(rel, before string, existed bool, after string) string {
+	from := "a/" + filepath.ToSlash(rel)
+	if !existed {
+		from = "/dev/null"
+	}
+	return diff.Unified(from, "b/"+filepath.ToSlash(rel), before, after)
+}
+
+// intArg reads an optional integer argument; JSON numbers decode as float64.
+func intArg(args map[string]interface{}, name string) int {
+	if v, ok := args[name].(float64); ok {
+		return int(v)
+	}
+	return 0
+}
+
+// lineRegion returns the byte offsets spanning lines start..end (1-based,
+// inclusive). Zero values default to the first and last line respectively.
+func lineRegion(content string, start, end int) (int, int, error) {
+	if start == 0 && end == 0 {
+		return 0, len(content), nil
+	}
+
+	lineStarts := []int{0}
+	for i, r := range content {
+		if r == '\n' && i+1 < len(content) {
+			lineStarts = append(lineStarts, i+1)
 		}
 	}
+	totalLines := len(lineStarts)
 
-	return nil, fmt.Errorf("old_string not found (tried exact, flexible, and fixer)")
+	if start == 0 {
+		start = 1
+	}
+	if end == 0 {
+		end = totalLines
+	}
+	if start < 1 || end < start || end > totalLines {
+		return 0, 0, fmt.Errorf("invalid line range %d-%d (file has %d lines)", start, end, totalLines)
+	}
+
+	regionEnd := len(content)
+	if end < totalLines {
+		regionEnd = lineStarts[end]
+	}
+	return lineStarts[start-1], regionEnd, nil
+}
+
+func replaceRegex(content, pattern, replacement string) (string, int, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid regex: %w", err)
+	}
+
+	matches := re.FindAllStringIndex(content, -1)
+	if len(matches) == 0 {
+		return "", 0, fmt.Errorf("regex %q matched nothing", pattern)
+	}
+
+	return re.ReplaceAllString(content, replacement), len(matches), nil
 }
 
-func (t *EditTool) tryExact(path, content, oldStr, newStr string) bool {
+func tryExact(content, oldStr, newStr string) (string, bool) {
 	if strings.Count(content, oldStr) == 1 {
-		newContent := strings.Replace(content, oldStr, newStr, 1)
-		return t.write(path, newContent) == nil
+		return strings.Replace(content, oldStr, newStr, 1), true
 	}
-	return false
+	return "", false
 }
 
-func (t *EditTool) tryFlexible(path, content, oldStr, newStr string) bool {
+func tryFlexible(content, oldStr, newStr string) (string, bool) {
 	fields := strings.Fields(oldStr)
 	if len(fields) == 0 {
-		return false
+		return "", false
 	}
-	
+
 	var patternBuilder strings.Builder
 	for i, field := range fields {
 		if i > 0 {
@@ -135,31 +405,59 @@ func (t *EditTool) tryFlexible(path, content, oldStr, newStr string) bool {
 		patternBuilder.WriteString(regexp.QuoteMeta(field))
 	}
 	flexiblePattern := patternBuilder.String()
-	
+
 	re, err := regexp.Compile(flexiblePattern)
 	if err != nil {
-		return false
+		return "", false
 	}
 
 	matches := re.FindAllStringIndex(content, -1)
 	if len(matches) == 1 {
 		matchIdx := matches[0]
 		start, end := matchIdx[0], matchIdx[1]
-		newContent := content[:start] + newStr + content[end:]
-		return t.write(path, newContent) == nil
+		return content[:start] + newStr + content[end:], true
 	}
-	return false
+	return "", false
 }
 
 func (t *EditTool) write(path string, content string) error {
-	return os.WriteFile(path, []byte(content), 0644)
+	if err := t.journal().Record(path); err != nil {
+		return err
+	}
+	return t.fs().WriteFile(path, []byte(content), 0644)
+}
+
+func (t *EditTool) journal() *Journal {
+	if t.Journal != nil {
+		return t.Journal
+	}
+	j := NewJournal(t.WorkspaceRoot)
+	j.FS = t.FS
+	return j
+}
+
+func (t *EditTool) fs() vfs.FS {
+	return vfs.Or(t.FS)
 }
 
-func (t *EditTool) runFixer(ctx context.Context, fileContent, brokenOldStr string) (string, error) {
-	// Construct a prompt to find the correct string
-	// We truncate fileContent if it's too huge to avoid token limits,
-	// but for now assume it fits.
-	
+func (t *EditTool) fixer() llm.Provider {
+	if t.FixerProvider != nil {
+		return t.FixerProvider
+	}
+	return t.Provider
+}
+
+func (t *EditTool) fixerMaxContent() int {
+	if t.FixerMaxContent > 0 {
+		return t.FixerMaxContent
+	}
+	return DefaultFixerMaxContent
+}
+
+func (t *EditTool) runFixer(ctx context.Context, provider llm.Provider, fileContent, brokenOldStr string) (string, error) {
+	// Construct a prompt to find the correct string.
+	// fileContent has already been truncated to fit the fixer's budget.
+
 	systemPrompt := "You are a specialized text correction agent. Your job is to find the closest match for a string in a file."
 	userPrompt := fmt.Sprintf(`I want to replace a string in a file, but I can't find an exact match. 
 Here is the string I'm looking for (it might have wrong indentation or whitespace):
@@ -181,8 +479,9 @@ If there is no clear match or multiple matches, return nothing.`, brokenOldStr,
 		{Role: llm.RoleUser, Content: []llm.Part{llm.TextPart{Text: userPrompt}}},
 	}
 
-	opts := llm.GenerateOptions{Temperature: 0.0} // Deterministic
-	stream, err := t.Provider.GenerateContent(ctx, history, opts)
+	var deterministic float32
+	opts := llm.GenerateOptions{Temperature: &deterministic}
+	stream, err := provider.GenerateContent(ctx, history, opts)
 	if err != nil {
 		return "", err
 	}
@@ -4,9 +4,17 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/vfs"
 )
 
 func TestEditTool(t *testing.T) {
@@ -95,6 +103,264 @@ func TestEditTool(t *testing.T) {
 		}
 	})
 
+	t.Run("CreateFile", func(t *testing.T) {
+		_, err := tool.Execute(ctx, map[string]interface{}{
+			"path":       "lib/new.js",
+			"old_string": "",
+			"new_string": "export {};\n",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error creating file: %v", err)
+		}
+		content, err := os.ReadFile(filepath.Join(tmpDir, "lib", "new.js"))
+		if err != nil || string(content) != "export {};\n" {
+			t.Errorf("created file mismatch: %q (%v)", string(content), err)
+		}
+
+		// A second create must not clobber the existing file
+		_, err = tool.Execute(ctx, map[string]interface{}{
+			"path":       "lib/new.js",
+			"old_string": "",
+			"new_string": "overwritten",
+		})
+		if err == nil {
+			t.Error("expected error creating a file that already exists")
+		}
+	})
+
+	t.Run("RegexMatch", func(t *testing.T) {
+		if err := os.WriteFile(targetFile, []byte("import a from 'old/pkg/a';\nimport b from 'old/pkg/b';\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := tool.Execute(ctx, map[string]interface{}{
+			"path":       "code.js",
+			"old_string": `'old/pkg/(\w+)'`,
+			"new_string": "'new/lib/$1'",
+			"regex":      true,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error on regex match: %v", err)
+		}
+
+		content, _ := os.ReadFile(targetFile)
+		expected := "import a from 'new/lib/a';\nimport b from 'new/lib/b';\n"
+		if string(content) != expected {
+			t.Errorf("content mismatch.\nGot: %s\nWant: %s", string(content), expected)
+		}
+
+		_, err = tool.Execute(ctx, map[string]interface{}{
+			"path":       "code.js",
+			"old_string": `nomatch\d+`,
+			"new_string": "x",
+			"regex":      true,
+		})
+		if err == nil {
+			t.Error("expected error when regex matches nothing")
+		}
+	})
+
+	t.Run("LineAnchored", func(t *testing.T) {
+		repeated := "if err != nil {\n\treturn err\n}\nif err != nil {\n\treturn err\n}\n"
+		if err := os.WriteFile(targetFile, []byte(repeated), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		// Without anchors the target is ambiguous
+		_, err := tool.Execute(ctx, map[string]interface{}{
+			"path":       "code.js",
+			"old_string": "return err",
+			"new_string": "return nil",
+		})
+		if err == nil {
+			t.Fatal("expected ambiguous match to fail without line anchors")
+		}
+
+		_, err = tool.Execute(ctx, map[string]interface{}{
+			"path":       "code.js",
+			"old_string": "return err",
+			"new_string": "return nil",
+			"start_line": float64(4),
+			"end_line":   float64(6),
+		})
+		if err != nil {
+			t.Fatalf("unexpected error on anchored edit: %v", err)
+		}
+
+		content, _ := os.ReadFile(targetFile)
+		expected := "if err != nil {\n\treturn err\n}\nif err != nil {\n\treturn nil\n}\n"
+		if string(content) != expected {
+			t.Errorf("content mismatch.\nGot: %s\nWant: %s", string(content), expected)
+		}
+
+		_, err = tool.Execute(ctx, map[string]interface{}{
+			"path":       "code.js",
+			"old_string": "return err",
+			"new_string": "return nil",
+			"start_line": float64(5),
+			"end_line":   float64(99),
+		})
+		if err == nil {
+			t.Error("expected error for out-of-range line anchors")
+		}
+	})
+
+	t.Run("ReplaceAll", func(t *testing.T) {
+		if err := os.WriteFile(targetFile, []byte("foo(); foo(); foo();"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		// Mismatched expected_count must leave the file untouched
+		_, err := tool.Execute(ctx, map[string]interface{}{
+			"path":           "code.js",
+			"old_string":     "foo",
+			"new_string":     "bar",
+			"replace_all":    true,
+			"expected_count": float64(2),
+		})
+		if err == nil {
+			t.Fatal("expected error for mismatched expected_count")
+		}
+		content, _ := os.ReadFile(targetFile)
+		if string(content) != "foo(); foo(); foo();" {
+			t.Fatalf("file modified despite failed count check: %s", string(content))
+		}
+
+		_, err = tool.Execute(ctx, map[string]interface{}{
+			"path":           "code.js",
+			"old_string":     "foo",
+			"new_string":     "bar",
+			"replace_all":    true,
+			"expected_count": float64(3),
+		})
+		if err != nil {
+			t.Fatalf("unexpected error on replace_all: %v", err)
+		}
+		content, _ = os.ReadFile(targetFile)
+		if string(content) != "bar(); bar(); bar();" {
+			t.Errorf("content mismatch: %s", string(content))
+		}
+	})
+
+	t.Run("FuzzyMatch", func(t *testing.T) {
+		source := "func add(a, b int) int {\n\t// add the two numbers together\n\treturn a + b\n}\n"
+		if err := os.WriteFile(targetFile, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		// old_string has a typo and a missing comment word
+		_, err := tool.Execute(ctx, map[string]interface{}{
+			"path":       "code.js",
+			"old_string": "func add(a, b int) int {\n  // add the two numbrs together\n  return a + b",
+			"new_string": "func add(a, b int) int {\n\treturn b + a",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error on fuzzy match: %v", err)
+		}
+
+		content, _ := os.ReadFile(targetFile)
+		expected := "func add(a, b int) int {\n\treturn b + a\n}\n"
+		if string(content) != expected {
+			t.Errorf("content mismatch.\nGot: %s\nWant: %s", string(content), expected)
+		}
+
+		strict := &EditTool{WorkspaceRoot: tmpDir, FuzzyThreshold: -1}
+		_, err = strict.Execute(ctx, map[string]interface{}{
+			"path":       "code.js",
+			"old_string": "return b + c",
+			"new_string": "return 0",
+		})
+		if err == nil {
+			t.Error("expected failure with fuzzy matching disabled")
+		}
+	})
+
+	t.Run("PreservesCRLFAndBOM", func(t *testing.T) {
+		original := "\xEF\xBB\xBFline one\r\nline two\r\nline three"
+		if err := os.WriteFile(targetFile, []byte(original), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := tool.Execute(ctx, map[string]interface{}{
+			"path":       "code.js",
+			"old_string": "line two\nline three",
+			"new_string": "line 2\nline 3\n",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error editing CRLF file: %v", err)
+		}
+
+		content, _ := os.ReadFile(targetFile)
+		expected := "\xEF\xBB\xBFline one\r\nline 2\r\nline 3"
+		if string(content) != expected {
+			t.Errorf("format not preserved.\nGot: %q\nWant: %q", string(content), expected)
+		}
+	})
+
+	t.Run("PreservesMixedLineEndings", func(t *testing.T) {
+		original := "one\r\ntwo\r\nthree\nfour\r\nfive\n"
+		if err := os.WriteFile(targetFile, []byte(original), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := tool.Execute(ctx, map[string]interface{}{
+			"path":       "code.js",
+			"old_string": "three\nfour",
+			"new_string": "3\n3.5\nfour",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error editing mixed file: %v", err)
+		}
+
+		// Only the changed lines take the ending of the line they replace
+		content, _ := os.ReadFile(targetFile)
+		expected := "one\r\ntwo\r\n3\n3.5\nfour\r\nfive\n"
+		if string(content) != expected {
+			t.Errorf("line endings not preserved.\nGot: %q\nWant: %q", string(content), expected)
+		}
+	})
+
+	t.Run("StructuredResult", func(t *testing.T) {
+		setupFile()
+
+		res, err := tool.Execute(ctx, map[string]interface{}{
+			"path":       "code.js",
+			"old_string": "hello world",
+			"new_string": "structured",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		result, ok := res.(*Result)
+		if !ok {
+			t.Fatalf("expected *Result, got %T", res)
+		}
+
+		content, _ := os.ReadFile(targetFile)
+		if result.Path != "code.js" || result.Strategy != "exact" {
+			t.Errorf("unexpected result metadata: %+v", result)
+		}
+		if result.NewHash != hashContent(content) {
+			t.Errorf("new_hash %s does not match file content", result.NewHash)
+		}
+		if len(result.LineRange) != 2 || result.LineRange[0] != 2 || result.LineRange[1] != 2 {
+			t.Errorf("unexpected line range: %v", result.LineRange)
+		}
+		if !strings.Contains(result.Diff, "+    console.log('structured');") {
+			t.Errorf("unexpected diff:\n%s", result.Diff)
+		}
+
+		// The returned hash is accepted as expected_hash for the next edit
+		if _, err := tool.Execute(ctx, map[string]interface{}{
+			"path":          "code.js",
+			"old_string":    "structured",
+			"new_string":    "chained",
+			"expected_hash": result.NewHash,
+		}); err != nil {
+			t.Errorf("follow-up edit with new_hash failed: %v", err)
+		}
+	})
+
 	t.Run("HashVerificationFailure", func(t *testing.T) {
 		setupFile()
 		
@@ -109,3 +375,378 @@ func TestEditTool(t *testing.T) {
 		}
 	})
 }
+
+func TestUndo(t *testing.T) {
+	tmpDir := t.TempDir()
+	targetFile := filepath.Join(tmpDir, "notes.txt")
+	if err := os.WriteFile(targetFile, []byte("alpha beta gamma"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tool := &EditTool{WorkspaceRoot: tmpDir}
+	undo := &UndoTool{WorkspaceRoot: tmpDir}
+	ctx := context.Background()
+
+	edits := []struct{ old, new string }{
+		{"alpha", "one"},
+		{"beta", "two"},
+	}
+	for _, e := range edits {
+		if _, err := tool.Execute(ctx, map[string]interface{}{
+			"path":       "notes.txt",
+			"old_string": e.old,
+			"new_string": e.new,
+		}); err != nil {
+			t.Fatalf("edit failed: %v", err)
+		}
+	}
+
+	if got := NewJournal(tmpDir).Len(); got != 2 {
+		t.Fatalf("expected 2 journal entries, got %d", got)
+	}
+
+	if got := undo.ChangedFiles(map[string]interface{}{}); len(got) != 1 || got[0] != targetFile {
+		t.Errorf("undo changes %v", got)
+	}
+	if _, err := undo.Execute(ctx, map[string]interface{}{}); err != nil {
+		t.Fatalf("undo failed: %v", err)
+	}
+	content, _ := os.ReadFile(targetFile)
+	if string(content) != "one beta gamma" {
+		t.Errorf("after one undo got %q", string(content))
+	}
+
+	if _, err := undo.Execute(ctx, map[string]interface{}{"count": float64(5)}); err != nil {
+		t.Fatalf("undo failed: %v", err)
+	}
+	content, _ = os.ReadFile(targetFile)
+	if string(content) != "alpha beta gamma" {
+		t.Errorf("after full undo got %q", string(content))
+	}
+
+	if _, err := undo.Undo(1); err == nil {
+		t.Error("expected error when journal is empty")
+	}
+}
+
+func TestUndoSameTimestamp(t *testing.T) {
+	tmpDir := t.TempDir()
+	target := filepath.Join(tmpDir, "a.txt")
+	stamp := time.Unix(1700000000, 0)
+	journal := NewJournal(tmpDir)
+	journal.now = func() time.Time { return stamp }
+
+	for _, content := range []string{"one", "two", "three"} {
+		if err := os.WriteFile(target, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := journal.Record(target); err != nil {
+			t.Fatalf("record failed: %v", err)
+		}
+	}
+	os.WriteFile(target, []byte("four"), 0644)
+
+	for _, want := range []string{"three", "two", "one"} {
+		if _, err := journal.Undo(1); err != nil {
+			t.Fatalf("undo failed: %v", err)
+		}
+		if got, _ := os.ReadFile(target); string(got) != want {
+			t.Errorf("after undo got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	ctx := context.Background()
+	root := filepath.Join(string(filepath.Separator), "castor-memfs-ws")
+	mem := vfs.NewMemFS()
+	mem.MkdirAll(root, 0755)
+
+	tool := &EditTool{WorkspaceRoot: root, FS: mem}
+	if _, err := tool.Execute(ctx, map[string]interface{}{
+		"path":       "pkg/a.go",
+		"old_string": "",
+		"new_string": "package a\n\nconst X = 1\n",
+	}); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if _, err := tool.Execute(ctx, map[string]interface{}{
+		"path":       "pkg/a.go",
+		"old_string": "X = 1",
+		"new_string": "X = 2",
+	}); err != nil {
+		t.Fatalf("edit failed: %v", err)
+	}
+
+	target := filepath.Join(root, "pkg", "a.go")
+	content, err := mem.ReadFile(target)
+	if err != nil || !strings.Contains(string(content), "X = 2") {
+		t.Fatalf("expected edited content in memory, got %q (%v)", content, err)
+	}
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing written to disk, stat returned %v", err)
+	}
+
+	undo := &UndoTool{WorkspaceRoot: root, Journal: tool.journal()}
+	if _, err := undo.Undo(2); err != nil {
+		t.Fatalf("undo failed: %v", err)
+	}
+	if _, err := mem.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("expected created file to be removed by undo, got %v", err)
+	}
+}
+
+func TestPostEditValidation(t *testing.T) {
+	tmpDir := t.TempDir()
+	goFile := filepath.Join(tmpDir, "main.go")
+	original := "package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n"
+	ctx := context.Background()
+
+	setup := func() {
+		if err := os.WriteFile(goFile, []byte(original), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	breakIt := map[string]interface{}{
+		"path":       "main.go",
+		"old_string": "println(\"hi\")",
+		"new_string": "println(\"hi\"",
+	}
+
+	t.Run("ReportsErrors", func(t *testing.T) {
+		setup()
+		tool := &EditTool{WorkspaceRoot: tmpDir}
+		res, err := tool.Execute(ctx, breakIt)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		result, ok := res.(*Result)
+		if !ok || len(result.Warnings) == 0 || !strings.Contains(result.Warnings[0], "syntax errors") {
+			t.Errorf("expected syntax warning in result, got %+v", res)
+		}
+	})
+
+	t.Run("RevertOnInvalid", func(t *testing.T) {
+		setup()
+		tool := &EditTool{WorkspaceRoot: tmpDir, RevertOnInvalid: true}
+		if _, err := tool.Execute(ctx, breakIt); err == nil {
+			t.Fatal("expected error for reverted edit")
+		}
+		content, _ := os.ReadFile(goFile)
+		if string(content) != original {
+			t.Errorf("file was not reverted: %s", string(content))
+		}
+	})
+
+	t.Run("CommandUnderOverlay", func(t *testing.T) {
+		if _, err := exec.LookPath("sh"); err != nil {
+			t.Skip("no sh")
+		}
+		setup()
+		check := &CommandValidator{Command: "sh", Args: []string{"-c", `grep -q 'println("hi")$' "$0" || { echo "$0: unclosed call"; exit 1; }`}}
+		tool := &EditTool{WorkspaceRoot: tmpDir, FS: vfs.NewOverlay(vfs.OS), Validators: map[string]Validator{".go": check}}
+		res, err := tool.Execute(ctx, breakIt)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// The checker sees the edit in the overlay, not the file on disk
+		result := res.(*Result)
+		if len(result.Warnings) == 0 || !strings.Contains(result.Warnings[0], goFile+": unclosed call") {
+			t.Errorf("expected the checker's warning for main.go, got %+v", result.Warnings)
+		}
+		if content, _ := os.ReadFile(goFile); string(content) != original {
+			t.Errorf("file on disk changed: %s", content)
+		}
+	})
+}
+
+func TestTransaction(t *testing.T) {
+	tmpDir := t.TempDir()
+	defFile := filepath.Join(tmpDir, "def.go")
+	useFile := filepath.Join(tmpDir, "use.go")
+	if err := os.WriteFile(defFile, []byte("package p\n\nfunc Old() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(useFile, []byte("package p\n\nfunc caller() { Old() }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := &TransactionTool{Editor: &EditTool{WorkspaceRoot: tmpDir}}
+	ctx := context.Background()
+
+	stage := func(path, old, new string) {
+		t.Helper()
+		if _, err := tx.Execute(ctx, map[string]interface{}{
+			"action": "stage", "path": path, "old_string": old, "new_string": new,
+		}); err != nil {
+			t.Fatalf("stage failed: %v", err)
+		}
+	}
+
+	stage("def.go", "func Old()", "func New()")
+	stage("use.go", "Old()", "New()")
+
+	res, err := tx.Execute(ctx, map[string]interface{}{"action": "preview"})
+	if err != nil {
+		t.Fatalf("preview failed: %v", err)
+	}
+	preview, _ := res.(string)
+	if !strings.Contains(preview, "+func New() {}") || !strings.Contains(preview, "+func caller() { New() }") {
+		t.Errorf("preview missing changes:\n%s", preview)
+	}
+
+	// Nothing is written before commit
+	content, _ := os.ReadFile(defFile)
+	if strings.Contains(string(content), "New") {
+		t.Fatal("staged edit was written before commit")
+	}
+
+	// Only a commit changes files: those staged
+	if got := tx.ChangedFiles(map[string]interface{}{"action": "stage", "path": "other.go"}); len(got) != 0 {
+		t.Errorf("stage changes %v", got)
+	}
+	if got := tx.ChangedFiles(map[string]interface{}{"action": "commit"}); strings.Join(got, " ") != defFile+" "+useFile {
+		t.Errorf("commit changes %v", got)
+	}
+
+	if _, err := tx.Execute(ctx, map[string]interface{}{"action": "commit"}); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	def, _ := os.ReadFile(defFile)
+	use, _ := os.ReadFile(useFile)
+	if !strings.Contains(string(def), "func New()") || !strings.Contains(string(use), "New()") {
+		t.Errorf("commit did not apply all edits:\n%s\n%s", def, use)
+	}
+
+	// Rollback discards staged edits
+	stage("def.go", "func New()", "func Other()")
+	if _, err := tx.Execute(ctx, map[string]interface{}{"action": "rollback"}); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	if _, err := tx.Execute(ctx, map[string]interface{}{"action": "commit"}); err == nil {
+		t.Error("expected commit with nothing staged to fail")
+	}
+
+	// Conflicting changes on disk abort the commit
+	stage("def.go", "func New()", "func Other()")
+	if err := os.WriteFile(defFile, []byte("package p\n\nfunc New() { changed() }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Execute(ctx, map[string]interface{}{"action": "commit"}); err == nil {
+		t.Error("expected commit to fail after concurrent modification")
+	}
+}
+
+func TestTransactionRollbackOnWriteFailure(t *testing.T) {
+	tmpDir := t.TempDir()
+	aFile := filepath.Join(tmpDir, "a.txt")
+	cFile := filepath.Join(tmpDir, "c.txt")
+	if err := os.WriteFile(aFile, []byte("alpha\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cFile, []byte("gamma\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// b.txt links into a directory that does not exist, so writing it fails
+	if err := os.Symlink(filepath.Join("missing", "b.txt"), filepath.Join(tmpDir, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := &TransactionTool{Editor: &EditTool{WorkspaceRoot: tmpDir}}
+	ctx := context.Background()
+	for _, args := range []map[string]interface{}{
+		{"action": "stage", "path": "a.txt", "old_string": "alpha", "new_string": "ALPHA"},
+		{"action": "stage", "path": "b.txt", "old_string": "", "new_string": "a brand new file\n"},
+		{"action": "stage", "path": "c.txt", "old_string": "gamma", "new_string": "GAMMA"},
+	} {
+		if _, err := tx.Execute(ctx, args); err != nil {
+			t.Fatalf("stage failed: %v", err)
+		}
+	}
+
+	_, err := tx.Execute(ctx, map[string]interface{}{"action": "commit"})
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("expected rolled back commit, got %v", err)
+	}
+	if got, _ := os.ReadFile(aFile); string(got) != "alpha\n" {
+		t.Errorf("a.txt was not restored: %q", got)
+	}
+	if got, _ := os.ReadFile(cFile); string(got) != "gamma\n" {
+		t.Errorf("c.txt was modified: %q", got)
+	}
+}
+
+func TestFixerContext(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&b, "filler line number %d\n", i)
+	}
+	b.WriteString("func target(x int) error {\n\treturn nil\n}\n")
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&b, "more filler %d\n", i)
+	}
+	content := b.String()
+
+	got := fixerContext(content, "func target(x int)  error {", 400)
+	if len(got) > 400 {
+		t.Errorf("context exceeds budget: %d bytes", len(got))
+	}
+	if !strings.Contains(got, "func target(x int) error {") {
+		t.Errorf("context does not include the candidate region:\n%s", got)
+	}
+
+	small := "short file\n"
+	if fixerContext(small, "short", 400) != small {
+		t.Error("small content should be returned unchanged")
+	}
+
+	// A candidate region larger than the budget is cut down to fit
+	var long strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&long, "\tstep%d := compute(x, %d)\n", i, i)
+	}
+	got = fixerContext(content+long.String(), long.String(), 400)
+	if len(got) > 400 || !strings.Contains(got, "compute(x") {
+		t.Errorf("oversized region not cut to budget: %d bytes", len(got))
+	}
+	oneLine := strings.Repeat("é", 300)
+	if got := fixerContext(oneLine, oneLine, 401); len(got) > 401 || !utf8.ValidString(got) {
+		t.Errorf("single long line not cut to a valid prefix: %d bytes", len(got))
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logPath := filepath.Join(tmpDir, "audit.jsonl")
+	tool := &EditTool{WorkspaceRoot: tmpDir, Audit: NewAuditLog(logPath)}
+	ctx := agent.WithToolCallID(context.Background(), "call_42")
+
+	if _, err := tool.Execute(ctx, map[string]interface{}{
+		"path": "a.txt", "old_string": "hello", "new_string": "goodbye",
+	}); err != nil {
+		t.Fatalf("edit failed: %v", err)
+	}
+
+	entries, err := ReadAuditLog(logPath)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Path != "a.txt" || e.ToolCallID != "call_42" {
+		t.Errorf("unexpected entry metadata: %+v", e)
+	}
+	if e.BeforeHash != hashContent([]byte("hello\n")) || e.AfterHash != hashContent([]byte("goodbye\n")) {
+		t.Errorf("unexpected hashes: %s -> %s", e.BeforeHash, e.AfterHash)
+	}
+	if !strings.Contains(e.Diff, "-hello\n+goodbye\n") {
+		t.Errorf("unexpected diff:\n%s", e.Diff)
+	}
+}
@@ -0,0 +1,106 @@
+package edit
+
+import (
+	"bytes"
+	"strings"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// textFormat captures encoding details of a file that edits must preserve.
+type textFormat struct {
+	bom             bool
+	crlf            bool
+	trailingNewline bool
+
+	// A file mixing CRLF and LF keeps the ending of each line; lines holds
+	// its normalized lines and endings the break after each
+	lines   []string
+	endings []string
+}
+
+// detectFormat inspects raw file content and returns its format along with
+// the content normalized to LF line endings without a BOM.
+func detectFormat(raw []byte) (textFormat, string) {
+	var f textFormat
+	if bytes.HasPrefix(raw, utf8BOM) {
+		f.bom = true
+		raw = raw[len(utf8BOM):]
+	}
+
+	// Treat the file as CRLF when all of its line breaks are CRLF
+	crlfCount := bytes.Count(raw, []byte("\r\n"))
+	lfCount := bytes.Count(raw, []byte("\n"))
+	f.crlf = crlfCount > 0 && crlfCount == lfCount
+	if crlfCount > 0 && crlfCount < lfCount {
+		for _, line := range strings.SplitAfter(string(raw), "\n") {
+			if strings.HasSuffix(line, "\r\n") {
+				f.endings = append(f.endings, "\r\n")
+			} else if strings.HasSuffix(line, "\n") {
+				f.endings = append(f.endings, "\n")
+			}
+		}
+	}
+
+	content := strings.ReplaceAll(string(raw), "\r\n", "\n")
+	if f.endings != nil {
+		f.lines = strings.Split(content, "\n")
+	}
+	f.trailingNewline = strings.HasSuffix(content, "\n")
+	return f, content
+}
+
+// apply converts normalized content back to the original format.
+func (f textFormat) apply(content string) string {
+	if content != "" {
+		hasNewline := strings.HasSuffix(content, "\n")
+		if f.trailingNewline && !hasNewline {
+			content += "\n"
+		} else if !f.trailingNewline && hasNewline {
+			content = strings.TrimSuffix(content, "\n")
+		}
+	}
+	if f.crlf {
+		content = strings.ReplaceAll(content, "\n", "\r\n")
+	} else if f.endings != nil {
+		content = f.restoreEndings(content)
+	}
+	if f.bom {
+		content = string(utf8BOM) + content
+	}
+	return content
+}
+
+// restoreEndings gives the lines of normalized content before and after
+// the edited span their original endings back, and the lines within it the
+// ending of the first line it replaced.
+func (f textFormat) restoreEndings(content string) string {
+	lines := strings.Split(content, "\n")
+	head, tail := 0, 0
+	for head < len(lines) && head < len(f.lines) && lines[head] == f.lines[head] {
+		head++
+	}
+	for tail < len(lines)-head && tail < len(f.lines)-head && lines[len(lines)-1-tail] == f.lines[len(f.lines)-1-tail] {
+		tail++
+	}
+
+	var b strings.Builder
+	for i, line := range lines {
+		b.WriteString(line)
+		switch {
+		case i == len(lines)-1:
+		case i < head && i < len(f.endings):
+			b.WriteString(f.endings[i])
+		case i >= len(lines)-tail:
+			b.WriteString(f.endings[i+len(f.lines)-len(lines)])
+		default:
+			b.WriteString(f.endings[min(head, len(f.endings)-1)])
+		}
+	}
+	return b.String()
+}
+
+// normalizeNewlines converts CRLF line endings to LF.
+func normalizeNewlines(s string) string {
+	return strings.ReplaceAll(s, "\r\n", "\n")
+}
@@ -0,0 +1,209 @@
+package edit
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultFuzzyThreshold is the minimum similarity for a fuzzy match.
+const DefaultFuzzyThreshold = 0.9
+
+// fuzzyMatch is a candidate region found by tryFuzzy.
+type fuzzyMatch struct {
+	start, end int // byte offsets in the content
+	score      float64
+}
+
+// tryFuzzy looks for the block of lines most similar to oldStr using
+// Levenshtein distance over whitespace-trimmed lines. It succeeds only when
+// the best candidate meets the threshold and no other non-overlapping
+// candidate scores about as well.
+func tryFuzzy(content, oldStr, newStr string, threshold float64) (string, bool) {
+	target := normalizeLines(strings.Split(strings.Trim(oldStr, "\n"), "\n"))
+	if strings.TrimSpace(target) == "" {
+		return "", false
+	}
+	targetLines := strings.Count(target, "\n") + 1
+
+	// Byte offsets of each line in the content
+	var starts, ends []int
+	pos := 0
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if line == "" {
+			continue
+		}
+		starts = append(starts, pos)
+		ends = append(ends, pos+len(strings.TrimSuffix(line, "\n")))
+		pos += len(line)
+	}
+	lines := make([]string, len(starts))
+	for i := range starts {
+		lines[i] = content[starts[i]:ends[i]]
+	}
+
+	var candidates []fuzzyMatch
+	for size := targetLines - 1; size <= targetLines+1; size++ {
+		if size < 1 {
+			continue
+		}
+		for i := 0; i+size <= len(lines); i++ {
+			candidate := normalizeLines(lines[i : i+size])
+			if score := similarity(candidate, target, threshold); score >= threshold {
+				candidates = append(candidates, fuzzyMatch{start: starts[i], end: ends[i+size-1], score: score})
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if overlaps(best, c) {
+			continue
+		}
+		if best.score-c.score < 0.02 {
+			return "", false // Ambiguous
+		}
+		break
+	}
+	return content[:best.start] + newStr + content[best.end:], true
+}
+
+func overlaps(a, b fuzzyMatch) bool {
+	return a.start < b.end && b.start < a.end
+}
+
+func normalizeLines(lines []string) string {
+	trimmed := make([]string, len(lines))
+	for i, l := range lines {
+		trimmed[i] = strings.TrimSpace(l)
+	}
+	return strings.Join(trimmed, "\n")
+}
+
+// similarity returns 1 - distance/maxLen. Pairs whose length difference
+// already rules out reaching the threshold are skipped without computing
+// the full distance.
+func similarity(a, b string, threshold float64) float64 {
+	ra, rb := []rune(a), []rune(b)
+	maxLen := max(len(ra), len(rb))
+	if maxLen == 0 {
+		return 1
+	}
+	diff := len(ra) - len(rb)
+	if diff < 0 {
+		diff = -diff
+	}
+	if 1-float64(diff)/float64(maxLen) < threshold {
+		return 0
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(maxLen)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// DefaultFixerMaxContent is the default byte budget for fixer context.
+const DefaultFixerMaxContent = 16000
+
+// fixerContext trims content to at most maxBytes, keeping whole lines
+// centered on the block that shares the most tokens with oldStr. If the
+// block alone is over budget, its lowest-scoring edge lines are dropped,
+// and a single remaining line is cut short.
+func fixerContext(content, oldStr string, maxBytes int) string {
+	if len(content) <= maxBytes {
+		return content
+	}
+
+	lines := strings.SplitAfter(content, "\n")
+	tokens := make(map[string]bool)
+	for _, f := range strings.Fields(oldStr) {
+		tokens[f] = true
+	}
+	window := max(1, strings.Count(strings.Trim(oldStr, "\n"), "\n")+1)
+
+	// Score each line by the number of oldStr tokens it contains, then find
+	// the window of lines with the highest total.
+	scores := make([]int, len(lines))
+	for i, l := range lines {
+		for _, f := range strings.Fields(l) {
+			if tokens[f] {
+				scores[i]++
+			}
+		}
+	}
+	bestStart, bestScore, sum := 0, -1, 0
+	for i := range lines {
+		sum += scores[i]
+		if i >= window {
+			sum -= scores[i-window]
+		}
+		if start := max(0, i-window+1); sum > bestScore {
+			bestStart, bestScore = start, sum
+		}
+	}
+
+	// Grow the region outward one line at a time while it fits the budget
+	lo, hi := bestStart, min(len(lines), bestStart+window)
+	size := 0
+	for _, l := range lines[lo:hi] {
+		size += len(l)
+	}
+	for size > maxBytes && hi-lo > 1 {
+		if scores[lo] < scores[hi-1] {
+			size -= len(lines[lo])
+			lo++
+		} else {
+			hi--
+			size -= len(lines[hi])
+		}
+	}
+	if size > maxBytes {
+		line := lines[lo]
+		cut := maxBytes
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		return line[:cut]
+	}
+	for lo > 0 || hi < len(lines) {
+		grew := false
+		if lo > 0 && size+len(lines[lo-1]) <= maxBytes {
+			lo--
+			size += len(lines[lo])
+			grew = true
+		}
+		if hi < len(lines) && size+len(lines[hi]) <= maxBytes {
+			size += len(lines[hi])
+			hi++
+			grew = true
+		}
+		if !grew {
+			break
+		}
+	}
+
+	return strings.Join(lines[lo:hi], "")
+}
@@ -0,0 +1,284 @@
+package edit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/techmuch/castor/pkg/agent"
+)
+
+// Ensure TransactionTool implements agent.Tool and agent.FileChanger
+var _ agent.Tool = (*TransactionTool)(nil)
+var _ agent.FileChanger = (*TransactionTool)(nil)
+
+// stagedFile is the pending state of one file in a transaction.
+type stagedFile struct {
+	rel      string
+	original []byte
+	existed  bool
+	content  string
+}
+
+// TransactionTool stages edits across several files, previews them as one
+// combined diff, and commits or rolls them back together.
+type TransactionTool struct {
+	Editor *EditTool
+
+	mu     sync.Mutex
+	staged map[string]*stagedFile // Keyed by absolute path
+	order  []string
+}
+
+func (t *TransactionTool) Name() string { return "edit_transaction" }
+
+func (t *TransactionTool) Description() string {
+	return "Stages edits across multiple files and applies them atomically. Use action 'stage' (same arguments as replace) for each change, 'preview' to see the combined diff, then 'commit' to write all files or 'rollback' to discard them."
+}
+
+func (t *TransactionTool) Schema() interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"action": map[string]interface{}{
+				"type": "string",
+				"enum": []string{"stage", "preview", "commit", "rollback"},
+			},
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": "File to edit (stage only).",
+			},
+			"old_string": map[string]interface{}{
+				"type":        "string",
+				"description": "Text to replace (stage only). Leave empty to create a new file.",
+			},
+			"new_string": map[string]interface{}{
+				"type":        "string",
+				"description": "Replacement text (stage only).",
+			},
+			"replace_all": map[string]interface{}{
+				"type": "boolean",
+			},
+			"regex": map[string]interface{}{
+				"type": "boolean",
+			},
+			"expected_count": map[string]interface{}{
+				"type": "integer",
+			},
+		},
+		"required": []string{"action"},
+	}
+}
+
+// ChangedFiles implements agent.FileChanger: only a commit changes files,
+// those staged.
+func (t *TransactionTool) ChangedFiles(args map[string]interface{}) []string {
+	if action, _ := args["action"].(string); action != "commit" {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.order)
+}
+
+func (t *TransactionTool) Execute(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	action, _ := args["action"].(string)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch action {
+	case "stage":
+		return t.stage(ctx, args)
+	case "preview":
+		if len(t.order) == 0 {
+			return "No edits staged.", nil
+		}
+		return t.preview(), nil
+	case "commit":
+		return t.commit(ctx)
+	case "rollback":
+		n := len(t.order)
+		t.reset()
+		return fmt.Sprintf("Discarded %d staged file(s).", n), nil
+	default:
+		return nil, fmt.Errorf("unknown action %q (expected stage, preview, commit, or rollback)", action)
+	}
+}
+
+func (t *TransactionTool) stage(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	pathStr, ok := args["path"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing path")
+	}
+	oldStr, _ := args["old_string"].(string)
+	newStr, ok := args["new_string"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing new_string")
+	}
+
+	targetPath, err := t.Editor.resolve(pathStr)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := t.load(targetPath, pathStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var summary string
+	if oldStr == "" {
+		if file.existed || file.content != "" {
+			return nil, fmt.Errorf("file already exists; provide old_string to modify it")
+		}
+		file.content = newStr
+		summary = "Staged new file"
+	} else {
+		if !file.existed && file.content == "" {
+			return nil, fmt.Errorf("file %s does not exist", pathStr)
+		}
+		newContent, _, err := t.Editor.replace(ctx, []byte(file.content), oldStr, newStr, args)
+		if err != nil {
+			return nil, err
+		}
+		file.content = newContent
+		summary = "Staged edit"
+	}
+
+	if _, exists := t.staged[targetPath]; !exists {
+		if t.staged == nil {
+			t.staged = make(map[string]*stagedFile)
+		}
+		t.staged[targetPath] = file
+		t.order = append(t.order, targetPath)
+	}
+	return fmt.Sprintf("%s to %s (%d file(s) staged).", summary, pathStr, len(t.order)), nil
+}
+
+// load returns the staged state for a file, reading it from disk on first use.
+func (t *TransactionTool) load(targetPath, rel string) (*stagedFile, error) {
+	if f, ok := t.staged[targetPath]; ok {
+		return f, nil
+	}
+
+	content, err := t.Editor.fs().ReadFile(targetPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &stagedFile{rel: rel}, nil
+		}
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return &stagedFile{rel: rel, original: content, existed: true, content: string(content)}, nil
+}
+
+func (t *TransactionTool) preview() string {
+	var b strings.Builder
+	for _, path := range t.order {
+		f := t.staged[path]
+		b.WriteString(fileDiff(f.rel, string(f.original), f.existed, f.content))
+	}
+	return b.String()
+}
+
+func (t *TransactionTool) commit(ctx context.Context) (interface{}, error) {
+	if len(t.order) == 0 {
+		return nil, fmt.Errorf("no edits staged")
+	}
+
+	// Refuse to commit over files that changed since they were staged
+	for _, path := range t.order {
+		f := t.staged[path]
+		current, err := t.Editor.fs().ReadFile(path)
+		switch {
+		case err == nil && (!f.existed || !bytes.Equal(current, f.original)):
+			return nil, fmt.Errorf("%s changed since it was staged; rollback and stage again", f.rel)
+		case err != nil && !os.IsNotExist(err):
+			return nil, fmt.Errorf("failed to read %s: %w", f.rel, err)
+		case err != nil && f.existed:
+			return nil, fmt.Errorf("%s was deleted since it was staged; rollback and stage again", f.rel)
+		}
+	}
+
+	for i, path := range t.order {
+		f := t.staged[path]
+		err := t.Editor.fs().MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = t.Editor.write(path, f.content)
+		}
+		if err != nil {
+			// The failed write may have left the file partly written, so
+			// it is restored along with the ones before it
+			if restoreErr := t.restore(t.order[:i+1]); restoreErr != nil {
+				return nil, fmt.Errorf("failed to write %s: %v; rollback also failed: %v", f.rel, err, restoreErr)
+			}
+			return nil, fmt.Errorf("failed to write %s, transaction rolled back: %w", f.rel, err)
+		}
+	}
+
+	var problems []string
+	for _, path := range t.order {
+		f := t.staged[path]
+		if err := t.Editor.validate(ctx, path, []byte(f.content)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", f.rel, err))
+		}
+	}
+
+	if len(problems) > 0 && t.Editor.RevertOnInvalid {
+		err := t.restore(t.order)
+		t.reset()
+		if err != nil {
+			return nil, fmt.Errorf("transaction produced invalid syntax and could not be reverted: %v", err)
+		}
+		return nil, fmt.Errorf("transaction reverted because it produced invalid syntax:\n%s", strings.Join(problems, "\n"))
+	}
+
+	patch := t.preview()
+	n := len(t.order)
+	staged, order := t.staged, t.order
+	t.reset()
+
+	for _, path := range order {
+		f := staged[path]
+		if err := t.Editor.audit(ctx, path, f.original, f.existed, f.content, "Committed in edit transaction."); err != nil {
+			problems = append(problems, fmt.Sprintf("audit log: %v", err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Sprintf("Committed %d file(s).\nWarning: problems after commit:\n%s\n%s", n, strings.Join(problems, "\n"), patch), nil
+	}
+	return fmt.Sprintf("Committed %d file(s).\n%s", n, patch), nil
+}
+
+// restore puts the given staged files back to their original content,
+// removing those the transaction created.
+func (t *TransactionTool) restore(paths []string) error {
+	var errs []string
+	for _, path := range paths {
+		f := t.staged[path]
+		var err error
+		if f.existed {
+			err = t.Editor.fs().WriteFile(path, f.original, 0644)
+		} else if err = t.Editor.fs().Remove(path); os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", f.rel, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (t *TransactionTool) reset() {
+	t.staged = nil
+	t.order = nil
+}
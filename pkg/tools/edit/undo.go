@@ -0,0 +1,301 @@
+package edit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/vfs"
+)
+
+// Ensure UndoTool implements agent.Tool, agent.Undoer, and agent.FileChanger
+var _ agent.Tool = (*UndoTool)(nil)
+var _ agent.Undoer = (*UndoTool)(nil)
+var _ agent.FileChanger = (*UndoTool)(nil)
+
+// DefaultMaxUndoEntries is the number of backups kept in the undo journal.
+const DefaultMaxUndoEntries = 100
+
+// UndoDir returns the undo journal directory for a workspace root.
+func UndoDir(workspaceRoot string) string {
+	absRoot, _ := filepath.Abs(workspaceRoot)
+	return filepath.Join(absRoot, ".castor", "undo")
+}
+
+// undoEntry is a single backup stored in the undo journal.
+type undoEntry struct {
+	Path    string    `json:"path"`
+	Time    time.Time `json:"time"`
+	Existed bool      `json:"existed"`
+	Content []byte    `json:"content,omitempty"`
+	Mode    uint32    `json:"mode,omitempty"`
+}
+
+// Journal stores pre-edit backups on disk so edits can be reverted.
+// Each entry is a JSON file named by its creation time and a fixed-width
+// sequence number that orders entries made at the same instant, so the journal
+// survives restarts and is shared by every tool pointed at the same directory.
+type Journal struct {
+	Dir        string
+	MaxEntries int
+	FS         vfs.FS // Optional: defaults to the local disk
+
+	now func() time.Time
+}
+
+// NewJournal returns a journal rooted at the workspace's undo directory.
+func NewJournal(workspaceRoot string) *Journal {
+	return &Journal{
+		Dir:        UndoDir(workspaceRoot),
+		MaxEntries: DefaultMaxUndoEntries,
+	}
+}
+
+// Record backs up the current content of path before it is modified.
+// Files that do not exist yet are recorded so that undo removes them.
+func (j *Journal) Record(path string) error {
+	entry := undoEntry{Path: path, Time: j.clock()}
+
+	info, err := j.fs().Stat(path)
+	switch {
+	case err == nil:
+		content, err := j.fs().ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read file for backup: %w", err)
+		}
+		entry.Existed = true
+		entry.Content = content
+		entry.Mode = uint32(info.Mode().Perm())
+	case !os.IsNotExist(err):
+		return fmt.Errorf("failed to stat file for backup: %w", err)
+	}
+
+	if err := j.fs().MkdirAll(j.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create undo journal: %w", err)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal undo entry: %w", err)
+	}
+
+	var name string
+	for seq := 0; ; seq++ {
+		name = fmt.Sprintf("%020d-%04d.json", entry.Time.UnixNano(), seq)
+		if _, err := j.fs().Stat(filepath.Join(j.Dir, name)); os.IsNotExist(err) {
+			break
+		}
+	}
+	if err := j.fs().WriteFile(filepath.Join(j.Dir, name), data, 0644); err != nil {
+		return fmt.Errorf("failed to write undo entry: %w", err)
+	}
+
+	return j.prune()
+}
+
+// Undo reverts the last n recorded edits, newest first, and returns the
+// paths that were restored.
+func (j *Journal) Undo(n int) ([]string, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("number of edits to undo must be positive")
+	}
+
+	names, err := j.entries()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("nothing to undo")
+	}
+
+	var restored []string
+	for i := len(names) - 1; i >= 0 && len(restored) < n; i-- {
+		entryPath := filepath.Join(j.Dir, names[i])
+		entry, err := j.read(names[i])
+		if err != nil {
+			return restored, err
+		}
+
+		if entry.Existed {
+			mode := os.FileMode(entry.Mode)
+			if mode == 0 {
+				mode = 0644
+			}
+			if err := j.fs().WriteFile(entry.Path, entry.Content, mode); err != nil {
+				return restored, fmt.Errorf("failed to restore %s: %w", entry.Path, err)
+			}
+		} else if err := j.fs().Remove(entry.Path); err != nil && !os.IsNotExist(err) {
+			return restored, fmt.Errorf("failed to remove %s: %w", entry.Path, err)
+		}
+
+		if err := j.fs().Remove(entryPath); err != nil {
+			return restored, fmt.Errorf("failed to remove undo entry: %w", err)
+		}
+		restored = append(restored, entry.Path)
+	}
+
+	return restored, nil
+}
+
+// Paths returns the paths of the last n recorded edits, newest first:
+// those Undo(n) would restore.
+func (j *Journal) Paths(n int) ([]string, error) {
+	names, err := j.entries()
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for i := len(names) - 1; i >= 0 && len(paths) < n; i-- {
+		entry, err := j.read(names[i])
+		if err != nil {
+			return paths, err
+		}
+		paths = append(paths, entry.Path)
+	}
+	return paths, nil
+}
+
+// read returns the journal entry in the file called name.
+func (j *Journal) read(name string) (undoEntry, error) {
+	var entry undoEntry
+	data, err := j.fs().ReadFile(filepath.Join(j.Dir, name))
+	if err != nil {
+		return entry, fmt.Errorf("failed to read undo entry: %w", err)
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, fmt.Errorf("failed to parse undo entry %s: %w", name, err)
+	}
+	return entry, nil
+}
+
+func (j *Journal) clock() time.Time {
+	if j.now != nil {
+		return j.now()
+	}
+	return time.Now()
+}
+
+func (j *Journal) fs() vfs.FS {
+	return vfs.Or(j.FS)
+}
+
+// Len returns the number of edits that can currently be undone.
+func (j *Journal) Len() int {
+	names, _ := j.entries()
+	return len(names)
+}
+
+// entries returns the journal file names, oldest first.
+func (j *Journal) entries() ([]string, error) {
+	dirEntries, err := j.fs().ReadDir(j.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read undo journal: %w", err)
+	}
+
+	var names []string
+	for _, e := range dirEntries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// prune drops the oldest entries beyond MaxEntries.
+func (j *Journal) prune() error {
+	if j.MaxEntries <= 0 {
+		return nil
+	}
+	names, err := j.entries()
+	if err != nil {
+		return err
+	}
+	for len(names) > j.MaxEntries {
+		if err := j.fs().Remove(filepath.Join(j.Dir, names[0])); err != nil {
+			return fmt.Errorf("failed to prune undo journal: %w", err)
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// --- Undo Tool ---
+
+// UndoTool reverts the most recent edits recorded in the undo journal.
+type UndoTool struct {
+	WorkspaceRoot string
+	Journal       *Journal // Optional: defaults to the workspace journal
+}
+
+func (t *UndoTool) Name() string { return "undo_edit" }
+
+func (t *UndoTool) Description() string {
+	return "Reverts the most recent file edits, restoring their previous content."
+}
+
+func (t *UndoTool) Schema() interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"count": map[string]interface{}{
+				"type":        "integer",
+				"description": "Number of edits to revert, newest first. Defaults to 1.",
+			},
+		},
+	}
+}
+
+// ChangedFiles implements agent.FileChanger.
+func (t *UndoTool) ChangedFiles(args map[string]interface{}) []string {
+	paths, _ := t.journal().Paths(undoCount(args))
+	return paths
+}
+
+func (t *UndoTool) Execute(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	count := undoCount(args)
+
+	restored, err := t.Undo(count)
+	if err != nil {
+		return nil, err
+	}
+
+	absRoot, _ := filepath.Abs(t.WorkspaceRoot)
+	var paths []string
+	for _, p := range restored {
+		if rel, err := filepath.Rel(absRoot, p); err == nil {
+			p = rel
+		}
+		paths = append(paths, p)
+	}
+	return fmt.Sprintf("Reverted %d edit(s): %s", len(paths), strings.Join(paths, ", ")), nil
+}
+
+// undoCount returns the count argument of an undo_edit call.
+func undoCount(args map[string]interface{}) int {
+	if c, ok := args["count"].(float64); ok {
+		return int(c)
+	}
+	return 1
+}
+
+// Undo implements agent.Undoer.
+func (t *UndoTool) Undo(n int) ([]string, error) {
+	return t.journal().Undo(n)
+}
+
+func (t *UndoTool) journal() *Journal {
+	if t.Journal != nil {
+		return t.Journal
+	}
+	return NewJournal(t.WorkspaceRoot)
+}
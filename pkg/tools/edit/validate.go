@@ -0,0 +1,85 @@
+package edit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Validator checks the content of an edited file for syntax errors.
+type Validator interface {
+	Validate(ctx context.Context, path string, content []byte) error
+}
+
+// ValidatorFunc adapts a function to the Validator interface.
+type ValidatorFunc func(ctx context.Context, path string, content []byte) error
+
+func (f ValidatorFunc) Validate(ctx context.Context, path string, content []byte) error {
+	return f(ctx, path, content)
+}
+
+// GoValidator parses Go source and reports syntax errors.
+var GoValidator = ValidatorFunc(func(ctx context.Context, path string, content []byte) error {
+	_, err := parser.ParseFile(token.NewFileSet(), filepath.Base(path), content, parser.AllErrors)
+	return err
+})
+
+// CommandValidator runs an external checker with the edited file path
+// appended to its arguments (e.g. "python3 -m py_compile"). A non-zero exit
+// status is reported as a validation error with the command's output.
+// When the file on disk does not hold the edited content, as under
+// -dry-run, the checker runs on a temporary copy with the same name.
+type CommandValidator struct {
+	Command string
+	Args    []string
+}
+
+func (v *CommandValidator) Validate(ctx context.Context, path string, content []byte) error {
+	checked := path
+	if onDisk, err := os.ReadFile(path); err != nil || !bytes.Equal(onDisk, content) {
+		dir, err := os.MkdirTemp("", "castor-validate-*")
+		if err != nil {
+			return nil // Nowhere to check it; don't block the edit
+		}
+		defer os.RemoveAll(dir)
+		checked = filepath.Join(dir, filepath.Base(path))
+		if err := os.WriteFile(checked, content, 0644); err != nil {
+			return nil
+		}
+	}
+
+	args := append(append([]string{}, v.Args...), checked)
+	out, err := exec.CommandContext(ctx, v.Command, args...).CombinedOutput()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("%s", strings.TrimSpace(strings.ReplaceAll(string(out), checked, path)))
+		}
+		return nil // Checker unavailable; don't block the edit
+	}
+	return nil
+}
+
+// DefaultValidators returns the built-in post-edit validators.
+func DefaultValidators() map[string]Validator {
+	return map[string]Validator{
+		".go": GoValidator,
+	}
+}
+
+func (t *EditTool) validate(ctx context.Context, path string, content []byte) error {
+	validators := t.Validators
+	if validators == nil {
+		validators = DefaultValidators()
+	}
+	v, ok := validators[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return nil
+	}
+	return v.Validate(ctx, path, content)
+}
@@ -1,13 +1,20 @@
 package fs
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"fmt"
-	"os"
+	"io"
+	iofs "io/fs"
+	"net/http"
 	"path/filepath"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/tools/workspace"
+	"github.com/techmuch/castor/pkg/vfs"
 )
 
 // Ensure tools implement agent.Tool
@@ -15,37 +22,30 @@ var _ agent.Tool = (*ListDirTool)(nil)
 var _ agent.Tool = (*ReadFileTool)(nil)
 
 // ensureInWorkspace checks if the target path is within the allowed workspace.
-func ensureInWorkspace(root, target string) (string, error) {
-	absRoot, err := filepath.Abs(root)
-	if err != nil {
-		return "", fmt.Errorf("invalid root path: %w", err)
-	}
-
-	// Handle relative paths
-	absTarget := target
-	if !filepath.IsAbs(target) {
-		absTarget = filepath.Join(absRoot, target)
-	} else {
-		absTarget = filepath.Clean(target)
-	}
-
-	if !strings.HasPrefix(absTarget, absRoot) {
-		return "", fmt.Errorf("access denied: path %s is outside workspace %s", target, root)
-	}
-
-	return absTarget, nil
+func ensureInWorkspace(fsys vfs.FS, root, target string) (string, error) {
+	return workspace.ResolveFS(fsys, root, target)
 }
 
 // --- List Directory Tool ---
 
+const (
+	// DefaultMaxEntries caps the number of entries returned by list_directory.
+	DefaultMaxEntries = 500
+	// DefaultMaxDepth is the recursion depth used when none is given.
+	DefaultMaxDepth = 3
+)
+
 type ListDirTool struct {
 	WorkspaceRoot string
+	FS            vfs.FS // Optional: defaults to the local disk
 }
 
 func (t *ListDirTool) Name() string { return "list_directory" }
 
+func (t *ListDirTool) ReadOnly() bool { return true }
+
 func (t *ListDirTool) Description() string {
-	return "Lists files and subdirectories in a specific directory."
+	return "Lists files and subdirectories in a specific directory, optionally recursively. Paths ignored by .gitignore or .castorignore are skipped unless include_ignored is set."
 }
 
 func (t *ListDirTool) Schema() interface{} {
@@ -56,6 +56,35 @@ func (t *ListDirTool) Schema() interface{} {
 				"type":        "string",
 				"description": "The directory path relative to the workspace root.",
 			},
+			"include_ignored": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Include entries excluded by .gitignore/.castorignore (e.g. node_modules, build output).",
+			},
+			"recursive": map[string]interface{}{
+				"type":        "boolean",
+				"description": "List subdirectories recursively. Entries are returned as paths relative to path.",
+			},
+			"max_depth": map[string]interface{}{
+				"type":        "integer",
+				"description": fmt.Sprintf("Maximum recursion depth when recursive (default %d).", DefaultMaxDepth),
+			},
+			"max_entries": map[string]interface{}{
+				"type":        "integer",
+				"description": fmt.Sprintf("Maximum number of entries to return (default %d). Alias for limit.", DefaultMaxEntries),
+			},
+			"offset": map[string]interface{}{
+				"type":        "integer",
+				"description": "Number of entries to skip, for paging through large directories.",
+			},
+			"limit": map[string]interface{}{
+				"type":        "integer",
+				"description": "Maximum number of entries to return in this page.",
+			},
+			"extensions": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Only list files with these extensions (e.g. [\".go\", \".md\"]). Directories are omitted when set.",
+			},
 		},
 		"required": []string{"path"},
 	}
@@ -67,37 +96,136 @@ func (t *ListDirTool) Execute(ctx context.Context, args map[string]interface{})
 		pathStr = "."
 	}
 
-	targetPath, err := ensureInWorkspace(t.WorkspaceRoot, pathStr)
+	fsys := vfs.Or(t.FS)
+	targetPath, err := ensureInWorkspace(fsys, t.WorkspaceRoot, pathStr)
 	if err != nil {
 		return nil, err
 	}
 
-	entries, err := os.ReadDir(targetPath)
+	info, err := fsys.Stat(targetPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read dir: %w", err)
 	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", pathStr)
+	}
+
+	var ignorer *workspace.Ignorer
+	if includeIgnored, _ := args["include_ignored"].(bool); !includeIgnored {
+		ignorer = workspace.NewIgnorerFS(fsys, t.WorkspaceRoot)
+	}
+
+	recursive, _ := args["recursive"].(bool)
+	maxDepth := 1
+	if recursive {
+		maxDepth = intArg(args, "max_depth")
+		if maxDepth <= 0 {
+			maxDepth = DefaultMaxDepth
+		}
+	}
+	limit := intArg(args, "limit")
+	if limit <= 0 {
+		limit = intArg(args, "max_entries")
+	}
+	if limit <= 0 {
+		limit = DefaultMaxEntries
+	}
+	offset := max(0, intArg(args, "offset"))
+
+	extensions := make(map[string]bool)
+	if exts, ok := args["extensions"].([]interface{}); ok {
+		for _, e := range exts {
+			if ext, ok := e.(string); ok && ext != "" {
+				if !strings.HasPrefix(ext, ".") {
+					ext = "." + ext
+				}
+				extensions[strings.ToLower(ext)] = true
+			}
+		}
+	}
 
+	// Walk the whole tree so the total count is accurate, but only keep the
+	// requested page.
 	var results []string
-	for _, e := range entries {
-		suffix := ""
-		if e.IsDir() {
-			suffix = "/"
+	total := 0
+	err = vfs.WalkDir(fsys, targetPath, func(path string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			if path == targetPath {
+				return err
+			}
+			return nil // Skip unreadable entries
+		}
+		if path == targetPath {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if ignorer != nil && ignorer.Ignored(path, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, _ := filepath.Rel(targetPath, path)
+		depth := strings.Count(rel, string(filepath.Separator)) + 1
+
+		include := len(extensions) == 0
+		if !d.IsDir() && !include {
+			include = extensions[strings.ToLower(filepath.Ext(path))]
+		}
+		if include {
+			if total >= offset && total < offset+limit {
+				entry := filepath.ToSlash(rel)
+				if d.IsDir() {
+					entry += "/"
+				}
+				results = append(results, entry)
+			}
+			total++
 		}
-		results = append(results, e.Name()+suffix)
+
+		if d.IsDir() && depth >= maxDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dir: %w", err)
+	}
+
+	if offset > 0 || offset+len(results) < total {
+		note := fmt.Sprintf("[Showing entries %d-%d of %d.", min(offset+1, total), offset+len(results), total)
+		if offset+len(results) < total {
+			note += fmt.Sprintf(" Use offset=%d for more, or narrow the path, lower max_depth, or filter by extensions.", offset+len(results))
+		}
+		results = append(results, note+"]")
 	}
 	return results, nil
 }
 
 // --- Read File Tool ---
 
+// DefaultMaxReadBytes is the default cap on content returned by read_file.
+const DefaultMaxReadBytes = 256 * 1024
+
+// binarySniffLen is how much of a file is inspected for binary content.
+const binarySniffLen = 8000
+
 type ReadFileTool struct {
 	WorkspaceRoot string
+	MaxBytes      int    // Optional: cap on returned content, defaults to DefaultMaxReadBytes
+	FS            vfs.FS // Optional: defaults to the local disk
 }
 
 func (t *ReadFileTool) Name() string { return "read_file" }
 
+func (t *ReadFileTool) ReadOnly() bool { return true }
+
 func (t *ReadFileTool) Description() string {
-	return "Reads the content of a file."
+	return "Reads the content of a file. Large files are truncated; use offset and limit to page through them. Binary files are summarized instead of returned."
 }
 
 func (t *ReadFileTool) Schema() interface{} {
@@ -108,6 +236,18 @@ func (t *ReadFileTool) Schema() interface{} {
 				"type":        "string",
 				"description": "The file path relative to the workspace root.",
 			},
+			"line_numbers": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Prefix each line with its 1-based line number, for use with the replace tool's start_line/end_line.",
+			},
+			"offset": map[string]interface{}{
+				"type":        "integer",
+				"description": "1-based line number to start reading from.",
+			},
+			"limit": map[string]interface{}{
+				"type":        "integer",
+				"description": "Maximum number of lines to return.",
+			},
 		},
 		"required": []string{"path"},
 	}
@@ -119,15 +259,154 @@ func (t *ReadFileTool) Execute(ctx context.Context, args map[string]interface{})
 		return nil, fmt.Errorf("missing argument: path")
 	}
 
-	targetPath, err := ensureInWorkspace(t.WorkspaceRoot, pathStr)
+	fsys := vfs.Or(t.FS)
+	targetPath, err := ensureInWorkspace(fsys, t.WorkspaceRoot, pathStr)
 	if err != nil {
 		return nil, err
 	}
 
-	content, err := os.ReadFile(targetPath)
+	f, err := fsys.Open(targetPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory; use list_directory", pathStr)
+	}
+
+	// Summarize binary files instead of returning their bytes
+	head := make([]byte, binarySniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	head = head[:n]
+	if isBinary(head) {
+		return fmt.Sprintf("Binary file %s (%s, %d bytes); content not shown.", pathStr, http.DetectContentType(head), info.Size()), nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	offset := intArg(args, "offset")
+	if offset < 1 {
+		offset = 1
+	}
+	limit := intArg(args, "limit")
+	lineNumbers, _ := args["line_numbers"].(bool)
+	maxBytes := t.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxReadBytes
+	}
+
+	var out strings.Builder
+	reader := bufio.NewReader(f)
+	lineNo, lastLine := 0, 0
+	truncated := false
+	longLine := 0 // Length of the first line shown, if it had to be cut
+	for {
+		// No line is kept past the budget, however long it is
+		line, n, err := readLine(reader, maxBytes+1)
+		if n > 0 {
+			lineNo++
+			if lineNo >= offset {
+				if limit > 0 && lineNo-offset >= limit {
+					truncated = true
+					break
+				}
+				prefix := ""
+				if lineNumbers {
+					prefix = fmt.Sprintf("%6d\t", lineNo)
+				}
+				if out.Len()+len(prefix)+n > maxBytes {
+					if lastLine > 0 {
+						truncated = true
+						break
+					}
+					// The first line alone is over budget: show what fits
+					line = cutAtRune(line, max(0, maxBytes-len(prefix)))
+					longLine = n
+				}
+				out.WriteString(prefix + line)
+				lastLine = lineNo
+				if longLine > 0 {
+					truncated = true
+					break
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+	}
+
+	if offset > 1 && lastLine == 0 {
+		return nil, fmt.Errorf("offset %d is past the end of the file (%d lines)", offset, lineNo)
+	}
+	switch {
+	case longLine > 0:
+		fmt.Fprintf(&out, "\n[Truncated: line %d of %s is %d bytes; only its start is shown. Call read_file with offset=%d to continue after it.]", lastLine, pathStr, longLine, lastLine+1)
+	case truncated:
+		fmt.Fprintf(&out, "\n[Truncated: showing lines %d-%d of %s (%d bytes). Call read_file with offset=%d to continue.]", offset, lastLine, pathStr, info.Size(), lastLine+1)
+	}
+	return out.String(), nil
+}
+
+// readLine reads the next line of r, including its newline, keeping at
+// most max bytes of it and discarding the rest. n is the full length of
+// the line.
+func readLine(r *bufio.Reader, max int) (line string, n int, err error) {
+	var b []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if room := max - len(b); room > 0 {
+			b = append(b, chunk[:min(room, len(chunk))]...)
+		}
+		n += len(chunk)
+		if err != bufio.ErrBufferFull {
+			return string(b), n, err
+		}
+	}
+}
+
+// cutAtRune shortens s to at most n bytes without splitting a rune.
+func cutAtRune(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
 
-	return string(content), nil
-}
\ No newline at end of file
+// isBinary reports whether data looks like binary rather than text content.
+func isBinary(data []byte) bool {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return true
+	}
+	// Allow a multi-byte rune to be cut off at the end of the sample
+	for i := 0; i < utf8.UTFMax && len(data) > 0; i++ {
+		if utf8.Valid(data) {
+			return false
+		}
+		data = data[:len(data)-1]
+	}
+	return len(data) > 0
+}
+
+// intArg reads an optional integer argument; JSON numbers decode as float64.
+func intArg(args map[string]interface{}, name string) int {
+	if v, ok := args[name].(float64); ok {
+		return int(v)
+	}
+	return 0
+}
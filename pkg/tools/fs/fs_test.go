@@ -2,9 +2,14 @@ package fs
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"unicode/utf8"
+
+	"github.com/techmuch/castor/pkg/vfs"
 )
 
 func TestSandboxing(t *testing.T) {
@@ -33,7 +38,7 @@ func TestSandboxing(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer os.RemoveAll(outsideDir)
-	
+
 	outsideFile := filepath.Join(outsideDir, "secret.txt")
 	if err := os.WriteFile(outsideFile, []byte("secret content"), 0644); err != nil {
 		t.Fatal(err)
@@ -59,7 +64,16 @@ func TestSandboxing(t *testing.T) {
 			t.Errorf("expected success reading ./safe.txt, got error: %v", err)
 		}
 
-		// Case 3: Read outside file using ../ (should fail)
+		// Case 3: Read with line numbers
+		res, err = tool.Execute(ctx, map[string]interface{}{"path": "safe.txt", "line_numbers": true})
+		if err != nil {
+			t.Errorf("expected success reading with line numbers, got error: %v", err)
+		}
+		if content, ok := res.(string); !ok || content != "     1\tsafe content" {
+			t.Errorf("unexpected numbered content: %q", res)
+		}
+
+		// Case 4: Read outside file using ../ (should fail)
 		// We construct a path that tries to traverse out
 		relPath, _ := filepath.Rel(tmpDir, outsideFile)
 		_, err = tool.Execute(ctx, map[string]interface{}{"path": relPath})
@@ -72,11 +86,21 @@ func TestSandboxing(t *testing.T) {
 			}
 		}
 
-		// Case 4: Read absolute path outside workspace (should fail)
+		// Case 5: Read absolute path outside workspace (should fail)
 		_, err = tool.Execute(ctx, map[string]interface{}{"path": outsideFile})
 		if err == nil {
 			t.Error("expected error reading absolute outside path, got success")
 		}
+
+		// Case 6: Read outside file through a symlink inside the workspace (should fail)
+		if err := os.Symlink(outsideDir, filepath.Join(tmpDir, "link")); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(filepath.Join(tmpDir, "link"))
+		_, err = tool.Execute(ctx, map[string]interface{}{"path": "link/secret.txt"})
+		if err == nil {
+			t.Error("expected error reading through escaping symlink, got success")
+		}
 	})
 
 	// Test ListDirTool
@@ -97,17 +121,211 @@ func TestSandboxing(t *testing.T) {
 		foundSafe := false
 		foundSub := false
 		for _, item := range list {
-			if item == "safe.txt" { foundSafe = true }
-			if item == "subdir/" { foundSub = true }
+			if item == "safe.txt" {
+				foundSafe = true
+			}
+			if item == "subdir/" {
+				foundSub = true
+			}
 		}
 		if !foundSafe || !foundSub {
 			t.Errorf("listing missing expected items: %v", list)
 		}
 
-		// Case 2: List outside dir (should fail)
+		// Case 2: Ignored entries are hidden unless requested
+		if err := os.WriteFile(filepath.Join(tmpDir, ".castorignore"), []byte("subdir/\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(filepath.Join(tmpDir, ".castorignore"))
+		res, _ = tool.Execute(ctx, map[string]interface{}{"path": "."})
+		for _, item := range res.([]string) {
+			if item == "subdir/" {
+				t.Error("ignored directory was listed")
+			}
+		}
+		res, _ = tool.Execute(ctx, map[string]interface{}{"path": ".", "include_ignored": true})
+		foundSub = false
+		for _, item := range res.([]string) {
+			if item == "subdir/" {
+				foundSub = true
+			}
+		}
+		if !foundSub {
+			t.Error("include_ignored did not list ignored directory")
+		}
+
+		// Case 3: List outside dir (should fail)
 		_, err = tool.Execute(ctx, map[string]interface{}{"path": outsideDir})
 		if err == nil {
 			t.Error("expected error listing outside dir, got success")
 		}
 	})
 }
+
+func TestReadFileLimits(t *testing.T) {
+	tmpDir := t.TempDir()
+	ctx := context.Background()
+
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	if err := os.WriteFile(filepath.Join(tmpDir, "image.png"), png, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for i := 1; i <= 100; i++ {
+		lines = append(lines, fmt.Sprintf("line %03d", i))
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "big.txt"), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tool := &ReadFileTool{WorkspaceRoot: tmpDir, MaxBytes: 90}
+
+	res, err := tool.Execute(ctx, map[string]interface{}{"path": "image.png"})
+	if err != nil {
+		t.Fatalf("unexpected error reading binary file: %v", err)
+	}
+	if s := res.(string); !strings.Contains(s, "Binary file") || !strings.Contains(s, "image/png") {
+		t.Errorf("expected binary stub, got %q", s)
+	}
+
+	res, err = tool.Execute(ctx, map[string]interface{}{"path": "big.txt"})
+	if err != nil {
+		t.Fatalf("unexpected error reading large file: %v", err)
+	}
+	if s := res.(string); !strings.HasPrefix(s, "line 001\n") || !strings.Contains(s, "offset=11") {
+		t.Errorf("expected truncated content with pagination hint, got %q", s)
+	}
+
+	res, err = tool.Execute(ctx, map[string]interface{}{"path": "big.txt", "offset": float64(99), "limit": float64(5)})
+	if err != nil {
+		t.Fatalf("unexpected error paging: %v", err)
+	}
+	if s := res.(string); s != "line 099\nline 100\n" {
+		t.Errorf("unexpected page content: %q", s)
+	}
+
+	res, _ = tool.Execute(ctx, map[string]interface{}{"path": "big.txt", "offset": float64(10), "limit": float64(2), "line_numbers": true})
+	if s := res.(string); !strings.HasPrefix(s, "    10\tline 010\n    11\tline 011\n") || !strings.Contains(s, "offset=12") {
+		t.Errorf("unexpected numbered page: %q", s)
+	}
+
+	// A line longer than MaxBytes is cut at a rune boundary
+	minified := "{\"k\":\"" + strings.Repeat("é", 5000) + "\"}\nsecond\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, "min.json"), []byte(minified), 0644); err != nil {
+		t.Fatal(err)
+	}
+	res, err = tool.Execute(ctx, map[string]interface{}{"path": "min.json"})
+	if err != nil {
+		t.Fatalf("unexpected error reading long line: %v", err)
+	}
+	s := res.(string)
+	content, note, _ := strings.Cut(s, "\n[Truncated")
+	if len(content) > 90 || !utf8.ValidString(content) || !strings.HasPrefix(content, "{\"k\":\"é") {
+		t.Errorf("long line not cut to the budget: %d bytes %q", len(content), content)
+	}
+	if !strings.Contains(note, "line 1 ") || !strings.Contains(note, "offset=2") {
+		t.Errorf("expected a note on the cut line, got %q", s)
+	}
+}
+
+func TestListDirRecursive(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, rel := range []string{"main.go", "README.md", "pkg/a/a.go", "pkg/a/deep/d.go", "pkg/b/b.txt"} {
+		p := filepath.Join(tmpDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tool := &ListDirTool{WorkspaceRoot: tmpDir}
+	ctx := context.Background()
+	list := func(args map[string]interface{}) []string {
+		t.Helper()
+		res, err := tool.Execute(ctx, args)
+		if err != nil {
+			t.Fatalf("list failed: %v", err)
+		}
+		return res.([]string)
+	}
+
+	got := list(map[string]interface{}{"path": ".", "recursive": true, "max_depth": float64(3)})
+	want := "README.md,main.go,pkg/,pkg/a/,pkg/a/a.go,pkg/a/deep/,pkg/b/,pkg/b/b.txt"
+	if strings.Join(got, ",") != want {
+		t.Errorf("recursive listing mismatch.\nGot:  %v\nWant: %s", got, want)
+	}
+
+	got = list(map[string]interface{}{"path": ".", "recursive": true, "max_depth": float64(4), "extensions": []interface{}{".go"}})
+	want = "main.go,pkg/a/a.go,pkg/a/deep/d.go"
+	if strings.Join(got, ",") != want {
+		t.Errorf("extension filter mismatch.\nGot:  %v\nWant: %s", got, want)
+	}
+
+	got = list(map[string]interface{}{"path": ".", "recursive": true, "max_depth": float64(2)})
+	want = "README.md,main.go,pkg/,pkg/a/,pkg/b/"
+	if strings.Join(got, ",") != want {
+		t.Errorf("depth-limited listing mismatch.\nGot:  %v\nWant: %s", got, want)
+	}
+
+	got = list(map[string]interface{}{"path": ".", "recursive": true, "max_entries": float64(2)})
+	if len(got) != 3 || !strings.Contains(got[2], "of 8") || !strings.Contains(got[2], "offset=2") {
+		t.Errorf("expected 2 entries and a paging note, got %v", got)
+	}
+}
+
+func TestListDirPagination(t *testing.T) {
+	tmpDir := t.TempDir()
+	for i := 0; i < 25; i++ {
+		if err := os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("f%02d.txt", i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tool := &ListDirTool{WorkspaceRoot: tmpDir}
+	res, err := tool.Execute(context.Background(), map[string]interface{}{"path": ".", "offset": float64(20), "limit": float64(10)})
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	got := res.([]string)
+	if len(got) != 6 || got[0] != "f20.txt" || got[4] != "f24.txt" {
+		t.Fatalf("unexpected page: %v", got)
+	}
+	if got[5] != "[Showing entries 21-25 of 25.]" {
+		t.Errorf("unexpected paging note: %q", got[5])
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	ctx := context.Background()
+	root := filepath.Join(string(filepath.Separator), "ws")
+	mem := vfs.NewMemFS()
+	mem.MkdirAll(filepath.Join(root, "src"), 0755)
+	mem.WriteFile(filepath.Join(root, ".gitignore"), []byte("*.log\n"), 0644)
+	mem.WriteFile(filepath.Join(root, "src", "main.go"), []byte("package main\n"), 0644)
+	mem.WriteFile(filepath.Join(root, "debug.log"), []byte("noise\n"), 0644)
+
+	list := &ListDirTool{WorkspaceRoot: root, FS: mem}
+	res, err := list.Execute(ctx, map[string]interface{}{"path": ".", "recursive": true})
+	if err != nil {
+		t.Fatalf("ListDir failed: %v", err)
+	}
+	got := strings.Join(res.([]string), ",")
+	if got != ".gitignore,src/,src/main.go" {
+		t.Errorf("Unexpected listing: %s", got)
+	}
+
+	read := &ReadFileTool{WorkspaceRoot: root, FS: mem}
+	content, err := read.Execute(ctx, map[string]interface{}{"path": "src/main.go"})
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if content != "package main\n" {
+		t.Errorf("Unexpected content: %q", content)
+	}
+
+	if _, err := read.Execute(ctx, map[string]interface{}{"path": "../etc/passwd"}); err == nil {
+		t.Error("Expected error reading outside the in-memory workspace")
+	}
+}
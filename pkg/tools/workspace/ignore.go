@@ -0,0 +1,216 @@
+package workspace
+
+import (
+	"bufio"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/techmuch/castor/pkg/vfs"
+)
+
+// IgnoreFiles are the per-directory files read by Ignorer, in order of
+// increasing precedence.
+var IgnoreFiles = []string{".gitignore", ".castorignore"}
+
+// DefaultIgnores are always applied before any ignore file.
+var DefaultIgnores = []string{".git/", "node_modules/", ".castor/"}
+
+// ignoreRule is a single compiled gitignore pattern.
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	return r.re.MatchString(rel)
+}
+
+// Ignorer decides whether workspace paths are excluded by .gitignore and
+// .castorignore files. Ignore files in subdirectories apply to paths below
+// them, and later rules override earlier ones as in git.
+type Ignorer struct {
+	fs       vfs.FS
+	root     string
+	defaults []ignoreRule
+
+	mu    sync.Mutex
+	cache map[string][]ignoreRule // Keyed by slash-separated dir relative to root
+}
+
+// NewIgnorer returns an Ignorer for the workspace at root.
+func NewIgnorer(root string) *Ignorer {
+	return NewIgnorerFS(vfs.OS, root)
+}
+
+// NewIgnorerFS is like NewIgnorer but reads ignore files from fsys.
+func NewIgnorerFS(fsys vfs.FS, root string) *Ignorer {
+	absRoot, _ := filepath.Abs(root)
+	return &Ignorer{
+		fs:       fsys,
+		root:     absRoot,
+		defaults: parseIgnore(DefaultIgnores),
+		cache:    make(map[string][]ignoreRule),
+	}
+}
+
+// Ignored reports whether path (absolute, or relative to the root) is
+// ignored, either directly or because one of its parent directories is.
+func (ig *Ignorer) Ignored(path string, isDir bool) bool {
+	if filepath.IsAbs(path) {
+		rel, err := filepath.Rel(ig.root, path)
+		if err != nil {
+			return false
+		}
+		path = rel
+	}
+	rel := filepath.ToSlash(filepath.Clean(path))
+	if rel == "." || rel == "" {
+		return false
+	}
+
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if ig.matches(parts[:i], true) {
+			return true
+		}
+	}
+	return ig.matches(parts, isDir)
+}
+
+func (ig *Ignorer) matches(parts []string, isDir bool) bool {
+	rel := strings.Join(parts, "/")
+	ignored := false
+	for _, r := range ig.defaults {
+		if r.match(rel, isDir) {
+			ignored = !r.negate
+		}
+	}
+
+	// Apply ignore files from the root down to the path's parent directory
+	for depth := 0; depth < len(parts); depth++ {
+		dir := strings.Join(parts[:depth], "/")
+		sub := strings.Join(parts[depth:], "/")
+		for _, r := range ig.rules(dir) {
+			if r.match(sub, isDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// rules returns the compiled ignore rules declared in dir.
+func (ig *Ignorer) rules(dir string) []ignoreRule {
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+
+	if rules, ok := ig.cache[dir]; ok {
+		return rules
+	}
+
+	var rules []ignoreRule
+	for _, name := range IgnoreFiles {
+		f, err := ig.fs.Open(filepath.Join(ig.root, filepath.FromSlash(dir), name))
+		if err != nil {
+			continue
+		}
+		var lines []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		f.Close()
+		rules = append(rules, parseIgnore(lines)...)
+	}
+	ig.cache[dir] = rules
+	return rules
+}
+
+// parseIgnore compiles gitignore-style pattern lines.
+func parseIgnore(lines []string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var r ignoreRule
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		// Patterns containing a slash are relative to the ignore file's
+		// directory; others match at any depth.
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+
+		pattern := globToRegexp(line)
+		if anchored {
+			pattern = "^" + pattern + "$"
+		} else {
+			pattern = "^(?:.*/)?" + pattern + "$"
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			continue
+		}
+		r.re = re
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// globToRegexp translates gitignore glob syntax into a regular expression.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
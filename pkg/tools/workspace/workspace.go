@@ -0,0 +1,104 @@
+// Package workspace confines tool file access to a workspace root.
+package workspace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/vfs"
+)
+
+// Resolve maps target (relative to root, or absolute) to an absolute path
+// and verifies that it stays inside root. Containment is checked on path
+// components rather than string prefixes, and symlinks are resolved so a
+// link inside the workspace cannot point outside it. Targets that do not
+// exist yet are checked through their nearest existing ancestor.
+func Resolve(root, target string) (string, error) {
+	return ResolveFS(vfs.OS, root, target)
+}
+
+// ResolveFS is like Resolve but resolves symlinks through fsys.
+func ResolveFS(fsys vfs.FS, root, target string) (string, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", fmt.Errorf("invalid root path: %w", err)
+	}
+
+	absTarget := filepath.Clean(target)
+	if !filepath.IsAbs(target) {
+		absTarget = filepath.Join(absRoot, target)
+	}
+
+	if !Contains(absRoot, absTarget) {
+		return "", fmt.Errorf("access denied: path %s is outside workspace %s", target, root)
+	}
+
+	realRoot, err := fsys.EvalSymlinks(absRoot)
+	if err != nil {
+		return "", fmt.Errorf("invalid root path: %w", err)
+	}
+	realTarget, err := evalExisting(fsys, absTarget)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve path %s: %w", target, err)
+	}
+	if !Contains(realRoot, realTarget) {
+		return "", fmt.Errorf("access denied: path %s resolves outside workspace %s", target, root)
+	}
+
+	return absTarget, nil
+}
+
+// Contains reports whether path is root or lies beneath it. Both paths must
+// be absolute and clean.
+func Contains(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// maxLinkHops bounds how many dangling links evalExisting follows, so a
+// chain of links cannot loop forever.
+const maxLinkHops = 255
+
+// evalExisting resolves symlinks in path. Components that do not exist yet
+// are appended unchanged to the resolved form of the deepest existing
+// ancestor. A dangling link along the way is followed to its target, so a
+// link to a file that does not exist yet cannot point outside the
+// workspace unnoticed.
+func evalExisting(fsys vfs.FS, path string) (string, error) {
+	var missing []string
+	current := path
+	hops := 0
+	for {
+		resolved, err := fsys.EvalSymlinks(current)
+		if err == nil {
+			for i := len(missing) - 1; i >= 0; i-- {
+				resolved = filepath.Join(resolved, missing[i])
+			}
+			return resolved, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if dest, lerr := fsys.Readlink(current); lerr == nil {
+			if hops++; hops > maxLinkHops {
+				return "", fmt.Errorf("too many links resolving %s", path)
+			}
+			if !filepath.IsAbs(dest) {
+				dest = filepath.Join(filepath.Dir(current), dest)
+			}
+			current = filepath.Clean(dest)
+			continue
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return "", err
+		}
+		missing = append(missing, filepath.Base(current))
+		current = parent
+	}
+}
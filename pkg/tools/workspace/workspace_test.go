@@ -0,0 +1,109 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "work")
+	evil := filepath.Join(parent, "workspace-evil")
+	for _, dir := range []string{root, evil} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(evil, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(evil, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "sub"), filepath.Join(root, "internal")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(evil, "newfile"), filepath.Join(root, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub/later.txt", filepath.Join(root, "pending")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		wantErr bool
+	}{
+		{"Relative", "file.txt", false},
+		{"Root", ".", false},
+		{"NewNestedFile", "a/b/c.txt", false},
+		{"InternalSymlink", "internal/x.txt", false},
+		{"DotDot", "../outside.txt", true},
+		{"PrefixCollision", evil + "/secret.txt", true},
+		{"PrefixCollisionRelative", "../workspace-evil/secret.txt", true},
+		{"SymlinkEscape", "escape/secret.txt", true},
+		{"SymlinkEscapeNewFile", "escape/new.txt", true},
+		{"DanglingSymlinkEscape", "dangling", true},
+		{"DanglingSymlinkEscapeChild", "dangling/x.txt", true},
+		{"DanglingSymlinkInternal", "pending", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Resolve(root, tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Resolve(%q) error = %v, wantErr %v", tt.target, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIgnorer(t *testing.T) {
+	root := t.TempDir()
+	write := func(rel, content string) {
+		t.Helper()
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".gitignore", "# build output\n/build/\n*.log\n!keep.log\ndocs/**/*.tmp\n")
+	write(".castorignore", "fixtures/\n")
+	write("pkg/.gitignore", "generated.go\n")
+
+	ig := NewIgnorer(root)
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{".git", true, true},
+		{".git/config", false, true},
+		{"node_modules", true, true},
+		{"web/node_modules/react/index.js", false, true},
+		{"build", true, true},
+		{"build/out.bin", false, true},
+		{"src/build", true, false},
+		{"debug.log", false, true},
+		{"logs/app.log", false, true},
+		{"keep.log", false, false},
+		{"docs/a/b/x.tmp", false, true},
+		{"docs/x.md", false, false},
+		{"testdata/fixtures", true, true},
+		{"pkg/generated.go", false, true},
+		{"generated.go", false, false},
+		{"main.go", false, false},
+	}
+	for _, tt := range tests {
+		if got := ig.Ignored(tt.path, tt.isDir); got != tt.ignored {
+			t.Errorf("Ignored(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.ignored)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/techmuch/castor/pkg/agent"
+)
+
+// newSpinner returns the spinner shown while the agent works.
+func newSpinner() spinner.Model {
+	return spinner.New(spinner.WithSpinner(spinner.Dot))
+}
+
+// track updates the activity shown for a running chat from one of its
+// events.
+func (m *model) track(event agent.Event) {
+	if m.cancelled {
+		return // Keep showing that it is stopping
+	}
+	switch {
+	case event.Progress != nil:
+		m.activity = "running " + event.Progress.String()
+	case event.Started != nil:
+		m.activity = "running " + event.Started.Name + "…"
+	case event.Result != nil:
+		m.activity = "thinking…"
+	case event.Delta != "":
+		m.activity = "writing…"
+	}
+}
+
+// cancelRun stops the running chat. Its stream closes once the provider
+// and any running tool have returned.
+func (m model) cancelRun() (tea.Model, tea.Cmd) {
+	if m.cancel != nil && !m.cancelled {
+		m.cancel()
+		m.cancelled = true
+		m.activity = "cancelling…"
+	}
+	return m, nil
+}
+
+// activityView renders the spinner line of a running chat.
+func (m model) activityView() string {
+	line := fmt.Sprintf("%s %s (%s)", strings.TrimSpace(m.spinner.View()), m.activity, formatElapsed(time.Since(m.started)))
+	cancel := m.keys.Cancel.Help().Key
+	hint := cancel + " to cancel"
+	if m.blocked {
+		hint = "wait for the reply to finish, or press " + cancel + " to cancel"
+	}
+	if cancel == "" {
+		hint = "wait for the reply to finish"
+	}
+	return line + m.sysStyle.Render(" · "+hint)
+}
+
+// formatElapsed shows a duration in whole seconds, e.g. 7s or 1m05s.
+func formatElapsed(d time.Duration) string {
+	s := int(d.Seconds())
+	if s < 60 {
+		return fmt.Sprintf("%ds", s)
+	}
+	return fmt.Sprintf("%dm%02ds", s/60, s%60)
+}
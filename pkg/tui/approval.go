@@ -0,0 +1,104 @@
+package tui
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// approvalArgLines is how many lines of a call's arguments the approval
+// prompt shows.
+const approvalArgLines = 6
+
+// approvalMsg asks the user whether a tool call may run. The answer is
+// sent to reply, which must have room for it.
+type approvalMsg struct {
+	call  llm.ToolCallPart
+	reply chan<- bool
+}
+
+// approver returns an agent.Approver that asks through the program. It
+// gives up when the chat is cancelled.
+func approver(p *tea.Program) agent.Approver {
+	return func(ctx context.Context, call llm.ToolCallPart) (bool, error) {
+		reply := make(chan bool, 1)
+		p.Send(approvalMsg{call: call, reply: reply})
+		select {
+		case ok := <-reply:
+			return ok, nil
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+// requestApproval shows the approval prompt for a call, unless its tool was
+// approved for the rest of the session.
+func (m model) requestApproval(msg approvalMsg) (tea.Model, tea.Cmd) {
+	if m.alwaysAllow[msg.call.Name] {
+		msg.reply <- true
+		return m, nil
+	}
+	if m.approval != nil {
+		m.approval.reply <- false // Superseded; should not happen
+	}
+	m.approval = &msg
+	m.activity = "waiting for approval of " + msg.call.Name
+	if m.notifyAfter > 0 && !m.focused {
+		return m, notify("Castor is waiting for approval to run " + msg.call.Name)
+	}
+	return m, nil
+}
+
+// updateApproval handles keys while the approval prompt is shown: y runs
+// the call, a runs it and every later call of the tool, and n or Esc
+// denies it.
+func (m model) updateApproval(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var ok bool
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "y":
+		ok = true
+	case "a":
+		if m.alwaysAllow == nil {
+			m.alwaysAllow = make(map[string]bool)
+		}
+		m.alwaysAllow[m.approval.call.Name] = true
+		ok = true
+	case "n", "esc":
+	default:
+		return m, nil
+	}
+	m.approval.reply <- ok
+	m.approval = nil
+	m.activity = "thinking…"
+	return m, nil
+}
+
+// dropApproval denies the pending call, if any, when its chat ends.
+func (m *model) dropApproval() {
+	if m.approval != nil {
+		m.approval.reply <- false
+		m.approval = nil
+	}
+}
+
+// approvalView renders the approval prompt.
+func (m model) approvalView() []string {
+	call := m.approval.call
+	lines := []string{m.botStyle.Render("Run "+call.Name+"?") + m.sysStyle.Render("  y yes · a always for this tool · n no")}
+	args, _ := json.MarshalIndent(call.Args, "", "  ")
+	argLines := strings.Split(string(args), "\n")
+	if len(argLines) > approvalArgLines {
+		argLines = append(argLines[:approvalArgLines-1], "…")
+	}
+	for _, line := range argLines {
+		lines = append(lines, m.sysStyle.Render("  "+truncate(line, max(m.viewport.Width-4, 20))))
+	}
+	return lines
+}
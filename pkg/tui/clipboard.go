@@ -0,0 +1,123 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/atotto/clipboard"
+	"github.com/aymanbagabas/go-osc52/v2"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// copiedMsg reports the outcome of a copy to the clipboard.
+type copiedMsg struct {
+	what string
+	err  error
+}
+
+// copyText puts text on the system clipboard. Over SSH, or when no
+// clipboard is available, it asks the terminal to do it with an OSC 52
+// escape sequence, which most modern terminals honor.
+func copyText(text, what string) tea.Cmd {
+	return func() tea.Msg {
+		if os.Getenv("SSH_TTY") == "" && os.Getenv("SSH_CONNECTION") == "" {
+			if err := clipboard.WriteAll(text); err == nil {
+				return copiedMsg{what: what}
+			}
+		}
+		seq := osc52.New(text)
+		switch {
+		case os.Getenv("TMUX") != "":
+			seq = seq.Tmux()
+		case strings.HasPrefix(os.Getenv("TERM"), "screen"):
+			seq = seq.Screen()
+		}
+		// The renderer owns stdout, so write the sequence to the terminal
+		// through stderr
+		if _, err := seq.WriteTo(os.Stderr); err != nil {
+			return copiedMsg{what: what, err: err}
+		}
+		return copiedMsg{what: what + " (via terminal)"}
+	}
+}
+
+// lastReply returns the text of the most recent assistant message.
+func (m model) lastReply() string {
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].role == "assistant" {
+			return m.messages[i].text
+		}
+	}
+	return ""
+}
+
+// codeBlock is a fenced code block of a reply.
+type codeBlock struct {
+	lang string
+	code string
+}
+
+// codeBlocks extracts the fenced code blocks of markdown text, in order.
+func codeBlocks(md string) []codeBlock {
+	var blocks []codeBlock
+	lines := strings.Split(md, "\n")
+	for i := 0; i < len(lines); i++ {
+		fence := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(fence, "```") {
+			continue
+		}
+		start := i + 1
+		for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+		}
+		blocks = append(blocks, codeBlock{
+			lang: strings.TrimSpace(strings.TrimPrefix(fence, "```")),
+			code: strings.Join(lines[start:min(i, len(lines))], "\n"),
+		})
+	}
+	return blocks
+}
+
+// copyReply copies the last reply.
+func (m model) copyReply() (tea.Model, tea.Cmd) {
+	reply := m.lastReply()
+	if reply == "" {
+		m.status = "Nothing to copy yet."
+		return m, nil
+	}
+	return m, copyText(reply, "reply")
+}
+
+// copyBlock copies the nth code block of the last reply, counting from 1.
+// Zero or less counts back from the last block.
+func (m model) copyBlock(n int) (tea.Model, tea.Cmd) {
+	blocks := codeBlocks(m.lastReply())
+	if len(blocks) == 0 {
+		m.status = "The last reply has no code blocks."
+		return m, nil
+	}
+	if n <= 0 {
+		n = len(blocks) + n
+	}
+	if n < 1 || n > len(blocks) {
+		m.status = fmt.Sprintf("The last reply has %d code block(s).", len(blocks))
+		return m, nil
+	}
+	b := blocks[n-1]
+	what := fmt.Sprintf("code block %d/%d", n, len(blocks))
+	if b.lang != "" {
+		what += " (" + b.lang + ")"
+	}
+	m.copyCursor = n
+	return m, copyText(b.code, what)
+}
+
+// cycleBlock copies the code block before the one copied last, starting
+// from the last block, so repeated presses walk back through the reply.
+func (m model) cycleBlock() (tea.Model, tea.Cmd) {
+	n := m.copyCursor - 1
+	if n < 1 {
+		n = 0 // Wrap around to the last block
+	}
+	return m.copyBlock(n)
+}
@@ -0,0 +1,150 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/techmuch/castor/pkg/tools/workspace"
+)
+
+// maxPathSuggestions caps the entries suggested for a partial path.
+const maxPathSuggestions = 50
+
+// suggestions are completions of the word being typed, shown in a popup
+// above the input.
+type suggestions struct {
+	items  []string
+	detail []string // Shown beside each item; may be empty
+	cursor int
+}
+
+// suggest offers completions for input: slash commands while the first
+// word is typed, and workspace paths for a word starting with "@". It
+// returns nil when there are none.
+func (m model) suggest(input string) *suggestions {
+	s := &suggestions{}
+	word := lastWord(input)
+	switch {
+	case strings.HasPrefix(input, "/") && !strings.Contains(input, " "):
+		for _, c := range commands {
+			if strings.HasPrefix(c.name, input) && c.name != input {
+				s.items = append(s.items, c.name)
+				s.detail = append(s.detail, c.help)
+			}
+		}
+	case strings.HasPrefix(word, "@"):
+		for _, p := range completePath(m.workspaceRoot(), word[1:]) {
+			if "@"+p != word {
+				s.items = append(s.items, "@"+p)
+			}
+		}
+	}
+	if len(s.items) == 0 {
+		return nil
+	}
+	return s
+}
+
+// completePath lists the entries of the directory named by partial whose
+// names start with its last element. Directories end with a slash. Ignored
+// and hidden entries are left out unless the name asks for them.
+func completePath(root, partial string) []string {
+	dir, prefix := path.Split(partial)
+	abs, err := workspace.Resolve(root, filepath.FromSlash(dir))
+	if err != nil {
+		return nil
+	}
+	entries, err := os.ReadDir(abs)
+	if err != nil {
+		return nil
+	}
+	ignorer := workspace.NewIgnorer(root)
+	var paths []string
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(strings.ToLower(name), strings.ToLower(prefix)) {
+			continue
+		}
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".") {
+			continue
+		}
+		if ignorer.Ignored(filepath.Join(abs, name), e.IsDir()) {
+			continue
+		}
+		if e.IsDir() {
+			name += "/"
+		}
+		paths = append(paths, dir+name)
+		if len(paths) == maxPathSuggestions {
+			break
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// updateSuggestions handles the keys that work the popup: Tab or Enter
+// takes the selected completion, ↑/↓ select, and Esc hides it. It reports
+// whether the key was used.
+func (m *model) updateSuggestions(msg tea.KeyMsg) bool {
+	s := m.suggestions
+	switch msg.Type {
+	case tea.KeyTab, tea.KeyEnter:
+		m.complete(s.items[s.cursor])
+	case tea.KeyUp:
+		s.cursor = max(s.cursor-1, 0)
+	case tea.KeyDown:
+		s.cursor = min(s.cursor+1, len(s.items)-1)
+	case tea.KeyEsc:
+		m.suggestions = nil
+	default:
+		return false
+	}
+	return true
+}
+
+// complete replaces the word being typed with item. A space follows unless
+// item is a directory, whose entries are suggested next.
+func (m *model) complete(item string) {
+	input := m.textarea.Value()
+	if !strings.HasSuffix(item, "/") {
+		item += " "
+	}
+	m.textarea.SetValue(strings.TrimSuffix(input, lastWord(input)) + item)
+	m.suggestions = m.suggest(m.textarea.Value())
+}
+
+// suggestionsView renders the popup.
+func (m model) suggestionsView() []string {
+	s := m.suggestions
+	var lines []string
+	start := max(s.cursor-pickerHeight+1, 0)
+	for i := start; i < len(s.items) && i < start+pickerHeight; i++ {
+		line := "  " + s.items[i]
+		if i < len(s.detail) {
+			line = fmtColumn(line, 20) + m.sysStyle.Render(s.detail[i])
+		}
+		if i == s.cursor {
+			line = m.senderStyle.Render("›") + line[1:]
+		}
+		lines = append(lines, line)
+	}
+	hint := "tab complete · ↑/↓ select · esc hide"
+	if len(s.items) > pickerHeight {
+		hint += fmt.Sprintf(" · %d matches", len(s.items))
+	}
+	return append(lines, m.sysStyle.Render("  "+hint))
+}
+
+// fmtColumn pads s to width, so the text after it lines up.
+func fmtColumn(s string, width int) string {
+	if n := len([]rune(s)); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s + " "
+}
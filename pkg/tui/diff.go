@@ -0,0 +1,97 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// maxDiffLines caps how much of a diff the transcript shows.
+const maxDiffLines = 60
+
+// editDiff finds the unified diff in the output of an edit tool: the diff
+// field of a JSON result, as the replace tool returns, or a patch following
+// a summary, as edit_transaction returns. It returns the text that
+// accompanies the diff and the diff itself, which is empty when there is
+// none.
+func editDiff(output string) (summary, patch string) {
+	var result struct {
+		Message  string   `json:"message"`
+		Diff     string   `json:"diff"`
+		Warnings []string `json:"warnings"`
+	}
+	if json.Unmarshal([]byte(output), &result) == nil && result.Diff != "" {
+		summary = result.Message
+		for _, w := range result.Warnings {
+			summary += "\nWarning: " + w
+		}
+		return summary, result.Diff
+	}
+
+	lines := strings.Split(output, "\n")
+	for i := 0; i+1 < len(lines); i++ {
+		if strings.HasPrefix(lines[i], "--- ") && strings.HasPrefix(lines[i+1], "+++ ") {
+			return strings.TrimSpace(strings.Join(lines[:i], "\n")), strings.Join(lines[i:], "\n")
+		}
+	}
+	return output, ""
+}
+
+// diffStats counts the added and removed lines of a unified diff.
+func diffStats(patch string) (added, removed int) {
+	for _, line := range strings.Split(patch, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"):
+			added++
+		case strings.HasPrefix(line, "-"):
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// renderDiff colors a unified diff and frames it as a panel, headed by
+// counts of the added and removed lines. Diffs longer than limit lines are
+// cut short, unless limit is zero.
+func renderDiff(patch string, theme Theme, limit int) string {
+	var (
+		addStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Added))
+		removeStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Removed))
+		hunkStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Hunk))
+		fileStyle   = lipgloss.NewStyle().Bold(true)
+		panelStyle  = lipgloss.NewStyle().
+				Border(lipgloss.NormalBorder(), false, false, false, true).
+				BorderForeground(lipgloss.Color(theme.Border)).
+				PaddingLeft(1)
+	)
+
+	lines := strings.Split(strings.TrimRight(patch, "\n"), "\n")
+	added, removed := diffStats(patch)
+
+	more := 0
+	if limit > 0 && len(lines) > limit {
+		more = len(lines) - limit
+		lines = lines[:limit]
+	}
+	out := []string{addStyle.Render(fmt.Sprintf("+%d", added)) + " " + removeStyle.Render(fmt.Sprintf("-%d", removed))}
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			line = fileStyle.Render(line)
+		case strings.HasPrefix(line, "@@"):
+			line = hunkStyle.Render(line)
+		case strings.HasPrefix(line, "+"):
+			line = addStyle.Render(line)
+		case strings.HasPrefix(line, "-"):
+			line = removeStyle.Render(line)
+		}
+		out = append(out, line)
+	}
+	if more > 0 {
+		out = append(out, fmt.Sprintf("… %d more lines", more))
+	}
+	return panelStyle.Render(strings.Join(out, "\n"))
+}
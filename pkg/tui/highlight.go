@@ -0,0 +1,150 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/techmuch/castor/pkg/agent"
+)
+
+// maxToolLines caps how much of a tool result the transcript shows.
+const maxToolLines = 20
+
+// detectLexer picks a lexer for code, by filename when one is known and
+// otherwise from the content. It returns nil when neither gives a match.
+func detectLexer(code, filename string) chroma.Lexer {
+	if filename != "" {
+		if l := lexers.Match(filename); l != nil {
+			return l
+		}
+	}
+	if l := lexers.Analyse(code); l != nil {
+		return l
+	}
+	if trimmed := strings.TrimSpace(code); (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		return lexers.Get("json")
+	}
+	return nil
+}
+
+// highlight colors code for the terminal in the named chroma style. Code
+// without a lexer is returned unchanged.
+func highlight(code string, lexer chroma.Lexer, style string) string {
+	if lexer == nil {
+		return code
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return code
+	}
+	var out strings.Builder
+	if err := formatters.TTY256.Format(&out, styles.Get(style), iterator); err != nil {
+		return code
+	}
+	return out.String()
+}
+
+// tagCodeFences names the language of fenced code blocks that lack one,
+// so the markdown renderer highlights them.
+func tagCodeFences(md string) string {
+	lines := strings.Split(md, "\n")
+	for i := 0; i < len(lines); i++ {
+		if !strings.HasPrefix(strings.TrimSpace(lines[i]), "```") {
+			continue
+		}
+		open := i
+		for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+		}
+		if strings.TrimSpace(lines[open]) != "```" {
+			continue // Already tagged
+		}
+		body := strings.Join(lines[open+1:min(i, len(lines))], "\n")
+		if l := detectLexer(body, ""); l != nil {
+			lines[open] = strings.Replace(lines[open], "```", "```"+l.Config().Aliases[0], 1)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// highlightFences colors the fenced code blocks of plain text, leaving the
+// rest as it is.
+func highlightFences(text, style string) string {
+	lines := strings.Split(text, "\n")
+	var out []string
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		out = append(out, line)
+		if !strings.HasPrefix(strings.TrimSpace(line), "```") {
+			continue
+		}
+		start := i + 1
+		for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+		}
+		code := strings.Join(lines[start:min(i, len(lines))], "\n")
+		lexer := lexers.Get(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "```")))
+		if lexer == nil {
+			lexer = detectLexer(code, "")
+		}
+		out = append(out, highlight(code, lexer, style))
+		if i < len(lines) {
+			out = append(out, lines[i])
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// renderToolResult formats a finished tool call: a header naming the tool
+// and its target, then its output, highlighted when it looks like code.
+// When full is set the arguments are shown too, and long output is not cut
+// short.
+func (m model) renderToolResult(r *agent.ToolResult, full bool) string {
+	path, _ := r.Args["path"].(string)
+	header := "⚙ " + r.Tool
+	if path != "" {
+		header += " " + path
+	}
+	head := m.toolStyle.Render(header)
+	if full && len(r.Args) > 0 {
+		args, _ := json.MarshalIndent(r.Args, "", "  ")
+		head += "\n" + highlight(string(args), lexers.Get("json"), m.theme.Code)
+	}
+	if r.Err != nil {
+		return head + "\n" + m.sysStyle.Render("  Error: "+r.Err.Error())
+	}
+
+	if summary, patch := editDiff(r.Output); patch != "" {
+		body := head
+		if summary != "" {
+			body += "\n" + summary
+		}
+		limit := maxDiffLines
+		if full {
+			limit = 0
+		}
+		return body + "\n" + renderDiff(patch, m.theme, limit)
+	}
+
+	lines := strings.Split(strings.TrimRight(r.Output, "\n"), "\n")
+	more := ""
+	if !full && len(lines) > maxToolLines {
+		more = m.sysStyle.Render(fmt.Sprintf("  … %d more lines", len(lines)-maxToolLines))
+		lines = lines[:maxToolLines]
+	}
+	output := strings.Join(lines, "\n")
+	if strings.Contains(output, "```") {
+		output = highlightFences(output, m.theme.Code)
+	} else {
+		output = highlight(output, detectLexer(output, path), m.theme.Code)
+	}
+
+	body := head + "\n" + output
+	if more != "" {
+		body += "\n" + more
+	}
+	return body
+}
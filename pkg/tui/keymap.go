@@ -0,0 +1,139 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+)
+
+// KeyMap holds the key bindings that can be remapped. Keys are named as
+// bubbletea prints them, such as "enter", "alt+enter", "ctrl+k", or
+// "pgup".
+type KeyMap struct {
+	Submit     key.Binding // Send the message
+	Newline    key.Binding // Start a new line in the message
+	Cancel     key.Binding // Stop the running reply
+	Palette    key.Binding // Open or close the command palette
+	ScrollUp   key.Binding // Scroll the chat up a page
+	ScrollDown key.Binding // Scroll the chat down a page
+
+	Search      key.Binding // Search the transcript
+	Copy        key.Binding // Copy the last reply
+	CopyCode    key.Binding // Copy the reply's code blocks, from the last one back
+	ToggleTools key.Binding // Expand or collapse tool calls
+	FilePane    key.Binding // Show or hide the file pane
+}
+
+// defaultKeys are the keys of each action until the config changes them.
+var defaultKeys = map[string]string{
+	"submit":     "enter",
+	"newline":    "alt+enter ctrl+j",
+	"cancel":     "esc",
+	"palette":    "ctrl+k",
+	"scrollUp":   "pgup",
+	"scrollDown": "pgdown",
+
+	"search":      "ctrl+s",
+	"copy":        "ctrl+y",
+	"copyCode":    "alt+y",
+	"toggleTools": "alt+t",
+	"filePane":    "ctrl+o",
+}
+
+// fixedKeys are the bindings that cannot be remapped, which remapped keys
+// must not clash with.
+var fixedKeys = map[string]string{
+	"ctrl+c": "quit",
+	"tab":    "complete",
+}
+
+// LoadKeyMap returns the default bindings with some actions remapped.
+// Override keys are the lowerCamelCase field names of KeyMap, such as
+// "submit" or "scrollUp"; values list keys separated by spaces, or are
+// empty to unbind the action. Two actions may not share a key.
+func LoadKeyMap(overrides map[string]string) (KeyMap, error) {
+	keys := make(map[string]string, len(defaultKeys))
+	for action, k := range defaultKeys {
+		keys[action] = k
+	}
+	for action, k := range overrides {
+		if _, ok := defaultKeys[action]; !ok {
+			return KeyMap{}, fmt.Errorf("unknown key action %q", action)
+		}
+		keys[action] = k
+	}
+	if len(strings.Fields(keys["submit"])) == 0 {
+		return KeyMap{}, fmt.Errorf("submit needs a key")
+	}
+
+	var actions []string
+	for action := range keys {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions) // Report conflicts the same way every time
+	owner := make(map[string]string)
+	for k, action := range fixedKeys {
+		owner[k] = action
+	}
+	for _, action := range actions {
+		for _, k := range strings.Fields(keys[action]) {
+			if other, ok := owner[k]; ok {
+				return KeyMap{}, fmt.Errorf("key %q is bound to both %s and %s", k, other, action)
+			}
+			owner[k] = action
+		}
+	}
+
+	bind := func(action string) key.Binding {
+		names := strings.Fields(keys[action])
+		if len(names) == 0 {
+			return key.NewBinding(key.WithDisabled())
+		}
+		return key.NewBinding(key.WithKeys(names...), key.WithHelp(keyLabel(names), action))
+	}
+	return KeyMap{
+		Submit:     bind("submit"),
+		Newline:    bind("newline"),
+		Cancel:     bind("cancel"),
+		Palette:    bind("palette"),
+		ScrollUp:   bind("scrollUp"),
+		ScrollDown: bind("scrollDown"),
+
+		Search:      bind("search"),
+		Copy:        bind("copy"),
+		CopyCode:    bind("copyCode"),
+		ToggleTools: bind("toggleTools"),
+		FilePane:    bind("filePane"),
+	}, nil
+}
+
+// keyLabel formats key names for display, such as "Ctrl+K / PgUp".
+func keyLabel(names []string) string {
+	var labels []string
+	for _, name := range names {
+		parts := strings.Split(name, "+")
+		for i, p := range parts {
+			switch p {
+			case "":
+			case "pgup":
+				parts[i] = "PgUp"
+			case "pgdown":
+				parts[i] = "PgDn"
+			default:
+				parts[i] = strings.ToUpper(p[:1]) + p[1:]
+			}
+		}
+		labels = append(labels, strings.Join(parts, "+"))
+	}
+	return strings.Join(labels, " / ")
+}
+
+// setKeys applies keys to the model and the input and chat it contains.
+func (m *model) setKeys(keys KeyMap) {
+	m.keys = keys
+	m.textarea.KeyMap.InsertNewline = keys.Newline
+	m.viewport.KeyMap.PageUp = keys.ScrollUp
+	m.viewport.KeyMap.PageDown = keys.ScrollDown
+}
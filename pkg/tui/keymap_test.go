@@ -0,0 +1,62 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestLoadKeyMap(t *testing.T) {
+	ctrlF := tea.KeyMsg{Type: tea.KeyCtrlF}
+	ctrlS := tea.KeyMsg{Type: tea.KeyCtrlS}
+
+	t.Run("Defaults", func(t *testing.T) {
+		keys, err := LoadKeyMap(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !key.Matches(ctrlS, keys.Search) {
+			t.Error("ctrl+s does not search")
+		}
+		if !key.Matches(tea.KeyMsg{Type: tea.KeyCtrlO}, keys.FilePane) {
+			t.Error("ctrl+o does not toggle the file pane")
+		}
+	})
+
+	t.Run("Override", func(t *testing.T) {
+		keys, err := LoadKeyMap(map[string]string{"search": "ctrl+f", "filePane": ""})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !key.Matches(ctrlF, keys.Search) || key.Matches(ctrlS, keys.Search) {
+			t.Error("search is not moved to ctrl+f")
+		}
+		if keys.FilePane.Enabled() {
+			t.Error("filePane is still bound")
+		}
+		if got := keys.Search.Help().Key; got != "Ctrl+F" {
+			t.Errorf("help label = %q, want Ctrl+F", got)
+		}
+	})
+
+	tests := []struct {
+		name      string
+		overrides map[string]string
+		err       string
+	}{
+		{"Unknown", map[string]string{"quit": "ctrl+q"}, `unknown key action "quit"`},
+		{"Conflict", map[string]string{"copy": "ctrl+s"}, `key "ctrl+s" is bound to both copy and search`},
+		{"Fixed", map[string]string{"filePane": "tab"}, `key "tab" is bound to both complete and filePane`},
+		{"NoSubmit", map[string]string{"submit": ""}, "submit needs a key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadKeyMap(tt.overrides)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
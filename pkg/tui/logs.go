@@ -0,0 +1,53 @@
+package tui
+
+import (
+	"log/slog"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxLogLines is how many log lines the debug panel keeps.
+const maxLogLines = 200
+
+// debugPanelHeight is how many lines the debug panel shows.
+const debugPanelHeight = 8
+
+// LogHandler is a slog.Handler whose records are shown in the TUI's debug
+// panel (toggled with /debug). Records logged while the panel cannot keep
+// up are dropped rather than blocking the logger.
+type LogHandler struct {
+	slog.Handler
+	lines chan string
+}
+
+// NewLogHandler returns a handler for records at level and above.
+func NewLogHandler(level slog.Leveler) *LogHandler {
+	lines := make(chan string, 64)
+	return &LogHandler{
+		Handler: slog.NewTextHandler(chanWriter(lines), &slog.HandlerOptions{Level: level}),
+		lines:   lines,
+	}
+}
+
+// chanWriter delivers each write, which slog makes once per record, as a
+// line on the channel.
+type chanWriter chan string
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	select {
+	case w <- strings.TrimRight(string(p), "\n"):
+	default:
+	}
+	return len(p), nil
+}
+
+// logMsg carries one formatted log record.
+type logMsg string
+
+// waitForLog reads the next record for the debug panel.
+func waitForLog(lines <-chan string) tea.Cmd {
+	return func() tea.Msg {
+		return logMsg(<-lines)
+	}
+}
@@ -0,0 +1,78 @@
+package tui
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/techmuch/castor/pkg/agent"
+)
+
+// message is one entry of the chat transcript. Assistant replies are kept
+// as markdown and rendered for the current width, so they re-wrap when the
+// terminal is resized.
+type message struct {
+	role string // "user", "assistant", "tool", or "system"
+	text string
+	tool *agent.ToolResult // Set for "tool" messages
+
+	// A tool message is "pending" or "running" until its result arrives,
+	// with the progress the call last reported.
+	state    string
+	progress *agent.ToolProgress
+	expanded bool // A tool message shows its arguments and full result
+
+	rendered string // Cached rendering of text
+	width    int    // Width rendered was made for
+}
+
+// markdown renders markdown for the terminal in a glamour style, reusing
+// the renderer while the width stays the same.
+type markdown struct {
+	style    string
+	width    int
+	renderer *glamour.TermRenderer
+}
+
+// render formats text wrapped to width. Text that cannot be rendered is
+// returned unchanged.
+func (md *markdown) render(text string, width int) string {
+	if md.renderer == nil || md.width != width {
+		r, err := glamour.NewTermRenderer(
+			glamour.WithStandardStyle(md.style),
+			glamour.WithWordWrap(max(width-markdownMargin, 20)),
+		)
+		if err != nil {
+			return text
+		}
+		md.renderer, md.width = r, width
+	}
+	out, err := md.renderer.Render(text)
+	if err != nil {
+		return text
+	}
+	return strings.Trim(out, "\n")
+}
+
+// markdownMargin is the horizontal space taken by glamour's document
+// margins.
+const markdownMargin = 4
+
+// renderMessage formats msg for the viewport, caching the result.
+func (m model) renderMessage(msg *message) string {
+	width := m.viewport.Width
+	if msg.rendered != "" && msg.width == width {
+		return msg.rendered
+	}
+	switch msg.role {
+	case "user":
+		msg.rendered = m.senderStyle.Render("You: ") + msg.text
+	case "assistant":
+		msg.rendered = m.botStyle.Render("Castor:") + "\n" + m.markdown.render(tagCodeFences(msg.text), width)
+	case "tool":
+		msg.rendered = m.renderToolCall(msg)
+	default:
+		msg.rendered = m.sysStyle.Render(msg.text)
+	}
+	msg.width = width
+	return msg.rendered
+}
@@ -0,0 +1,46 @@
+package tui
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// DefaultNotifyAfter is how long a run must take before its end is
+// announced while the terminal is in the background.
+const DefaultNotifyAfter = 10 * time.Second
+
+// shouldNotify reports whether the end of the current run deserves a
+// notification: it took long enough, the user did not stop it, and the
+// terminal has lost focus since. Terminals that do not report focus never
+// notify.
+func (m model) shouldNotify() bool {
+	return m.notifyAfter > 0 && !m.focused && !m.cancelled && time.Since(m.started) >= m.notifyAfter
+}
+
+// notify rings the terminal bell and posts body as a desktop notification
+// with OSC 9, which terminals without support ignore.
+func notify(body string) tea.Cmd {
+	return func() tea.Msg {
+		// Keep the sequence's terminator out of the text
+		body = strings.Map(func(r rune) rune {
+			if r < ' ' {
+				return ' '
+			}
+			return r
+		}, body)
+		seq := "\x1b]9;" + body + "\x07"
+		switch {
+		case os.Getenv("TMUX") != "":
+			seq = "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+		case strings.HasPrefix(os.Getenv("TERM"), "screen"):
+			seq = "\x1bP" + seq + "\x1b\\"
+		}
+		// The renderer owns stdout, so write to the terminal through
+		// stderr
+		_, _ = os.Stderr.WriteString("\a" + seq)
+		return nil
+	}
+}
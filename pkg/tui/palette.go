@@ -0,0 +1,208 @@
+package tui
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/techmuch/castor/pkg/agent"
+)
+
+// command describes a slash command, for /help and the command palette.
+type command struct {
+	name string
+	args string // Usage of the arguments; empty when there are none
+	help string
+}
+
+// commands are the slash commands, in the order /help lists them.
+var commands = []command{
+	{"/tools", "", "List all available tools"},
+	{"/sys", "", "Show current system prompt"},
+	{"/undo", "[n]", "Revert the last n file edits"},
+	{"/debug", "", "Toggle the debug log panel"},
+	{"/sessions", "", "Browse, load, fork, or delete saved sessions"},
+	{"/fork", "[turn]", "Continue in a new session from the end of a turn, keeping this one"},
+	{"/mouse", "", "Toggle mouse scrolling (off lets the terminal select text)"},
+	{"/viewer", "", "Show the file the agent last read or edited beside the chat"},
+	{"/search", "[text]", "Search the transcript"},
+	{"/copy", "[n]", "Copy the last reply, or its nth code block"},
+	{"/export", "[markdown|html|json] <path>", "Save the conversation, with tool calls and diffs, to a file"},
+	{"/prompts", "", "List MCP server prompts"},
+	{"/prompt", "<server>/<name> [arg=value ...]", "Send an MCP prompt (Tab completes)"},
+	{"/clear", "", "Clear chat history"},
+	{"/help", "", "Show this help message"},
+	{"/quit", "", "Exit the application"},
+}
+
+// helpText lists the commands and key bindings.
+func (m model) helpText() string {
+	keyHelp := []struct {
+		key  key.Binding
+		help string
+	}{
+		{m.keys.Palette, "Open the command palette"},
+		{key.NewBinding(key.WithHelp("@<file>", "")), "Attach a workspace file to the message (type @ to pick one)"},
+		{m.keys.Search, "Search the transcript"},
+		{m.keys.Copy, "Copy the last reply"},
+		{m.keys.CopyCode, "Copy the reply's code blocks, from the last one back"},
+		{m.keys.ToggleTools, "Expand or collapse tool calls (or click one)"},
+		{m.keys.FilePane, "Show or hide the file pane"},
+		{m.keys.Newline, "Start a new line"},
+		{m.keys.Cancel, "Cancel the running reply"},
+	}
+
+	lines := []string{"Available Commands:"}
+	for _, c := range commands {
+		lines = append(lines, fmt.Sprintf("  %-16s - %s", strings.TrimSpace(c.name+" "+c.args), c.help))
+	}
+	lines = append(lines, "", "Keys:")
+	for _, k := range keyHelp {
+		if label := k.key.Help().Key; label != "" {
+			lines = append(lines, fmt.Sprintf("  %-16s - %s", label, k.help))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// paletteItem is one entry of the command palette.
+type paletteItem struct {
+	kind   string // "command", "tool", or "session"
+	label  string
+	detail string
+	run    func(m model) (tea.Model, tea.Cmd)
+}
+
+// palette is the Ctrl+K list of everything that can be run or opened,
+// narrowed by fuzzy search.
+type palette struct {
+	query   string
+	items   []paletteItem
+	matches []paletteItem
+	cursor  int
+}
+
+// openPalette lists the commands, tools, and saved sessions.
+func (m model) openPalette() (tea.Model, tea.Cmd) {
+	p := &palette{}
+	for _, c := range commands {
+		p.items = append(p.items, paletteItem{kind: "command", label: c.name, detail: c.help, run: func(m model) (tea.Model, tea.Cmd) {
+			if c.args != "" {
+				// Let the user fill in the arguments
+				m.textarea.SetValue(c.name + " ")
+				return m, nil
+			}
+			return m.handleCommand(c.name)
+		}})
+	}
+
+	var names []string
+	for name := range m.agent.Tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := m.agent.Tools[name]
+		p.items = append(p.items, paletteItem{kind: "tool", label: name, detail: firstLine(t.Description()), run: func(m model) (tea.Model, tea.Cmd) {
+			return m.system(fmt.Sprintf("Tool %s: %s", t.Name(), t.Description()))
+		}})
+	}
+
+	if m.sessionDir != "" {
+		sessions, _ := agent.ListSessions(m.sessionDir)
+		for _, s := range sessions {
+			title := s.Title
+			if title == "" {
+				title = filepath.Base(s.Path)
+			}
+			p.items = append(p.items, paletteItem{kind: "session", label: title, detail: s.Updated.Format("2006-01-02 15:04"), run: func(m model) (tea.Model, tea.Cmd) {
+				if err := m.loadSession(s.Path); err != nil {
+					return m.system(fmt.Sprintf("Failed to load session: %v", err))
+				}
+				return m, nil
+			}})
+		}
+	}
+
+	p.filter()
+	m.palette = p
+	return m, nil
+}
+
+// updatePalette handles keys while the command palette is open.
+func (m model) updatePalette(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	p := m.palette
+	if key.Matches(msg, m.keys.Palette) {
+		m.palette = nil
+		return m, nil
+	}
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEsc:
+		m.palette = nil
+	case tea.KeyUp, tea.KeyCtrlP:
+		p.cursor = max(p.cursor-1, 0)
+	case tea.KeyDown, tea.KeyCtrlN:
+		p.cursor = min(p.cursor+1, max(len(p.matches)-1, 0))
+	case tea.KeyEnter:
+		m.palette = nil
+		if len(p.matches) > 0 {
+			return p.matches[p.cursor].run(m)
+		}
+	case tea.KeyBackspace:
+		if r := []rune(p.query); len(r) > 0 {
+			p.query = string(r[:len(r)-1])
+			p.filter()
+		}
+	case tea.KeySpace:
+		p.query += " "
+		p.filter()
+	case tea.KeyRunes:
+		p.query += string(msg.Runes)
+		p.filter()
+	}
+	return m, nil
+}
+
+// filter narrows the items to those matching the query, best first.
+func (p *palette) filter() {
+	type scored struct {
+		item  paletteItem
+		score int
+	}
+	var found []scored
+	for _, item := range p.items {
+		if s, ok := fuzzyScore(item.label, p.query); ok {
+			found = append(found, scored{item, s})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].score < found[j].score })
+	p.matches = p.matches[:0]
+	for _, f := range found {
+		p.matches = append(p.matches, f.item)
+	}
+	p.cursor = 0
+}
+
+// paletteView renders the matching items.
+func (m model) paletteView() []string {
+	p := m.palette
+	lines := []string{m.botStyle.Render("> "+p.query) + m.sysStyle.Render("  ↑/↓ select · enter run · esc close")}
+	if len(p.matches) == 0 {
+		lines = append(lines, m.sysStyle.Render("  No matches"))
+	}
+	start := max(p.cursor-pickerHeight+1, 0)
+	for i := start; i < len(p.matches) && i < start+pickerHeight; i++ {
+		item := p.matches[i]
+		line := fmt.Sprintf("  %-8s %-30s %s", item.kind, truncate(item.label, 30), m.sysStyle.Render(truncate(item.detail, 50)))
+		if i == p.cursor {
+			line = m.senderStyle.Render("›") + line[1:]
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,236 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	iofs "io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/techmuch/castor/pkg/tools/workspace"
+	"github.com/techmuch/castor/pkg/vfs"
+)
+
+const (
+	maxPickerFiles = 20000 // Files listed from the workspace, at most
+	pickerHeight   = 8     // Matches shown at once
+)
+
+// picker is the file list opened by typing "@", narrowed by what is typed
+// after it.
+type picker struct {
+	files   []string // Workspace files, slash-separated and relative
+	loading bool
+	query   string
+	matches []string
+	cursor  int
+}
+
+// pickerFilesMsg delivers the workspace files to an open picker.
+type pickerFilesMsg struct {
+	files []string
+	err   error
+}
+
+// openPicker shows the file picker and starts listing the workspace.
+func (m model) openPicker() (tea.Model, tea.Cmd) {
+	m.picker = &picker{loading: true}
+	return m, listFiles(m.workspaceRoot())
+}
+
+// listFiles walks the workspace for files that are not ignored.
+func listFiles(root string) tea.Cmd {
+	return func() tea.Msg {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			return pickerFilesMsg{err: err}
+		}
+		ignorer := workspace.NewIgnorer(root)
+		var files []string
+		err = vfs.WalkDir(vfs.OS, root, func(path string, d iofs.DirEntry, err error) error {
+			if err != nil {
+				if path == root {
+					return err
+				}
+				return nil // Skip unreadable entries
+			}
+			if path == root {
+				return nil
+			}
+			if ignorer.Ignored(path, d.IsDir()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.IsDir() {
+				rel, _ := filepath.Rel(root, path)
+				files = append(files, filepath.ToSlash(rel))
+				if len(files) >= maxPickerFiles {
+					return iofs.SkipAll
+				}
+			}
+			return nil
+		})
+		return pickerFilesMsg{files: files, err: err}
+	}
+}
+
+// updatePicker handles keys while the file picker is open.
+func (m model) updatePicker(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	p := m.picker
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEsc:
+		m.picker = nil // Leave the "@" as typed
+	case tea.KeyUp:
+		p.cursor = max(p.cursor-1, 0)
+	case tea.KeyDown:
+		p.cursor = min(p.cursor+1, max(len(p.matches)-1, 0))
+	case tea.KeyEnter, tea.KeyTab:
+		if len(p.matches) > 0 {
+			m.textarea.InsertString(p.matches[p.cursor] + " ")
+		}
+		m.picker = nil
+	case tea.KeyBackspace:
+		if p.query == "" {
+			// Take back the "@" that opened the picker
+			m.picker = nil
+			var cmd tea.Cmd
+			m.textarea, cmd = m.textarea.Update(msg)
+			return m, cmd
+		}
+		r := []rune(p.query)
+		p.query = string(r[:len(r)-1])
+		p.filter()
+	case tea.KeySpace:
+		// Paths rarely contain spaces; treat it as the end of the mention
+		m.textarea.InsertString(p.query + " ")
+		m.picker = nil
+	case tea.KeyRunes:
+		p.query += string(msg.Runes)
+		p.filter()
+	}
+	return m, nil
+}
+
+// filter narrows the files to those matching the query, best first.
+func (p *picker) filter() {
+	type scored struct {
+		path  string
+		score int
+	}
+	var found []scored
+	for _, f := range p.files {
+		if s, ok := fuzzyScore(f, p.query); ok {
+			found = append(found, scored{f, s})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].score != found[j].score {
+			return found[i].score < found[j].score
+		}
+		return len(found[i].path) < len(found[j].path)
+	})
+	p.matches = p.matches[:0]
+	for _, f := range found {
+		p.matches = append(p.matches, f.path)
+	}
+	p.cursor = 0
+}
+
+// fuzzyScore reports whether the characters of query appear in path in
+// order, ignoring case, and scores the match; lower is better. Matches in
+// the file name, and runs of adjacent characters, score best.
+func fuzzyScore(path, query string) (int, bool) {
+	if query == "" {
+		return 0, true
+	}
+	path, query = strings.ToLower(path), strings.ToLower(query)
+	base := strings.LastIndex(path, "/") + 1
+	if i := strings.Index(path[base:], query); i >= 0 {
+		return i, true // The file name contains the query
+	}
+
+	score, last := 100, -1
+	q := []rune(query)
+	for i, r := range path {
+		if len(q) == 0 {
+			break
+		}
+		if r != q[0] {
+			continue
+		}
+		if last >= 0 {
+			score += i - last - 1 // Penalize gaps
+		}
+		if i < base {
+			score++ // Prefer matches in the file name
+		}
+		last = i
+		q = q[1:]
+	}
+	return score, len(q) == 0
+}
+
+// pickerView renders the matching files.
+func (m model) pickerView() []string {
+	p := m.picker
+	lines := []string{m.botStyle.Render("@"+p.query) + m.sysStyle.Render("  ↑/↓ select · enter attach · esc cancel")}
+	switch {
+	case p.loading:
+		lines = append(lines, m.sysStyle.Render("  Listing files…"))
+	case len(p.matches) == 0:
+		lines = append(lines, m.sysStyle.Render("  No matching files"))
+	}
+	start := max(p.cursor-pickerHeight+1, 0)
+	for i := start; i < len(p.matches) && i < start+pickerHeight; i++ {
+		line := "  " + p.matches[i]
+		if i == p.cursor {
+			line = m.senderStyle.Render("›") + line[1:]
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// workspaceRoot returns the directory that "@" mentions are relative to.
+func (m model) workspaceRoot() string {
+	if m.workspace == "" {
+		return "."
+	}
+	return m.workspace
+}
+
+// attachFiles appends the contents of the files mentioned with "@" to a
+// message. Files are read with the agent's read_file tool, so the usual
+// workspace limits apply and large files are cut off with a note on how
+// to read the rest. Mentions that are not files are left as they are.
+func (m model) attachFiles(input string) string {
+	read := m.agent.Tools["read_file"]
+	if read == nil {
+		return input
+	}
+	seen := make(map[string]bool)
+	var attached []string
+	for _, word := range strings.Fields(input) {
+		path, ok := strings.CutPrefix(word, "@")
+		path = strings.TrimRight(path, ".,;:!?)")
+		if !ok || path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		out, err := read.Execute(context.Background(), map[string]interface{}{"path": path})
+		if err != nil {
+			continue
+		}
+		attached = append(attached, fmt.Sprintf("Contents of %s:\n```\n%s\n```", path, strings.TrimRight(fmt.Sprint(out), "\n")))
+	}
+	if len(attached) == 0 {
+		return input
+	}
+	return input + "\n\n" + strings.Join(attached, "\n\n")
+}
@@ -0,0 +1,234 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/techmuch/castor/pkg/mcp"
+)
+
+// promptTimeout bounds MCP prompt and completion requests.
+const promptTimeout = 10 * time.Second
+
+// maxShownCompletions caps how many suggestions the status line lists.
+const maxShownCompletions = 8
+
+// promptsMsg carries the /prompts listing.
+type promptsMsg string
+
+// promptMsg carries a filled-in prompt to send to the agent.
+type promptMsg struct {
+	text string
+	err  error
+}
+
+// completionMsg carries suggestions for the last word of input.
+type completionMsg struct {
+	input  string
+	values []string
+	err    error
+}
+
+// listPrompts lists the prompts of every server.
+func listPrompts(servers []*mcp.Conn) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), promptTimeout)
+		defer cancel()
+
+		var lines []string
+		for _, s := range servers {
+			prompts, err := s.ListPrompts(ctx)
+			if err != nil {
+				lines = append(lines, fmt.Sprintf("• %s: %v", s.Name, err))
+				continue
+			}
+			for _, p := range prompts {
+				var args []string
+				for _, a := range p.Arguments {
+					if a.Required {
+						args = append(args, a.Name+"=…")
+					} else {
+						args = append(args, "["+a.Name+"=…]")
+					}
+				}
+				line := fmt.Sprintf("• %s/%s %s", s.Name, p.Name, strings.Join(args, " "))
+				if p.Description != "" {
+					line += "\n    " + p.Description
+				}
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) == 0 {
+			return promptsMsg("No MCP prompts available.")
+		}
+		return promptsMsg("MCP Prompts (Tab completes arguments):\n" + strings.Join(lines, "\n"))
+	}
+}
+
+// runPrompt fills in the prompt named by args[0] ("server/prompt") with
+// the key=value pairs that follow.
+func runPrompt(servers []*mcp.Conn, args []string) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), promptTimeout)
+		defer cancel()
+
+		conn, prompt, err := findPrompt(ctx, servers, args[0])
+		if err != nil {
+			return promptMsg{err: err}
+		}
+		values := make(map[string]string)
+		for _, arg := range args[1:] {
+			key, value, ok := strings.Cut(arg, "=")
+			if !ok {
+				return promptMsg{err: fmt.Errorf("expected key=value, got %q", arg)}
+			}
+			values[key] = value
+		}
+		for _, a := range prompt.Arguments {
+			if _, ok := values[a.Name]; a.Required && !ok {
+				return promptMsg{err: fmt.Errorf("prompt %s requires %s", args[0], a.Name)}
+			}
+		}
+
+		messages, err := conn.GetPrompt(ctx, prompt.Name, values)
+		if err != nil {
+			return promptMsg{err: err}
+		}
+		return promptMsg{text: mcp.PromptText(messages)}
+	}
+}
+
+// completePrompt suggests completions for the last word of a /prompt
+// command line: the prompt name, an argument name, or an argument value
+// supplied by the server.
+func completePrompt(servers []*mcp.Conn, input string) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), promptTimeout)
+		defer cancel()
+		values, err := promptCompletions(ctx, servers, input)
+		return completionMsg{input: input, values: values, err: err}
+	}
+}
+
+// promptCompletions returns candidates that replace the last word of input.
+func promptCompletions(ctx context.Context, servers []*mcp.Conn, input string) ([]string, error) {
+	words := strings.Fields(input)
+	last := lastWord(input)
+	if last == "" {
+		words = append(words, "")
+	}
+
+	if len(words) == 2 {
+		var names []string
+		for _, s := range servers {
+			prompts, err := s.ListPrompts(ctx)
+			if err != nil {
+				continue
+			}
+			for _, p := range prompts {
+				if name := s.Name + "/" + p.Name; strings.HasPrefix(name, last) {
+					names = append(names, name)
+				}
+			}
+		}
+		return names, nil
+	}
+
+	conn, prompt, err := findPrompt(ctx, servers, words[1])
+	if err != nil {
+		return nil, err
+	}
+	key, value, ok := strings.Cut(last, "=")
+	if !ok {
+		var names []string
+		for _, a := range prompt.Arguments {
+			if strings.HasPrefix(a.Name, key) {
+				names = append(names, a.Name+"=")
+			}
+		}
+		return names, nil
+	}
+
+	completion, err := conn.Complete(ctx, mcp.PromptRef(prompt.Name), key, value)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, v := range completion.Values {
+		values = append(values, key+"="+v)
+	}
+	return values, nil
+}
+
+// findPrompt looks up a prompt by its "server/prompt" name.
+func findPrompt(ctx context.Context, servers []*mcp.Conn, name string) (*mcp.Conn, mcp.Prompt, error) {
+	server, promptName, ok := strings.Cut(name, "/")
+	if !ok {
+		return nil, mcp.Prompt{}, fmt.Errorf("expected <server>/<prompt>, got %q", name)
+	}
+	for _, s := range servers {
+		if s.Name != server {
+			continue
+		}
+		prompts, err := s.ListPrompts(ctx)
+		if err != nil {
+			return nil, mcp.Prompt{}, err
+		}
+		for _, p := range prompts {
+			if p.Name == promptName {
+				return s, p, nil
+			}
+		}
+		return nil, mcp.Prompt{}, fmt.Errorf("MCP server %s has no prompt %q", server, promptName)
+	}
+	return nil, mcp.Prompt{}, fmt.Errorf("no MCP server named %q", server)
+}
+
+// applyCompletion replaces the last word of the input with the completed
+// text, or with the suggestions' common prefix when there are several.
+func (m *model) applyCompletion(msg completionMsg) {
+	switch {
+	case msg.err != nil:
+		m.status = "Completion failed: " + msg.err.Error()
+		return
+	case len(msg.values) == 0:
+		m.status = "No completions"
+		return
+	}
+
+	word := msg.values[0]
+	for _, v := range msg.values[1:] {
+		word = commonPrefix(word, v)
+	}
+	if len(msg.values) == 1 && !strings.HasSuffix(word, "=") {
+		word += " "
+	}
+	base := strings.TrimSuffix(msg.input, lastWord(msg.input))
+	m.textarea.SetValue(base + word)
+
+	m.status = ""
+	if len(msg.values) > 1 {
+		shown := msg.values
+		if len(shown) > maxShownCompletions {
+			shown = append(shown[:maxShownCompletions:maxShownCompletions], "…")
+		}
+		m.status = strings.Join(shown, "  ")
+	}
+}
+
+// lastWord returns the word being typed at the end of input, which is
+// empty after a trailing space.
+func lastWord(input string) string {
+	return input[strings.LastIndex(input, " ")+1:]
+}
+
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
@@ -0,0 +1,100 @@
+package tui
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/techmuch/castor/pkg/agent"
+)
+
+// resumeWindow is how recently a session must have been updated to be
+// offered on startup.
+const resumeWindow = 7 * 24 * time.Hour
+
+// resume is the startup prompt offering to continue the latest session.
+type resume struct {
+	last   agent.SessionInfo
+	cursor int    // 0 continues, 1 starts anew, 2 browses
+	notice string // Why the session could not be loaded
+}
+
+// resumeChoices are the options of the startup prompt, in order.
+var resumeChoices = []string{"Continue last session", "Start a new session", "Browse all sessions…"}
+
+// offerResume opens the startup prompt when a session was saved recently.
+func (m *model) offerResume() {
+	if m.sessionDir == "" {
+		return
+	}
+	sessions, err := agent.ListSessions(m.sessionDir)
+	if err != nil || len(sessions) == 0 || time.Since(sessions[0].Updated) > resumeWindow {
+		return
+	}
+	m.resume = &resume{last: sessions[0]}
+}
+
+// updateResume handles keys while the startup prompt is shown. Esc or n
+// starts a new session, c continues the last one, and b browses them all.
+func (m model) updateResume(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	r := m.resume
+	choice := -1
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "up", "k":
+		r.cursor = max(r.cursor-1, 0)
+	case "down", "j":
+		r.cursor = min(r.cursor+1, len(resumeChoices)-1)
+	case "enter":
+		choice = r.cursor
+	case "c":
+		choice = 0
+	case "esc", "n":
+		choice = 1
+	case "b":
+		choice = 2
+	}
+
+	switch choice {
+	case 0:
+		if err := m.loadSession(r.last.Path); err != nil {
+			r.notice = err.Error()
+			return m, nil
+		}
+		m.resume = nil
+	case 1:
+		m.resume = nil
+	case 2:
+		m.resume = nil
+		return m.openBrowser()
+	}
+	return m, nil
+}
+
+// resumeView renders the startup prompt.
+func (m model) resumeView() string {
+	r := m.resume
+	title := r.last.Title
+	if title == "" {
+		title = filepath.Base(r.last.Path)
+	}
+	lines := []string{
+		m.botStyle.Render("Welcome back") + m.sysStyle.Render("  ↑/↓ select · enter choose · esc start new"),
+		"",
+		fmt.Sprintf("  Last session: %s", title),
+		m.sysStyle.Render(fmt.Sprintf("  %s  %s  ~%s tokens", r.last.Updated.Format("2006-01-02 15:04"), orDash(r.last.Model), formatCount(r.last.Tokens))),
+		"",
+	}
+	for i, choice := range resumeChoices {
+		line := "  " + choice
+		if i == r.cursor {
+			line = m.senderStyle.Render("›") + line[1:]
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "", m.sysStyle.Render(r.notice))
+	return strings.Join(lines, "\n")
+}
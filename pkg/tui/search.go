@@ -0,0 +1,130 @@
+package tui
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// search finds text in the transcript. Lines that match are shown
+// without their usual colors so the matches can be marked.
+type search struct {
+	query   string
+	pattern *regexp.Regexp // Case-insensitive query; nil when it is empty
+	lines   []int          // Transcript lines that match, in order
+	current int            // Index into lines of the match in view
+}
+
+var (
+	matchStyle        = lipgloss.NewStyle().Background(lipgloss.Color("58")).Foreground(lipgloss.Color("230"))
+	currentMatchStyle = lipgloss.NewStyle().Background(lipgloss.Color("214")).Foreground(lipgloss.Color("0"))
+)
+
+// openSearch starts a search of the transcript for query, which may be
+// empty to let the user type it.
+func (m model) openSearch(query string) (tea.Model, tea.Cmd) {
+	m.search = &search{}
+	m.setQuery(query)
+	return m, nil
+}
+
+// updateSearch handles keys while searching. Typing edits the query;
+// ↑/↓ or Enter move between matches, starting from the newest.
+func (m model) updateSearch(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	s := m.search
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEsc:
+		m.search = nil
+		m.viewport.SetContent(m.content())
+	case tea.KeyUp, tea.KeyCtrlP, tea.KeyShiftTab:
+		m.jumpTo(s.current - 1)
+	case tea.KeyDown, tea.KeyCtrlN, tea.KeyEnter, tea.KeyTab:
+		m.jumpTo(s.current + 1)
+	case tea.KeyPgUp, tea.KeyPgDown:
+		var cmd tea.Cmd
+		m.viewport, cmd = m.viewport.Update(msg)
+		return m, cmd
+	case tea.KeyBackspace:
+		if r := []rune(s.query); len(r) > 0 {
+			m.setQuery(string(r[:len(r)-1]))
+		}
+	case tea.KeySpace:
+		m.setQuery(s.query + " ")
+	case tea.KeyRunes:
+		m.setQuery(s.query + string(msg.Runes))
+	}
+	return m, nil
+}
+
+// setQuery searches for query and shows the newest match.
+func (m *model) setQuery(query string) {
+	s := m.search
+	s.query, s.pattern, s.lines = query, nil, nil
+	if query != "" {
+		s.pattern = regexp.MustCompile("(?i)" + regexp.QuoteMeta(query))
+		for i, line := range strings.Split(ansi.Strip(m.content()), "\n") {
+			if s.pattern.MatchString(line) {
+				s.lines = append(s.lines, i)
+			}
+		}
+	}
+	m.jumpTo(len(s.lines) - 1)
+}
+
+// jumpTo scrolls match i into the middle of the view, wrapping around at
+// either end.
+func (m *model) jumpTo(i int) {
+	s := m.search
+	if len(s.lines) == 0 {
+		s.current = 0
+		m.viewport.SetContent(m.content())
+		return
+	}
+	s.current = (i + len(s.lines)) % len(s.lines)
+	m.viewport.SetContent(m.content())
+	m.viewport.SetYOffset(s.lines[s.current] - m.viewport.Height/2)
+}
+
+// markMatches marks the matches of the search in the rendered transcript.
+func (m model) markMatches(content string) string {
+	s := m.search
+	if s == nil || s.pattern == nil {
+		return content
+	}
+	current := -1
+	if len(s.lines) > 0 {
+		current = s.lines[s.current]
+	}
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		plain := ansi.Strip(line)
+		if !s.pattern.MatchString(plain) {
+			continue
+		}
+		style := matchStyle
+		if i == current {
+			style = currentMatchStyle
+		}
+		lines[i] = s.pattern.ReplaceAllStringFunc(plain, func(match string) string { return style.Render(match) })
+	}
+	return strings.Join(lines, "\n")
+}
+
+// searchView renders the search line shown below the transcript.
+func (m model) searchView() string {
+	s := m.search
+	count := "no matches"
+	switch {
+	case s.query == "":
+		count = "type to search"
+	case len(s.lines) > 0:
+		count = fmt.Sprintf("%d/%d", s.current+1, len(s.lines))
+	}
+	return m.botStyle.Render("Search: ") + s.query + m.sysStyle.Render("  "+count+" · ↑/↓ previous/next · esc close")
+}
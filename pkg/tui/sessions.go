@@ -0,0 +1,324 @@
+package tui
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// browser is the /sessions screen, listing saved sessions.
+type browser struct {
+	sessions      []agent.SessionInfo
+	cursor        int
+	confirmDelete bool   // The next "d" deletes the selected session
+	notice        string // Outcome of the last action
+}
+
+// openBrowser shows the saved sessions.
+func (m model) openBrowser() (tea.Model, tea.Cmd) {
+	if m.sessionDir == "" {
+		return m.system("Sessions are not saved in this mode.")
+	}
+	sessions, err := agent.ListSessions(m.sessionDir)
+	if err != nil {
+		return m.system(fmt.Sprintf("Failed to list sessions: %v", err))
+	}
+	m.browser = &browser{sessions: sessions}
+	return m, nil
+}
+
+// updateBrowser handles keys while the session list is shown.
+func (m model) updateBrowser(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	b := m.browser
+	confirming := b.confirmDelete
+	b.confirmDelete = false
+	b.notice = ""
+
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc", "q":
+		m.browser = nil
+	case "up", "k":
+		b.cursor = max(b.cursor-1, 0)
+	case "down", "j":
+		b.cursor = min(b.cursor+1, max(len(b.sessions)-1, 0))
+	case "enter":
+		if len(b.sessions) > 0 {
+			return m.openSession(b.sessions[b.cursor].Path)
+		}
+	case "d":
+		if len(b.sessions) == 0 {
+			break
+		}
+		s := b.sessions[b.cursor]
+		if s.Path == m.sessionPath {
+			b.notice = "The current session cannot be deleted."
+			break
+		}
+		if !confirming {
+			b.confirmDelete = true
+			b.notice = "Press d again to delete this session."
+			break
+		}
+		if err := agent.DeleteSession(s.Path); err != nil {
+			b.notice = fmt.Sprintf("Delete failed: %v", err)
+			break
+		}
+		b.sessions = append(b.sessions[:b.cursor], b.sessions[b.cursor+1:]...)
+		b.cursor = min(b.cursor, max(len(b.sessions)-1, 0))
+		b.notice = "Deleted " + s.Title
+	case "f":
+		if len(b.sessions) == 0 {
+			break
+		}
+		path := agent.NewSessionPath(m.sessionDir)
+		if err := agent.ForkSession(b.sessions[b.cursor].Path, path, 0); err != nil {
+			b.notice = fmt.Sprintf("Fork failed: %v", err)
+			break
+		}
+		return m.openSession(path)
+	}
+	return m, nil
+}
+
+// openSession loads the session at path from the browser, which stays
+// open with the reason if it cannot be loaded.
+func (m model) openSession(path string) (tea.Model, tea.Cmd) {
+	if err := m.loadSession(path); err != nil {
+		m.browser.notice = err.Error()
+		return m, nil
+	}
+	m.browser = nil
+	return m, nil
+}
+
+// loadSession saves the current session and switches to the one at path.
+func (m *model) loadSession(path string) error {
+	if m.running {
+		return fmt.Errorf("wait for the current reply to finish")
+	}
+	if err := m.saveSession(); err != nil {
+		return err
+	}
+	if err := m.agent.LoadSession(path); err != nil {
+		return err
+	}
+	m.sessionPath = path
+	m.syncUsage()
+	m.messages = transcript(m.agent.History)
+	m.messages = append(m.messages, message{role: "system", text: "Loaded session: " + m.agent.Title})
+	m.refresh()
+	return nil
+}
+
+// forkSession continues the conversation in a new session, from the end
+// of its first turns turns or from here for zero. The current session
+// is saved first and stays as it was.
+func (m *model) forkSession(turns int) error {
+	if m.running {
+		return fmt.Errorf("wait for the current reply to finish")
+	}
+	fork, err := m.agent.Fork(turns)
+	if err != nil {
+		return err
+	}
+	if err := m.saveSession(); err != nil {
+		return err
+	}
+	*m.agent = *fork
+	m.sessionPath = agent.NewSessionPath(m.sessionDir)
+	if err := m.saveSession(); err != nil {
+		return err
+	}
+	m.syncUsage()
+	m.messages = transcript(m.agent.History)
+	m.messages = append(m.messages, message{role: "system", text: "Forked into a new session: " + m.agent.Title})
+	m.refresh()
+	return nil
+}
+
+// titleTimeout bounds the request that names a session.
+const titleTimeout = 30 * time.Second
+
+// titleMsg carries the generated title of the session saved at path.
+type titleMsg struct {
+	path  string
+	title string
+}
+
+// nameSession returns a command that generates a title for the session
+// after its first turn, or nil if it needs none. A failure leaves the
+// session named after its first message.
+func (m model) nameSession() tea.Cmd {
+	if m.sessionPath == "" || !m.agent.NeedsTitle() {
+		return nil
+	}
+	provider, history, path := m.agent.TitleProvider, slices.Clone(m.agent.History), m.sessionPath
+	logger := m.agent.Logger
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), titleTimeout)
+		defer cancel()
+		title, err := agent.GenerateTitle(ctx, provider, history)
+		if err != nil {
+			if logger != nil {
+				logger.Warn("failed to name the session", "error", err)
+			}
+			return nil
+		}
+		return titleMsg{path: path, title: title}
+	}
+}
+
+// saveSession writes the conversation to the current session file once it
+// has started.
+func (m model) saveSession() error {
+	if m.sessionPath == "" || !hasUserMessage(m.agent.History) {
+		return nil
+	}
+	if err := m.agent.SaveSession(m.sessionPath); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	return nil
+}
+
+// export writes the conversation to a file for /export [format] <path>,
+// and describes the outcome. The format follows the file extension unless
+// it is given.
+func (m model) export(args []string) string {
+	var format, path string
+	switch len(args) {
+	case 1:
+		path = args[0]
+		format = agent.ExportFormat(path)
+	case 2:
+		format, path = args[0], args[1]
+	default:
+		return "Usage: /export [" + strings.Join(agent.ExportFormats, "|") + "] <path>"
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(m.workspaceRoot(), path)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Sprintf("Export failed: %v", err)
+	}
+	err = m.agent.Export(f, format)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Sprintf("Export failed: %v", err)
+	}
+	return fmt.Sprintf("Exported the conversation as %s to %s", format, path)
+}
+
+// browserView renders the session list.
+func (m model) browserView() string {
+	b := m.browser
+	lines := []string{m.botStyle.Render("Saved sessions") + m.sysStyle.Render("  ↑/↓ select · enter load · f fork · d delete · esc back"), ""}
+	if len(b.sessions) == 0 {
+		lines = append(lines, m.sysStyle.Render("No saved sessions in "+m.sessionDir))
+	}
+
+	// Keep the cursor in view
+	height := max(m.viewport.Height-3, 1)
+	start := max(b.cursor-height+1, 0)
+	for i := start; i < len(b.sessions) && i < start+height; i++ {
+		s := b.sessions[i]
+		title := s.Title
+		if title == "" {
+			title = filepath.Base(s.Path)
+		}
+		if s.Path == m.sessionPath {
+			title += " (current)"
+		}
+		details := fmt.Sprintf("%s  %s  ~%s tokens", s.Updated.Format("2006-01-02 15:04"), orDash(s.Model), formatCount(s.Tokens))
+		if s.Cost > 0 {
+			details += fmt.Sprintf("  $%.4f", s.Cost)
+		}
+		if len(s.Tags) > 0 {
+			details += "  #" + strings.Join(s.Tags, " #")
+		}
+		line := fmt.Sprintf("  %-40s %s", truncate(title, 40), m.sysStyle.Render(details))
+		if i == b.cursor {
+			line = m.senderStyle.Render("›") + line[1:]
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "", m.sysStyle.Render(b.notice))
+	return strings.Join(lines, "\n")
+}
+
+// transcript rebuilds chat messages from an agent history.
+func transcript(history []llm.Message) []message {
+	var messages []message
+	for _, msg := range history {
+		for _, part := range msg.Content {
+			switch p := part.(type) {
+			case llm.TextPart:
+				switch msg.Role {
+				case llm.RoleUser:
+					messages = append(messages, message{role: "user", text: p.Text})
+				case llm.RoleModel:
+					messages = append(messages, message{role: "assistant", text: p.Text})
+				}
+			case llm.ToolResponsePart:
+				// String results were stored JSON-quoted
+				output := p.Content
+				var s string
+				if json.Unmarshal([]byte(output), &s) == nil {
+					output = s
+				}
+				messages = append(messages, message{role: "tool", tool: &agent.ToolResult{
+					ToolCallID: p.ID,
+					Tool:       p.Name,
+					Output:     output,
+				}})
+			}
+		}
+	}
+	return messages
+}
+
+func hasUserMessage(history []llm.Message) bool {
+	for _, msg := range history {
+		if msg.Role == llm.RoleUser {
+			return true
+		}
+	}
+	return false
+}
+
+// formatCount abbreviates large counts, e.g. 12.3k.
+func formatCount(n int) string {
+	if n < 1000 {
+		return fmt.Sprint(n)
+	}
+	return fmt.Sprintf("%.1fk", float64(n)/1000)
+}
+
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
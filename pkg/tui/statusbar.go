@@ -0,0 +1,91 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// addUsage records the tokens reported for one request. The last request
+// shows how full the context is; the running total prices the session.
+func (m *model) addUsage(u llm.Usage) {
+	m.usage = m.usage.Add(u)
+	m.contextTokens = u.Total()
+	if info, ok := llm.LookupModel(m.agent.Model); ok {
+		m.cost += info.Cost(u)
+	}
+}
+
+// syncUsage takes the tokens and cost of the session so far from the
+// agent, as saved with it, after a session is loaded.
+func (m *model) syncUsage() {
+	m.usage, m.cost = agent.TotalUsage(m.agent.Usage)
+	m.contextTokens = agent.ContextSize(m.agent.Usage)
+}
+
+// contextSize returns the tokens in the conversation so far, as reported
+// by the provider, or estimated when it reports nothing.
+func (m model) contextSize() int {
+	if m.contextTokens > 0 {
+		return m.contextTokens
+	}
+	return agent.EstimateTokens(m.agent.History)
+}
+
+// turns counts the user's messages in the conversation.
+func (m model) turns() int {
+	n := 0
+	for _, msg := range m.agent.History {
+		if msg.Role == llm.RoleUser {
+			n++
+		}
+	}
+	return n
+}
+
+// statusBar renders the model, workspace, turn count, context usage, and
+// cost of the session. Figures that are unknown for the model are left out.
+func (m model) statusBar() string {
+	info, known := llm.LookupModel(m.agent.Model)
+
+	fields := []string{orDash(m.agent.Model)}
+	if m.workspace != "" {
+		fields = append(fields, shortPath(m.workspace))
+	}
+	fields = append(fields, fmt.Sprintf("turn %d", m.turns()))
+	if known && info.ContextWindow > 0 {
+		fields = append(fields, fmt.Sprintf("ctx %d%%", m.contextSize()*100/info.ContextWindow))
+	} else {
+		fields = append(fields, "ctx "+formatCount(m.contextSize()))
+	}
+	if known || m.cost > 0 {
+		fields = append(fields, fmt.Sprintf("$%.4f", m.cost))
+	} else if m.usage.Total() > 0 {
+		fields = append(fields, formatCount(m.usage.Total())+" tokens")
+	}
+
+	bar := strings.Join(fields, " · ")
+	if w := m.width; w > 2 {
+		return m.statusStyle.Width(w).Render(truncate(bar, w-2))
+	}
+	return m.statusStyle.Render(bar)
+}
+
+// shortPath shows a path relative to the home directory when it is inside
+// it.
+func shortPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		if rel, err := filepath.Rel(home, abs); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.Join("~", rel)
+		}
+	}
+	return abs
+}
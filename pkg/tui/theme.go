@@ -0,0 +1,132 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Theme holds the colors of the TUI. Colors are lipgloss colors: ANSI
+// numbers such as "5" or hex values such as "#ff8800".
+type Theme struct {
+	User      string // "You:" label
+	Assistant string // "Castor:" label and headings
+	System    string // System messages and hints
+	Tool      string // Tool result headers
+	Accent    string // Welcome text
+
+	StatusForeground string
+	StatusBackground string
+
+	Added   string // Diff lines
+	Removed string
+	Hunk    string
+	Border  string // Left edge of diff panels
+
+	Markdown string // glamour style for replies: "dark" or "light"
+	Code     string // chroma style for code
+}
+
+// Themes are the built-in themes, by name.
+var Themes = map[string]Theme{
+	"dark": {
+		User:             "5",
+		Assistant:        "2",
+		System:           "240",
+		Tool:             "244",
+		Accent:           "63",
+		StatusForeground: "252",
+		StatusBackground: "236",
+		Added:            "2",
+		Removed:          "1",
+		Hunk:             "6",
+		Border:           "240",
+		Markdown:         "dark",
+		Code:             "monokai",
+	},
+	"light": {
+		User:             "90",
+		Assistant:        "28",
+		System:           "244",
+		Tool:             "242",
+		Accent:           "63",
+		StatusForeground: "235",
+		StatusBackground: "252",
+		Added:            "28",
+		Removed:          "124",
+		Hunk:             "30",
+		Border:           "250",
+		Markdown:         "light",
+		Code:             "github",
+	},
+}
+
+// LoadTheme returns the named built-in theme with colors overridden. An
+// empty name or "auto" picks dark or light to suit the terminal
+// background. Override keys are the lowerCamelCase field names of Theme,
+// such as "user" or "statusBackground".
+func LoadTheme(name string, colors map[string]string) (Theme, error) {
+	if name == "" || name == "auto" {
+		name = "light"
+		if lipgloss.HasDarkBackground() {
+			name = "dark"
+		}
+	}
+	theme, ok := Themes[name]
+	if !ok {
+		var names []string
+		for n := range Themes {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Theme{}, fmt.Errorf("unknown theme %q (available: auto, %s)", name, strings.Join(names, ", "))
+	}
+
+	fields := theme.fields()
+	for key, color := range colors {
+		field, ok := fields[key]
+		if !ok {
+			return Theme{}, fmt.Errorf("unknown theme color %q", key)
+		}
+		*field = color
+	}
+	return theme, nil
+}
+
+// fields maps override keys to the fields of t.
+func (t *Theme) fields() map[string]*string {
+	return map[string]*string{
+		"user":             &t.User,
+		"assistant":        &t.Assistant,
+		"system":           &t.System,
+		"tool":             &t.Tool,
+		"accent":           &t.Accent,
+		"statusForeground": &t.StatusForeground,
+		"statusBackground": &t.StatusBackground,
+		"added":            &t.Added,
+		"removed":          &t.Removed,
+		"hunk":             &t.Hunk,
+		"border":           &t.Border,
+		"markdown":         &t.Markdown,
+		"code":             &t.Code,
+	}
+}
+
+// setTheme styles the model with theme.
+func (m *model) setTheme(theme Theme) {
+	m.theme = theme
+	m.senderStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(theme.User)).Bold(true)
+	m.botStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Assistant))
+	m.sysStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(theme.System)).Italic(true)
+	m.toolStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Tool)).Italic(true)
+	m.statusStyle = lipgloss.NewStyle().
+		Foreground(lipgloss.Color(theme.StatusForeground)).
+		Background(lipgloss.Color(theme.StatusBackground)).
+		Padding(0, 1)
+	m.markdown = &markdown{style: theme.Markdown}
+	for i := range m.messages {
+		m.messages[i].rendered = "" // Render again in the new colors
+	}
+}
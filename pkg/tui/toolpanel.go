@@ -0,0 +1,223 @@
+package tui
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2/lexers"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/llm"
+)
+
+// maxSummaryArgs caps the length of the arguments in a collapsed tool call.
+const maxSummaryArgs = 60
+
+// renderToolCall formats a tool message: one summary line while it is
+// collapsed, or the arguments and full result once expanded.
+func (m model) renderToolCall(msg *message) string {
+	if msg.state != "" {
+		return m.toolFeedLine(msg)
+	}
+	if msg.expanded {
+		return m.toolStyle.Render("▾ ") + m.renderToolResult(msg.tool, true)
+	}
+	return m.toolSummary(msg.tool)
+}
+
+// toolSummary describes a tool call in one line: its name, main
+// arguments, and outcome.
+func (m model) toolSummary(r *agent.ToolResult) string {
+	line := m.toolStyle.Render("▸ ⚙ " + r.Tool)
+	if args := summarizeArgs(r.Args); args != "" {
+		line += " " + m.sysStyle.Render(args)
+	}
+	if r.Err != nil {
+		return line + " " + lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Removed)).Render("✗ "+firstLine(r.Err.Error()))
+	}
+	outcome := "✓"
+	if _, patch := editDiff(r.Output); patch != "" {
+		added, removed := diffStats(patch)
+		outcome += fmt.Sprintf(" +%d -%d", added, removed)
+	} else if output := strings.TrimRight(r.Output, "\n"); output != "" {
+		outcome += fmt.Sprintf(" %d lines", strings.Count(output, "\n")+1)
+	}
+	return line + " " + lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Added)).Render(outcome)
+}
+
+// toolFeedLine describes a tool call that has not finished yet, with its
+// arguments as well once expanded.
+func (m model) toolFeedLine(msg *message) string {
+	r := msg.tool
+	line := m.toolStyle.Render("▸ ⚙ " + r.Tool)
+	if msg.expanded {
+		line = m.toolStyle.Render("▾ ⚙ " + r.Tool)
+	}
+	if args := summarizeArgs(r.Args); args != "" && !msg.expanded {
+		line += " " + m.sysStyle.Render(args)
+	}
+	state := "○ pending"
+	if msg.state == "running" {
+		state = "● running"
+		if p := msg.progress; p != nil {
+			state += fmt.Sprintf(" %g", p.Progress)
+			if p.Total > 0 {
+				state += fmt.Sprintf("/%g", p.Total)
+			}
+			if p.Message != "" {
+				state += " " + firstLine(p.Message)
+			}
+		}
+	}
+	line += " " + lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Accent)).Render(state)
+	if msg.expanded && len(r.Args) > 0 {
+		args, _ := json.MarshalIndent(r.Args, "", "  ")
+		line += "\n" + highlight(string(args), lexers.Get("json"), m.theme.Code)
+	}
+	return line
+}
+
+// queueTools adds the tool calls the model asked for to the transcript,
+// after the text that led to them, as waiting to run.
+func (m *model) queueTools(calls []llm.ToolCallPart) {
+	if m.response != "" {
+		m.messages = append(m.messages, message{role: "assistant", text: m.response})
+		m.response = ""
+	}
+	for _, tc := range calls {
+		m.messages = append(m.messages, message{role: "tool", state: "pending", tool: &agent.ToolResult{
+			ToolCallID: tc.ID,
+			Tool:       tc.Name,
+			Args:       tc.Args,
+		}})
+	}
+}
+
+// unfinishedTool returns the tool message still waiting for the result of
+// the call with the given ID, or nil.
+func (m *model) unfinishedTool(id string) *message {
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		msg := &m.messages[i]
+		if msg.role == "tool" && msg.state != "" && msg.tool.ToolCallID == id {
+			return msg
+		}
+	}
+	return nil
+}
+
+// trackTool moves a tool message along as the call starts, reports
+// progress, and finishes. A result for a call that was never shown is
+// added at the end.
+func (m *model) trackTool(event agent.Event) {
+	switch {
+	case event.Started != nil:
+		if msg := m.unfinishedTool(event.Started.ID); msg != nil {
+			msg.state, msg.rendered = "running", ""
+		}
+	case event.Progress != nil:
+		if msg := m.unfinishedTool(event.Progress.ToolCallID); msg != nil {
+			msg.state, msg.progress, msg.rendered = "running", event.Progress, ""
+		}
+	case event.Result != nil:
+		if msg := m.unfinishedTool(event.Result.ToolCallID); msg != nil {
+			msg.tool, msg.state, msg.progress, msg.rendered = event.Result, "", nil, ""
+			return
+		}
+		// Show the result after the text that led to the call
+		if m.response != "" {
+			m.messages = append(m.messages, message{role: "assistant", text: m.response})
+			m.response = ""
+		}
+		m.messages = append(m.messages, message{role: "tool", tool: event.Result})
+	}
+}
+
+// abandonTools marks the tool calls that never finished, once the chat
+// has ended.
+func (m *model) abandonTools() {
+	for i := range m.messages {
+		msg := &m.messages[i]
+		if msg.role != "tool" || msg.state == "" {
+			continue
+		}
+		if msg.state == "running" {
+			msg.tool.Err = errors.New("cancelled")
+		} else {
+			msg.tool.Err = errors.New("not run")
+		}
+		msg.state, msg.progress, msg.rendered = "", nil, ""
+	}
+}
+
+// summarizeArgs lists arguments as key=value, shortening long values, with
+// the path first since it is usually the most telling.
+func summarizeArgs(args map[string]interface{}) string {
+	var keys []string
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if (keys[i] == "path") != (keys[j] == "path") {
+			return keys[i] == "path"
+		}
+		return keys[i] < keys[j]
+	})
+
+	var parts []string
+	for _, k := range keys {
+		v := firstLine(fmt.Sprint(args[k]))
+		parts = append(parts, k+"="+truncate(v, 30))
+	}
+	return truncate(strings.Join(parts, " "), maxSummaryArgs)
+}
+
+func firstLine(s string) string {
+	line, _, more := strings.Cut(s, "\n")
+	if more {
+		line += "…"
+	}
+	return line
+}
+
+// toggleTools expands every tool call, or collapses them all when any is
+// already expanded.
+func (m model) toggleTools() (tea.Model, tea.Cmd) {
+	expand := true
+	for _, msg := range m.messages {
+		if msg.role == "tool" && msg.expanded {
+			expand = false
+			break
+		}
+	}
+	for i := range m.messages {
+		if m.messages[i].role == "tool" {
+			m.messages[i].expanded = expand
+			m.messages[i].rendered = ""
+		}
+	}
+	m.viewport.SetContent(m.content())
+	return m, nil
+}
+
+// clickTool toggles the tool call shown at a row of the viewport.
+func (m model) clickTool(row int) (tea.Model, tea.Cmd) {
+	line := m.viewport.YOffset + row
+	for i := range m.messages {
+		msg := &m.messages[i]
+		height := strings.Count(m.renderMessage(msg), "\n") + 1
+		if line < height {
+			if msg.role == "tool" {
+				msg.expanded = !msg.expanded
+				msg.rendered = ""
+				m.viewport.SetContent(m.content())
+			}
+			break
+		}
+		line -= height
+	}
+	return m, nil
+}
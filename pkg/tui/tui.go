@@ -4,26 +4,84 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/llm"
+	"github.com/techmuch/castor/pkg/mcp"
 )
 
 type errMsg error
 
 type model struct {
 	viewport    viewport.Model
-	messages    []string
+	messages    []message
+	markdown    *markdown
 	textarea    textarea.Model
 	senderStyle lipgloss.Style
 	botStyle    lipgloss.Style
 	sysStyle    lipgloss.Style
+	toolStyle   lipgloss.Style
+	statusStyle lipgloss.Style
+	theme       Theme
+	keys        KeyMap
 	err         error
 	agent       *agent.Agent
+
+	running   bool   // A reply is being generated
+	response  string // Text of the reply so far, shown as it streams in
+	status    string // Hint shown below the chat, such as completions
+	activity  string // What the running chat is doing
+	started   time.Time
+	spinner   spinner.Model
+	cancel    context.CancelFunc // Stops the running chat
+	cancelled bool               // The running chat was stopped by the user
+	blocked   bool               // A message was held back while running
+	turnLimit int                // The running chat stopped at this many turns
+
+	approval    *approvalMsg    // The tool call waiting for the user's approval
+	alwaysAllow map[string]bool // Tools approved for the rest of the session
+
+	copyCursor int // Code block of the last reply copied last, from 1
+
+	mouse bool // The mouse scrolls the chat, instead of selecting text
+
+	focused     bool          // The terminal is in the foreground
+	notifyAfter time.Duration // Longer runs notify when they end unfocused; 0 never
+
+	width     int         // Terminal width
+	height    int         // Terminal height
+	logs      *LogHandler // Source of debug panel lines, if any
+	logLines  []string
+	showDebug bool
+
+	servers []*mcp.Conn // Offer their prompts through /prompt
+
+	sessionDir  string   // Where /sessions looks; empty disables it
+	sessionPath string   // Where the conversation is saved after each reply
+	browser     *browser // The /sessions screen, while it is open
+	resume      *resume  // The startup prompt to continue a session
+	picker      *picker  // The "@" file list, while it is open
+	search      *search  // The transcript search, while it is open
+	palette     *palette // The Ctrl+K command palette, while it is open
+
+	suggestions *suggestions // Completions of the word being typed, if any
+
+	showViewer bool        // Show the file pane beside the chat
+	viewer     *fileViewer // The file the agent last touched
+
+	workspace     string    // Shown in the status bar
+	usage         llm.Usage // Tokens used by the session's requests
+	cost          float64   // USD, for the models with known prices
+	contextTokens int       // Size of the last request, 0 until one is reported
 }
 
 func InitialModel(ag *agent.Agent) model {
@@ -41,23 +99,33 @@ func InitialModel(ag *agent.Agent) model {
 	ta.ShowLineNumbers = false
 
 	vp := viewport.New(30, 5)
-	vp.SetContent(lipgloss.NewStyle().Foreground(lipgloss.Color("63")).Render(`Welcome to Castor TUI!
-Type /help to see available commands.`))
-
-	ta.KeyMap.InsertNewline.SetEnabled(false)
 
-	return model{
-		textarea:    ta,
-		viewport:    vp,
-		messages:    []string{},
-		senderStyle: lipgloss.NewStyle().Foreground(lipgloss.Color("5")).Bold(true),
-		botStyle:    lipgloss.NewStyle().Foreground(lipgloss.Color("2")),
-		sysStyle:    lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Italic(true),
-		agent:       ag,
+	m := model{
+		textarea: ta,
+		viewport: vp,
+		messages: []message{},
+		spinner:  newSpinner(),
+		agent:    ag,
+		focused:  true,
 	}
+	m.syncUsage()
+	m.setTheme(Themes["dark"])
+	keys, _ := LoadKeyMap(nil)
+	m.setKeys(keys)
+	m.welcome()
+	return m
+}
+
+// welcome shows the greeting in place of the empty chat.
+func (m *model) welcome() {
+	m.viewport.SetContent(lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Accent)).Render(`Welcome to Castor TUI!
+Type /help to see available commands.`))
 }
 
 func (m model) Init() tea.Cmd {
+	if m.logs != nil {
+		return tea.Batch(textarea.Blink, waitForLog(m.logs.lines))
+	}
 	return textarea.Blink
 }
 
@@ -66,25 +134,106 @@ type agentResponseMsg struct {
 	err  error
 }
 
+// agentEventMsg carries one event from a running chat, along with the
+// stream to keep reading from.
+type agentEventMsg struct {
+	stream <-chan agent.Event
+	event  agent.Event
+}
+
+// agentDoneMsg signals that the chat stream has closed.
+type agentDoneMsg struct{}
+
+// waitForEvent reads the next event from a chat stream.
+func waitForEvent(stream <-chan agent.Event) tea.Cmd {
+	return func() tea.Msg {
+		event, ok := <-stream
+		if !ok {
+			return agentDoneMsg{}
+		}
+		return agentEventMsg{stream: stream, event: event}
+	}
+}
+
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var (
 		tiCmd tea.Cmd
 		vpCmd tea.Cmd
 	)
 
+	if msg, ok := msg.(tea.KeyMsg); ok && m.approval != nil {
+		return m.updateApproval(msg)
+	}
+	if msg, ok := msg.(tea.KeyMsg); ok && m.resume != nil {
+		return m.updateResume(msg)
+	}
+	if msg, ok := msg.(tea.KeyMsg); ok && m.browser != nil {
+		return m.updateBrowser(msg)
+	}
+	if msg, ok := msg.(tea.KeyMsg); ok && m.picker != nil {
+		return m.updatePicker(msg)
+	}
+
+	if msg, ok := msg.(tea.KeyMsg); ok && m.palette != nil {
+		return m.updatePalette(msg)
+	}
+	if msg, ok := msg.(tea.KeyMsg); ok && m.search != nil {
+		return m.updateSearch(msg)
+	}
+
+	if msg, ok := msg.(tea.KeyMsg); ok && m.suggestions != nil && m.updateSuggestions(msg) {
+		return m, nil
+	}
+
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch {
+		case key.Matches(msg, m.keys.Palette):
+			return m.openPalette()
+		case key.Matches(msg, m.keys.Search):
+			return m.openSearch("")
+		case key.Matches(msg, m.keys.Copy):
+			return m.copyReply()
+		case key.Matches(msg, m.keys.CopyCode):
+			return m.cycleBlock()
+		case key.Matches(msg, m.keys.ToggleTools):
+			return m.toggleTools()
+		case key.Matches(msg, m.keys.FilePane):
+			return m.toggleViewer()
+		}
+	}
+	if msg, ok := msg.(tea.MouseMsg); ok && m.browser == nil && msg.Y < m.viewport.Height {
+		if chat, pane := m.splitWidths(); pane > 0 && msg.X >= chat {
+			// Scroll the file pane rather than the chat
+			switch msg.Button {
+			case tea.MouseButtonWheelUp:
+				m.scrollViewer(-3)
+			case tea.MouseButtonWheelDown:
+				m.scrollViewer(3)
+			}
+			return m, nil
+		}
+		if msg.Action == tea.MouseActionPress && msg.Button == tea.MouseButtonLeft {
+			return m.clickTool(msg.Y)
+		}
+	}
+
+	// "@" at the start of a word opens the file picker
+	before := m.textarea.Value()
 	m.textarea, tiCmd = m.textarea.Update(msg)
 	m.viewport, vpCmd = m.viewport.Update(msg)
+	if m.textarea.Value() != before {
+		m.suggestions = m.suggest(m.textarea.Value())
+	}
 
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
-		m.viewport.Width = msg.Width
-		m.viewport.Height = msg.Height - m.textarea.Height() - 2
+		m.width, m.height = msg.Width, msg.Height
 		m.textarea.SetWidth(msg.Width)
+		m.resize()
+		m.viewport.SetContent(m.content()) // Re-wrap for the new width
 	case tea.KeyMsg:
-		switch msg.Type {
-		case tea.KeyCtrlC:
-			return m, tea.Quit
-		case tea.KeyEnter:
+		switch {
+		case key.Matches(msg, m.keys.Submit):
 			input := strings.TrimSpace(m.textarea.Value())
 			if input == "" {
 				return m, nil
@@ -96,39 +245,177 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m.handleCommand(input)
 			}
 
-			// Regular Chat
-			m.messages = append(m.messages, m.senderStyle.Render("You: ")+input)
-			m.viewport.SetContent(strings.Join(m.messages, "\n"))
+			// Regular Chat, one reply at a time; the input is kept
+			if m.running {
+				m.blocked = true
+				return m, nil
+			}
 			m.textarea.Reset()
-			m.viewport.GotoBottom()
-
-			// Start agent chat
-			return m, func() tea.Msg {
-				ctx := context.Background()
-				stream, err := m.agent.Chat(ctx, input)
-				if err != nil {
-					return agentResponseMsg{err: err}
-				}
-				
-				var fullContent strings.Builder
-				for event := range stream {
-					if event.Error != nil {
-						return agentResponseMsg{err: event.Error}
-					}
-					fullContent.WriteString(event.Delta)
-					// We could stream tool calls here too if we update the event type
-				}
-				return agentResponseMsg{text: fullContent.String()}
+			return m.startChat(input)
+		case key.Matches(msg, m.keys.Cancel) && m.running:
+			return m.cancelRun()
+		}
+		switch msg.Type {
+		case tea.KeyCtrlC:
+			return m, tea.Quit
+		case tea.KeyRunes:
+			if string(msg.Runes) == "@" && (before == "" || strings.HasSuffix(before, " ") || strings.HasSuffix(before, "\n")) {
+				m.suggestions = nil
+				return m.openPicker()
+			}
+		case tea.KeyTab:
+			if input := m.textarea.Value(); strings.HasPrefix(input, "/prompt ") && len(m.servers) > 0 {
+				return m, completePrompt(m.servers, input)
 			}
 		}
-	case agentResponseMsg:
+	case pickerFilesMsg:
+		if m.picker == nil {
+			return m, nil
+		}
+		if msg.err != nil {
+			m.picker = nil
+			return m.system(fmt.Sprintf("Failed to list files: %v", msg.err))
+		}
+		m.picker.files, m.picker.loading = msg.files, false
+		m.picker.filter()
+		return m, nil
+	case copiedMsg:
 		if msg.err != nil {
-			m.messages = append(m.messages, m.sysStyle.Render("Error: "+msg.err.Error()))
+			m.status = fmt.Sprintf("Copy failed: %v", msg.err)
 		} else {
-			m.messages = append(m.messages, m.botStyle.Render("Castor: ")+msg.text)
+			m.status = "Copied " + msg.what + " to the clipboard."
+		}
+		return m, nil
+	case completionMsg:
+		if msg.input == m.textarea.Value() {
+			m.applyCompletion(msg)
+		}
+		return m, nil
+	case spinner.TickMsg:
+		if !m.running {
+			return m, nil // Let the spinner stop
+		}
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+	case approvalMsg:
+		return m.requestApproval(msg)
+	case promptsMsg:
+		m.messages = append(m.messages, message{role: "system", text: string(msg)})
+		m.refresh()
+		return m, nil
+	case promptMsg:
+		if msg.err != nil {
+			return m.Update(agentResponseMsg{err: msg.err})
+		}
+		return m.startChat(msg.text)
+	case agentEventMsg:
+		if msg.event.Error != nil {
+			m.err = msg.event.Error
+		}
+		if msg.event.Usage != nil {
+			m.addUsage(*msg.event.Usage)
+		}
+		m.track(msg.event)
+		if n := msg.event.TurnLimit; n > 0 {
+			m.turnLimit = n
+		}
+		switch event := msg.event; {
+		case len(event.ToolCalls) > 0:
+			m.queueTools(event.ToolCalls)
+			m.refresh()
+		case event.Started != nil, event.Progress != nil, event.Result != nil:
+			follow := m.viewport.AtBottom()
+			m.trackTool(event)
+			if event.Result != nil {
+				m.followFile(event.Result)
+			}
+			if follow {
+				m.refresh()
+			} else {
+				m.viewport.SetContent(m.content())
+			}
+		}
+		if msg.event.Delta != "" {
+			// Follow the reply unless the user has scrolled up to read
+			follow := m.viewport.AtBottom()
+			m.response += msg.event.Delta
+			if follow {
+				m.refresh()
+			} else {
+				m.viewport.SetContent(m.content())
+			}
+		}
+		return m, waitForEvent(msg.stream)
+	case agentDoneMsg:
+		var notice tea.Cmd
+		if m.shouldNotify() {
+			notice = notify("Castor finished replying")
+			if m.err != nil {
+				notice = notify("Castor stopped with an error")
+			}
+		}
+		text, err := m.response, m.err
+		if m.cancelled {
+			// Keep what was written before the user stopped the reply
+			if text != "" {
+				m.messages = append(m.messages, message{role: "assistant", text: text})
+			}
+			text, err = "", nil
+			m.messages = append(m.messages, message{role: "system", text: "Cancelled."})
 		}
-		m.viewport.SetContent(strings.Join(m.messages, "\n"))
-		m.viewport.GotoBottom()
+		if m.cancel != nil {
+			m.cancel()
+		}
+		m.dropApproval()
+		m.abandonTools()
+		if m.turnLimit > 0 && err == nil {
+			if text != "" {
+				m.messages = append(m.messages, message{role: "assistant", text: text})
+				text = ""
+			}
+			m.messages = append(m.messages, message{role: "system", text: fmt.Sprintf("Stopped at the limit of %d turns. Send a message to let the agent continue.", m.turnLimit)})
+		}
+		m.turnLimit = 0
+		m.running, m.response, m.activity, m.err = false, "", "", nil
+		m.cancel, m.cancelled, m.blocked = nil, false, false
+		m.copyCursor = 0
+		if saveErr := m.saveSession(); saveErr != nil && err == nil {
+			err = saveErr
+		}
+		title := m.nameSession()
+		updated, cmd := m.Update(agentResponseMsg{text: text, err: err})
+		return updated, tea.Batch(cmd, notice, title)
+	case tea.FocusMsg:
+		m.focused = true
+		return m, nil
+	case tea.BlurMsg:
+		m.focused = false
+		return m, nil
+	case agentResponseMsg:
+		if msg.err != nil {
+			m.messages = append(m.messages, message{role: "system", text: "Error: " + msg.err.Error()})
+		} else if msg.text != "" {
+			m.messages = append(m.messages, message{role: "assistant", text: msg.text})
+		}
+		m.refresh()
+	case titleMsg:
+		if msg.path != m.sessionPath {
+			return m, nil // Another session was loaded meanwhile
+		}
+		m.agent.Title = msg.title
+		if !m.running { // Otherwise it is saved after the reply
+			if err := m.saveSession(); err != nil {
+				return m.system(err.Error())
+			}
+		}
+		return m, nil
+	case logMsg:
+		m.logLines = append(m.logLines, string(msg))
+		if len(m.logLines) > maxLogLines {
+			m.logLines = m.logLines[len(m.logLines)-maxLogLines:]
+		}
+		return m, tea.Batch(tiCmd, vpCmd, waitForLog(m.logs.lines))
 	case errMsg:
 		m.err = msg
 		return m, nil
@@ -137,10 +424,38 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(tiCmd, vpCmd)
 }
 
+// system shows text as a system message.
+func (m model) system(text string) (tea.Model, tea.Cmd) {
+	m.messages = append(m.messages, message{role: "system", text: text})
+	m.refresh()
+	return m, nil
+}
+
+// startChat shows input as the user's message and sends it to the agent.
+func (m model) startChat(input string) (tea.Model, tea.Cmd) {
+	if m.running {
+		m.blocked = true
+		return m, nil
+	}
+	m.messages = append(m.messages, message{role: "user", text: input})
+	m.refresh()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := m.agent.Chat(ctx, m.attachFiles(input))
+	if err != nil {
+		cancel()
+		return m, func() tea.Msg { return agentResponseMsg{err: err} }
+	}
+	m.running, m.response, m.status = true, "", ""
+	m.activity, m.started, m.cancel = "thinking…", time.Now(), cancel
+	m.refresh()
+	return m, tea.Batch(waitForEvent(stream), m.spinner.Tick)
+}
+
 func (m model) handleCommand(input string) (tea.Model, tea.Cmd) {
 	parts := strings.Fields(input)
 	cmd := parts[0]
-	// args := parts[1:] // For future use
+	args := parts[1:]
 
 	var output string
 
@@ -148,16 +463,11 @@ func (m model) handleCommand(input string) (tea.Model, tea.Cmd) {
 	case "/quit", "/exit":
 		return m, tea.Quit
 	case "/clear":
-		m.messages = []string{}
+		m.messages = []message{}
 		m.viewport.SetContent("Chat cleared.")
 		return m, nil
 	case "/help":
-		output = `Available Commands:
-  /tools   - List all available tools
-  /sys     - Show current system prompt
-  /clear   - Clear chat history
-  /help    - Show this help message
-  /quit    - Exit the application`
+		output = m.helpText()
 	case "/tools":
 		output = "Available Tools:\n"
 		var tools []string
@@ -170,29 +480,245 @@ func (m model) handleCommand(input string) (tea.Model, tea.Cmd) {
 		} else {
 			output += strings.Join(tools, "\n")
 		}
+	case "/undo":
+		n := 1
+		if len(args) > 0 {
+			parsed, err := strconv.Atoi(args[0])
+			if err != nil || parsed < 1 {
+				output = "Usage: /undo [n]"
+				break
+			}
+			n = parsed
+		}
+		restored, err := m.agent.UndoEdits(n)
+		if err != nil {
+			output = fmt.Sprintf("Undo failed: %v", err)
+		} else {
+			output = fmt.Sprintf("Reverted %d edit(s):\n%s", len(restored), strings.Join(restored, "\n"))
+		}
+	case "/sessions":
+		return m.openBrowser()
+	case "/fork":
+		turns := 0
+		if len(args) > 0 {
+			parsed, err := strconv.Atoi(args[0])
+			if err != nil || parsed < 1 {
+				output = "Usage: /fork [turn]"
+				break
+			}
+			turns = parsed
+		}
+		if err := m.forkSession(turns); err != nil {
+			output = fmt.Sprintf("Fork failed: %v", err)
+			break
+		}
+		return m, nil
+	case "/mouse":
+		m.mouse = !m.mouse
+		if m.mouse {
+			m.status = "Mouse on: the wheel scrolls the chat; hold Shift to select text."
+			return m, tea.EnableMouseCellMotion
+		}
+		m.status = "Mouse off: the terminal selects text; scroll with PgUp/PgDn."
+		return m, tea.DisableMouse
+	case "/export":
+		output = m.export(args)
+	case "/viewer":
+		return m.toggleViewer()
+	case "/search":
+		return m.openSearch(strings.Join(args, " "))
+	case "/copy":
+		if len(args) == 0 {
+			return m.copyReply()
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			output = "Usage: /copy [n]"
+			break
+		}
+		return m.copyBlock(n)
+	case "/prompts":
+		return m, listPrompts(m.servers)
+	case "/prompt":
+		if len(args) == 0 {
+			output = "Usage: /prompt <server>/<name> [arg=value ...]"
+			break
+		}
+		return m, runPrompt(m.servers, args)
+	case "/debug":
+		m.showDebug = !m.showDebug
+		m.resize()
+		return m, nil
 	case "/sys":
 		output = fmt.Sprintf("System Prompt:\n%s", m.agent.SystemPrompt)
 	default:
 		output = fmt.Sprintf("Unknown command: %s. Type /help for list.", cmd)
 	}
 
-	m.messages = append(m.messages, m.sysStyle.Render(output))
-	m.viewport.SetContent(strings.Join(m.messages, "\n"))
-	m.viewport.GotoBottom()
+	m.messages = append(m.messages, message{role: "system", text: output})
+	m.refresh()
 	return m, nil
 }
 
 func (m model) View() string {
+	status := ""
+	if m.search != nil {
+		status = m.searchView()
+	} else if m.running {
+		status = m.activityView()
+	} else if m.status != "" {
+		status = m.sysStyle.Render(m.status)
+	}
+	view := m.viewport.View()
+	if m.browser != nil {
+		view = lipgloss.NewStyle().Height(m.viewport.Height).Render(m.browserView())
+	}
+	if m.resume != nil {
+		view = lipgloss.NewStyle().Height(m.viewport.Height).Render(m.resumeView())
+	}
+	var list []string
+	switch {
+	case m.approval != nil:
+		list = m.approvalView()
+	case m.palette != nil:
+		list = m.paletteView()
+	case m.picker != nil:
+		list = m.pickerView()
+	case m.suggestions != nil:
+		list = m.suggestionsView()
+	}
+	if list != nil {
+		// Cover the bottom of the chat with the list
+		lines := strings.Split(view, "\n")
+		lines = append(lines[:max(len(lines)-len(list), 0)], list...)
+		view = strings.Join(lines, "\n")
+	}
+	if _, pane := m.splitWidths(); pane > 0 {
+		view = lipgloss.JoinHorizontal(lipgloss.Top, view, m.viewerView(pane, m.viewport.Height))
+	}
+	if m.showDebug {
+		view += "\n" + m.debugView()
+	}
 	return fmt.Sprintf(
-		"%s\n\n%s",
-		m.viewport.View(),
+		"%s\n%s\n%s\n%s",
+		view,
+		status,
 		m.textarea.View(),
-	) + "\n\n"
+		m.statusBar(),
+	) + "\n"
+}
+
+// content renders the chat history, followed by the reply being
+// generated.
+func (m model) content() string {
+	var parts []string
+	for i := range m.messages {
+		parts = append(parts, m.renderMessage(&m.messages[i]))
+	}
+	if m.running {
+		reply := message{role: "assistant", text: m.response + "▍"}
+		parts = append(parts, m.renderMessage(&reply))
+	}
+	return m.markMatches(strings.Join(parts, "\n"))
+}
+
+// refresh redraws the chat and scrolls to the newest message.
+func (m *model) refresh() {
+	m.viewport.SetContent(m.content())
+	m.viewport.GotoBottom()
+}
+
+// resize fits the viewport into the space left by the other panels.
+func (m *model) resize() {
+	if m.height == 0 {
+		return
+	}
+	m.viewport.Width, _ = m.splitWidths()
+	height := m.height - m.textarea.Height() - 2
+	if m.showDebug {
+		height -= debugPanelHeight + 1
+	}
+	m.viewport.Height = max(height, 1)
+}
+
+// debugView renders the most recent log lines.
+func (m model) debugView() string {
+	lines := m.logLines
+	if len(lines) > debugPanelHeight {
+		lines = lines[len(lines)-debugPanelHeight:]
+	}
+	out := make([]string, debugPanelHeight)
+	for i, line := range lines {
+		if r := []rune(line); m.viewport.Width > 0 && len(r) > m.viewport.Width {
+			line = string(r[:m.viewport.Width])
+		}
+		out[i] = line
+	}
+	if len(m.logLines) == 0 {
+		out[0] = "(no log messages)"
+	}
+	title := m.sysStyle.Render("── debug log ──")
+	return title + "\n" + m.sysStyle.Render(strings.Join(out, "\n"))
+}
+
+// Options configures optional TUI features.
+type Options struct {
+	Logs    *LogHandler // Feeds the /debug panel
+	Servers []*mcp.Conn // MCP servers whose prompts are offered
+
+	SessionDir  string // Saved sessions listed by /sessions
+	SessionPath string // Where the conversation is saved after each reply
+
+	// OfferResume asks on startup whether to continue the latest session
+	// in SessionDir, if one was saved recently.
+	OfferResume bool
+
+	Workspace string // Shown in the status bar
+
+	Theme *Theme // Colors; the dark theme when nil
+
+	DisableMouse bool // Leave the mouse to the terminal, for selecting text
+
+	Keys *KeyMap // Key bindings; the defaults when nil
+
+	// NotifyAfter is how long a run must take for its end to ring the bell
+	// and post a notification while the terminal is unfocused; 0 never
+	// notifies.
+	NotifyAfter time.Duration
+
+	// Context, if set, ends the TUI when it is canceled, as on SIGTERM.
+	Context context.Context
 }
 
 // Run starts the TUI
-func Run(ag *agent.Agent) error {
-	p := tea.NewProgram(InitialModel(ag), tea.WithAltScreen())
+func Run(ag *agent.Agent, opts Options) error {
+	m := InitialModel(ag)
+	m.logs = opts.Logs
+	m.servers = opts.Servers
+	m.sessionDir = opts.SessionDir
+	m.sessionPath = opts.SessionPath
+	if opts.OfferResume {
+		m.offerResume()
+	}
+	m.workspace = opts.Workspace
+	m.notifyAfter = opts.NotifyAfter
+	if opts.Keys != nil {
+		m.setKeys(*opts.Keys)
+	}
+	if opts.Theme != nil {
+		m.setTheme(*opts.Theme)
+		m.welcome()
+	}
+	programOpts := []tea.ProgramOption{tea.WithAltScreen(), tea.WithReportFocus()}
+	if !opts.DisableMouse {
+		m.mouse = true
+		programOpts = append(programOpts, tea.WithMouseCellMotion())
+	}
+	if opts.Context != nil {
+		programOpts = append(programOpts, tea.WithContext(opts.Context))
+	}
+	p := tea.NewProgram(m, programOpts...)
+	ag.Approve = approver(p)
 	_, err := p.Run()
 	return err
 }
@@ -0,0 +1,198 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/techmuch/castor/pkg/agent"
+	"github.com/techmuch/castor/pkg/tools/workspace"
+)
+
+const (
+	minSplitWidth  = 80      // Narrower terminals do not show the file pane
+	maxViewerBytes = 1 << 20 // Larger files are not shown
+)
+
+// fileViewer is the right-hand pane showing the file the agent last read
+// or edited.
+type fileViewer struct {
+	path    string
+	changed map[int]bool // Lines changed by the last edit, from 1
+	lines   []string     // Highlighted lines of the file
+	err     string       // Why the file cannot be shown
+	offset  int          // First line in view
+}
+
+// followFile points the viewer at the file a tool call read or edited.
+// Calls that did not touch a file are ignored.
+func (m *model) followFile(r *agent.ToolResult) {
+	if r.Err != nil {
+		return
+	}
+	path, _ := r.Args["path"].(string)
+	_, patch := editDiff(r.Output)
+	switch {
+	case r.Tool == "list_directory":
+		return
+	case r.Tool == "edit_transaction" && patch == "":
+		return // Staged edits are not written yet
+	case path == "" && patch != "":
+		path = patchFile(patch)
+	}
+	if path == "" {
+		return
+	}
+	m.viewer = &fileViewer{path: path, changed: changedLines(patch, path)}
+	m.loadViewer()
+}
+
+// loadViewer reads and highlights the viewed file, and scrolls to the
+// first changed line.
+func (m *model) loadViewer() {
+	v := m.viewer
+	v.lines, v.err = nil, ""
+	abs, err := workspace.Resolve(m.workspaceRoot(), v.path)
+	if err != nil {
+		v.err = err.Error()
+		return
+	}
+	info, err := os.Stat(abs)
+	switch {
+	case err != nil:
+		v.err = err.Error()
+		return
+	case info.IsDir():
+		v.err = "is a directory"
+		return
+	case info.Size() > maxViewerBytes:
+		v.err = fmt.Sprintf("too large to show (%d bytes)", info.Size())
+		return
+	}
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		v.err = err.Error()
+		return
+	}
+	content := strings.TrimRight(string(data), "\n")
+	v.lines = strings.Split(highlight(content, detectLexer(content, v.path), m.theme.Code), "\n")
+
+	first := 0
+	for n := range v.changed {
+		if first == 0 || n < first {
+			first = n
+		}
+	}
+	v.offset = max(first-4, 0)
+}
+
+// viewerView renders the file pane, width columns wide and height lines
+// tall.
+func (m model) viewerView(width, height int) string {
+	v := m.viewer
+	border := lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder(), false, false, false, true).
+		BorderForeground(lipgloss.Color(m.theme.Border)).
+		PaddingLeft(1)
+	width -= 2 // Border and padding
+
+	if v == nil {
+		return border.Render(lipgloss.NewStyle().Width(width).Height(height).Render(
+			m.sysStyle.Render("Files the agent reads or edits appear here.")))
+	}
+
+	lines := []string{ansi.Truncate(m.toolStyle.Render(v.path), width, "…")}
+	if v.err != "" {
+		lines = append(lines, m.sysStyle.Render(v.err))
+	}
+	added := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Added))
+	gutter := len(strconv.Itoa(len(v.lines)))
+	for i := v.offset; i < len(v.lines) && len(lines) < height; i++ {
+		mark := " "
+		number := m.sysStyle.Render(fmt.Sprintf("%*d", gutter, i+1))
+		if v.changed[i+1] {
+			mark = added.Render("+")
+			number = added.Render(fmt.Sprintf("%*d", gutter, i+1))
+		}
+		lines = append(lines, ansi.Truncate(number+mark+" "+v.lines[i], width, "…"))
+	}
+	return border.Render(lipgloss.NewStyle().Width(width).Height(height).MaxHeight(height).Render(strings.Join(lines, "\n")))
+}
+
+// scrollViewer moves the file pane by delta lines.
+func (m *model) scrollViewer(delta int) {
+	if m.viewer == nil {
+		return
+	}
+	m.viewer.offset = max(min(m.viewer.offset+delta, len(m.viewer.lines)-1), 0)
+}
+
+// splitWidths divides the terminal between the chat and the file pane. The
+// pane is zero wide when it is hidden or the terminal is too narrow.
+func (m model) splitWidths() (chat, pane int) {
+	if !m.showViewer || m.width < minSplitWidth {
+		return m.width, 0
+	}
+	pane = m.width * 45 / 100
+	return m.width - pane, pane
+}
+
+// patchFile returns the first file named by a unified diff.
+func patchFile(patch string) string {
+	for _, line := range strings.Split(patch, "\n") {
+		if name, ok := strings.CutPrefix(line, "+++ "); ok {
+			return strings.TrimPrefix(strings.TrimSpace(name), "b/")
+		}
+	}
+	return ""
+}
+
+// changedLines returns the lines of file that a unified diff adds or
+// changes, numbered in the new file.
+func changedLines(patch, file string) map[int]bool {
+	file = path.Clean(filepath.ToSlash(file))
+	changed := make(map[int]bool)
+	inFile, line := false, 0
+	for _, l := range strings.Split(patch, "\n") {
+		switch {
+		case strings.HasPrefix(l, "--- "):
+		case strings.HasPrefix(l, "+++ "):
+			inFile = path.Clean(strings.TrimPrefix(strings.TrimSpace(l[4:]), "b/")) == file
+		case !inFile:
+		case strings.HasPrefix(l, "@@"):
+			// @@ -a,b +c,d @@
+			fields := strings.Fields(l)
+			if len(fields) >= 3 {
+				start, _, _ := strings.Cut(strings.TrimPrefix(fields[2], "+"), ",")
+				line, _ = strconv.Atoi(start)
+			}
+		case strings.HasPrefix(l, "+"):
+			changed[line] = true
+			line++
+		case strings.HasPrefix(l, "-"):
+		default:
+			line++
+		}
+	}
+	return changed
+}
+
+// toggleViewer shows or hides the file pane.
+func (m model) toggleViewer() (tea.Model, tea.Cmd) {
+	m.showViewer = !m.showViewer
+	if m.showViewer && m.width > 0 && m.width < minSplitWidth {
+		m.status = fmt.Sprintf("The file pane needs a terminal at least %d columns wide.", minSplitWidth)
+	}
+	if m.showViewer && m.viewer != nil {
+		m.loadViewer() // Catch up with changes made since it was read
+	}
+	m.resize()
+	m.viewport.SetContent(m.content())
+	return m, nil
+}
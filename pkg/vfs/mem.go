@@ -0,0 +1,211 @@
+package vfs
+
+import (
+	"bytes"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemFS is an in-memory FS, intended for tests. It has no symlinks, and
+// the root of every volume always exists.
+type MemFS struct {
+	mu    sync.RWMutex
+	nodes map[string]*memNode // Keyed by clean absolute path
+}
+
+type memNode struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// NewMemFS returns an empty in-memory filesystem.
+func NewMemFS() *MemFS {
+	return &MemFS{nodes: make(map[string]*memNode)}
+}
+
+// lookup returns the node at a clean path. Callers hold the lock.
+func (m *MemFS) lookup(name string) (*memNode, bool) {
+	if filepath.Dir(name) == name {
+		return &memNode{mode: fs.ModeDir | 0755}, true
+	}
+	n, ok := m.nodes[name]
+	return n, ok
+}
+
+func (m *MemFS) Open(name string) (File, error) {
+	name = filepath.Clean(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n, ok := m.lookup(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &memFile{Reader: bytes.NewReader(n.data), info: n.info(name)}, nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	name = filepath.Clean(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n, ok := m.lookup(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return n.info(name), nil
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	name = filepath.Clean(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n, ok := m.lookup(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	if !n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	var entries []fs.DirEntry
+	for p, child := range m.nodes {
+		if filepath.Dir(p) == name && p != name {
+			entries = append(entries, fs.FileInfoToDirEntry(child.info(p)))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	name = filepath.Clean(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n, ok := m.lookup(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	return bytes.Clone(n.data), nil
+}
+
+// WriteFile creates or truncates a file. As with os.WriteFile, the parent
+// directory must already exist and perm only applies to new files.
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if parent, ok := m.lookup(filepath.Dir(name)); !ok || !parent.mode.IsDir() {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if n, ok := m.lookup(name); ok {
+		if n.mode.IsDir() {
+			return &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+		}
+		n.data = bytes.Clone(data)
+		n.modTime = time.Now()
+		return nil
+	}
+	m.nodes[name] = &memNode{data: bytes.Clone(data), mode: perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+func (m *MemFS) MkdirAll(path string, perm fs.FileMode) error {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for p := path; ; p = filepath.Dir(p) {
+		if n, ok := m.lookup(p); ok {
+			if !n.mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: p, Err: fs.ErrExist}
+			}
+			break
+		}
+		m.nodes[p] = &memNode{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+	}
+	return nil
+}
+
+// Remove deletes a file or an empty directory.
+func (m *MemFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := m.nodes[name]
+	if !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if n.mode.IsDir() {
+		prefix := name + string(filepath.Separator)
+		for p := range m.nodes {
+			if strings.HasPrefix(p, prefix) {
+				return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrExist}
+			}
+		}
+	}
+	delete(m.nodes, name)
+	return nil
+}
+
+// EvalSymlinks returns the clean path if it exists. MemFS has no links.
+func (m *MemFS) EvalSymlinks(path string) (string, error) {
+	path = filepath.Clean(path)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.lookup(path); !ok {
+		return "", &fs.PathError{Op: "lstat", Path: path, Err: fs.ErrNotExist}
+	}
+	return path, nil
+}
+
+// Readlink always fails, since MemFS has no links.
+func (m *MemFS) Readlink(name string) (string, error) {
+	name = filepath.Clean(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.lookup(name); !ok {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrNotExist}
+	}
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+}
+
+func (n *memNode) info(name string) fs.FileInfo {
+	return memInfo{name: filepath.Base(name), size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return i.mode }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memInfo) Sys() any           { return nil }
+
+type memFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
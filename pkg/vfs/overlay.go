@@ -0,0 +1,280 @@
+package vfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Overlay is an FS that reads through to a base FS but keeps every change
+// in memory, so tools can be run against a tree without modifying it.
+// Later reads see the changes, as they would on disk.
+type Overlay struct {
+	base FS
+
+	mu    sync.RWMutex
+	nodes map[string]*memNode // Written files and directories; nil marks a removed path
+}
+
+// NewOverlay returns an overlay over base, which it never writes to.
+func NewOverlay(base FS) *Overlay {
+	return &Overlay{base: base, nodes: make(map[string]*memNode)}
+}
+
+// Changes returns the files that were written, and the paths of the base
+// that were removed, sorted.
+func (o *Overlay) Changes() []string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	var paths []string
+	for p, n := range o.nodes {
+		if n == nil {
+			if _, err := o.base.Stat(p); err != nil {
+				continue // Made and removed again in the overlay
+			}
+		} else if n.mode.IsDir() {
+			continue
+		}
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// lookup returns the overlay's node for a clean path. found reports
+// whether the overlay decides the path at all; a nil node means it was
+// removed. Callers hold the lock.
+func (o *Overlay) lookup(name string) (n *memNode, found bool) {
+	for p := name; ; p = filepath.Dir(p) {
+		if n, ok := o.nodes[p]; ok {
+			if p != name {
+				if n == nil {
+					return nil, true // Beneath a removed directory
+				}
+				return nil, false
+			}
+			return n, true
+		}
+		if filepath.Dir(p) == p {
+			return nil, false
+		}
+	}
+}
+
+func (o *Overlay) Open(name string) (File, error) {
+	name = filepath.Clean(name)
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	if n, found := o.lookup(name); found {
+		if n == nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		return &memFile{Reader: bytes.NewReader(n.data), info: n.info(name)}, nil
+	}
+	return o.base.Open(name)
+}
+
+func (o *Overlay) Stat(name string) (fs.FileInfo, error) {
+	name = filepath.Clean(name)
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.stat(name)
+}
+
+// stat is Stat for a clean path. Callers hold the lock.
+func (o *Overlay) stat(name string) (fs.FileInfo, error) {
+	if n, found := o.lookup(name); found {
+		if n == nil {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+		}
+		return n.info(name), nil
+	}
+	return o.base.Stat(name)
+}
+
+// ReadDir merges the base directory's entries with the overlay's.
+func (o *Overlay) ReadDir(name string) ([]fs.DirEntry, error) {
+	name = filepath.Clean(name)
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.readDir(name)
+}
+
+// readDir is ReadDir for a clean path. Callers hold the lock.
+func (o *Overlay) readDir(name string) ([]fs.DirEntry, error) {
+	info, err := o.stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	byName := make(map[string]fs.DirEntry)
+	base, err := o.base.ReadDir(name)
+	if err != nil && !isNotExist(err) {
+		return nil, err
+	}
+	for _, e := range base {
+		byName[e.Name()] = e
+	}
+	for p, child := range o.nodes {
+		if filepath.Dir(p) != name || p == name {
+			continue
+		}
+		if child == nil {
+			delete(byName, filepath.Base(p))
+		} else {
+			byName[filepath.Base(p)] = fs.FileInfoToDirEntry(child.info(p))
+		}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(byName))
+	for _, e := range byName {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (o *Overlay) ReadFile(name string) ([]byte, error) {
+	name = filepath.Clean(name)
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	if n, found := o.lookup(name); found {
+		if n == nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		if n.mode.IsDir() {
+			return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+		}
+		return bytes.Clone(n.data), nil
+	}
+	return o.base.ReadFile(name)
+}
+
+// WriteFile creates or truncates a file in memory. As with os.WriteFile,
+// the parent directory must already exist and perm only applies to new
+// files.
+func (o *Overlay) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	name = filepath.Clean(name)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if parent, err := o.stat(filepath.Dir(name)); err != nil || !parent.IsDir() {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	mode := perm.Perm()
+	if info, err := o.stat(name); err == nil {
+		if info.IsDir() {
+			return &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+		}
+		mode = info.Mode().Perm()
+	}
+	o.nodes[name] = &memNode{data: bytes.Clone(data), mode: mode, modTime: time.Now()}
+	return nil
+}
+
+func (o *Overlay) MkdirAll(path string, perm fs.FileMode) error {
+	path = filepath.Clean(path)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var missing []string
+	for p := path; ; p = filepath.Dir(p) {
+		info, err := o.stat(p)
+		if err == nil {
+			if !info.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: p, Err: fs.ErrExist}
+			}
+			break
+		}
+		if !isNotExist(err) {
+			return err
+		}
+		missing = append(missing, p)
+		if filepath.Dir(p) == p {
+			break
+		}
+	}
+	for _, p := range missing {
+		o.nodes[p] = &memNode{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+	}
+	return nil
+}
+
+// Remove removes a file or empty directory, hiding it from later reads.
+func (o *Overlay) Remove(name string) error {
+	name = filepath.Clean(name)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	info, err := o.stat(name)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if info.IsDir() {
+		entries, err := o.readDir(name)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrExist}
+		}
+	}
+	// The removed children of a directory stay marked, so they do not
+	// show through if it is made again
+	o.nodes[name] = nil
+	return nil
+}
+
+// EvalSymlinks resolves links through the base FS. Paths created in the
+// overlay have no links of their own, so only their parents are resolved.
+func (o *Overlay) EvalSymlinks(path string) (string, error) {
+	path = filepath.Clean(path)
+	o.mu.RLock()
+	n, found := o.lookup(path)
+	o.mu.RUnlock()
+
+	switch {
+	case !found:
+		return o.base.EvalSymlinks(path)
+	case n == nil:
+		return "", &fs.PathError{Op: "lstat", Path: path, Err: fs.ErrNotExist}
+	case filepath.Dir(path) == path:
+		return path, nil
+	}
+	dir, err := o.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(path)), nil
+}
+
+// Readlink reads links from the base FS. Paths written or removed in the
+// overlay are never links.
+func (o *Overlay) Readlink(name string) (string, error) {
+	name = filepath.Clean(name)
+	o.mu.RLock()
+	n, found := o.lookup(name)
+	o.mu.RUnlock()
+
+	switch {
+	case !found:
+		return o.base.Readlink(name)
+	case n == nil:
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrNotExist}
+	}
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+}
+
+func isNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
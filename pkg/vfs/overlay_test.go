@@ -0,0 +1,124 @@
+package vfs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOverlay(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "sub", "b.txt"), []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(t *testing.T, o *Overlay, dir string) []string {
+		t.Helper()
+		entries, err := o.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("ReadDir: %v", err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+
+	t.Run("WritesStayInMemory", func(t *testing.T) {
+		o := NewOverlay(OS)
+		path := filepath.Join(root, "a.txt")
+		if err := o.WriteFile(path, []byte("changed"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := o.ReadFile(path); string(got) != "changed" {
+			t.Errorf("overlay content = %q, want %q", got, "changed")
+		}
+		if got, _ := os.ReadFile(path); string(got) != "a" {
+			t.Errorf("disk content = %q, want it unchanged", got)
+		}
+		if info, _ := o.Stat(path); info.Mode().Perm() != 0644 {
+			t.Errorf("mode = %v, want the existing file's", info.Mode().Perm())
+		}
+		if got, want := o.Changes(), []string{path}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Changes() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("NewFilesAndDirectories", func(t *testing.T) {
+		o := NewOverlay(OS)
+		dir := filepath.Join(root, "sub", "new", "deeper")
+		if err := o.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := o.WriteFile(filepath.Join(dir, "c.txt"), []byte("c"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := names(t, o, filepath.Join(root, "sub")), []string{"b.txt", "new"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("entries = %v, want %v", got, want)
+		}
+		if _, err := os.Stat(filepath.Join(root, "sub", "new")); !os.IsNotExist(err) {
+			t.Errorf("directory was made on disk")
+		}
+		if err := o.WriteFile(filepath.Join(root, "missing", "d.txt"), nil, 0644); err == nil {
+			t.Error("writing into a missing directory succeeded")
+		}
+		resolved, err := o.EvalSymlinks(filepath.Join(dir, "c.txt"))
+		if err != nil {
+			t.Fatalf("EvalSymlinks: %v", err)
+		}
+		realRoot, _ := filepath.EvalSymlinks(root)
+		if want := filepath.Join(realRoot, "sub", "new", "deeper", "c.txt"); resolved != want {
+			t.Errorf("EvalSymlinks = %q, want %q", resolved, want)
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		o := NewOverlay(OS)
+		sub := filepath.Join(root, "sub")
+		if err := o.Remove(sub); err == nil {
+			t.Error("removing a non-empty directory succeeded")
+		}
+		if err := o.Remove(filepath.Join(sub, "b.txt")); err != nil {
+			t.Fatal(err)
+		}
+		if err := o.Remove(sub); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := o.Stat(filepath.Join(sub, "b.txt")); !os.IsNotExist(err) {
+			t.Errorf("Stat of removed file: %v, want not exist", err)
+		}
+		if got, want := names(t, o, root), []string{"a.txt"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("entries = %v, want %v", got, want)
+		}
+		if _, err := os.Stat(filepath.Join(sub, "b.txt")); err != nil {
+			t.Errorf("file was removed from disk: %v", err)
+		}
+
+		// Making the directory again must not bring its old files back
+		if err := o.MkdirAll(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if got := names(t, o, sub); len(got) != 0 {
+			t.Errorf("entries of remade directory = %v, want none", got)
+		}
+
+		tmp := filepath.Join(root, "tmp.txt")
+		if err := o.WriteFile(tmp, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := o.Remove(tmp); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := o.Changes(), []string{filepath.Join(sub, "b.txt")}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Changes() = %v, want %v", got, want)
+		}
+	})
+}
@@ -60,7 +60,7 @@ func TestIntegration_Tools(t *testing.T) {
 
 	// Helper to run castor
 	runCastor := func(prompt string) string {
-		cmd := exec.Command(binary,
+		cmd := exec.Command(binary, "run",
 			"-url", baseURL,
 			"-model", model,
 			"-w", workspace, // SANDBOXED to temp dir